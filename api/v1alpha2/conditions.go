@@ -0,0 +1,96 @@
+package v1alpha2
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition types reported on a VeleroInstall
+const (
+	// ConditionBucketProvisioned indicates whether the storage bucket exists and is accessible
+	ConditionBucketProvisioned = "BucketProvisioned"
+	// ConditionBucketPolicyEnforced indicates whether encryption, access, lifecycle and tagging policy is applied to the storage bucket
+	ConditionBucketPolicyEnforced = "BucketPolicyEnforced"
+	// ConditionBucketPolicyCompliant indicates whether an existing bucket that the operator does not manage meets the bucket policy
+	ConditionBucketPolicyCompliant = "BucketPolicyCompliant"
+	// ConditionBucketRecoveryAmbiguous indicates whether several existing buckets matched the cluster when its storage bucket was recovered
+	ConditionBucketRecoveryAmbiguous = "BucketRecoveryAmbiguous"
+	// ConditionCredentialsReady indicates whether the cloud credentials for Velero have been provisioned
+	ConditionCredentialsReady = "CredentialsReady"
+	// ConditionReplicationReady indicates whether backups are replicated to a second region
+	ConditionReplicationReady = "ReplicationReady"
+	// ConditionVeleroSchedulable indicates whether there are nodes of an architecture the Velero pod can run on
+	ConditionVeleroSchedulable = "VeleroSchedulable"
+	// ConditionVeleroDeploymentAvailable indicates whether the Velero deployment is available
+	ConditionVeleroDeploymentAvailable = "VeleroDeploymentAvailable"
+	// ConditionReady indicates whether Velero is fully installed and configured
+	ConditionReady = "Ready"
+)
+
+// Condition reasons reported on a VeleroInstall
+const (
+	ReasonBucketNameSelected       = "BucketNameSelected"
+	ReasonBucketRecovered          = "BucketRecovered"
+	ReasonBucketAdopted            = "BucketAdopted"
+	ReasonSingleBucketMatched      = "SingleBucketMatched"
+	ReasonMultipleBucketsMatched   = "MultipleBucketsMatched"
+	ReasonBucketCreateFailed       = "BucketCreateFailed"
+	ReasonBucketNotFound           = "BucketNotFound"
+	ReasonBucketVerifyFailed       = "BucketVerifyFailed"
+	ReasonBucketAvailable          = "BucketAvailable"
+	ReasonBucketLocationMismatch   = "BucketLocationMismatch"
+	ReasonBucketOwnershipMismatch  = "BucketOwnershipMismatch"
+	ReasonBucketUnmanaged          = "BucketUnmanaged"
+	ReasonPolicyCompliant          = "PolicyCompliant"
+	ReasonPolicyGapsFound          = "PolicyGapsFound"
+	ReasonEncryptionFailed         = "EncryptionFailed"
+	ReasonPublicAccessBlockFailed  = "PublicAccessBlockFailed"
+	ReasonLifecycleFailed          = "LifecycleFailed"
+	ReasonTaggingFailed            = "TaggingFailed"
+	ReasonVersioningFailed         = "VersioningFailed"
+	ReasonObjectLockFailed         = "ObjectLockFailed"
+	ReasonReplicaCreateFailed      = "ReplicaCreateFailed"
+	ReasonReplicationFailed        = "ReplicationFailed"
+	ReasonReplicationConfigured    = "ReplicationConfigured"
+	ReasonPolicyEnforced           = "PolicyEnforced"
+	ReasonPolicyPartiallyEnforced  = "PolicyPartiallyEnforced"
+	ReasonUnsupportedConfiguration = "UnsupportedConfiguration"
+	ReasonCredentialsRequestFailed = "CredentialsRequestFailed"
+	ReasonCredentialsPending       = "CredentialsPending"
+	ReasonCredentialsProvisioned   = "CredentialsProvisioned"
+	ReasonCredentialsProvided      = "CredentialsProvided"
+	ReasonDeploymentFailed         = "DeploymentFailed"
+	ReasonDeploymentUnavailable    = "DeploymentUnavailable"
+	ReasonDeploymentAvailable      = "DeploymentAvailable"
+	ReasonSuitableNodesFound       = "SuitableNodesFound"
+	ReasonNoSuitableNodes          = "NoSuitableNodes"
+	ReasonPlatformError            = "PlatformError"
+	ReasonStorageReconcileFailed   = "StorageReconcileFailed"
+	ReasonStorageLocationFailed    = "StorageLocationFailed"
+	ReasonMetricsFailed            = "MetricsFailed"
+	ReasonStorageCleanupFailed     = "StorageCleanupFailed"
+	ReasonComponentsNotReady       = "ComponentsNotReady"
+	ReasonInstallationComplete     = "InstallationComplete"
+)
+
+// SetCondition adds or updates the condition of the given type, stamping it
+// with the generation of the VeleroInstall it was observed against.
+func (i *VeleroInstall) SetCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&i.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: i.Generation,
+	})
+}
+
+// IsConditionTrue returns true if the condition of the given type is present and true
+func (i *VeleroInstall) IsConditionTrue(conditionType string) bool {
+	return meta.IsStatusConditionTrue(i.Status.Conditions, conditionType)
+}
+
+// RemoveCondition removes the condition of the given type, if it is present
+func (i *VeleroInstall) RemoveCondition(conditionType string) {
+	meta.RemoveStatusCondition(&i.Status.Conditions, conditionType)
+}
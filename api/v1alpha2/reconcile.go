@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -15,16 +16,34 @@ func (i *VeleroInstall) StorageBucketReconcileRequired(reconcilePeriod time.Dura
 	// - Provisioned is false
 	// - The LastSyncTimestamp is unset
 	// - It's been longer than 1 hour since last sync
+	// - The bucket policy condition hasn't been reported yet
+	// - The replica bucket doesn't match the requested replication
+	// - The spec changed since the bucket was last synced
 	if i.Status.StorageBucket.Name == "" ||
 		!i.Status.StorageBucket.Provisioned ||
 		i.Status.StorageBucket.LastSyncTimestamp.IsZero() ||
-		time.Since(i.Status.StorageBucket.LastSyncTimestamp.Time) > reconcilePeriod {
+		time.Since(i.Status.StorageBucket.LastSyncTimestamp.Time) > reconcilePeriod ||
+		meta.FindStatusCondition(i.Status.Conditions, ConditionBucketPolicyEnforced) == nil ||
+		i.replicaReconcileRequired() ||
+		i.Status.ObservedGeneration != i.Generation {
 		return true
 	}
 
 	return false
 }
 
+// replicaReconcileRequired returns true if replication is requested but isn't
+// ready in the requested region, or if it is still reported after it is no
+// longer requested.
+func (i *VeleroInstall) replicaReconcileRequired() bool {
+	replication := i.Spec.Storage.Replication
+	if replication == nil {
+		return meta.FindStatusCondition(i.Status.Conditions, ConditionReplicationReady) != nil
+	}
+	replica := i.Status.StorageBucket.Replica
+	return !i.IsConditionTrue(ConditionReplicationReady) || (replica != nil && replica.Region != replication.Region)
+}
+
 func (i *VeleroInstall) StatusUpdate(reqLogger logr.Logger, kubeClient client.Client) error {
 	err := kubeClient.Status().Update(context.TODO(), i)
 	if err != nil {
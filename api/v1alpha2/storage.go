@@ -0,0 +1,91 @@
+package v1alpha2
+
+const (
+	// DefaultStorageRetentionDays is the number of days backups are kept when not otherwise specified
+	DefaultStorageRetentionDays int32 = 90
+	// DefaultStorageNoncurrentVersionRetentionDays is the number of days noncurrent object versions are kept when not otherwise specified
+	DefaultStorageNoncurrentVersionRetentionDays int32 = 30
+	// DefaultStorageNamePrefix is the storage bucket name prefix used when not otherwise specified
+	DefaultStorageNamePrefix = "managed-velero-backups-"
+	// DefaultS3CompatibleRegion is the region passed to an S3-compatible object store when not otherwise specified
+	DefaultS3CompatibleRegion = "us-east-1"
+)
+
+// The CRD defaults these fields, but objects that predate the fields (or are
+// built in code) may still carry zero values, so consumers should use the
+// accessors below rather than reading the fields directly.
+
+// GetRetentionDays returns the number of days after which backups are expired
+func (s *StorageSpec) GetRetentionDays() int64 {
+	if s.RetentionDays <= 0 {
+		return int64(DefaultStorageRetentionDays)
+	}
+	return int64(s.RetentionDays)
+}
+
+// GetNoncurrentVersionRetentionDays returns the number of days after which
+// noncurrent object versions are removed
+func (s *StorageSpec) GetNoncurrentVersionRetentionDays() int64 {
+	if s.Versioning.NoncurrentVersionRetentionDays <= 0 {
+		return int64(DefaultStorageNoncurrentVersionRetentionDays)
+	}
+	return int64(s.Versioning.NoncurrentVersionRetentionDays)
+}
+
+// GetNamePrefix returns the prefix used when generating a new storage bucket name
+func (s *StorageSpec) GetNamePrefix() string {
+	if s.NamePrefix == "" {
+		return DefaultStorageNamePrefix
+	}
+	return s.NamePrefix
+}
+
+// GetEncryptionMode returns the type of server-side encryption for the storage bucket
+func (s *StorageSpec) GetEncryptionMode() StorageEncryptionMode {
+	if s.Encryption.Mode == "" {
+		return StorageEncryptionModeProviderManaged
+	}
+	return s.Encryption.Mode
+}
+
+// GetKMSKeyID returns the customer-managed key used to encrypt the storage
+// bucket, which is only used in the KMS encryption mode
+func (s *StorageSpec) GetKMSKeyID() string {
+	if s.GetEncryptionMode() != StorageEncryptionModeKMS {
+		return ""
+	}
+	return s.Encryption.KMSKeyID
+}
+
+// GetMode returns the Object Lock retention mode applied to backups
+func (i *StorageImmutability) GetMode() StorageImmutabilityMode {
+	if i.Mode == "" {
+		return StorageImmutabilityModeGovernance
+	}
+	return i.Mode
+}
+
+// GetReplicaKMSKeyID returns the customer-managed key used to encrypt the
+// replica bucket, which is only used in the KMS encryption mode
+func (s *StorageSpec) GetReplicaKMSKeyID() string {
+	if s.Replication == nil || s.GetEncryptionMode() != StorageEncryptionModeKMS {
+		return ""
+	}
+	return s.Replication.KMSKeyID
+}
+
+// GetRegion returns the region passed to the S3-compatible object store
+func (s *S3CompatibleStorage) GetRegion() string {
+	if s.Region == "" {
+		return DefaultS3CompatibleRegion
+	}
+	return s.Region
+}
+
+// GetDeletionPolicy returns what happens to the storage bucket when the VeleroInstall is deleted
+func (s *VeleroInstallSpec) GetDeletionPolicy() DeletionPolicy {
+	if s.DeletionPolicy == "" {
+		return DeletionPolicyRetain
+	}
+	return s.DeletionPolicy
+}
@@ -1,17 +1,323 @@
 package v1alpha2
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // VeleroInstallSpec defines the desired state of Velero
-type VeleroInstallSpec struct{}
+type VeleroInstallSpec struct {
+	// Storage defines the desired state of the storage bucket for backups
+	// +kubebuilder:default={}
+	// +optional
+	Storage StorageSpec `json:"storage,omitempty"`
+
+	// DeletionPolicy defines what happens to the storage bucket when the VeleroInstall is deleted.
+	// Retain leaves the bucket and its backups untouched, RetainAndTag also tags the bucket
+	// with the time it was orphaned, and Delete empties and removes the bucket.
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Credentials configures how Velero authenticates to the cloud provider on
+	// clusters that use short-lived credentials.
+	// +optional
+	Credentials CredentialsSpec `json:"credentials,omitempty"`
+
+	// Velero configures the Velero server deployed by the operator.
+	// +optional
+	Velero VeleroSpec `json:"velero,omitempty"`
+}
+
+// VeleroSpec configures the Velero server deployed by the operator
+type VeleroSpec struct {
+	// PodConfig configures the resources and scheduling of the Velero pod.
+	// +optional
+	PodConfig *PodConfig `json:"podConfig,omitempty"`
+}
+
+// PodConfig configures the resources and scheduling of the Velero pod. Each
+// field is merged into the pod generated by the operator, which otherwise
+// tolerates infra nodes and prefers to be scheduled on them.
+type PodConfig struct {
+	// Resources are the compute resources of the Velero container. By default,
+	// no requests or limits are set.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector restricts the nodes the Velero pod is scheduled on.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations are added to the default toleration of infra nodes.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity replaces each kind of affinity that is set (node, pod and pod
+	// anti-affinity). The default node affinity prefers infra nodes, and
+	// requires an architecture found on the worker and infra nodes.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// PriorityClassName is the priority class of the Velero pod.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// CredentialsSpec configures the cloud credentials requested for Velero
+type CredentialsSpec struct {
+	// AWS configures the IAM role that Velero assumes on AWS clusters using STS.
+	// +optional
+	AWS *AWSCredentials `json:"aws,omitempty"`
+
+	// GCP configures the service account that Velero impersonates on GCP
+	// clusters using Workload Identity Federation.
+	// +optional
+	GCP *GCPCredentials `json:"gcp,omitempty"`
+}
+
+// AWSCredentials configures the IAM role that Velero assumes with its service
+// account token on AWS clusters using STS
+type AWSCredentials struct {
+	// RoleARN is the ARN of the IAM role that Velero assumes. The role must
+	// trust the cluster's OIDC provider for the velero service account.
+	// +kubebuilder:validation:Pattern=`^arn:[^:]+:iam::[0-9]{12}:role/.+$`
+	RoleARN string `json:"roleARN"`
+}
+
+// GCPCredentials configures the service account that Velero impersonates
+// through the cluster's workload identity pool on GCP clusters using Workload
+// Identity Federation
+type GCPCredentials struct {
+	// ServiceAccountEmail is the email of the GCP service account that Velero
+	// impersonates.
+	// +kubebuilder:validation:Pattern=`^[^@]+@[^@]+\.iam\.gserviceaccount\.com$`
+	ServiceAccountEmail string `json:"serviceAccountEmail"`
+
+	// Audience is the audience of the workload identity pool provider that
+	// trusts the cluster's service account tokens, in the form
+	// //iam.googleapis.com/projects/<number>/locations/global/workloadIdentityPools/<pool>/providers/<provider>.
+	// +kubebuilder:validation:Pattern=`^//iam\.googleapis\.com/projects/[0-9]+/locations/global/workloadIdentityPools/[^/]+/providers/[^/]+$`
+	Audience string `json:"audience"`
+}
+
+// DeletionPolicy is what happens to the storage bucket when the VeleroInstall is deleted
+// +kubebuilder:validation:Enum=Retain;RetainAndTag;Delete
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain leaves the storage bucket and its backups untouched.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyRetainAndTag leaves the storage bucket in place, tagged with velero.io/orphaned-at.
+	DeletionPolicyRetainAndTag DeletionPolicy = "RetainAndTag"
+	// DeletionPolicyDelete empties and removes the storage bucket.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+)
+
+// StorageSpec defines the desired state of the storage bucket for backups
+type StorageSpec struct {
+	// RetentionDays is the number of days after which backups are expired from the storage bucket.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3650
+	// +kubebuilder:default=90
+	// +optional
+	RetentionDays int32 `json:"retentionDays,omitempty"`
+
+	// Encryption defines how the storage bucket is encrypted at rest.
+	// +kubebuilder:default={}
+	// +optional
+	Encryption StorageEncryption `json:"encryption,omitempty"`
+
+	// Versioning defines how overwritten and deleted objects are kept in the storage bucket.
+	// Versioning is enforced on AWS and GCP.
+	// +kubebuilder:default={}
+	// +optional
+	Versioning StorageVersioning `json:"versioning,omitempty"`
+
+	// Immutability protects backups from being deleted or overwritten until a
+	// retention period has passed, using S3 Object Lock. It is only supported on AWS.
+	// Object Lock can only be enabled when a bucket is created, so it can't be added
+	// to a bucket that was provisioned without it.
+	// +optional
+	Immutability *StorageImmutability `json:"immutability,omitempty"`
+
+	// Replication copies backups to a second region, so that they can still be
+	// restored if the cluster's region is unavailable. It is only supported on AWS and GCP.
+	// +optional
+	Replication *StorageReplication `json:"replication,omitempty"`
+
+	// NamePrefix is the prefix used when generating the name of a new storage bucket.
+	// A UUID is appended to the prefix, so it is limited to 27 characters.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=27
+	// +kubebuilder:validation:Pattern=`^[a-z0-9][a-z0-9-]*$`
+	// +kubebuilder:default="managed-velero-backups-"
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+
+	// Tags are additional tags (labels on GCP) applied to the storage bucket.
+	// Tags managed by the operator take precedence over these.
+	// +kubebuilder:validation:MaxProperties=40
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// S3Compatible configures an S3-compatible object store, such as MinIO, NooBaa or Ceph RGW,
+	// to hold the storage bucket. It is required on platforms without a cloud object store
+	// (BareMetal, None, VSphere and OpenStack) and ignored on all other platforms.
+	// +optional
+	S3Compatible *S3CompatibleStorage `json:"s3Compatible,omitempty"`
+
+	// ExistingBucket uses a pre-existing storage bucket instead of provisioning one.
+	// The bucket is never created, and unless it is managed, its policy is only
+	// validated and any gaps are reported in the BucketPolicyCompliant condition.
+	// +optional
+	ExistingBucket *ExistingBucket `json:"existingBucket,omitempty"`
+}
+
+// ExistingBucket identifies a pre-existing storage bucket
+type ExistingBucket struct {
+	// Name is the name of the existing storage bucket.
+	// +kubebuilder:validation:MinLength=3
+	// +kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+
+	// Region is the region the bucket is expected to be in. On AWS it defaults
+	// to the cluster's region; on other platforms the location is only checked when it is set.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Project is the GCP project the bucket is expected to belong to.
+	// It defaults to the cluster's project and is ignored on other platforms.
+	// +optional
+	Project string `json:"project,omitempty"`
+
+	// Manage allows the operator to enforce its encryption, public access,
+	// lifecycle and tagging policy on the bucket, and to apply the deletion policy to it.
+	// +kubebuilder:default=false
+	// +optional
+	Manage bool `json:"manage,omitempty"`
+}
+
+// S3CompatibleStorage defines how to reach an S3-compatible object store
+type S3CompatibleStorage struct {
+	// Endpoint is the URL of the S3-compatible API, for example https://s3.example.com.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	Endpoint string `json:"endpoint"`
+
+	// Region is the region passed to the S3-compatible API.
+	// +kubebuilder:default="us-east-1"
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// CredentialsSecretName is the name of a secret in the operator's namespace that holds
+	// the aws_access_key_id and aws_secret_access_key used by both the operator and Velero.
+	// +kubebuilder:validation:MinLength=1
+	CredentialsSecretName string `json:"credentialsSecretName"`
+}
+
+// StorageEncryptionMode is the type of server-side encryption applied to the storage bucket
+// +kubebuilder:validation:Enum=ProviderManaged;KMS
+type StorageEncryptionMode string
+
+const (
+	// StorageEncryptionModeProviderManaged encrypts the storage bucket with keys managed by the cloud provider (SSE-S3 on AWS).
+	StorageEncryptionModeProviderManaged StorageEncryptionMode = "ProviderManaged"
+	// StorageEncryptionModeKMS encrypts the storage bucket with a key held in the cloud provider's key management service (SSE-KMS on AWS, CMEK on GCP).
+	StorageEncryptionModeKMS StorageEncryptionMode = "KMS"
+)
+
+// StorageEncryption defines how the storage bucket is encrypted at rest
+type StorageEncryption struct {
+	// Mode is the type of server-side encryption applied to the storage bucket.
+	// +kubebuilder:default=ProviderManaged
+	// +optional
+	Mode StorageEncryptionMode `json:"mode,omitempty"`
+
+	// KMSKeyID is the customer-managed key used to encrypt the storage bucket when
+	// the mode is KMS. On AWS it is a KMS key ID or ARN, and the AWS-managed aws/s3
+	// key is used when it is not set. On GCP it is a Cloud KMS key name, in the form
+	// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>, and
+	// is required for the KMS mode.
+	// +kubebuilder:validation:MaxLength=2048
+	// +optional
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+}
+
+// StorageVersioning defines how overwritten and deleted objects are kept in the storage bucket
+type StorageVersioning struct {
+	// NoncurrentVersionRetentionDays is the number of days after which an object version
+	// that has been overwritten or deleted is removed from the storage bucket.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3650
+	// +kubebuilder:default=30
+	// +optional
+	NoncurrentVersionRetentionDays int32 `json:"noncurrentVersionRetentionDays,omitempty"`
+}
+
+// StorageImmutabilityMode is the Object Lock retention mode applied to backups in the storage bucket
+// +kubebuilder:validation:Enum=Governance;Compliance
+type StorageImmutabilityMode string
+
+const (
+	// StorageImmutabilityModeGovernance prevents backups from being deleted or overwritten
+	// unless the caller has been granted permission to bypass governance retention.
+	StorageImmutabilityModeGovernance StorageImmutabilityMode = "Governance"
+	// StorageImmutabilityModeCompliance prevents backups from being deleted or overwritten
+	// by anyone, including the account's root user, until the retention period has passed.
+	StorageImmutabilityModeCompliance StorageImmutabilityMode = "Compliance"
+)
+
+// StorageImmutability defines the default retention applied to backups in the storage bucket
+type StorageImmutability struct {
+	// Mode is the Object Lock retention mode applied to new backups.
+	// +kubebuilder:default=Governance
+	// +optional
+	Mode StorageImmutabilityMode `json:"mode,omitempty"`
+
+	// RetentionDays is the number of days that new backups are locked for.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3650
+	RetentionDays int32 `json:"retentionDays"`
+}
+
+// StorageReplication defines where backups in the storage bucket are replicated to
+type StorageReplication struct {
+	// Region is the region that backups are replicated to. On AWS a replica bucket
+	// is provisioned there, and S3 Cross-Region Replication copies backups to it.
+	// On GCP the storage bucket is created as a dual-region bucket spanning the
+	// cluster's region and this one, which is only possible when the bucket is created.
+	// +kubebuilder:validation:MinLength=1
+	Region string `json:"region"`
+
+	// KMSKeyID is the ARN of the KMS key used to encrypt the replica bucket on AWS.
+	// A key belongs to a single region, so it is required when the encryption
+	// mode is KMS, and ignored otherwise.
+	// +kubebuilder:validation:MaxLength=2048
+	// +kubebuilder:validation:Pattern=`^arn:[^:]+:kms:`
+	// +optional
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+
+	// Turbo enables turbo replication of a dual-region bucket on GCP, which
+	// replicates new objects between the regions within 15 minutes. It is ignored on AWS.
+	// +optional
+	Turbo bool `json:"turbo,omitempty"`
+}
 
 // VeleroInstallStatus defines the observed state of Velero
 type VeleroInstallStatus struct {
 	// StorageBucket contains details of the storage bucket for backups
 	// +optional
 	StorageBucket StorageBucket `json:"storageBucket,omitempty"`
+
+	// ObservedGeneration is the most recent generation observed by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the installation's state
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -22,6 +328,9 @@ type VeleroInstallStatus struct {
 // +kubebuilder:printcolumn:name="Bucket",type="string",JSONPath=".status.storageBucket.name",description="Name of the storage bucket"
 // +kubebuilder:printcolumn:name="Provisioned",type="boolean",JSONPath=".status.storageBucket.provisioned",description="Has the storage bucket been successfully provisioned"
 // +kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=".status.storageBucket.lastSyncTimestamp"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Message",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message",priority=1
 type VeleroInstall struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -45,11 +354,64 @@ type StorageBucket struct {
 	// +kubebuilder:validation:MaxLength=63
 	Name string `json:"name,omitempty"`
 
+	// StorageAccount is the name of the storage account that contains the storage bucket.
+	// It is only set on Azure, where the storage bucket is a blob container.
+	// +kubebuilder:validation:MaxLength=24
+	// +optional
+	StorageAccount string `json:"storageAccount,omitempty"`
+
+	// NamePrefix is the prefix that the name of the storage bucket was generated with.
+	// Buckets with this prefix are still recovered after spec.storage.namePrefix changes.
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+
 	// Provisioned is true once the bucket has been initially provisioned.
 	Provisioned bool `json:"provisioned"`
 
 	// LastSyncTimestamp is the time that the bucket policy was last synced.
 	LastSyncTimestamp *metav1.Time `json:"lastSyncTimestamp,omitempty"`
+
+	// VersioningEnabled is true if object versioning is active on the storage bucket.
+	// +optional
+	VersioningEnabled bool `json:"versioningEnabled,omitempty"`
+
+	// ObjectLock is the effective Object Lock configuration of the storage bucket.
+	// It is only set once Object Lock is found to be enabled on the bucket.
+	// +optional
+	ObjectLock *ObjectLockStatus `json:"objectLock,omitempty"`
+
+	// Replica contains details of the bucket that backups are replicated to.
+	// It is only set on AWS when replication is configured.
+	// +optional
+	Replica *ReplicaBucket `json:"replica,omitempty"`
+}
+
+// ReplicaBucket contains details of the bucket that backups are replicated to
+type ReplicaBucket struct {
+	// Name is the name of the replica bucket
+	// +kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+
+	// Region is the region of the replica bucket
+	Region string `json:"region"`
+
+	// Provisioned is true once the replica bucket has been created and
+	// replication to it has been configured.
+	Provisioned bool `json:"provisioned"`
+}
+
+// ObjectLockStatus is the effective Object Lock configuration of a storage bucket
+type ObjectLockStatus struct {
+	// Enabled is true if Object Lock is enabled on the storage bucket.
+	Enabled bool `json:"enabled"`
+
+	// Mode is the default retention mode applied to new objects, if any.
+	// +optional
+	Mode StorageImmutabilityMode `json:"mode,omitempty"`
+
+	// RetentionDays is the default number of days that new objects are locked for, if any.
+	// +optional
+	RetentionDays int32 `json:"retentionDays,omitempty"`
 }
 
 func init() {
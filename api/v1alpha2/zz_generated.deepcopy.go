@@ -5,9 +5,165 @@
 package v1alpha2
 
 import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSCredentials) DeepCopyInto(out *AWSCredentials) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSCredentials.
+func (in *AWSCredentials) DeepCopy() *AWSCredentials {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSCredentials)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CredentialsSpec) DeepCopyInto(out *CredentialsSpec) {
+	*out = *in
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSCredentials)
+		**out = **in
+	}
+	if in.GCP != nil {
+		in, out := &in.GCP, &out.GCP
+		*out = new(GCPCredentials)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CredentialsSpec.
+func (in *CredentialsSpec) DeepCopy() *CredentialsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CredentialsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExistingBucket) DeepCopyInto(out *ExistingBucket) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExistingBucket.
+func (in *ExistingBucket) DeepCopy() *ExistingBucket {
+	if in == nil {
+		return nil
+	}
+	out := new(ExistingBucket)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPCredentials) DeepCopyInto(out *GCPCredentials) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPCredentials.
+func (in *GCPCredentials) DeepCopy() *GCPCredentials {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPCredentials)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectLockStatus) DeepCopyInto(out *ObjectLockStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectLockStatus.
+func (in *ObjectLockStatus) DeepCopy() *ObjectLockStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectLockStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodConfig) DeepCopyInto(out *PodConfig) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(v1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodConfig.
+func (in *PodConfig) DeepCopy() *PodConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PodConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplicaBucket) DeepCopyInto(out *ReplicaBucket) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReplicaBucket.
+func (in *ReplicaBucket) DeepCopy() *ReplicaBucket {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplicaBucket)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3CompatibleStorage) DeepCopyInto(out *S3CompatibleStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3CompatibleStorage.
+func (in *S3CompatibleStorage) DeepCopy() *S3CompatibleStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(S3CompatibleStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StorageBucket) DeepCopyInto(out *StorageBucket) {
 	*out = *in
@@ -15,6 +171,16 @@ func (in *StorageBucket) DeepCopyInto(out *StorageBucket) {
 		in, out := &in.LastSyncTimestamp, &out.LastSyncTimestamp
 		*out = (*in).DeepCopy()
 	}
+	if in.ObjectLock != nil {
+		in, out := &in.ObjectLock, &out.ObjectLock
+		*out = new(ObjectLockStatus)
+		**out = **in
+	}
+	if in.Replica != nil {
+		in, out := &in.Replica, &out.Replica
+		*out = new(ReplicaBucket)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageBucket.
@@ -27,12 +193,116 @@ func (in *StorageBucket) DeepCopy() *StorageBucket {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageEncryption) DeepCopyInto(out *StorageEncryption) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageEncryption.
+func (in *StorageEncryption) DeepCopy() *StorageEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageImmutability) DeepCopyInto(out *StorageImmutability) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageImmutability.
+func (in *StorageImmutability) DeepCopy() *StorageImmutability {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageImmutability)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageReplication) DeepCopyInto(out *StorageReplication) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageReplication.
+func (in *StorageReplication) DeepCopy() *StorageReplication {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageReplication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageSpec) DeepCopyInto(out *StorageSpec) {
+	*out = *in
+	out.Encryption = in.Encryption
+	out.Versioning = in.Versioning
+	if in.Immutability != nil {
+		in, out := &in.Immutability, &out.Immutability
+		*out = new(StorageImmutability)
+		**out = **in
+	}
+	if in.Replication != nil {
+		in, out := &in.Replication, &out.Replication
+		*out = new(StorageReplication)
+		**out = **in
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.S3Compatible != nil {
+		in, out := &in.S3Compatible, &out.S3Compatible
+		*out = new(S3CompatibleStorage)
+		**out = **in
+	}
+	if in.ExistingBucket != nil {
+		in, out := &in.ExistingBucket, &out.ExistingBucket
+		*out = new(ExistingBucket)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageSpec.
+func (in *StorageSpec) DeepCopy() *StorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageVersioning) DeepCopyInto(out *StorageVersioning) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageVersioning.
+func (in *StorageVersioning) DeepCopy() *StorageVersioning {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageVersioning)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VeleroInstall) DeepCopyInto(out *VeleroInstall) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -89,6 +359,9 @@ func (in *VeleroInstallList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VeleroInstallSpec) DeepCopyInto(out *VeleroInstallSpec) {
 	*out = *in
+	in.Storage.DeepCopyInto(&out.Storage)
+	in.Credentials.DeepCopyInto(&out.Credentials)
+	in.Velero.DeepCopyInto(&out.Velero)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VeleroInstallSpec.
@@ -105,6 +378,13 @@ func (in *VeleroInstallSpec) DeepCopy() *VeleroInstallSpec {
 func (in *VeleroInstallStatus) DeepCopyInto(out *VeleroInstallStatus) {
 	*out = *in
 	in.StorageBucket.DeepCopyInto(&out.StorageBucket)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VeleroInstallStatus.
@@ -116,3 +396,23 @@ func (in *VeleroInstallStatus) DeepCopy() *VeleroInstallStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VeleroSpec) DeepCopyInto(out *VeleroSpec) {
+	*out = *in
+	if in.PodConfig != nil {
+		in, out := &in.PodConfig, &out.PodConfig
+		*out = new(PodConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VeleroSpec.
+func (in *VeleroSpec) DeepCopy() *VeleroSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VeleroSpec)
+	in.DeepCopyInto(out)
+	return out
+}
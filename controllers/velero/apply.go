@@ -0,0 +1,28 @@
+package velero
+
+import (
+	"context"
+
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	"github.com/openshift/managed-velero-operator/pkg/ensure"
+
+	"github.com/go-logr/logr"
+)
+
+// fieldManager is the field manager the operator applies its objects as
+const fieldManager = "managed-velero-operator"
+
+// ensureObject ensures an object that makes up the Velero install, reporting
+// its result with Events on the instance. Unless the object's ownership says
+// otherwise, the instance is set as its controller.
+func (r *VeleroInstallReconciler) ensureObject(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall, object ensure.Object) error {
+	engine := &ensure.Engine{
+		Client:       r.Client,
+		Scheme:       r.Scheme,
+		Recorder:     r.Recorder,
+		Owner:        instance,
+		FieldManager: fieldManager,
+		Log:          reqLogger,
+	}
+	return engine.EnsureObject(context.TODO(), object).Err
+}
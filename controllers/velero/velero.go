@@ -2,11 +2,18 @@ package velero
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"reflect"
+	"sort"
 	"strings"
 
 	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	"github.com/openshift/managed-velero-operator/pkg/ensure"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+	"github.com/openshift/managed-velero-operator/pkg/storage/gcs"
+	"github.com/openshift/managed-velero-operator/pkg/storage/s3"
 	"github.com/openshift/managed-velero-operator/version"
 
 	configv1 "github.com/openshift/api/config/v1"
@@ -22,11 +29,11 @@ import (
 	endpoints "github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
 	runtimeClient "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -34,264 +41,470 @@ const (
 	awsCredsSecretIDKey     = "aws_access_key_id"     // #nosec G101
 	awsCredsSecretAccessKey = "aws_secret_access_key" // #nosec G101
 
+	azureCredsSecretSubscriptionIDKey = "azure_subscription_id"
+	azureCredsSecretTenantIDKey       = "azure_tenant_id"
+	azureCredsSecretClientIDKey       = "azure_client_id"
+	azureCredsSecretClientSecretKey   = "azure_client_secret" // #nosec G101
+	azureCredsSecretResourceGroupKey  = "azure_resourcegroup"
+
 	veleroImageRegistry = "registry.redhat.io/oadp"
 
 	credentialsRequestName = "velero-iam-credentials" // #nosec G101
+
+	// The projected service account token that Velero exchanges with the
+	// cloud provider on clusters using short-lived credentials
+	cloudTokenDir  = "/var/run/secrets/openshift/serviceaccount"
+	cloudTokenPath = cloudTokenDir + "/token"
+
+	// The ConfigMap the cluster's trusted CA bundle is injected into
+	trustedCABundleName = "trusted-ca-bundle"
+
+	// The name of the cluster-wide configuration objects, like the
+	// Infrastructure and Proxy
+	clusterConfigName = "cluster"
+
+	// The annotation on the Velero pod template holding the hash of the
+	// inputs that the pod only reads at startup, so that the pod is rolled
+	// when they change
+	podInputsHashAnnotation = "managed.openshift.io/inputs-hash"
+
+	// The roles of the nodes that the Velero pod may run on
+	nodeRoleWorkerLabel = "node-role.kubernetes.io/worker"
+	nodeRoleInfraLabel  = "node-role.kubernetes.io/infra"
 )
 
+// supportedArchitectures are the node architectures the Velero images are built for
+var supportedArchitectures = []string{"amd64", "arm64", "ppc64le", "s390x"}
+
 func (r *VeleroInstallReconciler) provisionVelero(reqLogger logr.Logger, namespace string, platformStatus *configv1.PlatformStatus, instance *veleroInstallCR.VeleroInstall) (reconcile.Result, error) {
 	var err error
 
-	var locationConfig map[string]string
+	// Keep a copy of the status as read, so it is only written back if it changed
+	origStatus := instance.Status.DeepCopy()
+
+	provider := strings.ToLower(string(r.driver.GetPlatformType()))
+	credentialsSecretName := credentialsRequestName
+	s3Compatible := false
+	webIdentity := false
+
+	// On clusters using short-lived credentials, Velero authenticates with
+	// its service account token instead of a long-lived key
+	shortLived, err := r.shortLivedCredentialsEnabled()
+	if err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonPlatformError, err)
+	}
+
+	var locationConfig, snapshotLocationConfig map[string]string
 	switch r.driver.GetPlatformType() {
 	case configv1.AWSPlatformType:
 		locationConfig = map[string]string{
 			"region": platformStatus.AWS.Region,
 		}
+		snapshotLocationConfig = map[string]string{
+			"region": platformStatus.AWS.Region,
+		}
+		// Snapshots carry the cluster's user tags, like its volumes do
+		if len(platformStatus.AWS.ResourceTags) > 0 {
+			tags := make(map[string]string, len(platformStatus.AWS.ResourceTags))
+			for _, tag := range platformStatus.AWS.ResourceTags {
+				tags[tag.Key] = tag.Value
+			}
+			snapshotLocationConfig["tags"] = snapshotTags(tags)
+		}
+		// An existing bucket may be in a different region to the cluster's volumes
+		if existingBucket := instance.Spec.Storage.ExistingBucket; existingBucket != nil && existingBucket.Region != "" {
+			locationConfig["region"] = existingBucket.Region
+		}
+		// On clusters using STS, Velero assumes a role with its service account token
+		if shortLived {
+			if instance.Spec.Credentials.AWS == nil {
+				return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonUnsupportedConfiguration,
+					fmt.Errorf("spec.credentials.aws.roleARN must be set on clusters using AWS STS"))
+			}
+			webIdentity = true
+		}
 	case configv1.GCPPlatformType:
 		// No region configuration needed for GCP
+
+		// Snapshots carry the cluster's user labels, like its disks do
+		resourceLabels, err := gcs.ResourceLabels(context.TODO(), r.Client)
+		if err != nil {
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonPlatformError, err)
+		}
+		if len(resourceLabels) > 0 {
+			snapshotLocationConfig = map[string]string{
+				"labels": snapshotTags(gcs.SanitizeLabels(resourceLabels)),
+			}
+		}
+
+		// On clusters using Workload Identity Federation, Velero impersonates a
+		// service account with its service account token
+		if shortLived {
+			if instance.Spec.Credentials.GCP == nil {
+				return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonUnsupportedConfiguration,
+					fmt.Errorf("spec.credentials.gcp must be set on clusters using GCP Workload Identity Federation"))
+			}
+			webIdentity = true
+		}
+	case configv1.AzurePlatformType:
+		// The storage bucket is a container in a storage account, and
+		// snapshots are kept in the cluster's resource group
+		locationConfig = map[string]string{
+			"resourceGroup":  platformStatus.Azure.ResourceGroupName,
+			"storageAccount": instance.Status.StorageBucket.StorageAccount,
+		}
+		snapshotLocationConfig = map[string]string{
+			"resourceGroup": platformStatus.Azure.ResourceGroupName,
+		}
+	case configv1.BareMetalPlatformType, configv1.NonePlatformType, configv1.VSpherePlatformType, configv1.OpenStackPlatformType:
+		// The storage bucket is in a user-supplied S3-compatible object
+		// store, which Velero reaches through the AWS plugin
+		storageConfig := instance.Spec.Storage.S3Compatible
+		if storageConfig == nil {
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonUnsupportedConfiguration,
+				fmt.Errorf("spec.storage.s3Compatible must be set on platform %v", r.driver.GetPlatformType()))
+		}
+		provider = "aws"
+		credentialsSecretName = storageConfig.CredentialsSecretName
+		s3Compatible = true
+		locationConfig = map[string]string{
+			"region":           storageConfig.GetRegion(),
+			"s3Url":            storageConfig.Endpoint,
+			"s3ForcePathStyle": "true",
+		}
 	default:
-		return reconcile.Result{}, fmt.Errorf("unable to determine platform")
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonPlatformError, fmt.Errorf("unable to determine platform"))
 	}
 
-	provider := strings.ToLower(string(r.driver.GetPlatformType()))
+	// Have Velero write backups with the customer-managed key
+	if kmsKeyID := instance.Spec.Storage.GetKMSKeyID(); kmsKeyID != "" {
+		switch provider {
+		case "aws":
+			locationConfig["kmsKeyId"] = kmsKeyID
+		case "gcp":
+			locationConfig = map[string]string{
+				"kmsKeyName": kmsKeyID,
+			}
+		}
+	}
 
 	// Install BackupStorageLocation
-	foundBsl := &velerov1.BackupStorageLocation{}
 	var caCertData []byte
 	bsl := veleroInstall.BackupStorageLocation(namespace, provider, instance.Status.StorageBucket.Name, "", locationConfig, caCertData)
-	if err = r.Get(context.TODO(), runtimeClient.ObjectKeyFromObject(bsl), foundBsl); err != nil {
-		if errors.IsNotFound(err) {
-			// Didn't find BackupStorageLocation
-			reqLogger.Info("Creating BackupStorageLocation")
-			if err := controllerutil.SetControllerReference(instance, bsl, r.Scheme); err != nil {
-				return reconcile.Result{}, err
-			}
-			if err = r.Create(context.TODO(), bsl); err != nil {
-				return reconcile.Result{}, err
-			}
-		} else {
-			return reconcile.Result{}, err
-		}
+	if err = r.ensureObject(reqLogger, instance, ensure.Object{Desired: bsl}); err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonStorageLocationFailed, err)
+	}
+
+	// Install a read-only BackupStorageLocation for the replica bucket, so
+	// that backups can be restored from it if the cluster's region is lost
+	if replicaBsl := replicaBackupStorageLocation(namespace, provider, instance, locationConfig); replicaBsl != nil {
+		err = r.ensureObject(reqLogger, instance, ensure.Object{Desired: replicaBsl})
 	} else {
-		// BackupStorageLocation exists, check if it's updated.
-		if !reflect.DeepEqual(foundBsl.Spec, bsl.Spec) {
-			// Specs aren't equal, update and fix.
-			reqLogger.Info("Updating BackupStorageLocation", "foundBsl.Spec", foundBsl.Spec, "bsl.Spec", bsl.Spec)
-			foundBsl.Spec = *bsl.Spec.DeepCopy()
-			if err = r.Update(context.TODO(), foundBsl); err != nil {
-				return reconcile.Result{}, err
-			}
-		}
+		err = r.removeBackupStorageLocation(reqLogger, instance, namespace, storageConstants.ReplicaVeleroBackupStorageLocation)
+	}
+	if err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonStorageLocationFailed, err)
 	}
 
 	// Install VolumeSnapshotLocation
-	foundVsl := &velerov1.VolumeSnapshotLocation{}
-	vsl := veleroInstall.VolumeSnapshotLocation(namespace, provider, locationConfig)
-	if err = r.Get(context.TODO(), runtimeClient.ObjectKeyFromObject(vsl), foundVsl); err != nil {
-		if errors.IsNotFound(err) {
-			// Didn't find VolumeSnapshotLocation
-			reqLogger.Info("Creating VolumeSnapshotLocation")
-			if err := controllerutil.SetControllerReference(instance, vsl, r.Scheme); err != nil {
-				return reconcile.Result{}, err
-			}
-			if err = r.Create(context.TODO(), vsl); err != nil {
-				return reconcile.Result{}, err
-			}
-		} else {
-			return reconcile.Result{}, err
-		}
-	} else {
-		// VolumeSnapshotLocation exists, check if it's updated.
-		if !reflect.DeepEqual(foundVsl.Spec, vsl.Spec) {
-			// Specs aren't equal, update and fix.
-			reqLogger.Info("Updating VolumeSnapshotLocation", "foundVsl.Spec", foundVsl.Spec, "vsl.Spec", vsl.Spec)
-			foundVsl.Spec = *vsl.Spec.DeepCopy()
-			if err = r.Update(context.TODO(), foundVsl); err != nil {
-				return reconcile.Result{}, err
-			}
+	// There is no snapshot provider for volumes on S3-compatible platforms
+	if !s3Compatible {
+		vsl := veleroInstall.VolumeSnapshotLocation(namespace, provider, snapshotLocationConfig)
+		if err = r.ensureObject(reqLogger, instance, ensure.Object{Desired: vsl}); err != nil {
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonStorageLocationFailed, err)
 		}
 	}
 
 	// Install CredentialsRequest
-	foundCr := &minterv1.CredentialsRequest{}
-	var cr *minterv1.CredentialsRequest
-	switch r.driver.GetPlatformType() {
-	case configv1.AWSPlatformType:
-		partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), locationConfig["region"])
-		if !ok {
-			return reconcile.Result{}, fmt.Errorf("no partition found for region %q", locationConfig["region"])
-		}
-		cr = awsCredentialsRequest(namespace, credentialsRequestName, partition.ID(), instance.Status.StorageBucket.Name)
-	case configv1.GCPPlatformType:
-		cr = gcpCredentialsRequest(namespace, credentialsRequestName)
-	default:
-		return reconcile.Result{}, fmt.Errorf("unable to determine platform")
-	}
-	if err = r.Get(context.TODO(), runtimeClient.ObjectKeyFromObject(cr), foundCr); err != nil {
-		if errors.IsNotFound(err) {
-			// Didn't find CredentialsRequest
-			reqLogger.Info("Creating CredentialsRequest")
-			if err := controllerutil.SetControllerReference(instance, cr, r.Scheme); err != nil {
-				return reconcile.Result{}, err
+	// The credentials for an S3-compatible object store are supplied by the
+	// user, so there is nothing for the cloud-credential-operator to mint
+	if s3Compatible {
+		instance.SetCondition(veleroInstallCR.ConditionCredentialsReady, metav1.ConditionTrue, veleroInstallCR.ReasonCredentialsProvided,
+			fmt.Sprintf("Credentials are read from secret %v", credentialsSecretName))
+	} else {
+		var cr *minterv1.CredentialsRequest
+		switch r.driver.GetPlatformType() {
+		case configv1.AWSPlatformType:
+			partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), locationConfig["region"])
+			if !ok {
+				return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonCredentialsRequestFailed, fmt.Errorf("no partition found for region %q", locationConfig["region"]))
 			}
-			if err = r.Create(context.TODO(), cr); err != nil {
-				return reconcile.Result{}, err
+			var kmsKeyARN string
+			if kmsKeyID := instance.Spec.Storage.GetKMSKeyID(); kmsKeyID != "" {
+				kmsKeyARN = s3.KMSKeyARN(partition.ID(), locationConfig["region"], kmsKeyID)
 			}
-		} else {
-			return reconcile.Result{}, err
+			// Velero reads backups from the replica bucket through the read-only location
+			var replicaBucketName, replicaKMSKeyARN string
+			if replica := instance.Status.StorageBucket.Replica; replica != nil {
+				replicaBucketName = replica.Name
+				replicaKMSKeyARN = instance.Spec.Storage.GetReplicaKMSKeyID()
+			}
+			cr = awsCredentialsRequest(namespace, credentialsRequestName, partition.ID(), instance.Status.StorageBucket.Name, kmsKeyARN,
+				replicaBucketName, replicaKMSKeyARN, instance.Spec.Credentials.AWS, webIdentity)
+		case configv1.GCPPlatformType:
+			cr = gcpCredentialsRequest(namespace, credentialsRequestName, instance.Spec.Credentials.GCP, webIdentity)
+		case configv1.AzurePlatformType:
+			cr = azureCredentialsRequest(namespace, credentialsRequestName)
+		default:
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonCredentialsRequestFailed, fmt.Errorf("unable to determine platform"))
 		}
-	} else {
-		// CredentialsRequest exists, check if it's updated.
-		crEqual, err := credentialsRequestSpecEqual(foundCr.Spec, cr.Spec)
+		var tokenPath string
+		if webIdentity {
+			tokenPath = cloudTokenPath
+		}
+		crObj, err := credentialsRequestObject(cr, tokenPath)
 		if err != nil {
-			return reconcile.Result{}, err
-		}
-		if !crEqual {
-			// Specs aren't equal, update and fix.
-			reqLogger.Info("Updating CredentialsRequest", "foundCr.Spec", foundCr.Spec, "cr.Spec", cr.Spec)
-			foundCr.Spec = *cr.Spec.DeepCopy()
-			if err = r.Update(context.TODO(), foundCr); err != nil {
-				return reconcile.Result{}, err
-			}
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonCredentialsRequestFailed, err)
+		}
+		if err = r.ensureObject(reqLogger, instance, ensure.Object{Desired: crObj}); err != nil {
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonCredentialsRequestFailed, err)
 		}
+		foundCr := &minterv1.CredentialsRequest{}
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(crObj.Object, foundCr); err != nil {
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionCredentialsReady, veleroInstallCR.ReasonCredentialsRequestFailed, err)
+		}
+
+		setCredentialsReadyCondition(instance, foundCr)
 	}
 
 	// Install Deployment
-	foundDeployment := &appsv1.Deployment{}
-	deployment := veleroDeployment(namespace, r.driver.GetPlatformType(), veleroImageRegistry)
-	if err = r.Get(context.TODO(), runtimeClient.ObjectKeyFromObject(deployment), foundDeployment); err != nil {
-		if errors.IsNotFound(err) {
-			// Didn't find Deployment
-			reqLogger.Info("Creating Deployment")
-			if err := controllerutil.SetControllerReference(instance, deployment, r.Scheme); err != nil {
-				return reconcile.Result{}, err
-			}
-			if err = r.Create(context.TODO(), deployment); err != nil {
-				return reconcile.Result{}, err
-			}
-		} else {
-			return reconcile.Result{}, err
-		}
-	} else {
-		// Deployment exists, check if it's updated.
-		if !reflect.DeepEqual(foundDeployment.Spec, deployment.Spec) {
-			// Specs aren't equal, update and fix.
-			reqLogger.Info("Updating Deployment", "foundDeployment.Spec", foundDeployment.Spec, "deployment.Spec", deployment.Spec)
-			foundDeployment.Spec = *deployment.Spec.DeepCopy()
-			if err = r.Update(context.TODO(), foundDeployment); err != nil {
-				return reconcile.Result{}, err
-			}
-		}
+	architectures, err := r.nodeArchitectures()
+	if err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionVeleroSchedulable, veleroInstallCR.ReasonDeploymentFailed, err)
+	}
+	setSchedulableCondition(instance, architectures)
+	if len(architectures) == 0 {
+		// Let the pod schedule as soon as a suitable node joins the cluster
+		architectures = supportedArchitectures
+	}
+	deployment := veleroDeployment(namespace, platformStatus, credentialsSecretName, veleroImageRegistry, webIdentity, architectures)
+	applyPodConfig(deployment, instance.Spec.Velero.PodConfig)
+	proxy, err := r.clusterProxy()
+	if err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionVeleroDeploymentAvailable, veleroInstallCR.ReasonDeploymentFailed, err)
+	}
+	applyProxy(deployment, proxy)
+	inputsHash, err := r.podInputsHash(namespace, credentialsSecretName)
+	if err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionVeleroDeploymentAvailable, veleroInstallCR.ReasonDeploymentFailed, err)
+	}
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = make(map[string]string, 1)
+	}
+	deployment.Spec.Template.Annotations[podInputsHashAnnotation] = inputsHash
+	// The metrics Service is built from the desired pod template, before the
+	// deployment is overwritten with the applied object
+	service := metricsServiceFromDeployment(deployment)
+	if err = r.ensureObject(reqLogger, instance, ensure.Object{Desired: deployment}); err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionVeleroDeploymentAvailable, veleroInstallCR.ReasonDeploymentFailed, err)
 	}
 
+	setDeploymentAvailableCondition(instance, deployment)
+
 	// Install Metrics Service
-	foundService := &corev1.Service{}
-	service := metricsServiceFromDeployment(deployment)
-	if err = r.Get(context.TODO(), runtimeClient.ObjectKeyFromObject(service), foundService); err != nil {
-		if errors.IsNotFound(err) {
-			// Didn't find Service
-			reqLogger.Info("Creating Service")
-			if err := controllerutil.SetControllerReference(instance, service, r.Scheme); err != nil {
-				return reconcile.Result{}, err
-			}
-			if err = r.Create(context.TODO(), service); err != nil {
-				return reconcile.Result{}, err
-			}
-			// We need a populated foundService (with a UID) to generate the
-			// ServiceMonitor below, so requeue and fetch it on the next pass.
-			return reconcile.Result{Requeue: true}, nil
+	// The cluster IP and IP family fields are allocated by the API server,
+	// and aren't applied, so they are left alone.
+	if err = r.ensureObject(reqLogger, instance, ensure.Object{Desired: service}); err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonMetricsFailed, err)
+	}
+
+	// Install Metrics ServiceMonitor
+	// The applied Service has a UID, so the ServiceMonitor can be owned by it.
+	serviceMonitor := generateServiceMonitor(service)
+	if err = r.ensureObject(reqLogger, instance, ensure.Object{Desired: serviceMonitor, Ownership: ensure.OwnershipAsIs}); err != nil {
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonMetricsFailed, err)
+	}
+
+	setReadyCondition(instance)
+	return reconcile.Result{}, r.updateStatusIfChanged(reqLogger, instance, origStatus)
+}
+
+// setCredentialsReadyCondition reports whether the cloud-credential-operator
+// has provisioned the credentials for the given CredentialsRequest.
+func setCredentialsReadyCondition(instance *veleroInstallCR.VeleroInstall, cr *minterv1.CredentialsRequest) {
+	if cr.Status.Provisioned {
+		instance.SetCondition(veleroInstallCR.ConditionCredentialsReady, metav1.ConditionTrue, veleroInstallCR.ReasonCredentialsProvisioned,
+			fmt.Sprintf("Credentials for CredentialsRequest %v have been provisioned", credentialsRequestName))
+		return
+	}
+	for _, condition := range cr.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue &&
+			(condition.Type == minterv1.CredentialsProvisionFailure || condition.Type == minterv1.InsufficientCloudCredentials) {
+			instance.SetCondition(veleroInstallCR.ConditionCredentialsReady, metav1.ConditionFalse, veleroInstallCR.ReasonCredentialsRequestFailed, condition.Message)
+			return
 		}
-		return reconcile.Result{}, err
 	}
-	// Service exists, check if it's updated.
-	// Note: We leave fields related to cluster IP address, IPv6, and
-	// InternalTrafficPolicy alone.
-	service.Spec.ClusterIP = foundService.Spec.ClusterIP
-	service.Spec.ClusterIPs = foundService.Spec.ClusterIPs
-	service.Spec.IPFamilies = foundService.Spec.IPFamilies
-	service.Spec.IPFamilyPolicy = foundService.Spec.IPFamilyPolicy
-	service.Spec.InternalTrafficPolicy = foundService.Spec.InternalTrafficPolicy
-	if !reflect.DeepEqual(foundService.Spec, service.Spec) {
-		// Specs aren't equal, update and fix.
-		reqLogger.Info("Updating Service", "foundService.Spec", foundService.Spec, "service.Spec", service.Spec)
-		foundService.Spec = *service.Spec.DeepCopy()
-		if err = r.Update(context.TODO(), foundService); err != nil {
-			return reconcile.Result{}, err
+	instance.SetCondition(veleroInstallCR.ConditionCredentialsReady, metav1.ConditionFalse, veleroInstallCR.ReasonCredentialsPending,
+		fmt.Sprintf("Waiting for the cloud-credential-operator to provision CredentialsRequest %v", credentialsRequestName))
+}
+
+// setDeploymentAvailableCondition reports whether the Velero deployment is available.
+func setDeploymentAvailableCondition(instance *veleroInstallCR.VeleroInstall, deployment *appsv1.Deployment) {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type != appsv1.DeploymentAvailable {
+			continue
+		}
+		if condition.Status == corev1.ConditionTrue {
+			instance.SetCondition(veleroInstallCR.ConditionVeleroDeploymentAvailable, metav1.ConditionTrue, veleroInstallCR.ReasonDeploymentAvailable, condition.Message)
+			return
 		}
+		instance.SetCondition(veleroInstallCR.ConditionVeleroDeploymentAvailable, metav1.ConditionFalse, veleroInstallCR.ReasonDeploymentUnavailable, condition.Message)
+		return
 	}
+	instance.SetCondition(veleroInstallCR.ConditionVeleroDeploymentAvailable, metav1.ConditionFalse, veleroInstallCR.ReasonDeploymentUnavailable,
+		"Waiting for the Velero deployment to become available")
+}
 
-	// Install Metrics ServiceMonitor
-	foundServiceMonitor := &monitoringv1.ServiceMonitor{}
-	serviceMonitor := generateServiceMonitor(foundService)
-	if err = r.Get(context.TODO(), runtimeClient.ObjectKeyFromObject(serviceMonitor), foundServiceMonitor); err != nil {
-		if errors.IsNotFound(err) {
-			// Didn't find ServiceMonitor
-			reqLogger.Info("Creating ServiceMonitor")
-			// Note, generateServiceMonitor already set an owner reference.
-			if err = r.Create(context.TODO(), serviceMonitor); err != nil {
-				return reconcile.Result{}, err
-			}
-		} else {
-			return reconcile.Result{}, err
+// nodeArchitectures returns the architectures of the worker and infra nodes
+// that the Velero images are built for, sorted by name.
+func (r *VeleroInstallReconciler) nodeArchitectures() ([]string, error) {
+	nodes := &corev1.NodeList{}
+	if err := r.List(context.TODO(), nodes); err != nil {
+		return nil, fmt.Errorf("unable to list nodes: %v", err)
+	}
+	return schedulableArchitectures(nodes.Items), nil
+}
+
+// schedulableArchitectures returns the supported architectures of the worker
+// and infra nodes in the list, sorted by name. Nodes without the architecture
+// label fall back to the architecture reported by their kubelet.
+func schedulableArchitectures(nodes []corev1.Node) []string {
+	found := sets.NewString()
+	for _, node := range nodes {
+		_, worker := node.Labels[nodeRoleWorkerLabel]
+		_, infra := node.Labels[nodeRoleInfraLabel]
+		if !worker && !infra {
+			continue
 		}
-	} else {
-		// ServiceMonitor exists, check if it's updated.
-		if !reflect.DeepEqual(foundServiceMonitor.Spec, serviceMonitor.Spec) {
-			// Specs aren't equal, update and fix.
-			reqLogger.Info("Updating ServiceMonitor", "foundServiceMonitor.Spec", foundServiceMonitor.Spec, "serviceMonitor.Spec", serviceMonitor.Spec)
-			foundServiceMonitor.Spec = *serviceMonitor.Spec.DeepCopy()
-			if err = r.Update(context.TODO(), foundServiceMonitor); err != nil {
-				return reconcile.Result{}, err
-			}
+		arch := node.Labels[corev1.LabelArchStable]
+		if arch == "" {
+			arch = node.Status.NodeInfo.Architecture
 		}
+		found.Insert(arch)
+	}
+	return found.Intersection(sets.NewString(supportedArchitectures...)).List()
+}
+
+// setSchedulableCondition reports whether there are nodes of an architecture
+// that the Velero pod can run on.
+func setSchedulableCondition(instance *veleroInstallCR.VeleroInstall, architectures []string) {
+	if len(architectures) == 0 {
+		instance.SetCondition(veleroInstallCR.ConditionVeleroSchedulable, metav1.ConditionFalse, veleroInstallCR.ReasonNoSuitableNodes,
+			fmt.Sprintf("No worker or infra nodes have an architecture that Velero supports (%v)", strings.Join(supportedArchitectures, ", ")))
+		return
 	}
+	instance.SetCondition(veleroInstallCR.ConditionVeleroSchedulable, metav1.ConditionTrue, veleroInstallCR.ReasonSuitableNodesFound,
+		fmt.Sprintf("Velero can run on %v nodes", strings.Join(architectures, ", ")))
+}
 
-	return reconcile.Result{}, nil
+// setReadyCondition summarises the component conditions into the Ready condition.
+func setReadyCondition(instance *veleroInstallCR.VeleroInstall) {
+	var notReady []string
+	for _, conditionType := range []string{
+		veleroInstallCR.ConditionBucketProvisioned,
+		veleroInstallCR.ConditionBucketPolicyEnforced,
+		veleroInstallCR.ConditionCredentialsReady,
+		veleroInstallCR.ConditionVeleroDeploymentAvailable,
+	} {
+		if !instance.IsConditionTrue(conditionType) {
+			notReady = append(notReady, conditionType)
+		}
+	}
+	if len(notReady) > 0 {
+		instance.SetCondition(veleroInstallCR.ConditionReady, metav1.ConditionFalse, veleroInstallCR.ReasonComponentsNotReady,
+			fmt.Sprintf("Waiting for %v", strings.Join(notReady, ", ")))
+		return
+	}
+	instance.SetCondition(veleroInstallCR.ConditionReady, metav1.ConditionTrue, veleroInstallCR.ReasonInstallationComplete, "Velero is installed and configured")
 }
 
-func awsCredentialsRequest(namespace, name, partitionID, bucketName string) *minterv1.CredentialsRequest {
+func awsCredentialsRequest(namespace, name, partitionID, bucketName, kmsKeyARN, replicaBucketName, replicaKMSKeyARN string, awsCredentials *veleroInstallCR.AWSCredentials, webIdentity bool) *minterv1.CredentialsRequest {
+	statementEntries := []minterv1.StatementEntry{
+		{
+			Effect: "Allow",
+			Action: []string{
+				"ec2:DescribeVolumes",
+				"ec2:DescribeSnapshots",
+				"ec2:CreateTags",
+				"ec2:CreateVolume",
+				"ec2:CreateSnapshot",
+				"ec2:DeleteSnapshot",
+			},
+			Resource: "*",
+		},
+		{
+			Effect: "Allow",
+			Action: []string{
+				"s3:GetObject",
+				"s3:DeleteObject",
+				"s3:PutObject",
+				"s3:AbortMultipartUpload",
+				"s3:ListMultipartUploadParts",
+			},
+			Resource: fmt.Sprintf("arn:%s:s3:::%s/*", partitionID, bucketName),
+		},
+		{
+			Effect: "Allow",
+			Action: []string{
+				"s3:ListBucket",
+			},
+			Resource: fmt.Sprintf("arn:%s:s3:::%s", partitionID, bucketName),
+		},
+	}
+
+	// Objects in a bucket encrypted with a customer-managed key are read and
+	// written through that key
+	if kmsKeyARN != "" {
+		statementEntries = append(statementEntries, minterv1.StatementEntry{
+			Effect: "Allow",
+			Action: []string{
+				"kms:Encrypt",
+				"kms:Decrypt",
+				"kms:GenerateDataKey",
+			},
+			Resource: kmsKeyARN,
+		})
+	}
+
+	// Backups in the replica bucket are only ever read
+	if replicaBucketName != "" {
+		statementEntries = append(statementEntries,
+			minterv1.StatementEntry{
+				Effect: "Allow",
+				Action: []string{
+					"s3:GetObject",
+				},
+				Resource: fmt.Sprintf("arn:%s:s3:::%s/*", partitionID, replicaBucketName),
+			},
+			minterv1.StatementEntry{
+				Effect: "Allow",
+				Action: []string{
+					"s3:ListBucket",
+				},
+				Resource: fmt.Sprintf("arn:%s:s3:::%s", partitionID, replicaBucketName),
+			},
+		)
+		if replicaKMSKeyARN != "" {
+			statementEntries = append(statementEntries, minterv1.StatementEntry{
+				Effect: "Allow",
+				Action: []string{
+					"kms:Decrypt",
+				},
+				Resource: replicaKMSKeyARN,
+			})
+		}
+	}
+
 	codec, _ := minterv1.NewCodec()
 	provSpec, _ := codec.EncodeProviderSpec(
 		&minterv1.AWSProviderSpec{
 			TypeMeta: metav1.TypeMeta{
 				Kind: "AWSProviderSpec",
 			},
-			StatementEntries: []minterv1.StatementEntry{
-				{
-					Effect: "Allow",
-					Action: []string{
-						"ec2:DescribeVolumes",
-						"ec2:DescribeSnapshots",
-						"ec2:CreateTags",
-						"ec2:CreateVolume",
-						"ec2:CreateSnapshot",
-						"ec2:DeleteSnapshot",
-					},
-					Resource: "*",
-				},
-				{
-					Effect: "Allow",
-					Action: []string{
-						"s3:GetObject",
-						"s3:DeleteObject",
-						"s3:PutObject",
-						"s3:AbortMultipartUpload",
-						"s3:ListMultipartUploadParts",
-					},
-					Resource: fmt.Sprintf("arn:%s:s3:::%s/*", partitionID, bucketName),
-				},
-				{
-					Effect: "Allow",
-					Action: []string{
-						"s3:ListBucket",
-					},
-					Resource: fmt.Sprintf("arn:%s:s3:::%s", partitionID, bucketName),
-				},
-			},
+			StatementEntries: statementEntries,
 		})
 
-	return &minterv1.CredentialsRequest{
+	cr := &minterv1.CredentialsRequest{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
@@ -308,9 +521,74 @@ func awsCredentialsRequest(namespace, name, partitionID, bucketName string) *min
 			ProviderSpec: provSpec,
 		},
 	}
+
+	// With a role to assume, the cloud-credential-operator writes a credentials
+	// file for the velero service account's token instead of minting a user.
+	// The vendored AWSProviderSpec predates stsIAMRoleARN.
+	if webIdentity && awsCredentials != nil {
+		setWebIdentityProviderSpec(cr, map[string]interface{}{
+			"stsIAMRoleARN": awsCredentials.RoleARN,
+		})
+	}
+
+	return cr
+}
+
+// removeBackupStorageLocation deletes the named BackupStorageLocation, if it
+// exists and is owned by the instance.
+func (r *VeleroInstallReconciler) removeBackupStorageLocation(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall, namespace, name string) error {
+	foundBsl := &velerov1.BackupStorageLocation{}
+	if err := r.Get(context.TODO(), runtimeClient.ObjectKey{Namespace: namespace, Name: name}, foundBsl); err != nil {
+		return runtimeClient.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(foundBsl, instance) {
+		return nil
+	}
+	reqLogger.Info("Deleting BackupStorageLocation", "BackupStorageLocation.Name", name)
+	return runtimeClient.IgnoreNotFound(r.Delete(context.TODO(), foundBsl))
+}
+
+// snapshotTags formats the tags applied to volume snapshots for the
+// VolumeSnapshotLocation config, as comma-separated key=value pairs sorted by
+// key, so that the config is stable between reconciles.
+func snapshotTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
 }
 
-func gcpCredentialsRequest(namespace, name string) *minterv1.CredentialsRequest {
+// replicaBackupStorageLocation returns a read-only BackupStorageLocation for
+// the bucket that backups are replicated to, or nil if there is no replica
+// bucket. It shares the configuration of the storage bucket's location, apart
+// from the region and key of the replica.
+func replicaBackupStorageLocation(namespace, provider string, instance *veleroInstallCR.VeleroInstall, locationConfig map[string]string) *velerov1.BackupStorageLocation {
+	replica := instance.Status.StorageBucket.Replica
+	if replica == nil || !replica.Provisioned {
+		return nil
+	}
+
+	replicaConfig := make(map[string]string, len(locationConfig))
+	for k, v := range locationConfig {
+		replicaConfig[k] = v
+	}
+	replicaConfig["region"] = replica.Region
+	delete(replicaConfig, "kmsKeyId")
+	if kmsKeyID := instance.Spec.Storage.GetReplicaKMSKeyID(); kmsKeyID != "" {
+		replicaConfig["kmsKeyId"] = kmsKeyID
+	}
+
+	var caCertData []byte
+	bsl := veleroInstall.BackupStorageLocation(namespace, provider, replica.Name, "", replicaConfig, caCertData)
+	bsl.Name = storageConstants.ReplicaVeleroBackupStorageLocation
+	bsl.Spec.Default = false
+	bsl.Spec.AccessMode = velerov1.BackupStorageLocationAccessModeReadOnly
+	return bsl
+}
+
+func gcpCredentialsRequest(namespace, name string, gcpCredentials *veleroInstallCR.GCPCredentials, webIdentity bool) *minterv1.CredentialsRequest {
 	codec, _ := minterv1.NewCodec()
 	provSpec, _ := codec.EncodeProviderSpec(
 		&minterv1.GCPProviderSpec{
@@ -325,6 +603,66 @@ func gcpCredentialsRequest(namespace, name string) *minterv1.CredentialsRequest
 			SkipServiceCheck: true,
 		})
 
+	cr := &minterv1.CredentialsRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "CredentialsRequest",
+			APIVersion: minterv1.SchemeGroupVersion.String(),
+		},
+		Spec: minterv1.CredentialsRequestSpec{
+			SecretRef: corev1.ObjectReference{
+				Name:      name,
+				Namespace: namespace,
+			},
+			ProviderSpec: provSpec,
+		},
+	}
+
+	// With a service account to impersonate, the cloud-credential-operator
+	// writes an external account credential config for the velero service
+	// account's token instead of minting a key. The vendored GCPProviderSpec
+	// predates serviceAccountEmail and audience.
+	if webIdentity && gcpCredentials != nil {
+		setWebIdentityProviderSpec(cr, map[string]interface{}{
+			"serviceAccountEmail": gcpCredentials.ServiceAccountEmail,
+			"audience":            gcpCredentials.Audience,
+		})
+	}
+
+	return cr
+}
+
+// setWebIdentityProviderSpec adds fields to the encoded provider spec of a
+// CredentialsRequest, and requests credentials for the velero service account.
+func setWebIdentityProviderSpec(cr *minterv1.CredentialsRequest, fields map[string]interface{}) {
+	var providerSpec map[string]interface{}
+	_ = json.Unmarshal(cr.Spec.ProviderSpec.Raw, &providerSpec)
+	for k, v := range fields {
+		providerSpec[k] = v
+	}
+	cr.Spec.ProviderSpec.Raw, _ = json.Marshal(providerSpec)
+	cr.Spec.ServiceAccountNames = []string{"velero"}
+}
+
+func azureCredentialsRequest(namespace, name string) *minterv1.CredentialsRequest {
+	codec, _ := minterv1.NewCodec()
+	provSpec, _ := codec.EncodeProviderSpec(
+		&minterv1.AzureProviderSpec{
+			TypeMeta: metav1.TypeMeta{
+				Kind: "AzureProviderSpec",
+			},
+			// Velero reads the storage account keys to write backups, snapshots
+			// the cluster's disks, and creates disks from the snapshots on restore
+			RoleBindings: []minterv1.RoleBinding{
+				{Role: "Storage Account Contributor"},
+				{Role: "Disk Snapshot Contributor"},
+				{Role: "Disk Restore Operator"},
+			},
+		})
+
 	return &minterv1.CredentialsRequest{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -344,26 +682,67 @@ func gcpCredentialsRequest(namespace, name string) *minterv1.CredentialsRequest
 	}
 }
 
-func veleroDeployment(namespace string, platform configv1.PlatformType, veleroImageRegistry string) *appsv1.Deployment {
+// veleroDeployment returns the Velero deployment for the platform. The pod is
+// required to run on a node of one of the given architectures.
+func veleroDeployment(namespace string, platformStatus *configv1.PlatformStatus, credentialsSecretName, veleroImageRegistry string, webIdentity bool, architectures []string) *appsv1.Deployment {
 	var deployment *appsv1.Deployment
 
-	//TODO(cblecker): fix resources
-	// veleroPodResources, _ := velerokubeutil.ParseResourceRequirements(veleroInstall.DefaultVeleroPodCPURequest, veleroInstall.DefaultVeleroPodMemRequest, veleroInstall.DefaultVeleroPodCPULimit, veleroInstall.DefaultVeleroPodMemLimit)
-
-	switch platform {
+	switch platformStatus.Type {
 	case configv1.AWSPlatformType:
+		if !webIdentity {
+			deployment = veleroInstall.Deployment(namespace,
+				veleroInstall.WithEnvFromSecretKey(strings.ToUpper(awsCredsSecretIDKey), credentialsSecretName, awsCredsSecretIDKey),
+				veleroInstall.WithEnvFromSecretKey(strings.ToUpper(awsCredsSecretAccessKey), credentialsSecretName, awsCredsSecretAccessKey),
+				veleroInstall.WithPlugins([]string{veleroImageRegistry + "/" + version.VeleroAwsImageTag}),
+				veleroInstall.WithImage(veleroImageRegistry+"/"+version.VeleroImageTag),
+			)
+			break
+		}
+
+		// The credentials file written by the cloud-credential-operator names
+		// the role to assume and the projected token to assume it with
 		deployment = veleroInstall.Deployment(namespace,
-			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(awsCredsSecretIDKey), credentialsRequestName, awsCredsSecretIDKey),
-			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(awsCredsSecretAccessKey), credentialsRequestName, awsCredsSecretAccessKey),
-			//TODO(cblecker): fix resources
-			// veleroInstall.WithResources(veleroPodResources),
+			veleroInstall.WithPlugins([]string{veleroImageRegistry + "/" + version.VeleroAwsImageTag}),
+			veleroInstall.WithImage(veleroImageRegistry+"/"+version.VeleroImageTag),
+		)
+		defaultMode := int32(420)
+		deployment.Spec.Template.Spec.Volumes = append(
+			deployment.Spec.Template.Spec.Volumes,
+			corev1.Volume{
+				Name: "cloud-credentials",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName:  credentialsSecretName,
+						DefaultMode: &defaultMode,
+					},
+				},
+			},
+		)
+
+		deployment.Spec.Template.Spec.Containers[0].VolumeMounts = append(
+			deployment.Spec.Template.Spec.Containers[0].VolumeMounts,
+			corev1.VolumeMount{
+				Name:      "cloud-credentials",
+				MountPath: "/credentials",
+			},
+		)
+		addBoundServiceAccountToken(deployment)
+
+		deployment.Spec.Template.Spec.Containers[0].Env = append(deployment.Spec.Template.Spec.Containers[0].Env, []corev1.EnvVar{
+			{
+				Name:  "AWS_SHARED_CREDENTIALS_FILE",
+				Value: "/credentials/credentials",
+			},
+		}...)
+	case configv1.BareMetalPlatformType, configv1.NonePlatformType, configv1.VSpherePlatformType, configv1.OpenStackPlatformType:
+		deployment = veleroInstall.Deployment(namespace,
+			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(awsCredsSecretIDKey), credentialsSecretName, awsCredsSecretIDKey),
+			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(awsCredsSecretAccessKey), credentialsSecretName, awsCredsSecretAccessKey),
 			veleroInstall.WithPlugins([]string{veleroImageRegistry + "/" + version.VeleroAwsImageTag}),
 			veleroInstall.WithImage(veleroImageRegistry+"/"+version.VeleroImageTag),
 		)
 	case configv1.GCPPlatformType:
 		deployment = veleroInstall.Deployment(namespace,
-			//TODO(cblecker): fix resources
-			// veleroInstall.WithResources(veleroPodResources),
 			veleroInstall.WithPlugins([]string{veleroImageRegistry + "/" + version.VeleroGcpImageTag}),
 			veleroInstall.WithImage(veleroImageRegistry+"/"+version.VeleroImageTag),
 		)
@@ -374,7 +753,7 @@ func veleroDeployment(namespace string, platform configv1.PlatformType, veleroIm
 				Name: "cloud-credentials",
 				VolumeSource: corev1.VolumeSource{
 					Secret: &corev1.SecretVolumeSource{
-						SecretName:  credentialsRequestName,
+						SecretName:  credentialsSecretName,
 						DefaultMode: &defaultMode,
 					},
 				},
@@ -395,37 +774,40 @@ func veleroDeployment(namespace string, platform configv1.PlatformType, veleroIm
 				Value: "/credentials/service_account.json",
 			},
 		}...)
+
+		// With Workload Identity Federation, service_account.json is an
+		// external account credential config that reads the projected token
+		if webIdentity {
+			addBoundServiceAccountToken(deployment)
+		}
+	case configv1.AzurePlatformType:
+		deployment = veleroInstall.Deployment(namespace,
+			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(azureCredsSecretSubscriptionIDKey), credentialsSecretName, azureCredsSecretSubscriptionIDKey),
+			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(azureCredsSecretTenantIDKey), credentialsSecretName, azureCredsSecretTenantIDKey),
+			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(azureCredsSecretClientIDKey), credentialsSecretName, azureCredsSecretClientIDKey),
+			veleroInstall.WithEnvFromSecretKey(strings.ToUpper(azureCredsSecretClientSecretKey), credentialsSecretName, azureCredsSecretClientSecretKey),
+			veleroInstall.WithEnvFromSecretKey("AZURE_RESOURCE_GROUP", credentialsSecretName, azureCredsSecretResourceGroupKey),
+			veleroInstall.WithPlugins([]string{veleroImageRegistry + "/" + version.VeleroAzureImageTag}),
+			veleroInstall.WithImage(veleroImageRegistry+"/"+version.VeleroImageTag),
+		)
+		if platformStatus.Azure != nil && platformStatus.Azure.CloudName != "" {
+			deployment.Spec.Template.Spec.Containers[0].Env = append(deployment.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+				Name:  "AZURE_CLOUD_NAME",
+				Value: string(platformStatus.Azure.CloudName),
+			})
+		}
 	}
 
+	// Fields that the API server defaults are left unset, since the
+	// deployment is applied and only owns the fields it sets. The port
+	// protocol is part of the key of the container's ports, so it must be set.
 	replicas := int32(1)
-	terminationGracePeriodSeconds := int64(30)
 	revisionHistoryLimit := int32(2)
-	progressDeadlineSeconds := int32(600)
-	maxUnavailable := intstr.FromString("25%")
-	maxSurge := intstr.FromString("25%")
 	defaultMode := int32(420)
 	deployment.Spec.Replicas = &replicas
 	deployment.Spec.RevisionHistoryLimit = &revisionHistoryLimit
-	deployment.Spec.ProgressDeadlineSeconds = &progressDeadlineSeconds
-	deployment.Spec.Template.Spec.InitContainers[0].TerminationMessagePath = "/dev/termination-log"
-	deployment.Spec.Template.Spec.InitContainers[0].TerminationMessagePolicy = "File"
-	deployment.Spec.Template.Spec.Containers[0].Env[1].ValueFrom.FieldRef.APIVersion = "v1"
-	deployment.Spec.Template.Spec.Containers[0].Ports[0].Protocol = "TCP"
-	deployment.Spec.Template.Spec.Containers[0].TerminationMessagePath = "/dev/termination-log"
-	deployment.Spec.Template.Spec.Containers[0].TerminationMessagePolicy = "File"
-	deployment.Spec.Template.Spec.DeprecatedServiceAccount = "velero"
+	deployment.Spec.Template.Spec.Containers[0].Ports[0].Protocol = corev1.ProtocolTCP
 	deployment.Spec.Template.Spec.ServiceAccountName = "velero"
-	deployment.Spec.Template.Spec.DNSPolicy = "ClusterFirst"
-	deployment.Spec.Template.Spec.SchedulerName = "default-scheduler"
-	deployment.Spec.Template.Spec.SecurityContext = &corev1.PodSecurityContext{}
-	deployment.Spec.Template.Spec.TerminationGracePeriodSeconds = &terminationGracePeriodSeconds
-	deployment.Spec.Strategy = appsv1.DeploymentStrategy{
-		Type: appsv1.RollingUpdateDeploymentStrategyType,
-		RollingUpdate: &appsv1.RollingUpdateDeployment{
-			MaxUnavailable: &maxUnavailable,
-			MaxSurge:       &maxSurge,
-		},
-	}
 	deployment.Spec.Template.Spec.Tolerations = []corev1.Toleration{
 		{
 			Key:      "node-role.kubernetes.io/infra",
@@ -453,9 +835,9 @@ func veleroDeployment(namespace string, platform configv1.PlatformType, veleroIm
 					{
 						MatchExpressions: []corev1.NodeSelectorRequirement{
 							{
-								Key:      "beta.kubernetes.io/arch",
+								Key:      corev1.LabelArchStable,
 								Operator: corev1.NodeSelectorOpIn,
-								Values:   []string{"amd64"},
+								Values:   architectures,
 							},
 						},
 					},
@@ -480,7 +862,7 @@ func veleroDeployment(namespace string, platform configv1.PlatformType, veleroIm
 			VolumeSource: corev1.VolumeSource{
 				ConfigMap: &corev1.ConfigMapVolumeSource{
 					LocalObjectReference: corev1.LocalObjectReference{
-						Name: "trusted-ca-bundle",
+						Name: trustedCABundleName,
 					},
 					DefaultMode: &defaultMode,
 					Items: []corev1.KeyToPath{
@@ -497,6 +879,169 @@ func veleroDeployment(namespace string, platform configv1.PlatformType, veleroIm
 	return deployment
 }
 
+// applyPodConfig merges the pod configuration set on the instance into the
+// Velero deployment. Resources and the priority class are only set when
+// configured, the node selector and tolerations are added to the defaults, and
+// each kind of affinity that is configured replaces the default one.
+func applyPodConfig(deployment *appsv1.Deployment, podConfig *veleroInstallCR.PodConfig) {
+	if podConfig == nil {
+		return
+	}
+	podSpec := &deployment.Spec.Template.Spec
+
+	if podConfig.Resources != nil {
+		podSpec.Containers[0].Resources = *podConfig.Resources.DeepCopy()
+	}
+
+	if len(podConfig.NodeSelector) > 0 {
+		if podSpec.NodeSelector == nil {
+			podSpec.NodeSelector = make(map[string]string, len(podConfig.NodeSelector))
+		}
+		for key, value := range podConfig.NodeSelector {
+			podSpec.NodeSelector[key] = value
+		}
+	}
+
+	for _, toleration := range podConfig.Tolerations {
+		podSpec.Tolerations = append(podSpec.Tolerations, *toleration.DeepCopy())
+	}
+
+	if podConfig.Affinity != nil {
+		affinity := podConfig.Affinity.DeepCopy()
+		if podSpec.Affinity == nil {
+			podSpec.Affinity = &corev1.Affinity{}
+		}
+		if affinity.NodeAffinity != nil {
+			podSpec.Affinity.NodeAffinity = affinity.NodeAffinity
+		}
+		if affinity.PodAffinity != nil {
+			podSpec.Affinity.PodAffinity = affinity.PodAffinity
+		}
+		if affinity.PodAntiAffinity != nil {
+			podSpec.Affinity.PodAntiAffinity = affinity.PodAntiAffinity
+		}
+	}
+
+	if podConfig.PriorityClassName != "" {
+		podSpec.PriorityClassName = podConfig.PriorityClassName
+	}
+}
+
+// clusterProxy returns the cluster-wide proxy configuration, or nil if there
+// is none.
+func (r *VeleroInstallReconciler) clusterProxy() (*configv1.Proxy, error) {
+	proxy := &configv1.Proxy{}
+	if err := r.Get(context.TODO(), runtimeClient.ObjectKey{Name: clusterConfigName}, proxy); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read the cluster proxy: %v", err)
+	}
+	return proxy, nil
+}
+
+// applyProxy has the Velero container reach the cloud provider through the
+// cluster-wide proxy, if one is configured. The proxy's status holds the
+// settings in effect, including the cluster's own networks in noProxy.
+func applyProxy(deployment *appsv1.Deployment, proxy *configv1.Proxy) {
+	if proxy == nil {
+		return
+	}
+	container := &deployment.Spec.Template.Spec.Containers[0]
+	for _, env := range []corev1.EnvVar{
+		{Name: "HTTP_PROXY", Value: proxy.Status.HTTPProxy},
+		{Name: "HTTPS_PROXY", Value: proxy.Status.HTTPSProxy},
+		{Name: "NO_PROXY", Value: proxy.Status.NoProxy},
+	} {
+		if env.Value != "" {
+			container.Env = append(container.Env, env)
+		}
+	}
+}
+
+// podInputsHash returns the hash of the inputs that the Velero pod only reads
+// when it starts: the credentials in its environment, and the trusted CA
+// bundle. Inputs that don't exist yet are left out.
+func (r *VeleroInstallReconciler) podInputsHash(namespace, credentialsSecretName string) (string, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(context.TODO(), runtimeClient.ObjectKey{Namespace: namespace, Name: credentialsSecretName}, secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", fmt.Errorf("unable to read secret %v: %v", credentialsSecretName, err)
+		}
+		secret = nil
+	}
+	caBundle := &corev1.ConfigMap{}
+	if err := r.Get(context.TODO(), runtimeClient.ObjectKey{Namespace: namespace, Name: trustedCABundleName}, caBundle); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", fmt.Errorf("unable to read ConfigMap %v: %v", trustedCABundleName, err)
+		}
+		caBundle = nil
+	}
+	return hashPodInputs(secret, caBundle), nil
+}
+
+// hashPodInputs hashes the data of the credentials secret and the trusted CA
+// bundle, in a stable order. Either may be nil.
+func hashPodInputs(secret *corev1.Secret, caBundle *corev1.ConfigMap) string {
+	hash := sha256.New()
+	write := func(kind, key string, value []byte) {
+		// Lengths are written with each field, so that fields can't run into each other
+		fmt.Fprintf(hash, "%v/%d:%v/%d:", kind, len(key), key, len(value))
+		hash.Write(value)
+	}
+	if secret != nil {
+		for _, key := range sets.StringKeySet(secret.Data).List() {
+			write("secret", key, secret.Data[key])
+		}
+	}
+	if caBundle != nil {
+		for _, key := range sets.StringKeySet(caBundle.Data).List() {
+			write("configmap", key, []byte(caBundle.Data[key]))
+		}
+		for _, key := range sets.StringKeySet(caBundle.BinaryData).List() {
+			write("configmap-binary", key, caBundle.BinaryData[key])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// addBoundServiceAccountToken mounts a projected service account token in the
+// Velero pod, for exchanging with the cloud provider on clusters using
+// short-lived credentials.
+func addBoundServiceAccountToken(deployment *appsv1.Deployment) {
+	defaultMode := int32(420)
+	expirationSeconds := int64(3600)
+	deployment.Spec.Template.Spec.Volumes = append(
+		deployment.Spec.Template.Spec.Volumes,
+		corev1.Volume{
+			Name: "bound-sa-token",
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{
+							ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+								Audience:          "openshift",
+								ExpirationSeconds: &expirationSeconds,
+								Path:              "token",
+							},
+						},
+					},
+					DefaultMode: &defaultMode,
+				},
+			},
+		},
+	)
+
+	deployment.Spec.Template.Spec.Containers[0].VolumeMounts = append(
+		deployment.Spec.Template.Spec.Containers[0].VolumeMounts,
+		corev1.VolumeMount{
+			Name:      "bound-sa-token",
+			MountPath: cloudTokenDir,
+			ReadOnly:  true,
+		},
+	)
+}
+
 func metricsServiceFromDeployment(deployment *appsv1.Deployment) *corev1.Service {
 	// Build a list of ServicePorts from the container ports of the
 	// deployment's pod template having "metrics" in the port name.
@@ -540,43 +1085,37 @@ func metricsServiceFromDeployment(deployment *appsv1.Deployment) *corev1.Service
 	}
 }
 
-func credentialsRequestSpecEqual(x, y minterv1.CredentialsRequestSpec) (bool, error) {
-	var err error
-
-	// Create new scheme for CredentialsRequest
-	credentialsRequestScheme := runtime.NewScheme()
-
-	// Add Cloud Credential apis to scheme
-	if err := minterv1.AddToScheme(credentialsRequestScheme); err != nil {
-		return false, err
-	}
-
-	// Create decoder to allow us to read CredentialsRequest API types
-	credentialsRequestDecoder := serializer.NewCodecFactory(credentialsRequestScheme).UniversalDecoder(minterv1.SchemeGroupVersion)
-
-	// Decode the ProviderSpecs for both objects
-	xps, err := runtime.Decode(credentialsRequestDecoder, x.ProviderSpec.Raw)
-	if err != nil {
-		return false, err
-	}
-	yps, err := runtime.Decode(credentialsRequestDecoder, y.ProviderSpec.Raw)
+// credentialsRequestObject converts a CredentialsRequest to an unstructured
+// object, setting spec.cloudTokenPath when a token path is given. The vendored
+// CredentialsRequest API predates short-lived credentials, and would drop the
+// field on a round trip through the typed object.
+func credentialsRequestObject(cr *minterv1.CredentialsRequest, tokenPath string) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cr)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-
-	// Check ProviderSpec matches
-	if !reflect.DeepEqual(xps, yps) {
-		return false, nil
+	crObj := &unstructured.Unstructured{Object: obj}
+	crObj.SetGroupVersionKind(minterv1.SchemeGroupVersion.WithKind("CredentialsRequest"))
+	if tokenPath != "" {
+		if err := unstructured.SetNestedField(crObj.Object, tokenPath, "spec", "cloudTokenPath"); err != nil {
+			return nil, err
+		}
 	}
+	return crObj, nil
+}
 
-	// nil out the ProviderSpec and check everyhing else matches
-	x.ProviderSpec = nil
-	y.ProviderSpec = nil
-	if !reflect.DeepEqual(x, y) {
-		return false, nil
+// shortLivedCredentialsEnabled reports whether the cluster issues service
+// account tokens that the cloud provider trusts, which is how clusters using
+// AWS STS or GCP Workload Identity Federation are configured.
+func (r *VeleroInstallReconciler) shortLivedCredentialsEnabled() (bool, error) {
+	authentication := &configv1.Authentication{}
+	if err := r.Get(context.TODO(), runtimeClient.ObjectKey{Name: "cluster"}, authentication); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
 	}
-
-	return true, nil
+	return authentication.Spec.ServiceAccountIssuer != "", nil
 }
 
 // generateServiceMonitor generates a prometheus-operator ServiceMonitor object
@@ -1,13 +1,25 @@
 package velero
 
 import (
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"k8s.io/apimachinery/pkg/util/sets"
+
+	configv1 "github.com/openshift/api/config/v1"
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+	"github.com/openshift/managed-velero-operator/version"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 )
 
 var exampleService = &corev1.Service{
@@ -101,3 +113,670 @@ func TestPopulateEndpointsFromServicePorts(t *testing.T) {
 		})
 	}
 }
+
+func TestSetReadyCondition(t *testing.T) {
+	componentConditions := []string{
+		veleroInstallCR.ConditionBucketProvisioned,
+		veleroInstallCR.ConditionBucketPolicyEnforced,
+		veleroInstallCR.ConditionCredentialsReady,
+		veleroInstallCR.ConditionVeleroDeploymentAvailable,
+	}
+
+	tests := []struct {
+		name      string
+		notReady  string
+		wantReady metav1.ConditionStatus
+	}{
+		{
+			name:      "All components ready",
+			wantReady: metav1.ConditionTrue,
+		},
+		{
+			name:      "Credentials not ready",
+			notReady:  veleroInstallCR.ConditionCredentialsReady,
+			wantReady: metav1.ConditionFalse,
+		},
+		{
+			name:      "Deployment not available",
+			notReady:  veleroInstallCR.ConditionVeleroDeploymentAvailable,
+			wantReady: metav1.ConditionFalse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &veleroInstallCR.VeleroInstall{}
+			for _, conditionType := range componentConditions {
+				status := metav1.ConditionTrue
+				if conditionType == tt.notReady {
+					status = metav1.ConditionFalse
+				}
+				instance.SetCondition(conditionType, status, "Test", "")
+			}
+
+			setReadyCondition(instance)
+
+			ready := meta.FindStatusCondition(instance.Status.Conditions, veleroInstallCR.ConditionReady)
+			if ready == nil {
+				t.Fatalf("Ready condition was not set")
+			}
+			if ready.Status != tt.wantReady {
+				t.Errorf("Ready condition status = %v, want %v", ready.Status, tt.wantReady)
+			}
+			if tt.notReady != "" && !strings.Contains(ready.Message, tt.notReady) {
+				t.Errorf("Ready condition message %q doesn't mention %v", ready.Message, tt.notReady)
+			}
+		})
+	}
+}
+
+func TestSetDeploymentAvailableCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []appsv1.DeploymentCondition
+		want       metav1.ConditionStatus
+	}{
+		{
+			name: "Deployment available",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue},
+			},
+			want: metav1.ConditionTrue,
+		},
+		{
+			name: "Deployment unavailable",
+			conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionFalse},
+			},
+			want: metav1.ConditionFalse,
+		},
+		{
+			name: "Deployment has no status yet",
+			want: metav1.ConditionFalse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &veleroInstallCR.VeleroInstall{}
+			deployment := &appsv1.Deployment{Status: appsv1.DeploymentStatus{Conditions: tt.conditions}}
+
+			setDeploymentAvailableCondition(instance, deployment)
+
+			if !meta.IsStatusConditionPresentAndEqual(instance.Status.Conditions, veleroInstallCR.ConditionVeleroDeploymentAvailable, tt.want) {
+				t.Errorf("%v condition is not %v: %v", veleroInstallCR.ConditionVeleroDeploymentAvailable, tt.want, instance.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestVeleroDeploymentAzure(t *testing.T) {
+	platformStatus := &configv1.PlatformStatus{
+		Type: configv1.AzurePlatformType,
+		Azure: &configv1.AzurePlatformStatus{
+			ResourceGroupName: "cluster-rg",
+			CloudName:         configv1.AzureUSGovernmentCloud,
+		},
+	}
+
+	deployment := veleroDeployment("openshift-velero", platformStatus, credentialsRequestName, veleroImageRegistry, false, supportedArchitectures)
+
+	wantPlugin := veleroImageRegistry + "/" + version.VeleroAzureImageTag
+	if got := deployment.Spec.Template.Spec.InitContainers[0].Image; got != wantPlugin {
+		t.Errorf("veleroDeployment() plugin image = %v, want %v", got, wantPlugin)
+	}
+
+	env := make(map[string]corev1.EnvVar)
+	for _, e := range deployment.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e
+	}
+	for name, key := range map[string]string{
+		"AZURE_SUBSCRIPTION_ID": azureCredsSecretSubscriptionIDKey,
+		"AZURE_TENANT_ID":       azureCredsSecretTenantIDKey,
+		"AZURE_CLIENT_ID":       azureCredsSecretClientIDKey,
+		"AZURE_CLIENT_SECRET":   azureCredsSecretClientSecretKey,
+		"AZURE_RESOURCE_GROUP":  azureCredsSecretResourceGroupKey,
+	} {
+		e, ok := env[name]
+		if !ok || e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil {
+			t.Errorf("veleroDeployment() env %v is not read from a secret", name)
+			continue
+		}
+		if e.ValueFrom.SecretKeyRef.Name != credentialsRequestName || e.ValueFrom.SecretKeyRef.Key != key {
+			t.Errorf("veleroDeployment() env %v = %v/%v, want %v/%v", name,
+				e.ValueFrom.SecretKeyRef.Name, e.ValueFrom.SecretKeyRef.Key, credentialsRequestName, key)
+		}
+	}
+	if got := env["AZURE_CLOUD_NAME"].Value; got != string(configv1.AzureUSGovernmentCloud) {
+		t.Errorf("veleroDeployment() AZURE_CLOUD_NAME = %v, want %v", got, configv1.AzureUSGovernmentCloud)
+	}
+}
+
+func TestVeleroDeploymentS3Compatible(t *testing.T) {
+	platformStatus := &configv1.PlatformStatus{
+		Type: configv1.BareMetalPlatformType,
+	}
+
+	deployment := veleroDeployment("openshift-velero", platformStatus, "minio-credentials", veleroImageRegistry, false, supportedArchitectures)
+
+	wantPlugin := veleroImageRegistry + "/" + version.VeleroAwsImageTag
+	if got := deployment.Spec.Template.Spec.InitContainers[0].Image; got != wantPlugin {
+		t.Errorf("veleroDeployment() plugin image = %v, want %v", got, wantPlugin)
+	}
+
+	for _, e := range deployment.Spec.Template.Spec.Containers[0].Env {
+		if e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil {
+			continue
+		}
+		if e.ValueFrom.SecretKeyRef.Name != "minio-credentials" {
+			t.Errorf("veleroDeployment() env %v is read from secret %v, want %v", e.Name, e.ValueFrom.SecretKeyRef.Name, "minio-credentials")
+		}
+	}
+}
+
+func TestSchedulableArchitectures(t *testing.T) {
+	node := func(name string, labels map[string]string, kubeletArch string) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{Architecture: kubeletArch}},
+		}
+	}
+	tests := []struct {
+		name  string
+		nodes []corev1.Node
+		want  []string
+	}{
+		{
+			name: "single architecture",
+			nodes: []corev1.Node{
+				node("worker", map[string]string{nodeRoleWorkerLabel: "", corev1.LabelArchStable: "amd64"}, "amd64"),
+			},
+			want: []string{"amd64"},
+		},
+		{
+			name: "multi-architecture workers and infra nodes",
+			nodes: []corev1.Node{
+				node("worker-1", map[string]string{nodeRoleWorkerLabel: "", corev1.LabelArchStable: "arm64"}, "arm64"),
+				node("worker-2", map[string]string{nodeRoleWorkerLabel: "", corev1.LabelArchStable: "amd64"}, "amd64"),
+				node("infra", map[string]string{nodeRoleInfraLabel: "", corev1.LabelArchStable: "s390x"}, "s390x"),
+			},
+			want: []string{"amd64", "arm64", "s390x"},
+		},
+		{
+			name: "control plane nodes are ignored",
+			nodes: []corev1.Node{
+				node("master", map[string]string{"node-role.kubernetes.io/master": "", corev1.LabelArchStable: "amd64"}, "amd64"),
+				node("worker", map[string]string{nodeRoleWorkerLabel: "", corev1.LabelArchStable: "ppc64le"}, "ppc64le"),
+			},
+			want: []string{"ppc64le"},
+		},
+		{
+			name: "kubelet architecture without a label",
+			nodes: []corev1.Node{
+				node("worker", map[string]string{nodeRoleWorkerLabel: ""}, "arm64"),
+			},
+			want: []string{"arm64"},
+		},
+		{
+			name: "unsupported architecture",
+			nodes: []corev1.Node{
+				node("worker", map[string]string{nodeRoleWorkerLabel: "", corev1.LabelArchStable: "riscv64"}, "riscv64"),
+			},
+			want: []string{},
+		},
+		{
+			name: "no nodes",
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schedulableArchitectures(tt.nodes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schedulableArchitectures() = %v, want %v", got, tt.want)
+			}
+
+			instance := &veleroInstallCR.VeleroInstall{}
+			setSchedulableCondition(instance, tt.want)
+			wantStatus := metav1.ConditionTrue
+			if len(tt.want) == 0 {
+				wantStatus = metav1.ConditionFalse
+			}
+			if !meta.IsStatusConditionPresentAndEqual(instance.Status.Conditions, veleroInstallCR.ConditionVeleroSchedulable, wantStatus) {
+				t.Errorf("setSchedulableCondition() %v condition is not %v", veleroInstallCR.ConditionVeleroSchedulable, wantStatus)
+			}
+		})
+	}
+}
+
+func TestVeleroDeploymentArchitectures(t *testing.T) {
+	platformStatus := &configv1.PlatformStatus{
+		Type: configv1.AWSPlatformType,
+		AWS: &configv1.AWSPlatformStatus{
+			Region: "us-east-1",
+		},
+	}
+
+	deployment := veleroDeployment("openshift-velero", platformStatus, credentialsRequestName, veleroImageRegistry, false, []string{"amd64", "arm64"})
+	terms := deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || len(terms[0].MatchExpressions) != 1 {
+		t.Fatalf("veleroDeployment() required node affinity = %v", terms)
+	}
+	requirement := terms[0].MatchExpressions[0]
+	if requirement.Key != corev1.LabelArchStable || !reflect.DeepEqual(requirement.Values, []string{"amd64", "arm64"}) {
+		t.Errorf("veleroDeployment() requires %v in %v, want %v in %v", requirement.Key, requirement.Values, corev1.LabelArchStable, []string{"amd64", "arm64"})
+	}
+}
+
+func TestApplyPodConfig(t *testing.T) {
+	platformStatus := &configv1.PlatformStatus{
+		Type: configv1.AWSPlatformType,
+		AWS: &configv1.AWSPlatformStatus{
+			Region: "us-east-1",
+		},
+	}
+
+	// Without a pod configuration, the generated deployment is unchanged
+	deployment := veleroDeployment("openshift-velero", platformStatus, credentialsRequestName, veleroImageRegistry, false, supportedArchitectures)
+	applyPodConfig(deployment, nil)
+	applyPodConfig(deployment, &veleroInstallCR.PodConfig{})
+	if want := veleroDeployment("openshift-velero", platformStatus, credentialsRequestName, veleroImageRegistry, false, supportedArchitectures); !reflect.DeepEqual(deployment, want) {
+		t.Errorf("applyPodConfig() changed the deployment without a pod configuration")
+	}
+
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+	}
+	antiAffinity := &corev1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+			{Weight: 1, PodAffinityTerm: corev1.PodAffinityTerm{TopologyKey: "kubernetes.io/hostname"}},
+		},
+	}
+	applyPodConfig(deployment, &veleroInstallCR.PodConfig{
+		Resources:    &resources,
+		NodeSelector: map[string]string{"node-role.kubernetes.io/infra": ""},
+		Tolerations: []corev1.Toleration{
+			{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "backup", Effect: corev1.TaintEffectNoSchedule},
+		},
+		Affinity:          &corev1.Affinity{PodAntiAffinity: antiAffinity},
+		PriorityClassName: "system-cluster-critical",
+	})
+
+	podSpec := deployment.Spec.Template.Spec
+	if !reflect.DeepEqual(podSpec.Containers[0].Resources, resources) {
+		t.Errorf("applyPodConfig() resources = %v, want %v", podSpec.Containers[0].Resources, resources)
+	}
+	if podSpec.NodeSelector["node-role.kubernetes.io/infra"] != "" || len(podSpec.NodeSelector) != 1 {
+		t.Errorf("applyPodConfig() nodeSelector = %v", podSpec.NodeSelector)
+	}
+	// The default infra toleration is kept
+	if len(podSpec.Tolerations) != 2 || podSpec.Tolerations[0].Key != "node-role.kubernetes.io/infra" || podSpec.Tolerations[1].Key != "dedicated" {
+		t.Errorf("applyPodConfig() tolerations = %v", podSpec.Tolerations)
+	}
+	// Only the configured kind of affinity replaces the default
+	if podSpec.Affinity.NodeAffinity == nil {
+		t.Errorf("applyPodConfig() removed the default node affinity")
+	}
+	if !reflect.DeepEqual(podSpec.Affinity.PodAntiAffinity, antiAffinity) {
+		t.Errorf("applyPodConfig() podAntiAffinity = %v, want %v", podSpec.Affinity.PodAntiAffinity, antiAffinity)
+	}
+	if podSpec.PriorityClassName != "system-cluster-critical" {
+		t.Errorf("applyPodConfig() priorityClassName = %v, want %v", podSpec.PriorityClassName, "system-cluster-critical")
+	}
+
+	// A configured node affinity replaces the default one
+	nodeAffinity := &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"us-east-1a"}},
+				}},
+			},
+		},
+	}
+	applyPodConfig(deployment, &veleroInstallCR.PodConfig{Affinity: &corev1.Affinity{NodeAffinity: nodeAffinity}})
+	if !reflect.DeepEqual(deployment.Spec.Template.Spec.Affinity.NodeAffinity, nodeAffinity) {
+		t.Errorf("applyPodConfig() nodeAffinity = %v, want %v", deployment.Spec.Template.Spec.Affinity.NodeAffinity, nodeAffinity)
+	}
+}
+
+func TestVeleroDeploymentAWSWebIdentity(t *testing.T) {
+	platformStatus := &configv1.PlatformStatus{
+		Type: configv1.AWSPlatformType,
+		AWS: &configv1.AWSPlatformStatus{
+			Region: "us-east-1",
+		},
+	}
+
+	deployment := veleroDeployment("openshift-velero", platformStatus, credentialsRequestName, veleroImageRegistry, true, supportedArchitectures)
+	podSpec := deployment.Spec.Template.Spec
+
+	env := make(map[string]corev1.EnvVar)
+	for _, e := range podSpec.Containers[0].Env {
+		env[e.Name] = e
+	}
+	for _, name := range []string{strings.ToUpper(awsCredsSecretIDKey), strings.ToUpper(awsCredsSecretAccessKey)} {
+		if _, ok := env[name]; ok {
+			t.Errorf("veleroDeployment() env %v is set with a web identity", name)
+		}
+	}
+	if got := env["AWS_SHARED_CREDENTIALS_FILE"].Value; got != "/credentials/credentials" {
+		t.Errorf("veleroDeployment() AWS_SHARED_CREDENTIALS_FILE = %v, want %v", got, "/credentials/credentials")
+	}
+
+	volumes := make(map[string]corev1.Volume)
+	for _, v := range podSpec.Volumes {
+		volumes[v.Name] = v
+	}
+	if v := volumes["cloud-credentials"]; v.Secret == nil || v.Secret.SecretName != credentialsRequestName {
+		t.Errorf("veleroDeployment() cloud-credentials volume = %+v, want secret %v", v.VolumeSource, credentialsRequestName)
+	}
+	if v := volumes["bound-sa-token"]; v.Projected == nil || v.Projected.Sources[0].ServiceAccountToken == nil ||
+		v.Projected.Sources[0].ServiceAccountToken.Audience != "openshift" {
+		t.Errorf("veleroDeployment() bound-sa-token volume = %+v, want a projected token for audience openshift", v.VolumeSource)
+	}
+
+	mounts := make(map[string]string)
+	for _, m := range podSpec.Containers[0].VolumeMounts {
+		mounts[m.Name] = m.MountPath
+	}
+	if got := mounts["bound-sa-token"]; got != cloudTokenDir {
+		t.Errorf("veleroDeployment() bound-sa-token mounted at %v, want %v", got, cloudTokenDir)
+	}
+}
+
+func TestAWSCredentialsRequestWebIdentity(t *testing.T) {
+	roleARN := "arn:aws:iam::123456789012:role/velero"
+	awsCredentials := &veleroInstallCR.AWSCredentials{RoleARN: roleARN}
+	cr := awsCredentialsRequest("openshift-velero", credentialsRequestName, "aws", "managed-velero-backups-test", "", "", "", awsCredentials, true)
+
+	var providerSpec map[string]interface{}
+	if err := json.Unmarshal(cr.Spec.ProviderSpec.Raw, &providerSpec); err != nil {
+		t.Fatal(err)
+	}
+	if got := providerSpec["stsIAMRoleARN"]; got != roleARN {
+		t.Errorf("awsCredentialsRequest() stsIAMRoleARN = %v, want %v", got, roleARN)
+	}
+	if !reflect.DeepEqual(cr.Spec.ServiceAccountNames, []string{"velero"}) {
+		t.Errorf("awsCredentialsRequest() serviceAccountNames = %v, want %v", cr.Spec.ServiceAccountNames, []string{"velero"})
+	}
+
+	crObj, err := credentialsRequestObject(cr, cloudTokenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := unstructured.NestedString(crObj.Object, "spec", "cloudTokenPath"); got != cloudTokenPath {
+		t.Errorf("credentialsRequestObject() cloudTokenPath = %v, want %v", got, cloudTokenPath)
+	}
+	if got, _, _ := unstructured.NestedString(crObj.Object, "spec", "providerSpec", "stsIAMRoleARN"); got != roleARN {
+		t.Errorf("credentialsRequestObject() stsIAMRoleARN = %v, want %v", got, roleARN)
+	}
+
+	// Without short-lived credentials, the operator mints a user as before
+	cr = awsCredentialsRequest("openshift-velero", credentialsRequestName, "aws", "managed-velero-backups-test", "", "", "", awsCredentials, false)
+	if strings.Contains(string(cr.Spec.ProviderSpec.Raw), "stsIAMRoleARN") || cr.Spec.ServiceAccountNames != nil {
+		t.Errorf("awsCredentialsRequest() requested a web identity without short-lived credentials")
+	}
+}
+
+func TestGCPCredentialsRequestWebIdentity(t *testing.T) {
+	gcpCredentials := &veleroInstallCR.GCPCredentials{
+		ServiceAccountEmail: "velero@my-project.iam.gserviceaccount.com",
+		Audience:            "//iam.googleapis.com/projects/123456789/locations/global/workloadIdentityPools/my-pool/providers/my-provider",
+	}
+	cr := gcpCredentialsRequest("openshift-velero", credentialsRequestName, gcpCredentials, true)
+
+	var providerSpec map[string]interface{}
+	if err := json.Unmarshal(cr.Spec.ProviderSpec.Raw, &providerSpec); err != nil {
+		t.Fatal(err)
+	}
+	if got := providerSpec["serviceAccountEmail"]; got != gcpCredentials.ServiceAccountEmail {
+		t.Errorf("gcpCredentialsRequest() serviceAccountEmail = %v, want %v", got, gcpCredentials.ServiceAccountEmail)
+	}
+	if got := providerSpec["audience"]; got != gcpCredentials.Audience {
+		t.Errorf("gcpCredentialsRequest() audience = %v, want %v", got, gcpCredentials.Audience)
+	}
+	if got := providerSpec["kind"]; got != "GCPProviderSpec" {
+		t.Errorf("gcpCredentialsRequest() kind = %v, want %v", got, "GCPProviderSpec")
+	}
+	if !reflect.DeepEqual(cr.Spec.ServiceAccountNames, []string{"velero"}) {
+		t.Errorf("gcpCredentialsRequest() serviceAccountNames = %v, want %v", cr.Spec.ServiceAccountNames, []string{"velero"})
+	}
+
+	cr = gcpCredentialsRequest("openshift-velero", credentialsRequestName, nil, false)
+	if strings.Contains(string(cr.Spec.ProviderSpec.Raw), "serviceAccountEmail") || cr.Spec.ServiceAccountNames != nil {
+		t.Errorf("gcpCredentialsRequest() requested Workload Identity Federation without short-lived credentials")
+	}
+}
+
+func TestVeleroDeploymentGCPWebIdentity(t *testing.T) {
+	platformStatus := &configv1.PlatformStatus{
+		Type: configv1.GCPPlatformType,
+	}
+
+	for _, webIdentity := range []bool{false, true} {
+		deployment := veleroDeployment("openshift-velero", platformStatus, credentialsRequestName, veleroImageRegistry, webIdentity, supportedArchitectures)
+		podSpec := deployment.Spec.Template.Spec
+
+		mounted := false
+		for _, m := range podSpec.Containers[0].VolumeMounts {
+			if m.Name == "bound-sa-token" && m.MountPath == cloudTokenDir {
+				mounted = true
+			}
+		}
+		if mounted != webIdentity {
+			t.Errorf("veleroDeployment() mounted service account token = %v, want %v", mounted, webIdentity)
+		}
+
+		credentialsFile := ""
+		for _, e := range podSpec.Containers[0].Env {
+			if e.Name == "GOOGLE_APPLICATION_CREDENTIALS" {
+				credentialsFile = e.Value
+			}
+		}
+		if credentialsFile != "/credentials/service_account.json" {
+			t.Errorf("veleroDeployment() GOOGLE_APPLICATION_CREDENTIALS = %v, want %v", credentialsFile, "/credentials/service_account.json")
+		}
+	}
+}
+
+func TestAzureCredentialsRequest(t *testing.T) {
+	cr := azureCredentialsRequest("openshift-velero", credentialsRequestName)
+	var providerSpec struct {
+		RoleBindings []struct {
+			Role string `json:"role"`
+		} `json:"roleBindings"`
+	}
+	if err := json.Unmarshal(cr.Spec.ProviderSpec.Raw, &providerSpec); err != nil {
+		t.Fatal(err)
+	}
+	var roles []string
+	for _, binding := range providerSpec.RoleBindings {
+		roles = append(roles, binding.Role)
+	}
+	// Velero isn't given subscription-wide Contributor rights
+	if want := []string{"Storage Account Contributor", "Disk Snapshot Contributor", "Disk Restore Operator"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("azureCredentialsRequest() roles = %v, want %v", roles, want)
+	}
+}
+
+func TestAWSCredentialsRequestKMSKey(t *testing.T) {
+	kmsKeyARN := "arn:aws:kms:us-east-1:*:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+	cr := awsCredentialsRequest("openshift-velero", credentialsRequestName, "aws", "managed-velero-backups-test", kmsKeyARN, "", "", nil, false)
+	var providerSpec struct {
+		StatementEntries []struct {
+			Action   []string `json:"action"`
+			Resource string   `json:"resource"`
+		} `json:"statementEntries"`
+	}
+	if err := json.Unmarshal(cr.Spec.ProviderSpec.Raw, &providerSpec); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, entry := range providerSpec.StatementEntries {
+		if entry.Resource == kmsKeyARN {
+			found = true
+			if want := []string{"kms:Encrypt", "kms:Decrypt", "kms:GenerateDataKey"}; !reflect.DeepEqual(entry.Action, want) {
+				t.Errorf("awsCredentialsRequest() KMS actions = %v, want %v", entry.Action, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("awsCredentialsRequest() has no statement for key %v", kmsKeyARN)
+	}
+}
+
+func TestAWSCredentialsRequestReplica(t *testing.T) {
+	replicaKeyARN := "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	cr := awsCredentialsRequest("openshift-velero", credentialsRequestName, "aws", "managed-velero-backups-test", "",
+		"managed-velero-backups-replica", replicaKeyARN, nil, false)
+	var providerSpec struct {
+		StatementEntries []struct {
+			Action   []string `json:"action"`
+			Resource string   `json:"resource"`
+		} `json:"statementEntries"`
+	}
+	if err := json.Unmarshal(cr.Spec.ProviderSpec.Raw, &providerSpec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"arn:aws:s3:::managed-velero-backups-replica/*": {"s3:GetObject"},
+		"arn:aws:s3:::managed-velero-backups-replica":   {"s3:ListBucket"},
+		replicaKeyARN: {"kms:Decrypt"},
+	}
+	for _, entry := range providerSpec.StatementEntries {
+		if actions, ok := want[entry.Resource]; ok {
+			if !reflect.DeepEqual(entry.Action, actions) {
+				t.Errorf("awsCredentialsRequest() actions on %v = %v, want %v", entry.Resource, entry.Action, actions)
+			}
+			delete(want, entry.Resource)
+		}
+	}
+	for resource := range want {
+		t.Errorf("awsCredentialsRequest() has no statement for %v", resource)
+	}
+}
+
+func TestReplicaBackupStorageLocation(t *testing.T) {
+	instance := &veleroInstallCR.VeleroInstall{
+		Spec: veleroInstallCR.VeleroInstallSpec{
+			Storage: veleroInstallCR.StorageSpec{
+				Encryption: veleroInstallCR.StorageEncryption{
+					Mode:     veleroInstallCR.StorageEncryptionModeKMS,
+					KMSKeyID: "source-key",
+				},
+				Replication: &veleroInstallCR.StorageReplication{
+					Region:   "us-west-2",
+					KMSKeyID: "arn:aws:kms:us-west-2:123456789012:key/replica-key",
+				},
+			},
+		},
+		Status: veleroInstallCR.VeleroInstallStatus{
+			StorageBucket: veleroInstallCR.StorageBucket{
+				Name: "managed-velero-backups-test",
+				Replica: &veleroInstallCR.ReplicaBucket{
+					Name:   "managed-velero-backups-replica",
+					Region: "us-west-2",
+				},
+			},
+		},
+	}
+	locationConfig := map[string]string{"region": "us-east-1", "kmsKeyId": "source-key"}
+
+	if bsl := replicaBackupStorageLocation("openshift-velero", "aws", instance, locationConfig); bsl != nil {
+		t.Errorf("replicaBackupStorageLocation() = %v, want nil before the replica is provisioned", bsl.Name)
+	}
+
+	instance.Status.StorageBucket.Replica.Provisioned = true
+	bsl := replicaBackupStorageLocation("openshift-velero", "aws", instance, locationConfig)
+	if bsl == nil {
+		t.Fatal("replicaBackupStorageLocation() = nil")
+	}
+	if bsl.Name != storageConstants.ReplicaVeleroBackupStorageLocation {
+		t.Errorf("replicaBackupStorageLocation() name = %v, want %v", bsl.Name, storageConstants.ReplicaVeleroBackupStorageLocation)
+	}
+	if bsl.Spec.Default {
+		t.Error("replicaBackupStorageLocation() is the default location")
+	}
+	if bsl.Spec.AccessMode != velerov1.BackupStorageLocationAccessModeReadOnly {
+		t.Errorf("replicaBackupStorageLocation() access mode = %v, want %v", bsl.Spec.AccessMode, velerov1.BackupStorageLocationAccessModeReadOnly)
+	}
+	if bsl.Spec.ObjectStorage.Bucket != "managed-velero-backups-replica" {
+		t.Errorf("replicaBackupStorageLocation() bucket = %v, want %v", bsl.Spec.ObjectStorage.Bucket, "managed-velero-backups-replica")
+	}
+	want := map[string]string{"region": "us-west-2", "kmsKeyId": "arn:aws:kms:us-west-2:123456789012:key/replica-key"}
+	if !reflect.DeepEqual(bsl.Spec.Config, want) {
+		t.Errorf("replicaBackupStorageLocation() config = %v, want %v", bsl.Spec.Config, want)
+	}
+	if locationConfig["region"] != "us-east-1" {
+		t.Error("replicaBackupStorageLocation() modified the storage location's config")
+	}
+}
+
+func TestSnapshotTags(t *testing.T) {
+	got := snapshotTags(map[string]string{
+		"environment": "production",
+		"cost-center": "1234",
+	})
+	if want := "cost-center=1234,environment=production"; got != want {
+		t.Errorf("snapshotTags() = %v, want %v", got, want)
+	}
+}
+
+func TestApplyProxy(t *testing.T) {
+	platformStatus := &configv1.PlatformStatus{Type: configv1.AWSPlatformType}
+	deployment := veleroDeployment("openshift-velero", platformStatus, "velero-iam-credentials", veleroImageRegistry, false, supportedArchitectures)
+	envCount := len(deployment.Spec.Template.Spec.Containers[0].Env)
+
+	applyProxy(deployment, nil)
+	if got := len(deployment.Spec.Template.Spec.Containers[0].Env); got != envCount {
+		t.Fatalf("applyProxy() without a proxy added %d env vars", got-envCount)
+	}
+
+	applyProxy(deployment, &configv1.Proxy{
+		Status: configv1.ProxyStatus{
+			HTTPSProxy: "http://proxy.example.com:3128",
+			NoProxy:    ".cluster.local,.svc,10.0.0.0/16",
+		},
+	})
+	env := map[string]string{}
+	for _, e := range deployment.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["HTTPS_PROXY"] != "http://proxy.example.com:3128" || env["NO_PROXY"] != ".cluster.local,.svc,10.0.0.0/16" {
+		t.Errorf("applyProxy() env = %v, want HTTPS_PROXY and NO_PROXY from the proxy status", env)
+	}
+	if _, ok := env["HTTP_PROXY"]; ok {
+		t.Error("applyProxy() set HTTP_PROXY, which isn't configured")
+	}
+}
+
+func TestHashPodInputs(t *testing.T) {
+	secret := &corev1.Secret{Data: map[string][]byte{
+		"aws_access_key_id":     []byte("id"),
+		"aws_secret_access_key": []byte("key"),
+	}}
+	caBundle := &corev1.ConfigMap{Data: map[string]string{"ca-bundle.crt": "bundle"}}
+
+	hash := hashPodInputs(secret, caBundle)
+	if hash != hashPodInputs(secret.DeepCopy(), caBundle.DeepCopy()) {
+		t.Error("hashPodInputs() isn't stable")
+	}
+
+	rotated := secret.DeepCopy()
+	rotated.Data["aws_secret_access_key"] = []byte("rotated")
+	if hashPodInputs(rotated, caBundle) == hash {
+		t.Error("hashPodInputs() didn't change when the credentials were rotated")
+	}
+
+	renewed := caBundle.DeepCopy()
+	renewed.Data["ca-bundle.crt"] = "renewed"
+	if hashPodInputs(secret, renewed) == hash {
+		t.Error("hashPodInputs() didn't change when the CA bundle was renewed")
+	}
+
+	if hashPodInputs(nil, caBundle) == hash {
+		t.Error("hashPodInputs() didn't change when the credentials were missing")
+	}
+}
@@ -2,17 +2,30 @@ package velero
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	configv1 "github.com/openshift/api/config/v1"
 	minterv1 "github.com/openshift/cloud-credential-operator/pkg/apis/cloudcredential/v1"
 	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 
+	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crhandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
@@ -21,6 +34,7 @@ import (
 	"github.com/cblecker/platformutils"
 	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
 	"github.com/openshift/managed-velero-operator/pkg/storage"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
 )
 
 var (
@@ -28,16 +42,27 @@ var (
 	s3ReconcilePeriod = 60 * time.Minute
 )
 
+// storageFinalizer holds a VeleroInstall until its deletion policy has been
+// applied to the storage bucket
+const storageFinalizer = "managed.openshift.io/storage-bucket"
+
 // VeleroInstallReconciler reconciles a Velero object
 type VeleroInstallReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	driver storage.Driver
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	driver   storage.Driver
+	// driverInfraStatus is the Infrastructure status the driver was built from
+	driverInfraStatus *configv1.InfrastructureStatus
+	// storageSyncPending is set when the driver is built, so that the bucket
+	// is synced with the region and resource tags it was built with
+	storageSyncPending bool
 }
 
 //+kubebuilder:rbac:groups=managed.openshift.io,resources=veleroinstalls,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=managed.openshift.io,resources=veleroinstalls/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=managed.openshift.io,resources=veleroinstalls/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile reads that state of the cluster for a Velero object and makes changes based on the state read
 // and what is in the Velero.Spec
@@ -63,37 +88,237 @@ func (r *VeleroInstallReconciler) Reconcile(ctx context.Context, request ctrl.Re
 	// Grab infrastructureStatus to determine where OpenShift is installed.
 	pc, err := platformutils.NewClient(ctx)
 	if err != nil {
-		return reconcile.Result{}, err
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonPlatformError, err)
 	}
 	infraStatus, err := pc.GetInfrastructureStatus()
 	if err != nil {
-		return reconcile.Result{}, err
+		return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonPlatformError, err)
 	}
 
-	// Create the Storage Driver
-	if r.driver == nil {
-		r.driver, err = storage.NewDriver(infraStatus, r.Client)
+	// Create the Storage Driver. The driver takes the region and the resource
+	// tags from the Infrastructure status, so it's built again when they change.
+	if r.driver == nil || !equality.Semantic.DeepEqual(r.driverInfraStatus, infraStatus) {
+		reqLogger.Info("Creating storage driver")
+		r.driver, err = storage.NewDriver(infraStatus, r.Client, r.Recorder)
 		if err != nil {
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonPlatformError, err)
+		}
+		r.driverInfraStatus = infraStatus.DeepCopy()
+		r.storageSyncPending = true
+	}
+
+	// Apply the deletion policy to the storage bucket before letting the instance go
+	if !instance.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, r.finalizeStorage(reqLogger, instance)
+	}
+	if !controllerutil.ContainsFinalizer(instance, storageFinalizer) {
+		reqLogger.Info("Adding storage finalizer")
+		controllerutil.AddFinalizer(instance, storageFinalizer)
+		if err = r.Update(ctx, instance); err != nil {
 			return reconcile.Result{}, err
 		}
 	}
 
 	// Check if bucket needs to be reconciled
-	if instance.StorageBucketReconcileRequired(s3ReconcilePeriod) {
+	if r.storageSyncPending || instance.StorageBucketReconcileRequired(s3ReconcilePeriod) {
 		// Create storage using the storage driver
 		// Always return from this, as we will either be updating the status *or* there will be an error.
-		return reconcile.Result{}, r.driver.CreateStorage(reqLogger, instance)
+		// The status the driver persists records the generation of the spec it synced.
+		observedGeneration := instance.Status.ObservedGeneration
+		instance.Status.ObservedGeneration = instance.Generation
+		if err = r.driver.CreateStorage(reqLogger, instance); err != nil {
+			instance.Status.ObservedGeneration = observedGeneration
+			return reconcile.Result{}, r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonStorageReconcileFailed, err)
+		}
+		r.storageSyncPending = false
+		return reconcile.Result{}, nil
 	}
 
 	// Now go provision Velero
 	return r.provisionVelero(reqLogger, request.Namespace, infraStatus.PlatformStatus, instance)
 }
 
+// finalizeStorage applies the deletion policy to the storage bucket, and then
+// removes the finalizer so that the instance can be deleted.
+func (r *VeleroInstallReconciler) finalizeStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	var err error
+
+	if !controllerutil.ContainsFinalizer(instance, storageFinalizer) {
+		return nil
+	}
+
+	// Only a bucket that has been provisioned or recovered belongs to the instance
+	bucketName := instance.Status.StorageBucket.Name
+	if bucketName != "" && instance.Status.StorageBucket.Provisioned {
+		policy := instance.Spec.GetDeletionPolicy()
+		bucketLog := reqLogger.WithValues("StorageBucket.Name", bucketName, "DeletionPolicy", policy)
+		existingBucket := instance.Spec.Storage.ExistingBucket
+		switch {
+		// An existing bucket is left untouched unless it is managed
+		case existingBucket != nil && !existingBucket.Manage:
+			bucketLog.Info("Retaining unmanaged storage bucket")
+		case policy == veleroInstallCR.DeletionPolicyRetainAndTag:
+			bucketLog.Info("Retaining storage bucket and tagging it as orphaned")
+			err = r.driver.MarkStorageOrphaned(reqLogger, instance)
+		// Locked backups can't be deleted, so the bucket could never be emptied
+		case policy == veleroInstallCR.DeletionPolicyDelete && instance.Status.StorageBucket.ObjectLock != nil:
+			bucketLog.Info("Retaining storage bucket with object lock enabled and tagging it as orphaned")
+			err = r.driver.MarkStorageOrphaned(reqLogger, instance)
+		case policy == veleroInstallCR.DeletionPolicyDelete:
+			bucketLog.Info("Deleting storage bucket and all backups in it")
+			err = r.driver.DeleteStorage(reqLogger, instance)
+		default:
+			bucketLog.Info("Retaining storage bucket")
+		}
+		if err != nil {
+			return r.failReconcile(reqLogger, instance, veleroInstallCR.ConditionReady, veleroInstallCR.ReasonStorageCleanupFailed,
+				fmt.Errorf("unable to apply deletion policy %v to bucket %v: %v", policy, bucketName, err))
+		}
+	}
+
+	reqLogger.Info("Removing storage finalizer")
+	controllerutil.RemoveFinalizer(instance, storageFinalizer)
+	return r.Update(context.TODO(), instance)
+}
+
+// failReconcile records a failed condition on the instance, marks it not
+// Ready, and persists the status. The generation isn't marked as observed, so
+// that the storage bucket is synced again when the instance is retried. The
+// original error is returned so it can be passed back to the controller for a
+// retry.
+func (r *VeleroInstallReconciler) failReconcile(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall, conditionType, reason string, err error) error {
+	instance.SetCondition(conditionType, metav1.ConditionFalse, reason, err.Error())
+	if conditionType != veleroInstallCR.ConditionReady {
+		instance.SetCondition(veleroInstallCR.ConditionReady, metav1.ConditionFalse, reason, err.Error())
+	}
+	// StatusUpdate logs its own failure; the reconcile error is the one worth returning.
+	_ = instance.StatusUpdate(reqLogger, r.Client)
+	return err
+}
+
+// updateStatusIfChanged persists the status of the instance if it differs
+// from the original status that was read at the start of the reconcile.
+func (r *VeleroInstallReconciler) updateStatusIfChanged(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall, origStatus *veleroInstallCR.VeleroInstallStatus) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	if equality.Semantic.DeepEqual(origStatus, &instance.Status) {
+		return nil
+	}
+	return instance.StatusUpdate(reqLogger, r.Client)
+}
+
+// enqueueVeleroInstalls returns a request for each VeleroInstall in the
+// namespace of the object, or in any namespace for a cluster-scoped object, so
+// that a change to an object they depend on, but don't own, is reconciled
+// straight away.
+func (r *VeleroInstallReconciler) enqueueVeleroInstalls(obj client.Object) []reconcile.Request {
+	instances := &veleroInstallCR.VeleroInstallList{}
+	if err := r.List(context.TODO(), instances, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.Error(err, "Unable to list VeleroInstalls", "Object.Name", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(instances.Items))
+	for _, instance := range instances.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name},
+		})
+	}
+	return requests
+}
+
+// enqueueSecretReaders returns a request for each VeleroInstall in the
+// namespace of the secret that reads it. When the operator's credentials are
+// rotated, the storage driver builds its cloud clients again from the new
+// secret, and when Velero's credentials are rotated, its pod is rolled.
+func (r *VeleroInstallReconciler) enqueueSecretReaders(obj client.Object) []reconcile.Request {
+	instances := &veleroInstallCR.VeleroInstallList{}
+	if err := r.List(context.TODO(), instances, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.Error(err, "Unable to list VeleroInstalls", "Object.Name", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, instance := range instances.Items {
+		if readsSecret(&instance, obj.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// readsSecret returns true if the instance reads the named secret: either
+// the operator's or Velero's cloud credentials, or the credentials of the
+// S3-compatible object store it is configured with.
+func readsSecret(instance *veleroInstallCR.VeleroInstall, name string) bool {
+	if name == storageBase.CredentialsSecretName || name == credentialsRequestName {
+		return true
+	}
+	storageConfig := instance.Spec.Storage.S3Compatible
+	return storageConfig != nil && storageConfig.CredentialsSecretName == name
+}
+
+// hasName returns a predicate that only passes objects with the given name
+func hasName(name string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == name
+	})
+}
+
+// inputChanged passes updates that change the content the operator reads
+// from an object, and filters out those that only touch its metadata, such
+// as resyncs and changes to annotations or managed fields.
+var inputChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !equality.Semantic.DeepEqual(inputContent(e.ObjectOld), inputContent(e.ObjectNew))
+	},
+}
+
+// inputContent returns the part of a watched object that the operator reads
+func inputContent(obj client.Object) interface{} {
+	switch o := obj.(type) {
+	case *corev1.Secret:
+		return o.Data
+	case *corev1.ConfigMap:
+		return []interface{}{o.Data, o.BinaryData}
+	case *configv1.Infrastructure:
+		return []interface{}{o.Spec, o.Status}
+	case *configv1.Proxy:
+		return []interface{}{o.Spec, o.Status}
+	case *corev1.Node:
+		return nodePlacement(o)
+	default:
+		return obj
+	}
+}
+
+// nodePlacement returns the parts of a node that decide where Velero can run:
+// its role and architecture. The role labels are usually empty, so their
+// presence is compared.
+func nodePlacement(node *corev1.Node) interface{} {
+	labels := map[string]string{}
+	for _, key := range []string{nodeRoleWorkerLabel, nodeRoleInfraLabel, corev1.LabelArchStable} {
+		if value, ok := node.Labels[key]; ok {
+			labels[key] = value
+		}
+	}
+	return []interface{}{labels, node.Status.NodeInfo.Architecture}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *VeleroInstallReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&veleroInstallCR.VeleroInstall{}).
 		Watches(&source.Kind{Type: &veleroInstallCR.VeleroInstall{}}, &handler.InstrumentedEnqueueRequestForObject{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, crhandler.EnqueueRequestsFromMapFunc(r.enqueueSecretReaders),
+			builder.WithPredicates(inputChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, crhandler.EnqueueRequestsFromMapFunc(r.enqueueVeleroInstalls),
+			builder.WithPredicates(hasName(trustedCABundleName), inputChanged)).
+		Watches(&source.Kind{Type: &configv1.Infrastructure{}}, crhandler.EnqueueRequestsFromMapFunc(r.enqueueVeleroInstalls),
+			builder.WithPredicates(hasName(clusterConfigName), inputChanged)).
+		Watches(&source.Kind{Type: &configv1.Proxy{}}, crhandler.EnqueueRequestsFromMapFunc(r.enqueueVeleroInstalls),
+			builder.WithPredicates(hasName(clusterConfigName), inputChanged)).
+		Watches(&source.Kind{Type: &corev1.Node{}}, crhandler.EnqueueRequestsFromMapFunc(r.enqueueVeleroInstalls),
+			builder.WithPredicates(inputChanged)).
 		Owns(&velerov1.BackupStorageLocation{}).
 		Owns(&velerov1.VolumeSnapshotLocation{}).
 		Owns(&minterv1.CredentialsRequest{}).
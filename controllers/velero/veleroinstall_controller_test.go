@@ -0,0 +1,284 @@
+package velero
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+)
+
+// fakeDriver records which deletion policy actions were taken on the storage
+type fakeDriver struct {
+	deleted  bool
+	orphaned bool
+	err      error
+}
+
+func (d *fakeDriver) GetPlatformType() configv1.PlatformType {
+	return configv1.AWSPlatformType
+}
+
+func (d *fakeDriver) CreateStorage(logr.Logger, *veleroInstallCR.VeleroInstall) error {
+	return nil
+}
+
+func (d *fakeDriver) StorageExists(string) (bool, error) {
+	return true, nil
+}
+
+func (d *fakeDriver) DeleteStorage(logr.Logger, *veleroInstallCR.VeleroInstall) error {
+	d.deleted = true
+	return d.err
+}
+
+func (d *fakeDriver) MarkStorageOrphaned(logr.Logger, *veleroInstallCR.VeleroInstall) error {
+	d.orphaned = true
+	return d.err
+}
+
+func TestFinalizeStorage(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       veleroInstallCR.DeletionPolicy
+		existing     *veleroInstallCR.ExistingBucket
+		objectLock   *veleroInstallCR.ObjectLockStatus
+		provisioned  bool
+		driverErr    error
+		wantDeleted  bool
+		wantOrphaned bool
+		wantErr      bool
+	}{
+		{
+			name:        "retain by default",
+			provisioned: true,
+		},
+		{
+			name:         "retain and tag",
+			policy:       veleroInstallCR.DeletionPolicyRetainAndTag,
+			provisioned:  true,
+			wantOrphaned: true,
+		},
+		{
+			name:        "delete",
+			policy:      veleroInstallCR.DeletionPolicyDelete,
+			provisioned: true,
+			wantDeleted: true,
+		},
+		{
+			name:        "retain an unmanaged existing bucket",
+			policy:      veleroInstallCR.DeletionPolicyDelete,
+			existing:    &veleroInstallCR.ExistingBucket{Name: "managed-velero-backups-test"},
+			provisioned: true,
+		},
+		{
+			name:        "delete a managed existing bucket",
+			policy:      veleroInstallCR.DeletionPolicyDelete,
+			existing:    &veleroInstallCR.ExistingBucket{Name: "managed-velero-backups-test", Manage: true},
+			provisioned: true,
+			wantDeleted: true,
+		},
+		{
+			name:         "retain and tag a bucket with object lock enabled",
+			policy:       veleroInstallCR.DeletionPolicyDelete,
+			objectLock:   &veleroInstallCR.ObjectLockStatus{Enabled: true},
+			provisioned:  true,
+			wantOrphaned: true,
+		},
+		{
+			name:   "nothing to delete before the bucket is provisioned",
+			policy: veleroInstallCR.DeletionPolicyDelete,
+		},
+		{
+			name:        "keep finalizer when the policy can't be applied",
+			policy:      veleroInstallCR.DeletionPolicyDelete,
+			provisioned: true,
+			driverErr:   fmt.Errorf("access denied"),
+			wantDeleted: true,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := metav1.Now()
+			instance := &veleroInstallCR.VeleroInstall{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "cluster",
+					Namespace:         "openshift-velero",
+					DeletionTimestamp: &now,
+					Finalizers:        []string{storageFinalizer},
+				},
+				Spec: veleroInstallCR.VeleroInstallSpec{
+					DeletionPolicy: tt.policy,
+					Storage: veleroInstallCR.StorageSpec{
+						ExistingBucket: tt.existing,
+					},
+				},
+				Status: veleroInstallCR.VeleroInstallStatus{
+					StorageBucket: veleroInstallCR.StorageBucket{
+						Name:        "managed-velero-backups-test",
+						Provisioned: tt.provisioned,
+						ObjectLock:  tt.objectLock,
+					},
+				},
+			}
+			s := scheme.Scheme
+			s.AddKnownTypes(veleroInstallCR.GroupVersion, instance)
+			driver := &fakeDriver{err: tt.driverErr}
+			r := &VeleroInstallReconciler{
+				Client: fake.NewClientBuilder().WithScheme(s).WithObjects(instance).Build(),
+				Scheme: s,
+				driver: driver,
+			}
+
+			err := r.finalizeStorage(logr.Discard(), instance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("finalizeStorage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if driver.deleted != tt.wantDeleted {
+				t.Errorf("finalizeStorage() deleted storage = %v, want %v", driver.deleted, tt.wantDeleted)
+			}
+			if driver.orphaned != tt.wantOrphaned {
+				t.Errorf("finalizeStorage() marked storage orphaned = %v, want %v", driver.orphaned, tt.wantOrphaned)
+			}
+
+			found := &veleroInstallCR.VeleroInstall{}
+			err = r.Get(context.TODO(), client.ObjectKeyFromObject(instance), found)
+			if err != nil && !errors.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			finalized := errors.IsNotFound(err) || !controllerutil.ContainsFinalizer(found, storageFinalizer)
+			if finalized == tt.wantErr {
+				t.Errorf("finalizeStorage() removed finalizer = %v, want %v", finalized, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnqueueVeleroInstalls(t *testing.T) {
+	instance := &veleroInstallCR.VeleroInstall{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster",
+			Namespace: "openshift-velero",
+		},
+	}
+	s := scheme.Scheme
+	s.AddKnownTypes(veleroInstallCR.GroupVersion, instance, &veleroInstallCR.VeleroInstallList{})
+	r := &VeleroInstallReconciler{
+		Client: fake.NewClientBuilder().WithScheme(s).WithObjects(instance).Build(),
+		Scheme: s,
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      storageBase.CredentialsSecretName,
+			Namespace: "openshift-velero",
+		},
+	}
+	requests := r.enqueueVeleroInstalls(secret)
+	if len(requests) != 1 || requests[0].Name != "cluster" || requests[0].Namespace != "openshift-velero" {
+		t.Errorf("enqueueVeleroInstalls() = %v, want the cluster VeleroInstall", requests)
+	}
+
+	// Cluster-scoped objects enqueue the VeleroInstall in any namespace
+	requests = r.enqueueVeleroInstalls(&configv1.Proxy{ObjectMeta: metav1.ObjectMeta{Name: clusterConfigName}})
+	if len(requests) != 1 || requests[0].Name != "cluster" {
+		t.Errorf("enqueueVeleroInstalls() = %v for the Proxy, want the cluster VeleroInstall", requests)
+	}
+}
+
+func TestEnqueueSecretReaders(t *testing.T) {
+	instance := &veleroInstallCR.VeleroInstall{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster",
+			Namespace: "openshift-velero",
+		},
+		Spec: veleroInstallCR.VeleroInstallSpec{
+			Storage: veleroInstallCR.StorageSpec{
+				S3Compatible: &veleroInstallCR.S3CompatibleStorage{
+					CredentialsSecretName: "object-store-credentials",
+				},
+			},
+		},
+	}
+	s := scheme.Scheme
+	s.AddKnownTypes(veleroInstallCR.GroupVersion, instance, &veleroInstallCR.VeleroInstallList{})
+	r := &VeleroInstallReconciler{
+		Client: fake.NewClientBuilder().WithScheme(s).WithObjects(instance).Build(),
+		Scheme: s,
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: storageBase.CredentialsSecretName, want: true},
+		{name: credentialsRequestName, want: true},
+		{name: "object-store-credentials", want: true},
+		{name: "unrelated", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: tt.name, Namespace: "openshift-velero"}}
+			requests := r.enqueueSecretReaders(secret)
+			if got := len(requests) == 1; got != tt.want {
+				t.Errorf("enqueueSecretReaders() = %v, want the cluster VeleroInstall %v", requests, tt.want)
+			}
+		})
+	}
+}
+
+func TestInputChanged(t *testing.T) {
+	old := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: credentialsRequestName, ResourceVersion: "1"},
+		Data:       map[string][]byte{"aws_access_key_id": []byte("old")},
+	}
+
+	resynced := old.DeepCopy()
+	resynced.ResourceVersion = "2"
+	resynced.Annotations = map[string]string{"example.com/touched": "true"}
+	if inputChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: resynced}) {
+		t.Error("inputChanged passed an update to the secret's metadata")
+	}
+
+	rotated := old.DeepCopy()
+	rotated.Data["aws_access_key_id"] = []byte("new")
+	if !inputChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: rotated}) {
+		t.Error("inputChanged filtered an update to the secret's data")
+	}
+
+	proxy := &configv1.Proxy{ObjectMeta: metav1.ObjectMeta{Name: clusterConfigName}}
+	updatedProxy := proxy.DeepCopy()
+	updatedProxy.Status.HTTPSProxy = "http://proxy.example.com:3128"
+	if !inputChanged.Update(event.UpdateEvent{ObjectOld: proxy, ObjectNew: updatedProxy}) {
+		t.Error("inputChanged filtered an update to the proxy's status")
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{corev1.LabelArchStable: "amd64"}},
+	}
+	heartbeat := node.DeepCopy()
+	heartbeat.Labels["example.com/zone"] = "a"
+	heartbeat.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	if inputChanged.Update(event.UpdateEvent{ObjectOld: node, ObjectNew: heartbeat}) {
+		t.Error("inputChanged passed an update to the node's status and other labels")
+	}
+	worker := node.DeepCopy()
+	worker.Labels[nodeRoleWorkerLabel] = ""
+	if !inputChanged.Update(event.UpdateEvent{ObjectOld: node, ObjectNew: worker}) {
+		t.Error("inputChanged filtered an update to the node's role")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"reflect"
 	"runtime"
 
 	"github.com/operator-framework/operator-lib/leader"
@@ -16,7 +15,6 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -30,6 +28,7 @@ import (
 
 	managedv1alpha2 "github.com/openshift/managed-velero-operator/api/v1alpha2"
 	veleroctrl "github.com/openshift/managed-velero-operator/controllers/velero"
+	"github.com/openshift/managed-velero-operator/pkg/ensure"
 	"github.com/openshift/managed-velero-operator/pkg/velero"
 	"github.com/openshift/managed-velero-operator/version"
 	opmetrics "github.com/openshift/operator-custom-metrics/pkg/metrics"
@@ -65,6 +64,11 @@ const (
 var supportedPlatforms = []configv1.PlatformType{
 	configv1.AWSPlatformType,
 	configv1.GCPPlatformType,
+	configv1.AzurePlatformType,
+	configv1.BareMetalPlatformType,
+	configv1.NonePlatformType,
+	configv1.VSpherePlatformType,
+	configv1.OpenStackPlatformType,
 }
 
 func init() {
@@ -178,8 +182,9 @@ func main() {
 	}
 
 	if err = (&veleroctrl.VeleroInstallReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor(OperatorName),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VeleroInstall")
 		os.Exit(1)
@@ -221,71 +226,28 @@ func getWatchNamespace() (string, error) {
 // addMetrics will create the Services and Service Monitors to allow the operator export the metrics by using
 // the Prometheus operator
 func addMetrics(ctx context.Context, cl crclient.Client, cfg *rest.Config) error {
-	foundService := &corev1.Service{}
 	service, err := opmetrics.GenerateService(metricsPort, "http-metrics", OperatorName+"-metrics", ManagedVeleroOperatorNamespace, map[string]string{"name": OperatorName})
-	service.Spec.SessionAffinity = corev1.ServiceAffinityNone // Set session affinity to None (default)
-	service.Spec.Type = corev1.ServiceTypeClusterIP           // Set service type to ClusterIP (default)
 	if err != nil {
 		log.Error(err, "Could not generate metrics service")
 		return err
 	}
-	if err = cl.Get(ctx, crclient.ObjectKeyFromObject(service), foundService); err != nil {
-		if apierrors.IsNotFound(err) {
-			// Didn't find metrics service, so create it
-			log.Info("Creating metrics service")
-			if err = cl.Create(ctx, service); err != nil {
-				log.Error(err, "Could not create metrics service")
-				return err
-			}
-		} else {
-			log.Error(err, "Could not get existing metrics service")
-			return err
-		}
-	} else {
-		// Service exists, check if it's updated.
-		// Note: We leave fields related to cluster IP address, IPv6, and
-		// InternalTrafficPolicy alone.
-		service.Spec.ClusterIP = foundService.Spec.ClusterIP
-		service.Spec.ClusterIPs = foundService.Spec.ClusterIPs
-		service.Spec.IPFamilies = foundService.Spec.IPFamilies
-		service.Spec.IPFamilyPolicy = foundService.Spec.IPFamilyPolicy
-		service.Spec.InternalTrafficPolicy = foundService.Spec.InternalTrafficPolicy
-		if !reflect.DeepEqual(foundService.Spec, service.Spec) {
-			// Specs aren't equal, update and fix.
-			log.Info("Updating metrics service", "foundService.Spec", foundService.Spec, "service.Spec", service.Spec)
-			foundService.Spec = *service.Spec.DeepCopy()
-			if err = cl.Update(ctx, foundService); err != nil {
-				log.Error(err, "Could not update metrics service")
-				return err
-			}
-		}
-	}
-
-	foundServiceMonitor := &monitoringv1.ServiceMonitor{}
+	service.Spec.SessionAffinity = corev1.ServiceAffinityNone // Set session affinity to None (default)
+	service.Spec.Type = corev1.ServiceTypeClusterIP           // Set service type to ClusterIP (default)
 	serviceMonitor := opmetrics.GenerateServiceMonitor(service)
-	if err = cl.Get(ctx, crclient.ObjectKeyFromObject(serviceMonitor), foundServiceMonitor); err != nil {
-		if apierrors.IsNotFound(err) {
-			// Didn't find service monitor, so create it
-			log.Info("Creating service monitor")
-			if err = cl.Create(ctx, serviceMonitor); err != nil {
-				log.Error(err, "Could not create service monitor")
-				return err
-			}
-		} else {
-			log.Error(err, "Could not get existing service monitor")
-			return err
-		}
-	} else {
-		// ServiceMonitor exists, check if it's updated.
-		if !reflect.DeepEqual(foundServiceMonitor.Spec, serviceMonitor.Spec) {
-			// Specs aren't equal, update and fix.
-			log.Info("Updating service monitor", "foundServiceMonitor.Spec", foundServiceMonitor.Spec, "serviceMonitor.Spec", serviceMonitor.Spec)
-			foundServiceMonitor.Spec = *serviceMonitor.Spec.DeepCopy()
-			if err = cl.Update(ctx, foundServiceMonitor); err != nil {
-				log.Error(err, "Could not update service monitor")
-				return err
-			}
-		}
+
+	// The operator's own metrics objects have no owner
+	engine := &ensure.Engine{
+		Client:       cl,
+		Scheme:       cl.Scheme(),
+		FieldManager: OperatorName,
+		Log:          log,
+	}
+	if _, err := engine.Ensure(ctx,
+		ensure.Object{Desired: service, Ownership: ensure.OwnershipAsIs},
+		ensure.Object{Desired: serviceMonitor, Ownership: ensure.OwnershipAsIs},
+	); err != nil {
+		log.Error(err, "Could not reconcile metrics service and service monitor")
+		return err
 	}
 
 	return nil
@@ -0,0 +1,281 @@
+// Package ensure reconciles the objects that the operator manages towards
+// their desired state, reporting what it did for each of them.
+package ensure
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openshift/managed-velero-operator/pkg/metrics"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Event reasons of the Events emitted on the owner of the ensured objects
+const (
+	EventReasonObjectCreated = "ObjectCreated"
+	EventReasonObjectUpdated = "ObjectUpdated"
+	EventReasonObjectFailed  = "ObjectReconcileFailed"
+)
+
+// Result is what was done to an object to ensure it
+type Result string
+
+const (
+	ResultCreated   Result = "created"
+	ResultUpdated   Result = "updated"
+	ResultUnchanged Result = "unchanged"
+	ResultError     Result = "error"
+)
+
+// Ownership decides which owner references an object is written with
+type Ownership int
+
+const (
+	// ControlledByOwner sets the engine's owner as the object's controller,
+	// so that the object is garbage collected with it
+	ControlledByOwner Ownership = iota
+	// OwnershipAsIs writes the object with the owner references it was
+	// given, for objects that are controlled by another object, or by nothing
+	OwnershipAsIs
+)
+
+// Comparison decides how an existing object is compared with its desired state
+type Comparison int
+
+const (
+	// Apply writes the object with server-side apply as the engine's field
+	// manager. Only the fields set on the desired object are owned by the
+	// engine, so fields defaulted by the API server or set by other actors
+	// are left alone. The object is updated when an applied field changed.
+	Apply Comparison = iota
+	// CreateOnly creates the object if it doesn't exist, and otherwise
+	// leaves it as it is
+	CreateOnly
+)
+
+// Object is an object to ensure, with the strategies to ensure it with
+type Object struct {
+	// Desired is the desired state of the object. Once ensured, it holds the
+	// object as stored by the API server.
+	Desired    client.Object
+	Ownership  Ownership
+	Comparison Comparison
+}
+
+// ObjectResult reports what was done to ensure an object
+type ObjectResult struct {
+	Kind   string
+	Name   string
+	Result Result
+	// Changed lists the paths of the fields that were updated
+	Changed []string
+	Err     error
+}
+
+// Engine ensures objects with the same client, owner and field manager
+type Engine struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+	// Recorder emits Events on the owner, if both are set
+	Recorder record.EventRecorder
+	// Owner is the controller of objects ensured as ControlledByOwner
+	Owner        client.Object
+	FieldManager string
+	Log          logr.Logger
+}
+
+// Ensure ensures each of the objects in turn, and returns their results. An
+// object that fails doesn't stop the others from being ensured, and the
+// errors of all failed objects are returned together.
+func (e *Engine) Ensure(ctx context.Context, objects ...Object) ([]ObjectResult, error) {
+	results := make([]ObjectResult, 0, len(objects))
+	var errs []error
+	for _, object := range objects {
+		result := e.EnsureObject(ctx, object)
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+		results = append(results, result)
+	}
+	return results, utilerrors.NewAggregate(errs)
+}
+
+// EnsureObject ensures a single object. The result is counted in the
+// reconciled objects metric, and created, updated and failed objects are
+// reported with an Event on the owner.
+func (e *Engine) EnsureObject(ctx context.Context, object Object) ObjectResult {
+	result := ObjectResult{Name: object.Desired.GetName()}
+	gvk, err := apiutil.GVKForObject(object.Desired, e.Scheme)
+	if err != nil {
+		result.Result, result.Err = ResultError, err
+		e.record(result)
+		return result
+	}
+	result.Kind = gvk.Kind
+
+	result.Result, result.Changed, err = e.ensure(ctx, object, gvk)
+	if err != nil {
+		result.Result = ResultError
+		result.Err = fmt.Errorf("unable to reconcile %v %v: %v", result.Kind, result.Name, err)
+	}
+	e.record(result)
+	return result
+}
+
+func (e *Engine) ensure(ctx context.Context, object Object, gvk schema.GroupVersionKind) (Result, []string, error) {
+	obj := object.Desired
+	// The apply request names the object's kind, and typed objects are
+	// usually built without it
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	if object.Ownership == ControlledByOwner {
+		if e.Owner == nil {
+			return ResultError, nil, fmt.Errorf("no owner to control the object")
+		}
+		if err := controllerutil.SetControllerReference(e.Owner, obj, e.Scheme); err != nil {
+			return ResultError, nil, err
+		}
+	}
+
+	desired, err := desiredContent(obj)
+	if err != nil {
+		return ResultError, nil, err
+	}
+
+	found := &unstructured.Unstructured{}
+	found.SetGroupVersionKind(gvk)
+	if err := e.Client.Get(ctx, client.ObjectKeyFromObject(obj), found); err != nil {
+		if !errors.IsNotFound(err) {
+			return ResultError, nil, err
+		}
+		found = nil
+	}
+
+	switch object.Comparison {
+	case CreateOnly:
+		if found != nil {
+			return ResultUnchanged, nil, setContent(obj, found.Object)
+		}
+		e.Log.Info("Creating "+gvk.Kind, "Name", obj.GetName())
+		return ResultCreated, nil, e.Client.Create(ctx, obj)
+	case Apply:
+		if found == nil {
+			e.Log.Info("Creating "+gvk.Kind, "Name", obj.GetName())
+		}
+		// The object is applied without the fields that it can't set, so that
+		// the operator doesn't own them, and they aren't reported as changed
+		applied := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(desired)}
+		if err := e.Client.Patch(ctx, applied, client.Apply, client.FieldOwner(e.FieldManager), client.ForceOwnership); err != nil {
+			return ResultError, nil, err
+		}
+		if err := setContent(obj, applied.Object); err != nil {
+			return ResultError, nil, err
+		}
+		if found == nil {
+			return ResultCreated, nil, nil
+		}
+		changed := ChangedFields(desired, found.Object)
+		if len(changed) == 0 {
+			return ResultUnchanged, nil, nil
+		}
+		e.Log.Info("Updated "+gvk.Kind, "Name", obj.GetName(), "changed", changed)
+		return ResultUpdated, changed, nil
+	default:
+		return ResultError, nil, fmt.Errorf("unknown comparison %v", object.Comparison)
+	}
+}
+
+// desiredContent returns the content of the object to apply. Typed objects
+// always carry a status and a creation timestamp, even when they are empty, but
+// neither is written by applying the object, so both are left out.
+func desiredContent(obj client.Object) (map[string]interface{}, error) {
+	desired, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	desired = runtime.DeepCopyJSON(desired)
+	delete(desired, "status")
+	unstructured.RemoveNestedField(desired, "metadata", "creationTimestamp")
+	return desired, nil
+}
+
+// setContent sets obj to the content of an object read from the API server
+func setContent(obj client.Object, content map[string]interface{}) error {
+	if u, ok := obj.(runtime.Unstructured); ok {
+		u.SetUnstructuredContent(content)
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, obj)
+}
+
+// record counts the result in the reconciled objects metric, and emits an
+// Event on the owner unless the object was unchanged
+func (e *Engine) record(result ObjectResult) {
+	metrics.ReconciledObjectsTotal.WithLabelValues(result.Kind, string(result.Result)).Inc()
+	if e.Recorder == nil || e.Owner == nil {
+		return
+	}
+	switch result.Result {
+	case ResultCreated:
+		e.Recorder.Eventf(e.Owner, corev1.EventTypeNormal, EventReasonObjectCreated, "Created %v %v", result.Kind, result.Name)
+	case ResultUpdated:
+		e.Recorder.Eventf(e.Owner, corev1.EventTypeNormal, EventReasonObjectUpdated, "Updated %v %v: %v", result.Kind, result.Name, strings.Join(result.Changed, ", "))
+	case ResultError:
+		e.Recorder.Eventf(e.Owner, corev1.EventTypeWarning, EventReasonObjectFailed, "%v", result.Err)
+	}
+}
+
+// ChangedFields returns the paths of the fields set in desired whose values
+// differ from those found, sorted. Fields that are unset in desired aren't
+// applied, so they are never reported, even when the API server has defaulted
+// them. Lists of the same length are compared element by element, and lists
+// whose length changed are reported as a whole.
+func ChangedFields(desired, found map[string]interface{}) []string {
+	var changed []string
+	var compare func(path string, desired, found interface{})
+	compare = func(path string, desired, found interface{}) {
+		switch desiredValue := desired.(type) {
+		case nil:
+			return
+		case map[string]interface{}:
+			foundMap, _ := found.(map[string]interface{})
+			for key, value := range desiredValue {
+				fieldPath := key
+				if path != "" {
+					fieldPath = path + "." + key
+				}
+				compare(fieldPath, value, foundMap[key])
+			}
+		case []interface{}:
+			foundList, _ := found.([]interface{})
+			if len(desiredValue) != len(foundList) {
+				changed = append(changed, path)
+				return
+			}
+			for i := range desiredValue {
+				compare(fmt.Sprintf("%v[%d]", path, i), desiredValue[i], foundList[i])
+			}
+		default:
+			if !equality.Semantic.DeepEqual(desired, found) {
+				changed = append(changed, path)
+			}
+		}
+	}
+	compare("", desired, found)
+	sort.Strings(changed)
+	return changed
+}
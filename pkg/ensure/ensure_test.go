@@ -0,0 +1,238 @@
+package ensure
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	minterv1 "github.com/openshift/cloud-credential-operator/pkg/apis/cloudcredential/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/openshift/managed-velero-operator/pkg/metrics"
+)
+
+func TestChangedFields(t *testing.T) {
+	// The deployment is shaped like the Velero deployment that the operator
+	// applies, and found holds it as read back from the API server
+	replicas := int32(1)
+	revisionHistoryLimit := int32(2)
+	labels := map[string]string{"component": "velero"}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "velero", Namespace: "openshift-velero", Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas:             &replicas,
+			RevisionHistoryLimit: &revisionHistoryLimit,
+			Selector:             &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "velero",
+						Image: "velero:latest",
+						Env:   []corev1.EnvVar{{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: "/credentials/cloud"}},
+					}},
+					Tolerations: []corev1.Toleration{
+						{Key: "node-role.kubernetes.io/infra", Operator: corev1.TolerationOpExists},
+					},
+				},
+			},
+		},
+	}
+	desired, err := runtime.DefaultUnstructuredConverter.ToUnstructured(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(found map[string]interface{})
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			mutate: func(found map[string]interface{}) {},
+		},
+		{
+			name: "fields defaulted by the API server",
+			mutate: func(found map[string]interface{}) {
+				_ = unstructured.SetNestedField(found, "ClusterFirst", "spec", "template", "spec", "dnsPolicy")
+				_ = unstructured.SetNestedField(found, int64(600), "spec", "progressDeadlineSeconds")
+				_ = unstructured.SetNestedField(found, "Always", "spec", "template", "spec", "restartPolicy")
+				_ = unstructured.SetNestedField(found, "RollingUpdate", "spec", "strategy", "type")
+				_ = unstructured.SetNestedField(found, "2023-01-01T00:00:00Z", "metadata", "creationTimestamp")
+				_ = unstructured.SetNestedField(found, "12345", "metadata", "resourceVersion")
+				_ = unstructured.SetNestedField(found, int64(1), "status", "readyReplicas")
+				containers, _, _ := unstructured.NestedSlice(found, "spec", "template", "spec", "containers")
+				containers[0].(map[string]interface{})["terminationMessagePath"] = "/dev/termination-log"
+				containers[0].(map[string]interface{})["imagePullPolicy"] = "IfNotPresent"
+				_ = unstructured.SetNestedSlice(found, containers, "spec", "template", "spec", "containers")
+			},
+		},
+		{
+			name: "changed image",
+			mutate: func(found map[string]interface{}) {
+				containers, _, _ := unstructured.NestedSlice(found, "spec", "template", "spec", "containers")
+				containers[0].(map[string]interface{})["image"] = "velero:old"
+				_ = unstructured.SetNestedSlice(found, containers, "spec", "template", "spec", "containers")
+			},
+			want: []string{"spec.template.spec.containers[0].image"},
+		},
+		{
+			name: "changed label",
+			mutate: func(found map[string]interface{}) {
+				_ = unstructured.SetNestedField(found, "other", "spec", "template", "metadata", "labels", "component")
+			},
+			want: []string{"spec.template.metadata.labels.component"},
+		},
+		{
+			name: "changed list length and removed field",
+			mutate: func(found map[string]interface{}) {
+				_ = unstructured.SetNestedSlice(found, []interface{}{}, "spec", "template", "spec", "tolerations")
+				unstructured.RemoveNestedField(found, "spec", "revisionHistoryLimit")
+				unstructured.RemoveNestedField(found, "spec", "replicas")
+			},
+			want: []string{"spec.replicas", "spec.revisionHistoryLimit", "spec.template.spec.tolerations"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := runtime.DeepCopyJSON(desired)
+			tt.mutate(found)
+			if got := ChangedFields(desired, found); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChangedFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	owner := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "openshift-velero", UID: "1234"},
+	}
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "openshift-velero"},
+		Data:       map[string]string{"key": "old"},
+	}
+	recorder := record.NewFakeRecorder(10)
+	engine := &Engine{
+		Client:       fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(owner, existing).Build(),
+		Scheme:       scheme.Scheme,
+		Recorder:     recorder,
+		Owner:        owner,
+		FieldManager: "managed-velero-operator",
+		Log:          logr.Discard(),
+	}
+	createdBefore := testutil.ToFloat64(metrics.ReconciledObjectsTotal.WithLabelValues("ConfigMap", string(ResultCreated)))
+
+	results, err := engine.Ensure(context.TODO(),
+		Object{
+			Desired:    &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "created", Namespace: "openshift-velero"}},
+			Comparison: CreateOnly,
+		},
+		Object{
+			Desired:    &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "openshift-velero"}},
+			Ownership:  OwnershipAsIs,
+			Comparison: CreateOnly,
+		},
+		Object{
+			Desired: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "openshift-velero"},
+				Data:       map[string]string{"key": "new"},
+			},
+		},
+		Object{
+			Desired:    &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unknown", Namespace: "openshift-velero"}},
+			Comparison: Comparison(-1),
+		},
+	)
+	if err == nil {
+		t.Error("Ensure() error = nil, want the error of the failed object")
+	}
+
+	want := []Result{ResultCreated, ResultUnchanged, ResultUpdated, ResultError}
+	for i, result := range results {
+		if result.Kind != "ConfigMap" || result.Result != want[i] {
+			t.Errorf("Ensure() result %d = %v %v, want ConfigMap %v", i, result.Kind, result.Result, want[i])
+		}
+	}
+	// The existing object is taken over by the owner when it's applied
+	if got, want := results[2].Changed, []string{"data.key", "metadata.ownerReferences"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() changed = %v, want %v", got, want)
+	}
+
+	created := &corev1.ConfigMap{}
+	if err := engine.Client.Get(context.TODO(), client.ObjectKey{Name: "created", Namespace: "openshift-velero"}, created); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(created, owner) {
+		t.Errorf("Ensure() didn't set the owner as the controller of the created object")
+	}
+
+	if got := testutil.ToFloat64(metrics.ReconciledObjectsTotal.WithLabelValues("ConfigMap", string(ResultCreated))); got != createdBefore+1 {
+		t.Errorf("reconciled objects metric = %v, want %v", got, createdBefore+1)
+	}
+	// Unchanged objects aren't reported with an Event
+	if got := len(recorder.Events); got != 3 {
+		t.Errorf("Ensure() recorded %d events, want 3", got)
+	}
+}
+
+func TestEnsureProvisionedCredentialsRequest(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := scheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := minterv1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	owner := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "openshift-velero", UID: "1234"},
+	}
+	desired := func() *minterv1.CredentialsRequest {
+		return &minterv1.CredentialsRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "velero-iam-credentials", Namespace: "openshift-velero"},
+			Spec: minterv1.CredentialsRequestSpec{
+				SecretRef: corev1.ObjectReference{Name: "velero-iam-credentials", Namespace: "openshift-velero"},
+			},
+		}
+	}
+
+	// The cloud-credential-operator has provisioned the credentials
+	found := desired()
+	if err := controllerutil.SetControllerReference(owner, found, s); err != nil {
+		t.Fatal(err)
+	}
+	found.Status.Provisioned = true
+	found.Status.LastSyncGeneration = 1
+
+	engine := &Engine{
+		Client:       fake.NewClientBuilder().WithScheme(s).WithObjects(owner, found).Build(),
+		Scheme:       s,
+		Owner:        owner,
+		FieldManager: "managed-velero-operator",
+		Log:          logr.Discard(),
+	}
+	cr := desired()
+	result := engine.EnsureObject(context.TODO(), Object{Desired: cr})
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result.Result != ResultUnchanged {
+		t.Errorf("EnsureObject() = %v %v, want %v", result.Result, result.Changed, ResultUnchanged)
+	}
+	if !cr.Status.Provisioned {
+		t.Error("EnsureObject() didn't return the status of the stored object")
+	}
+}
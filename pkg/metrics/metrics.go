@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// BucketDriftTotal counts the settings of a storage bucket that had drifted
+// from the policy enforced by the operator, and were corrected
+var BucketDriftTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "managed_velero_bucket_drift_total",
+		Help: "Number of times a storage bucket setting had drifted from the enforced policy and was corrected",
+	},
+	[]string{"setting"},
+)
+
+// ReconciledObjectsTotal counts the objects that the operator has ensured, by
+// kind and by whether they were created, updated, unchanged or failed
+var ReconciledObjectsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "managed_velero_reconciled_objects_total",
+		Help: "Number of times an object managed by the operator was reconciled, by kind and result",
+	},
+	[]string{"kind", "result"},
+)
+
+func init() {
+	// Register with the controller-runtime registry, which the manager serves
+	metrics.Registry.MustRegister(BucketDriftTotal, ReconciledObjectsTotal)
+}
@@ -0,0 +1,350 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	configv1 "github.com/openshift/api/config/v1"
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// storageAccountPrefix is the prefix of generated storage account names.
+	// Storage account names are limited to 24 lowercase letters and numbers.
+	storageAccountPrefix = "velero"
+	storageAccountLength = 24
+)
+
+type Azure struct {
+	ResourceGroup string
+	CloudName     configv1.AzureCloudEnvironment
+	InfraName     string
+}
+
+type driver struct {
+	storageBase.Driver
+	Config *Azure
+}
+
+// NewDriver creates a new azure storage driver
+// Used during bootstrapping
+func NewDriver(ctx context.Context, cfg *configv1.InfrastructureStatus, clnt client.Client, recorder record.EventRecorder) *driver {
+	drv := driver{
+		Config: &Azure{
+			ResourceGroup: cfg.PlatformStatus.Azure.ResourceGroupName,
+			CloudName:     cfg.PlatformStatus.Azure.CloudName,
+			InfraName:     cfg.InfrastructureName,
+		},
+	}
+	drv.Context = ctx
+	drv.KubeClient = clnt
+	drv.Recorder = recorder
+	return &drv
+}
+
+// GetPlatformType returns the platform type of this driver
+func (d *driver) GetPlatformType() configv1.PlatformType {
+	return configv1.AzurePlatformType
+}
+
+// CreateStorage attempts to create a storage account and blob container
+// and apply any provided tags
+func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	var err error
+
+	// Only provider-managed encryption is available for Azure storage accounts
+	if instance.Spec.Storage.GetEncryptionMode() != veleroInstallCR.StorageEncryptionModeProviderManaged {
+		err = fmt.Errorf("encryption mode %v is not supported for Azure storage accounts", instance.Spec.Storage.GetEncryptionMode())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+
+	// An existing container can't be used, as it's identified by its storage account
+	if instance.Spec.Storage.ExistingBucket != nil {
+		err = fmt.Errorf("spec.storage.existingBucket is not supported for Azure storage accounts")
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+
+	// Immutability relies on S3 Object Lock
+	if instance.Spec.Storage.Immutability != nil {
+		err = fmt.Errorf("spec.storage.immutability is not supported for Azure storage accounts")
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+
+	// Storage accounts are replicated through their redundancy, which the operator doesn't manage
+	if instance.Spec.Storage.Replication != nil {
+		err = fmt.Errorf("spec.storage.replication is not supported for Azure storage accounts")
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+	instance.RemoveCondition(veleroInstallCR.ConditionReplicationReady)
+
+	// Create an Azure client
+	azClient, err := NewAzureClient(d.KubeClient, d.Config.CloudName)
+	if err != nil {
+		return err
+	}
+
+	return d.reconcileStorage(azClient, reqLogger, instance)
+}
+
+// reconcileStorage handles the provisioning steps/checks for the storage
+// account and blob container using the given Azure client
+func (d *driver) reconcileStorage(azClient Client, reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	var err error
+
+	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name,
+		"StorageBucket.StorageAccount", instance.Status.StorageBucket.StorageAccount, "StorageBucket.Region", azClient.GetRegion())
+
+	// This switch handles the provisioning steps/checks
+	switch {
+	// We don't yet have a container name selected
+	case instance.Status.StorageBucket.Name == "" || instance.Status.StorageBucket.StorageAccount == "":
+		return setInstanceStorageNames(d, azClient, reqLogger, instance)
+
+	// We have a container name, but haven't kicked off provisioning of the container yet
+	case !instance.Status.StorageBucket.Provisioned:
+		bucketLog.Info("Azure container defined, but not provisioned")
+
+		accountExists, err := d.storageAccountExists(azClient, instance.Status.StorageBucket.StorageAccount)
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		if !accountExists {
+			// Create storage account
+			bucketLog.Info("Creating Azure storage account")
+			err = d.createStorageAccount(azClient, instance.Status.StorageBucket.StorageAccount, instance.Spec.Storage.Tags)
+			if err != nil {
+				var respErr *azcore.ResponseError
+				if errors.As(err, &respErr) && respErr.ErrorCode == "StorageAccountAlreadyTaken" {
+					bucketLog.Info("Storage account exists, but is not owned by current subscription; retrying")
+					instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed,
+						fmt.Sprintf("Storage account %v is owned by another subscription; selecting a new name", instance.Status.StorageBucket.StorageAccount))
+					instance.Status.StorageBucket.Name = ""
+					instance.Status.StorageBucket.StorageAccount = ""
+					return instance.StatusUpdate(reqLogger, d.KubeClient)
+				}
+				err = fmt.Errorf("error occurred when creating storage account %v: %v", instance.Status.StorageBucket.StorageAccount, err.Error())
+				instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed, err.Error())
+				return err
+			}
+		}
+
+		containerExists, err := d.containerExists(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name)
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		if containerExists {
+			bucketLog.Info("Container exists in storage account; continue")
+		} else {
+			// Create blob container
+			bucketLog.Info("Creating Azure container")
+			err = d.createContainer(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name)
+			if err != nil {
+				err = fmt.Errorf("error occurred when creating container %v: %v", instance.Status.StorageBucket.Name, err.Error())
+				instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed, err.Error())
+				return err
+			}
+		}
+	}
+
+	// Verify Azure container exists
+	bucketLog.Info("Verifing Azure container exists")
+	exists, err := d.containerExists(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name)
+	if err != nil {
+		err = fmt.Errorf("error occurred when verifying container %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	if !exists {
+		bucketLog.Error(nil, "Azure container doesn't appear to exist")
+		instance.Status.StorageBucket.Provisioned = false
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNotFound,
+			fmt.Sprintf("Container %v in storage account %v does not exist or is not accessible",
+				instance.Status.StorageBucket.Name, instance.Status.StorageBucket.StorageAccount))
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionTrue, veleroInstallCR.ReasonBucketAvailable,
+		fmt.Sprintf("Container %v in storage account %v is available", instance.Status.StorageBucket.Name, instance.Status.StorageBucket.StorageAccount))
+
+	// Encrypt storage account
+	bucketLog.Info("Enforcing Azure storage account encryption")
+	err = d.encryptStorageAccount(azClient, instance.Status.StorageBucket.StorageAccount)
+	if err != nil {
+		err = fmt.Errorf("error occurred when encrypting storage account %v: %v", instance.Status.StorageBucket.StorageAccount, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonEncryptionFailed, err.Error())
+		return err
+	}
+
+	// Block public access to the storage account and container
+	bucketLog.Info("Enforcing Azure container public access policy")
+	err = d.blockPublicAccess(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name)
+	if err != nil {
+		err = fmt.Errorf("error occurred when blocking public access to container %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonPublicAccessBlockFailed, err.Error())
+		return err
+	}
+
+	// Configure lifecycle management policy on storage account
+	bucketLog.Info("Enforcing Azure lifecycle management policy on storage account")
+	err = d.setLifecycle(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name, instance.Spec.Storage.GetRetentionDays())
+	if err != nil {
+		err = fmt.Errorf("error occurred when configuring lifecycle management policy on storage account %v: %v", instance.Status.StorageBucket.StorageAccount, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonLifecycleFailed, err.Error())
+		return err
+	}
+
+	// Make sure that tags are applied to the storage account and container
+	bucketLog.Info("Enforcing Azure tags on storage account and container")
+	err = d.tagStorage(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name, instance.Spec.Storage.Tags)
+	if err != nil {
+		err = fmt.Errorf("error occurred when tagging container %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonTaggingFailed, err.Error())
+		return err
+	}
+	instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionTrue, veleroInstallCR.ReasonPolicyEnforced,
+		"Encryption, public access block, lifecycle and tagging policy is enforced")
+
+	instance.Status.StorageBucket.Provisioned = true
+	instance.Status.StorageBucket.LastSyncTimestamp = &metav1.Time{
+		Time: time.Now(),
+	}
+	return instance.StatusUpdate(reqLogger, d.KubeClient)
+}
+
+// StorageExists checks that a container with the given name exists in one of
+// the storage accounts in the cluster's resource group, and that we have access to it.
+func (d *driver) StorageExists(containerName string) (bool, error) {
+	// Create an Azure client
+	azClient, err := NewAzureClient(d.KubeClient, d.Config.CloudName)
+	if err != nil {
+		return false, err
+	}
+
+	accounts, err := azClient.ListAccounts(d.Context, d.Config.ResourceGroup)
+	if err != nil {
+		return false, err
+	}
+
+	for _, account := range accounts {
+		if account == nil || account.Name == nil {
+			continue
+		}
+		exists, err := d.containerExists(azClient, *account.Name, containerName)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// DeleteStorage deletes the Azure container, and the storage account if it holds no other containers
+func (d *driver) DeleteStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	if instance.Status.StorageBucket.StorageAccount == "" {
+		return nil
+	}
+
+	azClient, err := NewAzureClient(d.KubeClient, d.Config.CloudName)
+	if err != nil {
+		return err
+	}
+
+	reqLogger.Info("Deleting Azure container", "StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.StorageAccount", instance.Status.StorageBucket.StorageAccount)
+	return d.deleteStorage(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name)
+}
+
+// MarkStorageOrphaned tags the Azure storage account with the time it was orphaned
+func (d *driver) MarkStorageOrphaned(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	if instance.Status.StorageBucket.StorageAccount == "" {
+		return nil
+	}
+
+	azClient, err := NewAzureClient(d.KubeClient, d.Config.CloudName)
+	if err != nil {
+		return err
+	}
+
+	reqLogger.Info("Tagging Azure storage account as orphaned", "StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.StorageAccount", instance.Status.StorageBucket.StorageAccount)
+	return d.markStorageOrphaned(azClient, instance.Status.StorageBucket.StorageAccount, time.Now())
+}
+
+// generateBucketName generates a proposed name for the Azure container
+func generateBucketName(prefix string) string {
+	id := uuid.New().String()
+	return prefix + id
+}
+
+// generateStorageAccountName generates a proposed name for the Azure storage account
+func generateStorageAccountName() string {
+	id := strings.ReplaceAll(uuid.New().String(), "-", "")
+	return storageAccountPrefix + id[:storageAccountLength-len(storageAccountPrefix)]
+}
+
+// setInstanceStorageNames selects the storage account and container for the
+// backups, recovering an existing container for the cluster if one exists,
+// and then updates the instance status with the names
+func setInstanceStorageNames(d *driver, azClient Client, reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", azClient.GetRegion())
+
+	// Use an existing storage account, if it exists.
+	bucketLog.Info("No Azure container defined. Searching for existing storage account to use")
+	accounts, err := azClient.ListAccounts(d.Context, d.Config.ResourceGroup)
+	if err != nil {
+		return err
+	}
+
+	accountName := d.findVeleroAccount(accounts)
+	if accountName != "" {
+		// Use an existing container in the storage account, if it exists.
+		containers, err := azClient.ListContainers(d.Context, d.Config.ResourceGroup, accountName)
+		if err != nil {
+			return err
+		}
+
+		existingContainer := d.findVeleroContainer(containers)
+		if existingContainer != "" {
+			bucketLog.Info("Recovered existing container", "StorageBucket.Name", existingContainer, "StorageBucket.StorageAccount", accountName)
+			instance.Status.StorageBucket.Name = existingContainer
+			instance.Status.StorageBucket.StorageAccount = accountName
+			instance.Status.StorageBucket.Provisioned = true
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionTrue, veleroInstallCR.ReasonBucketRecovered,
+				fmt.Sprintf("Recovered existing container %v in storage account %v", existingContainer, accountName))
+			return instance.StatusUpdate(reqLogger, d.KubeClient)
+		}
+	} else {
+		// Prepare to create a new storage account, if none exist. Storage
+		// account names are global, so a name that is already taken is
+		// detected when the account is created.
+		accountName = generateStorageAccountName()
+	}
+
+	proposedName := generateBucketName(instance.Spec.Storage.GetNamePrefix())
+
+	bucketLog.Info("Setting proposed container name", "StorageBucket.Name", proposedName, "StorageBucket.StorageAccount", accountName)
+	instance.Status.StorageBucket.Name = proposedName
+	instance.Status.StorageBucket.NamePrefix = instance.Spec.Storage.GetNamePrefix()
+	instance.Status.StorageBucket.StorageAccount = accountName
+	instance.Status.StorageBucket.Provisioned = false
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNameSelected,
+		fmt.Sprintf("Selected name %v for a new container in storage account %v", proposedName, accountName))
+	return instance.StatusUpdate(reqLogger, d.KubeClient)
+}
@@ -0,0 +1,221 @@
+package azure
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8sClient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	velerov1alpha2 "github.com/openshift/managed-velero-operator/api/v1alpha2"
+)
+
+// utilities and variables
+var nullLogr = logr.Discard()
+
+// NB: this file shares a package with bucket_test.go and all the fake azure
+// client stuff is in there
+
+// setUpInstance sets up a new VeleroInstall instance and returns a pointer to it.
+// This is to avoid cross-contamination between tests
+func setUpInstance(t *testing.T) *velerov1alpha2.VeleroInstall {
+	t.Helper()
+
+	return &velerov1alpha2.VeleroInstall{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "VeleroInstall",
+			APIVersion: "managed.openshift.io/v1alpha2",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster",
+			Namespace: "openshift-velero",
+		},
+		Spec:   velerov1alpha2.VeleroInstallSpec{},
+		Status: velerov1alpha2.VeleroInstallStatus{},
+	}
+}
+
+// setUpTestClient sets up a test kube client loaded with a VeleroInstall instance
+func setUpTestClient(t *testing.T, instance *velerov1alpha2.VeleroInstall) k8sClient.Client {
+	s := scheme.Scheme
+	s.AddKnownTypes(velerov1alpha2.GroupVersion, instance)
+	objects := []runtime.Object{instance}
+
+	return fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objects...).Build()
+}
+
+// setUpDriver creates a new driver and returns a pointer to it. This is to avoid
+// cross-contamination between tests.
+func setUpDriver(t *testing.T, instance *velerov1alpha2.VeleroInstall) *driver {
+	t.Helper()
+
+	drv := setUpBareDriver()
+	drv.KubeClient = setUpTestClient(t, instance)
+
+	return drv
+}
+
+func TestSetInstanceStorageNames(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(t *testing.T, d *driver, c *fakeClient)
+		wantName      string
+		wantAccount   string
+		wantRecovered bool
+	}{
+		{
+			name:  "select new storage account and container",
+			setup: func(t *testing.T, d *driver, c *fakeClient) {},
+		},
+		{
+			name: "reuse tagged storage account without a velero container",
+			setup: func(t *testing.T, d *driver, c *fakeClient) {
+				if err := d.createStorageAccount(c, "veleroexistingaccount", nil); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantAccount: "veleroexistingaccount",
+		},
+		{
+			name: "recover existing container",
+			setup: func(t *testing.T, d *driver, c *fakeClient) {
+				if err := d.createStorageAccount(c, "veleroexistingaccount", nil); err != nil {
+					t.Fatal(err)
+				}
+				if err := d.createContainer(c, "veleroexistingaccount", "existingcontainer"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantName:      "existingcontainer",
+			wantAccount:   "veleroexistingaccount",
+			wantRecovered: true,
+		},
+		{
+			name: "ignore storage account for a different cluster",
+			setup: func(t *testing.T, d *driver, c *fakeClient) {
+				other := setUpBareDriver()
+				other.Config.InfraName = "otherCluster"
+				if err := other.createStorageAccount(c, "veleroothercluster", nil); err != nil {
+					t.Fatal(err)
+				}
+				if err := other.createContainer(c, "veleroothercluster", "othercontainer"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := setUpInstance(t)
+			testDriver := setUpDriver(t, instance)
+			azClient := newFakeClient()
+			tt.setup(t, testDriver, azClient)
+
+			err := setInstanceStorageNames(testDriver, azClient, nullLogr, instance)
+			if err != nil {
+				t.Fatalf("got an unexpected error: %s", err)
+			}
+
+			if tt.wantName != "" && instance.Status.StorageBucket.Name != tt.wantName {
+				t.Errorf("setInstanceStorageNames() container name: %s, expected %s", instance.Status.StorageBucket.Name, tt.wantName)
+			}
+			if tt.wantName == "" && !strings.HasPrefix(instance.Status.StorageBucket.Name, velerov1alpha2.DefaultStorageNamePrefix) {
+				t.Errorf("setInstanceStorageNames() container name: %s, didn't have prefix %s", instance.Status.StorageBucket.Name, velerov1alpha2.DefaultStorageNamePrefix)
+			}
+
+			if tt.wantAccount != "" && instance.Status.StorageBucket.StorageAccount != tt.wantAccount {
+				t.Errorf("setInstanceStorageNames() storage account: %s, expected %s", instance.Status.StorageBucket.StorageAccount, tt.wantAccount)
+			}
+			if tt.wantAccount == "" {
+				account := instance.Status.StorageBucket.StorageAccount
+				if len(account) != storageAccountLength || !strings.HasPrefix(account, storageAccountPrefix) {
+					t.Errorf("setInstanceStorageNames() storage account: %s, is not a generated name", account)
+				}
+			}
+
+			if instance.Status.StorageBucket.Provisioned != tt.wantRecovered {
+				t.Errorf("setInstanceStorageNames() provisioned = %v, expected %v", instance.Status.StorageBucket.Provisioned, tt.wantRecovered)
+			}
+			wantProvisioned := metav1.ConditionFalse
+			if tt.wantRecovered {
+				wantProvisioned = metav1.ConditionTrue
+			}
+			if !meta.IsStatusConditionPresentAndEqual(instance.Status.Conditions, velerov1alpha2.ConditionBucketProvisioned, wantProvisioned) {
+				t.Errorf("setInstanceStorageNames() %v condition is not %v", velerov1alpha2.ConditionBucketProvisioned, wantProvisioned)
+			}
+		})
+	}
+}
+
+func TestReconcileStorage(t *testing.T) {
+	instance := setUpInstance(t)
+	instance.Spec.Storage.Tags = map[string]string{"cost-center": "1234"}
+	testDriver := setUpDriver(t, instance)
+	azClient := newFakeClient()
+
+	// The first pass selects names, the second provisions the storage
+	for i := 0; i < 2; i++ {
+		if err := testDriver.reconcileStorage(azClient, nullLogr, instance); err != nil {
+			t.Fatalf("reconcileStorage() pass %d error: %v", i+1, err)
+		}
+	}
+
+	if !instance.Status.StorageBucket.Provisioned {
+		t.Errorf("reconcileStorage() did not mark the storage as provisioned")
+	}
+	for _, conditionType := range []string{velerov1alpha2.ConditionBucketProvisioned, velerov1alpha2.ConditionBucketPolicyEnforced} {
+		if !instance.IsConditionTrue(conditionType) {
+			t.Errorf("reconcileStorage() %v condition is not True", conditionType)
+		}
+	}
+
+	account := azClient.accounts[instance.Status.StorageBucket.StorageAccount]
+	if account == nil {
+		t.Fatalf("reconcileStorage() did not create storage account %v", instance.Status.StorageBucket.StorageAccount)
+	}
+	if *account.Tags["cost-center"] != "1234" {
+		t.Errorf("reconcileStorage() did not apply extra tags")
+	}
+	exists, err := testDriver.containerExists(azClient, instance.Status.StorageBucket.StorageAccount, instance.Status.StorageBucket.Name)
+	if err != nil || !exists {
+		t.Errorf("reconcileStorage() did not create container %v: %v", instance.Status.StorageBucket.Name, err)
+	}
+	if azClient.managementPolicies[instance.Status.StorageBucket.StorageAccount] == nil {
+		t.Errorf("reconcileStorage() did not set a lifecycle management policy")
+	}
+}
+
+func TestReconcileStorageAccountNameTaken(t *testing.T) {
+	instance := setUpInstance(t)
+	instance.Status.StorageBucket.Name = "testcontainer"
+	instance.Status.StorageBucket.StorageAccount = "velerotakenaccount"
+	testDriver := setUpDriver(t, instance)
+	azClient := newFakeClient()
+	azClient.takenNames["velerotakenaccount"] = true
+
+	if err := testDriver.reconcileStorage(azClient, nullLogr, instance); err != nil {
+		t.Fatalf("reconcileStorage() error: %v", err)
+	}
+
+	if instance.Status.StorageBucket.Name != "" || instance.Status.StorageBucket.StorageAccount != "" {
+		t.Errorf("reconcileStorage() did not clear the names of a storage account owned by another subscription")
+	}
+}
+
+func TestGenerateStorageAccountName(t *testing.T) {
+	name := generateStorageAccountName()
+	if len(name) != storageAccountLength {
+		t.Errorf("generateStorageAccountName() = %v, length %d, want %d", name, len(name), storageAccountLength)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') {
+			t.Errorf("generateStorageAccountName() = %v, contains invalid character %q", name, r)
+		}
+	}
+}
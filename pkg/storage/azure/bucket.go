@@ -0,0 +1,299 @@
+package azure
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+)
+
+const (
+	// lifecycleRuleName is the name of the management policy rule that expires backups
+	lifecycleRuleName = "backup-expiry"
+)
+
+// createStorageAccount creates a new storage account in the cluster's resource group.
+// The account is created with encryption, HTTPS only and public blob access disabled,
+// so that the policy is in place before any container exists.
+func (d *driver) createStorageAccount(azClient Client, accountName string, extraTags map[string]string) error {
+	return azClient.CreateAccount(d.Context, d.Config.ResourceGroup, accountName, armstorage.AccountCreateParameters{
+		Kind:     to.Ptr(armstorage.KindStorageV2),
+		Location: to.Ptr(azClient.GetRegion()),
+		SKU: &armstorage.SKU{
+			Name: to.Ptr(armstorage.SKUNameStandardGRS),
+		},
+		Properties: &armstorage.AccountPropertiesCreateParameters{
+			AccessTier:             to.Ptr(armstorage.AccessTierHot),
+			AllowBlobPublicAccess:  to.Ptr(false),
+			EnableHTTPSTrafficOnly: to.Ptr(true),
+			MinimumTLSVersion:      to.Ptr(armstorage.MinimumTLSVersionTLS12),
+			Encryption:             accountEncryption(),
+		},
+		Tags: buildTagMap(d.Config.InfraName, extraTags),
+	})
+}
+
+// createContainer creates a new private blob container in the storage account.
+func (d *driver) createContainer(azClient Client, accountName, containerName string) error {
+	return azClient.CreateContainer(d.Context, d.Config.ResourceGroup, accountName, containerName, armstorage.BlobContainer{
+		ContainerProperties: &armstorage.ContainerProperties{
+			PublicAccess: to.Ptr(armstorage.PublicAccessNone),
+			Metadata:     buildContainerMetadata(d.Config.InfraName),
+		},
+	})
+}
+
+// storageAccountExists checks that the storage account exists in the cluster's resource group.
+func (d *driver) storageAccountExists(azClient Client, accountName string) (bool, error) {
+	_, err := azClient.GetAccount(d.Context, d.Config.ResourceGroup, accountName)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to determine storage account %v status: %v", accountName, err)
+	}
+	return true, nil
+}
+
+// containerExists checks that the blob container exists in the storage account.
+func (d *driver) containerExists(azClient Client, accountName, containerName string) (bool, error) {
+	_, err := azClient.GetContainer(d.Context, d.Config.ResourceGroup, accountName, containerName)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to determine container %v status: %v", containerName, err)
+	}
+	return true, nil
+}
+
+// encryptStorageAccount enforces encryption at rest with Microsoft-managed keys on the storage account.
+func (d *driver) encryptStorageAccount(azClient Client, accountName string) error {
+	return azClient.UpdateAccount(d.Context, d.Config.ResourceGroup, accountName, armstorage.AccountUpdateParameters{
+		Properties: &armstorage.AccountPropertiesUpdateParameters{
+			Encryption: accountEncryption(),
+		},
+	})
+}
+
+// blockPublicAccess disables anonymous access to blobs on both the storage account
+// and the container, and requires secure transport to the storage account.
+func (d *driver) blockPublicAccess(azClient Client, accountName, containerName string) error {
+	err := azClient.UpdateAccount(d.Context, d.Config.ResourceGroup, accountName, armstorage.AccountUpdateParameters{
+		Properties: &armstorage.AccountPropertiesUpdateParameters{
+			AllowBlobPublicAccess:  to.Ptr(false),
+			EnableHTTPSTrafficOnly: to.Ptr(true),
+			MinimumTLSVersion:      to.Ptr(armstorage.MinimumTLSVersionTLS12),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return azClient.UpdateContainer(d.Context, d.Config.ResourceGroup, accountName, containerName, armstorage.BlobContainer{
+		ContainerProperties: &armstorage.ContainerProperties{
+			PublicAccess: to.Ptr(armstorage.PublicAccessNone),
+		},
+	})
+}
+
+// setLifecycle sets a management policy on the storage account, deleting backups
+// in the container after the given number of days.
+func (d *driver) setLifecycle(azClient Client, accountName, containerName string, retentionDays int64) error {
+	return azClient.SetManagementPolicy(d.Context, d.Config.ResourceGroup, accountName, armstorage.ManagementPolicy{
+		Properties: &armstorage.ManagementPolicyProperties{
+			Policy: &armstorage.ManagementPolicySchema{
+				Rules: []*armstorage.ManagementPolicyRule{
+					{
+						Name:    to.Ptr(lifecycleRuleName),
+						Type:    to.Ptr(armstorage.RuleTypeLifecycle),
+						Enabled: to.Ptr(true),
+						Definition: &armstorage.ManagementPolicyDefinition{
+							Filters: &armstorage.ManagementPolicyFilter{
+								BlobTypes:   []*string{to.Ptr("blockBlob")},
+								PrefixMatch: []*string{to.Ptr(containerName + "/backups/")},
+							},
+							Actions: &armstorage.ManagementPolicyAction{
+								BaseBlob: &armstorage.ManagementPolicyBaseBlob{
+									Delete: &armstorage.DateAfterModification{
+										DaysAfterModificationGreaterThan: to.Ptr(float32(retentionDays)),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// tagStorage enforces tags on the storage account and metadata on the container.
+// These are used to indicate that velero backups are stored in the container,
+// and to identify the associated cluster.
+func (d *driver) tagStorage(azClient Client, accountName, containerName string, extraTags map[string]string) error {
+	err := azClient.UpdateAccount(d.Context, d.Config.ResourceGroup, accountName, armstorage.AccountUpdateParameters{
+		Tags: buildTagMap(d.Config.InfraName, extraTags),
+	})
+	if err != nil {
+		return err
+	}
+
+	return azClient.UpdateContainer(d.Context, d.Config.ResourceGroup, accountName, containerName, armstorage.BlobContainer{
+		ContainerProperties: &armstorage.ContainerProperties{
+			Metadata: buildContainerMetadata(d.Config.InfraName),
+		},
+	})
+}
+
+// markStorageOrphaned adds a tag to the storage account recording the time that
+// it was orphaned by its cluster. Existing tags on the storage account are kept.
+func (d *driver) markStorageOrphaned(azClient Client, accountName string, orphanedAt time.Time) error {
+	account, err := azClient.GetAccount(d.Context, d.Config.ResourceGroup, accountName)
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]*string, len(account.Tags)+1)
+	for k, v := range account.Tags {
+		tags[k] = v
+	}
+	tags[sanitizeTagName(storageConstants.BucketTagOrphanedAt)] = to.Ptr(orphanedAt.UTC().Format(time.RFC3339))
+	return azClient.UpdateAccount(d.Context, d.Config.ResourceGroup, accountName, armstorage.AccountUpdateParameters{
+		Tags: tags,
+	})
+}
+
+// deleteStorage deletes the container along with its blobs, and then the
+// storage account if no other containers remain in it. Storage that no longer
+// exists is not an error.
+func (d *driver) deleteStorage(azClient Client, accountName, containerName string) error {
+	err := azClient.DeleteContainer(d.Context, d.Config.ResourceGroup, accountName, containerName)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	containers, err := azClient.ListContainers(d.Context, d.Config.ResourceGroup, accountName)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if len(containers) > 0 {
+		return nil
+	}
+
+	err = azClient.DeleteAccount(d.Context, d.Config.ResourceGroup, accountName)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// findVeleroAccount looks through the tags of the storage accounts and determines
+// if any of them are tagged for velero backups for the cluster.
+// If matching tags are found, the storage account name is returned.
+func (d *driver) findVeleroAccount(accounts []*armstorage.Account) string {
+	for _, account := range accounts {
+		if account == nil || account.Name == nil {
+			continue
+		}
+		if matchesVelero(account.Tags, sanitizeTagName, d.Config.InfraName) {
+			return *account.Name
+		}
+	}
+
+	// No matching storage accounts found.
+	return ""
+}
+
+// findVeleroContainer looks through the metadata of the containers and determines
+// if any of them are marked for velero backups for the cluster.
+// If matching metadata is found, the container name is returned.
+func (d *driver) findVeleroContainer(containers []*armstorage.ListContainerItem) string {
+	for _, container := range containers {
+		if container == nil || container.Name == nil || container.Properties == nil {
+			continue
+		}
+		if matchesVelero(container.Properties.Metadata, sanitizeMetadataName, d.Config.InfraName) {
+			return *container.Name
+		}
+	}
+
+	// No matching containers found.
+	return ""
+}
+
+// matchesVelero returns true if the tags identify velero backups for the cluster.
+// Azure does not preserve the case of metadata names, so names are compared
+// case-insensitively.
+func matchesVelero(tags map[string]*string, sanitize func(string) string, infraName string) bool {
+	tagMatchesCluster := false
+	tagMatchesVelero := false
+	for k, v := range tags {
+		if v == nil {
+			continue
+		}
+		if strings.EqualFold(k, sanitize(storageConstants.BucketTagInfrastructureName)) && *v == infraName {
+			tagMatchesCluster = true
+		}
+		if strings.EqualFold(k, sanitize(storageConstants.BucketTagBackupStorageLocation)) && *v == storageConstants.DefaultVeleroBackupStorageLocation {
+			tagMatchesVelero = true
+		}
+	}
+	return tagMatchesCluster && tagMatchesVelero
+}
+
+// accountEncryption returns the encryption settings for a velero storage account.
+func accountEncryption() *armstorage.Encryption {
+	return &armstorage.Encryption{
+		KeySource: to.Ptr(armstorage.KeySourceMicrosoftStorage),
+		Services: &armstorage.EncryptionServices{
+			Blob: &armstorage.EncryptionService{
+				Enabled: to.Ptr(true),
+				KeyType: to.Ptr(armstorage.KeyTypeAccount),
+			},
+		},
+	}
+}
+
+// sanitizeTagName replaces the characters that are not allowed in Azure tag names.
+func sanitizeTagName(input string) string {
+	// https://learn.microsoft.com/en-us/azure/azure-resource-manager/management/tag-resources#limitations
+	disallowedRegEx := regexp.MustCompile(`[<>%&\\?/]+`)
+
+	return disallowedRegEx.ReplaceAllString(input, "-")
+}
+
+// sanitizeMetadataName replaces the characters that are not allowed in container
+// metadata names, which must be valid C# identifiers.
+func sanitizeMetadataName(input string) string {
+	disallowedRegEx := regexp.MustCompile("[^a-zA-Z0-9_]+")
+
+	return disallowedRegEx.ReplaceAllString(input, "_")
+}
+
+// buildTagMap builds the set of tags for a velero storage account. Any extra
+// tags are included, but cannot override the velero tags.
+func buildTagMap(infraName string, extraTags map[string]string) map[string]*string {
+	tags := make(map[string]*string, len(extraTags)+2)
+	for k, v := range extraTags {
+		tags[sanitizeTagName(k)] = to.Ptr(v)
+	}
+	tags[sanitizeTagName(storageConstants.BucketTagBackupStorageLocation)] = to.Ptr(storageConstants.DefaultVeleroBackupStorageLocation)
+	tags[sanitizeTagName(storageConstants.BucketTagInfrastructureName)] = to.Ptr(infraName)
+	return tags
+}
+
+// buildContainerMetadata builds the metadata for a velero blob container.
+func buildContainerMetadata(infraName string) map[string]*string {
+	return map[string]*string{
+		sanitizeMetadataName(storageConstants.BucketTagBackupStorageLocation): to.Ptr(storageConstants.DefaultVeleroBackupStorageLocation),
+		sanitizeMetadataName(storageConstants.BucketTagInfrastructureName):    to.Ptr(infraName),
+	}
+}
@@ -0,0 +1,460 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+)
+
+const (
+	clusterInfraName = "fakeCluster"
+	resourceGroup    = "fakeCluster-rg"
+	region           = "eastus"
+)
+
+// fakeClient is an in-process fake of the Azure Storage management API. It
+// keeps storage accounts, containers and management policies in memory and
+// applies updates with the same merge semantics as the real API.
+type fakeClient struct {
+	accounts           map[string]*armstorage.Account
+	containers         map[string]map[string]*armstorage.BlobContainer
+	managementPolicies map[string]*armstorage.ManagementPolicy
+	takenNames         map[string]bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		accounts:           make(map[string]*armstorage.Account),
+		containers:         make(map[string]map[string]*armstorage.BlobContainer),
+		managementPolicies: make(map[string]*armstorage.ManagementPolicy),
+		takenNames:         make(map[string]bool),
+	}
+}
+
+func notFoundError(code string) error {
+	return &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: code}
+}
+
+func (c *fakeClient) CreateAccount(_ context.Context, _, accountName string, parameters armstorage.AccountCreateParameters) error {
+	if c.takenNames[accountName] {
+		return &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "StorageAccountAlreadyTaken"}
+	}
+	c.accounts[accountName] = &armstorage.Account{
+		Name:     to.Ptr(accountName),
+		Kind:     parameters.Kind,
+		Location: parameters.Location,
+		SKU:      parameters.SKU,
+		Tags:     parameters.Tags,
+		Properties: &armstorage.AccountProperties{
+			AllowBlobPublicAccess:  parameters.Properties.AllowBlobPublicAccess,
+			EnableHTTPSTrafficOnly: parameters.Properties.EnableHTTPSTrafficOnly,
+			MinimumTLSVersion:      parameters.Properties.MinimumTLSVersion,
+			Encryption:             parameters.Properties.Encryption,
+		},
+	}
+	c.containers[accountName] = make(map[string]*armstorage.BlobContainer)
+	return nil
+}
+
+func (c *fakeClient) GetAccount(_ context.Context, _, accountName string) (*armstorage.Account, error) {
+	account, ok := c.accounts[accountName]
+	if !ok {
+		return nil, notFoundError("ResourceNotFound")
+	}
+	return account, nil
+}
+
+func (c *fakeClient) ListAccounts(_ context.Context, _ string) ([]*armstorage.Account, error) {
+	var results []*armstorage.Account
+	for _, account := range c.accounts {
+		results = append(results, account)
+	}
+	return results, nil
+}
+
+func (c *fakeClient) UpdateAccount(_ context.Context, _, accountName string, parameters armstorage.AccountUpdateParameters) error {
+	account, ok := c.accounts[accountName]
+	if !ok {
+		return notFoundError("ResourceNotFound")
+	}
+	if parameters.Tags != nil {
+		account.Tags = parameters.Tags
+	}
+	if p := parameters.Properties; p != nil {
+		if p.AllowBlobPublicAccess != nil {
+			account.Properties.AllowBlobPublicAccess = p.AllowBlobPublicAccess
+		}
+		if p.EnableHTTPSTrafficOnly != nil {
+			account.Properties.EnableHTTPSTrafficOnly = p.EnableHTTPSTrafficOnly
+		}
+		if p.MinimumTLSVersion != nil {
+			account.Properties.MinimumTLSVersion = p.MinimumTLSVersion
+		}
+		if p.Encryption != nil {
+			account.Properties.Encryption = p.Encryption
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) DeleteAccount(_ context.Context, _, accountName string) error {
+	if _, ok := c.accounts[accountName]; !ok {
+		return notFoundError("ResourceNotFound")
+	}
+	delete(c.accounts, accountName)
+	delete(c.containers, accountName)
+	delete(c.managementPolicies, accountName)
+	return nil
+}
+
+func (c *fakeClient) CreateContainer(_ context.Context, _, accountName, containerName string, container armstorage.BlobContainer) error {
+	containers, ok := c.containers[accountName]
+	if !ok {
+		return notFoundError("ResourceNotFound")
+	}
+	if _, ok := containers[containerName]; ok {
+		return &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "ContainerAlreadyExists"}
+	}
+	container.Name = to.Ptr(containerName)
+	containers[containerName] = &container
+	return nil
+}
+
+func (c *fakeClient) GetContainer(_ context.Context, _, accountName, containerName string) (*armstorage.BlobContainer, error) {
+	container, ok := c.containers[accountName][containerName]
+	if !ok {
+		return nil, notFoundError("ContainerNotFound")
+	}
+	return container, nil
+}
+
+func (c *fakeClient) ListContainers(_ context.Context, _, accountName string) ([]*armstorage.ListContainerItem, error) {
+	containers, ok := c.containers[accountName]
+	if !ok {
+		return nil, notFoundError("ResourceNotFound")
+	}
+	var results []*armstorage.ListContainerItem
+	for _, container := range containers {
+		results = append(results, &armstorage.ListContainerItem{
+			Name:       container.Name,
+			Properties: container.ContainerProperties,
+		})
+	}
+	return results, nil
+}
+
+func (c *fakeClient) UpdateContainer(_ context.Context, _, accountName, containerName string, container armstorage.BlobContainer) error {
+	existing, ok := c.containers[accountName][containerName]
+	if !ok {
+		return notFoundError("ContainerNotFound")
+	}
+	if p := container.ContainerProperties; p != nil {
+		if p.PublicAccess != nil {
+			existing.ContainerProperties.PublicAccess = p.PublicAccess
+		}
+		if p.Metadata != nil {
+			existing.ContainerProperties.Metadata = p.Metadata
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) DeleteContainer(_ context.Context, _, accountName, containerName string) error {
+	if _, ok := c.containers[accountName][containerName]; !ok {
+		return notFoundError("ContainerNotFound")
+	}
+	delete(c.containers[accountName], containerName)
+	return nil
+}
+
+func (c *fakeClient) SetManagementPolicy(_ context.Context, _, accountName string, policy armstorage.ManagementPolicy) error {
+	if _, ok := c.accounts[accountName]; !ok {
+		return notFoundError("ResourceNotFound")
+	}
+	c.managementPolicies[accountName] = &policy
+	return nil
+}
+
+func (c *fakeClient) GetRegion() string {
+	return region
+}
+
+// setUpBareDriver creates a driver without a kube client, for tests that only
+// talk to the Azure API.
+func setUpBareDriver() *driver {
+	drv := &driver{
+		Config: &Azure{
+			ResourceGroup: resourceGroup,
+			InfraName:     clusterInfraName,
+		},
+	}
+	drv.Context = context.TODO()
+	return drv
+}
+
+func TestCreateStorageAccount(t *testing.T) {
+	azClient := newFakeClient()
+	drv := setUpBareDriver()
+
+	err := drv.createStorageAccount(azClient, "velerotestaccount", map[string]string{"cost-center": "1234"})
+	if err != nil {
+		t.Fatalf("createStorageAccount() error: %v", err)
+	}
+
+	account := azClient.accounts["velerotestaccount"]
+	if account == nil {
+		t.Fatalf("createStorageAccount() did not create the storage account")
+	}
+	if *account.Location != region {
+		t.Errorf("createStorageAccount() location = %v, want %v", *account.Location, region)
+	}
+	if *account.Properties.AllowBlobPublicAccess {
+		t.Errorf("createStorageAccount() allowed public blob access")
+	}
+	if !*account.Properties.EnableHTTPSTrafficOnly {
+		t.Errorf("createStorageAccount() did not require HTTPS")
+	}
+	if !*account.Properties.Encryption.Services.Blob.Enabled {
+		t.Errorf("createStorageAccount() did not enable blob encryption")
+	}
+	if drv.findVeleroAccount([]*armstorage.Account{account}) != "velerotestaccount" {
+		t.Errorf("createStorageAccount() did not tag the storage account for velero")
+	}
+	if *account.Tags["cost-center"] != "1234" {
+		t.Errorf("createStorageAccount() did not apply extra tags")
+	}
+}
+
+func TestCreateContainer(t *testing.T) {
+	azClient := newFakeClient()
+	drv := setUpBareDriver()
+
+	err := drv.createContainer(azClient, "velerotestaccount", "testcontainer")
+	if err == nil {
+		t.Errorf("createContainer() expected an error for a missing storage account")
+	}
+
+	if err := drv.createStorageAccount(azClient, "velerotestaccount", nil); err != nil {
+		t.Fatalf("createStorageAccount() error: %v", err)
+	}
+	if err := drv.createContainer(azClient, "velerotestaccount", "testcontainer"); err != nil {
+		t.Fatalf("createContainer() error: %v", err)
+	}
+
+	exists, err := drv.containerExists(azClient, "velerotestaccount", "testcontainer")
+	if err != nil {
+		t.Fatalf("containerExists() error: %v", err)
+	}
+	if !exists {
+		t.Errorf("containerExists() = false, want true")
+	}
+
+	container := azClient.containers["velerotestaccount"]["testcontainer"]
+	if *container.ContainerProperties.PublicAccess != armstorage.PublicAccessNone {
+		t.Errorf("createContainer() public access = %v, want %v", *container.ContainerProperties.PublicAccess, armstorage.PublicAccessNone)
+	}
+}
+
+func TestBlockPublicAccess(t *testing.T) {
+	azClient := newFakeClient()
+	drv := setUpBareDriver()
+
+	if err := drv.createStorageAccount(azClient, "velerotestaccount", nil); err != nil {
+		t.Fatalf("createStorageAccount() error: %v", err)
+	}
+	if err := drv.createContainer(azClient, "velerotestaccount", "testcontainer"); err != nil {
+		t.Fatalf("createContainer() error: %v", err)
+	}
+
+	// Simulate public access being enabled out of band
+	account := azClient.accounts["velerotestaccount"]
+	account.Properties.AllowBlobPublicAccess = to.Ptr(true)
+	container := azClient.containers["velerotestaccount"]["testcontainer"]
+	container.ContainerProperties.PublicAccess = to.Ptr(armstorage.PublicAccessBlob)
+
+	if err := drv.blockPublicAccess(azClient, "velerotestaccount", "testcontainer"); err != nil {
+		t.Fatalf("blockPublicAccess() error: %v", err)
+	}
+	if *account.Properties.AllowBlobPublicAccess {
+		t.Errorf("blockPublicAccess() did not disable public blob access on the storage account")
+	}
+	if *container.ContainerProperties.PublicAccess != armstorage.PublicAccessNone {
+		t.Errorf("blockPublicAccess() did not disable public access on the container")
+	}
+	if container.ContainerProperties.Metadata == nil {
+		t.Errorf("blockPublicAccess() removed the container metadata")
+	}
+}
+
+func TestSetLifecycle(t *testing.T) {
+	azClient := newFakeClient()
+	drv := setUpBareDriver()
+
+	if err := drv.createStorageAccount(azClient, "velerotestaccount", nil); err != nil {
+		t.Fatalf("createStorageAccount() error: %v", err)
+	}
+	if err := drv.setLifecycle(azClient, "velerotestaccount", "testcontainer", 30); err != nil {
+		t.Fatalf("setLifecycle() error: %v", err)
+	}
+
+	policy := azClient.managementPolicies["velerotestaccount"]
+	if policy == nil {
+		t.Fatalf("setLifecycle() did not set a management policy")
+	}
+	rule := policy.Properties.Policy.Rules[0]
+	if got := *rule.Definition.Filters.PrefixMatch[0]; got != "testcontainer/backups/" {
+		t.Errorf("setLifecycle() prefix = %v, want %v", got, "testcontainer/backups/")
+	}
+	if got := *rule.Definition.Actions.BaseBlob.Delete.DaysAfterModificationGreaterThan; got != 30 {
+		t.Errorf("setLifecycle() days = %v, want %v", got, 30)
+	}
+}
+
+func TestMarkStorageOrphaned(t *testing.T) {
+	azClient := newFakeClient()
+	drv := setUpBareDriver()
+	if err := drv.createStorageAccount(azClient, "velerotestaccount", map[string]string{"cost-center": "1234"}); err != nil {
+		t.Fatal(err)
+	}
+
+	orphanedAt := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+	if err := drv.markStorageOrphaned(azClient, "velerotestaccount", orphanedAt); err != nil {
+		t.Fatalf("markStorageOrphaned() error = %v", err)
+	}
+
+	tags := azClient.accounts["velerotestaccount"].Tags
+	if got := tags[sanitizeTagName(storageConstants.BucketTagOrphanedAt)]; got == nil || *got != "2021-03-04T05:06:07Z" {
+		t.Errorf("markStorageOrphaned() did not tag the storage account with the orphaned time")
+	}
+	if got := tags["cost-center"]; got == nil || *got != "1234" {
+		t.Errorf("markStorageOrphaned() did not keep the existing tags")
+	}
+}
+
+func TestDeleteStorage(t *testing.T) {
+	azClient := newFakeClient()
+	drv := setUpBareDriver()
+	if err := drv.createStorageAccount(azClient, "velerotestaccount", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, containerName := range []string{"testcontainer", "othercontainer"} {
+		if err := drv.createContainer(azClient, "velerotestaccount", containerName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The storage account is kept while it holds another container
+	if err := drv.deleteStorage(azClient, "velerotestaccount", "testcontainer"); err != nil {
+		t.Fatalf("deleteStorage() error = %v", err)
+	}
+	if _, ok := azClient.containers["velerotestaccount"]["testcontainer"]; ok {
+		t.Errorf("deleteStorage() did not delete the container")
+	}
+	if _, ok := azClient.accounts["velerotestaccount"]; !ok {
+		t.Errorf("deleteStorage() deleted a storage account that still holds a container")
+	}
+
+	if err := drv.deleteStorage(azClient, "velerotestaccount", "othercontainer"); err != nil {
+		t.Fatalf("deleteStorage() error = %v", err)
+	}
+	if _, ok := azClient.accounts["velerotestaccount"]; ok {
+		t.Errorf("deleteStorage() did not delete the empty storage account")
+	}
+
+	// Deleting storage that is already gone succeeds
+	if err := drv.deleteStorage(azClient, "velerotestaccount", "othercontainer"); err != nil {
+		t.Errorf("deleteStorage() error on deleted storage = %v", err)
+	}
+}
+
+func TestFindVeleroContainer(t *testing.T) {
+	drv := setUpBareDriver()
+
+	tests := []struct {
+		name       string
+		containers []*armstorage.ListContainerItem
+		want       string
+	}{
+		{
+			name:       "no containers",
+			containers: []*armstorage.ListContainerItem{},
+			want:       "",
+		},
+		{
+			name: "container for a different cluster",
+			containers: []*armstorage.ListContainerItem{
+				{
+					Name: to.Ptr("othercontainer"),
+					Properties: &armstorage.ContainerProperties{
+						Metadata: buildContainerMetadata("otherCluster"),
+					},
+				},
+			},
+			want: "",
+		},
+		{
+			name: "container without metadata",
+			containers: []*armstorage.ListContainerItem{
+				{
+					Name: to.Ptr("untagged"),
+				},
+			},
+			want: "",
+		},
+		{
+			name: "container with lowercased metadata names",
+			containers: []*armstorage.ListContainerItem{
+				{
+					Name: to.Ptr("othercontainer"),
+					Properties: &armstorage.ContainerProperties{
+						Metadata: buildContainerMetadata("otherCluster"),
+					},
+				},
+				{
+					Name: to.Ptr("testcontainer"),
+					Properties: &armstorage.ContainerProperties{
+						Metadata: map[string]*string{
+							"velero_io_backup_location":    to.Ptr("default"),
+							"velero_io_infrastructurename": to.Ptr(clusterInfraName),
+						},
+					},
+				},
+			},
+			want: "testcontainer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := drv.findVeleroContainer(tt.containers); got != tt.want {
+				t.Errorf("findVeleroContainer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTagMap(t *testing.T) {
+	got := buildTagMap("dummy-infra", map[string]string{
+		"cost/center":                  "R&D",
+		"velero.io/infrastructureName": "overridden",
+	})
+	want := map[string]string{
+		"cost-center":                  "R&D",
+		"velero.io-backup-location":    "default",
+		"velero.io-infrastructureName": "dummy-infra",
+	}
+	gotValues := make(map[string]string, len(got))
+	for k, v := range got {
+		gotValues[k] = *v
+	}
+	if !reflect.DeepEqual(gotValues, want) {
+		t.Errorf("buildTagMap() = %v, want %v", gotValues, want)
+	}
+}
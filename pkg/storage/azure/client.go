@@ -0,0 +1,248 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/openshift/managed-velero-operator/config"
+	"github.com/openshift/managed-velero-operator/version"
+
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+)
+
+const (
+	azureCredsSecretSubscriptionIDKey = "azure_subscription_id"
+	azureCredsSecretTenantIDKey       = "azure_tenant_id"
+	azureCredsSecretClientIDKey       = "azure_client_id"
+	azureCredsSecretClientSecretKey   = "azure_client_secret" // #nosec G101
+	azureCredsSecretRegionKey         = "azure_region"
+)
+
+var (
+	azureCredsSecretName = version.OperatorName + "-iam-credentials"
+)
+
+// azureClient implements the Client interface.
+type azureClient struct {
+	accounts           *armstorage.AccountsClient
+	containers         *armstorage.BlobContainersClient
+	managementPolicies *armstorage.ManagementPoliciesClient
+	region             string
+}
+
+// Client is a wrapper object for the actual Azure SDK clients to allow for easier testing.
+type Client interface {
+	CreateAccount(ctx context.Context, resourceGroup, accountName string, parameters armstorage.AccountCreateParameters) error
+	GetAccount(ctx context.Context, resourceGroup, accountName string) (*armstorage.Account, error)
+	ListAccounts(ctx context.Context, resourceGroup string) ([]*armstorage.Account, error)
+	UpdateAccount(ctx context.Context, resourceGroup, accountName string, parameters armstorage.AccountUpdateParameters) error
+	DeleteAccount(ctx context.Context, resourceGroup, accountName string) error
+	CreateContainer(ctx context.Context, resourceGroup, accountName, containerName string, container armstorage.BlobContainer) error
+	GetContainer(ctx context.Context, resourceGroup, accountName, containerName string) (*armstorage.BlobContainer, error)
+	ListContainers(ctx context.Context, resourceGroup, accountName string) ([]*armstorage.ListContainerItem, error)
+	UpdateContainer(ctx context.Context, resourceGroup, accountName, containerName string, container armstorage.BlobContainer) error
+	DeleteContainer(ctx context.Context, resourceGroup, accountName, containerName string) error
+	SetManagementPolicy(ctx context.Context, resourceGroup, accountName string, policy armstorage.ManagementPolicy) error
+	GetRegion() string
+}
+
+// When all of the above Client methods are implemented for azureClient, azureClient becomes a kind of Client.
+
+// CreateAccount creates a storage account and waits for the operation to complete.
+func (c *azureClient) CreateAccount(ctx context.Context, resourceGroup, accountName string, parameters armstorage.AccountCreateParameters) error {
+	poller, err := c.accounts.BeginCreate(ctx, resourceGroup, accountName, parameters, nil)
+	if err != nil {
+		return err
+	}
+	_, err = poller.PollUntilDone(ctx, nil)
+	return err
+}
+
+// GetAccount implements the GetAccount method for azureClient.
+func (c *azureClient) GetAccount(ctx context.Context, resourceGroup, accountName string) (*armstorage.Account, error) {
+	resp, err := c.accounts.GetProperties(ctx, resourceGroup, accountName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Account, nil
+}
+
+// ListAccounts lists all storage accounts in a resource group.
+func (c *azureClient) ListAccounts(ctx context.Context, resourceGroup string) ([]*armstorage.Account, error) {
+	var results []*armstorage.Account
+
+	pager := c.accounts.NewListByResourceGroupPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, page.Value...)
+	}
+
+	return results, nil
+}
+
+// UpdateAccount implements the UpdateAccount method for azureClient.
+func (c *azureClient) UpdateAccount(ctx context.Context, resourceGroup, accountName string, parameters armstorage.AccountUpdateParameters) error {
+	_, err := c.accounts.Update(ctx, resourceGroup, accountName, parameters, nil)
+	return err
+}
+
+// DeleteAccount implements the DeleteAccount method for azureClient.
+func (c *azureClient) DeleteAccount(ctx context.Context, resourceGroup, accountName string) error {
+	_, err := c.accounts.Delete(ctx, resourceGroup, accountName, nil)
+	return err
+}
+
+// CreateContainer implements the CreateContainer method for azureClient.
+func (c *azureClient) CreateContainer(ctx context.Context, resourceGroup, accountName, containerName string, container armstorage.BlobContainer) error {
+	_, err := c.containers.Create(ctx, resourceGroup, accountName, containerName, container, nil)
+	return err
+}
+
+// GetContainer implements the GetContainer method for azureClient.
+func (c *azureClient) GetContainer(ctx context.Context, resourceGroup, accountName, containerName string) (*armstorage.BlobContainer, error) {
+	resp, err := c.containers.Get(ctx, resourceGroup, accountName, containerName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.BlobContainer, nil
+}
+
+// ListContainers lists all blob containers in a storage account, including their metadata.
+func (c *azureClient) ListContainers(ctx context.Context, resourceGroup, accountName string) ([]*armstorage.ListContainerItem, error) {
+	var results []*armstorage.ListContainerItem
+
+	pager := c.containers.NewListPager(resourceGroup, accountName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, page.Value...)
+	}
+
+	return results, nil
+}
+
+// UpdateContainer implements the UpdateContainer method for azureClient.
+func (c *azureClient) UpdateContainer(ctx context.Context, resourceGroup, accountName, containerName string, container armstorage.BlobContainer) error {
+	_, err := c.containers.Update(ctx, resourceGroup, accountName, containerName, container, nil)
+	return err
+}
+
+// DeleteContainer implements the DeleteContainer method for azureClient.
+// The blobs in the container are deleted along with it.
+func (c *azureClient) DeleteContainer(ctx context.Context, resourceGroup, accountName, containerName string) error {
+	_, err := c.containers.Delete(ctx, resourceGroup, accountName, containerName, nil)
+	return err
+}
+
+// SetManagementPolicy replaces the lifecycle management policy of a storage account.
+func (c *azureClient) SetManagementPolicy(ctx context.Context, resourceGroup, accountName string, policy armstorage.ManagementPolicy) error {
+	_, err := c.managementPolicies.CreateOrUpdate(ctx, resourceGroup, accountName, armstorage.ManagementPolicyNameDefault, policy, nil)
+	return err
+}
+
+// GetRegion returns the region that new storage accounts are created in.
+func (c *azureClient) GetRegion() string {
+	return c.region
+}
+
+// NewAzureClient reads the azure secrets in the operator's namespace and uses
+// them to create a new client for accessing the Azure Storage management API.
+func NewAzureClient(kubeClient client.Client, cloudName configv1.AzureCloudEnvironment) (Client, error) {
+	var err error
+
+	cloudConfig, err := cloudConfiguration(cloudName)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := config.OperatorNamespace
+	secret := &corev1.Secret{}
+	err = kubeClient.Get(context.TODO(),
+		types.NamespacedName{
+			Name:      azureCredsSecretName,
+			Namespace: namespace,
+		},
+		secret)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, key := range []string{
+		azureCredsSecretSubscriptionIDKey,
+		azureCredsSecretTenantIDKey,
+		azureCredsSecretClientIDKey,
+		azureCredsSecretClientSecretKey,
+		azureCredsSecretRegionKey,
+	} {
+		value, ok := secret.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("Azure credentials secret %v did not contain key %v",
+				azureCredsSecretName, key)
+		}
+		values[key] = string(value)
+	}
+
+	clientOptions := azcore.ClientOptions{Cloud: cloudConfig}
+	credential, err := azidentity.NewClientSecretCredential(
+		values[azureCredsSecretTenantIDKey],
+		values[azureCredsSecretClientIDKey],
+		values[azureCredsSecretClientSecretKey],
+		&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	factory, err := armstorage.NewClientFactory(values[azureCredsSecretSubscriptionIDKey], credential,
+		&arm.ClientOptions{ClientOptions: clientOptions})
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the actual Azure clients into the azureClient interface.
+	return &azureClient{
+		accounts:           factory.NewAccountsClient(),
+		containers:         factory.NewBlobContainersClient(),
+		managementPolicies: factory.NewManagementPoliciesClient(),
+		region:             values[azureCredsSecretRegionKey],
+	}, nil
+}
+
+// cloudConfiguration returns the Azure SDK cloud configuration for the cloud
+// the cluster is running in.
+func cloudConfiguration(cloudName configv1.AzureCloudEnvironment) (cloud.Configuration, error) {
+	switch cloudName {
+	case "", configv1.AzurePublicCloud:
+		return cloud.AzurePublic, nil
+	case configv1.AzureUSGovernmentCloud:
+		return cloud.AzureGovernment, nil
+	case configv1.AzureChinaCloud:
+		return cloud.AzureChina, nil
+	default:
+		return cloud.Configuration{}, fmt.Errorf("unsupported Azure cloud %v", cloudName)
+	}
+}
+
+// isNotFound returns true if the error is an Azure API error for a resource
+// that does not exist.
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
@@ -2,18 +2,148 @@ package base
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	configv1 "github.com/openshift/api/config/v1"
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	"github.com/openshift/managed-velero-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// EventReasonBucketDriftCorrected is the reason of the Event emitted when a
+// bucket setting had drifted from the enforced policy and was corrected
+const EventReasonBucketDriftCorrected = "BucketDriftCorrected"
+
 // Driver holds common fields for storage drivers
 type Driver struct {
 	Context    context.Context
 	KubeClient client.Client
+	Recorder   record.EventRecorder
+	Clients    ClientCache
 }
 
 // GetPlatformType returns the platform type of this driver
 func (d *Driver) GetPlatformType() configv1.PlatformType {
 	return configv1.NonePlatformType
 }
+
+// ExtraBucketTags returns the tags applied to a bucket alongside the velero
+// tags. These are the user tags from the cluster's Infrastructure config, along
+// with the tags set in spec.storage.tags, which take precedence.
+func ExtraBucketTags(resourceTags map[string]string, instance *veleroInstallCR.VeleroInstall) map[string]string {
+	tags := make(map[string]string, len(resourceTags)+len(instance.Spec.Storage.Tags))
+	for key, value := range resourceTags {
+		tags[key] = value
+	}
+	for key, value := range instance.Spec.Storage.Tags {
+		tags[key] = value
+	}
+	return tags
+}
+
+// RecordDrift reports that a setting of the bucket had drifted from the policy
+// enforced by the operator, and has been corrected. The correction is counted
+// in the bucket drift metric, and an Event is emitted on the instance.
+func (d *Driver) RecordDrift(instance *veleroInstallCR.VeleroInstall, bucketName string, setting string) {
+	metrics.BucketDriftTotal.WithLabelValues(setting).Inc()
+	if d.Recorder != nil {
+		d.Recorder.Eventf(instance, corev1.EventTypeWarning, EventReasonBucketDriftCorrected,
+			"Corrected drift in the %v setting of bucket %v", setting, bucketName)
+	}
+}
+
+// SetUnmanagedBucketConditions reports the policy of an existing bucket that
+// the operator doesn't manage. The policy is never enforced on such a bucket,
+// so any gaps found in it are reported instead.
+func SetUnmanagedBucketConditions(instance *veleroInstallCR.VeleroInstall, gaps []string) {
+	instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionTrue, veleroInstallCR.ReasonBucketUnmanaged,
+		fmt.Sprintf("Bucket %v is not managed by the operator; its policy is not enforced", instance.Status.StorageBucket.Name))
+	if len(gaps) > 0 {
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyCompliant, metav1.ConditionFalse, veleroInstallCR.ReasonPolicyGapsFound,
+			fmt.Sprintf("Bucket %v does not meet the bucket policy: %v", instance.Status.StorageBucket.Name, strings.Join(gaps, "; ")))
+		return
+	}
+	instance.SetCondition(veleroInstallCR.ConditionBucketPolicyCompliant, metav1.ConditionTrue, veleroInstallCR.ReasonPolicyCompliant,
+		fmt.Sprintf("Bucket %v meets the bucket policy", instance.Status.StorageBucket.Name))
+}
+
+// BucketCandidate is an existing bucket tagged for the cluster's backups,
+// which may be recovered as its storage bucket
+type BucketCandidate struct {
+	Name         string
+	CreationDate time.Time
+}
+
+// RankBucketCandidates orders the buckets that may be recovered, newest
+// first. Buckets created at the same time are ordered by name, so the same
+// bucket is always chosen whatever order the buckets were listed in.
+func RankBucketCandidates(candidates []BucketCandidate) []string {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].CreationDate.Equal(candidates[j].CreationDate) {
+			return candidates[i].CreationDate.After(candidates[j].CreationDate)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	var names []string
+	for _, candidate := range candidates {
+		names = append(names, candidate.Name)
+	}
+	return names
+}
+
+// RecoveryNamePrefixes returns the name prefixes of the buckets that can be
+// recovered for the instance. Besides the prefix in the spec, these are the
+// default prefix and the prefix of the last bucket name generated, so that a
+// bucket is still found after spec.storage.namePrefix is changed.
+func RecoveryNamePrefixes(instance *veleroInstallCR.VeleroInstall) []string {
+	var prefixes []string
+	for _, prefix := range []string{instance.Spec.Storage.GetNamePrefix(), veleroInstallCR.DefaultStorageNamePrefix, instance.Status.StorageBucket.NamePrefix} {
+		if prefix != "" && !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// AdoptBucket sets the storage bucket of the instance to the first of the
+// ranked buckets that are tagged for the cluster, but had no cluster marker
+// until the first was adopted. The adoption is reported in the
+// BucketProvisioned condition, and, as with RecoverBucket, the choice between
+// several buckets in the BucketRecoveryAmbiguous condition.
+func AdoptBucket(instance *veleroInstallCR.VeleroInstall, matches []string) {
+	RecoverBucket(instance, matches)
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionTrue, veleroInstallCR.ReasonBucketAdopted,
+		fmt.Sprintf("Adopted existing bucket %v, which had no cluster marker", matches[0]))
+	if len(matches) > 1 {
+		instance.SetCondition(veleroInstallCR.ConditionBucketRecoveryAmbiguous, metav1.ConditionTrue, veleroInstallCR.ReasonMultipleBucketsMatched,
+			fmt.Sprintf("Adopted bucket %v, the newest of the buckets tagged for this cluster without a cluster marker: %v", matches[0], strings.Join(matches, ", ")))
+		return
+	}
+	instance.SetCondition(veleroInstallCR.ConditionBucketRecoveryAmbiguous, metav1.ConditionFalse, veleroInstallCR.ReasonSingleBucketMatched,
+		fmt.Sprintf("Bucket %v is the only bucket tagged for this cluster, and was adopted since it had no cluster marker", matches[0]))
+}
+
+// RecoverBucket sets the storage bucket of the instance to the first of the
+// ranked buckets that matched the cluster. When more than one bucket matched,
+// the choice is reported in the BucketRecoveryAmbiguous condition, so that
+// the other buckets can be checked for backups.
+func RecoverBucket(instance *veleroInstallCR.VeleroInstall, matches []string) {
+	instance.Status.StorageBucket.Name = matches[0]
+	instance.Status.StorageBucket.Provisioned = true
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionTrue, veleroInstallCR.ReasonBucketRecovered,
+		fmt.Sprintf("Recovered existing bucket %v", matches[0]))
+	if len(matches) > 1 {
+		instance.SetCondition(veleroInstallCR.ConditionBucketRecoveryAmbiguous, metav1.ConditionTrue, veleroInstallCR.ReasonMultipleBucketsMatched,
+			fmt.Sprintf("Recovered bucket %v, the newest of the buckets tagged for this cluster: %v", matches[0], strings.Join(matches, ", ")))
+		return
+	}
+	instance.SetCondition(veleroInstallCR.ConditionBucketRecoveryAmbiguous, metav1.ConditionFalse, veleroInstallCR.ReasonSingleBucketMatched,
+		fmt.Sprintf("Bucket %v is the only bucket tagged for this cluster", matches[0]))
+}
@@ -0,0 +1,209 @@
+package base
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRecoverBucket(t *testing.T) {
+	tests := []struct {
+		name       string
+		matches    []string
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{
+			name:       "single bucket",
+			matches:    []string{"bucket1"},
+			wantStatus: metav1.ConditionFalse,
+			wantReason: veleroInstallCR.ReasonSingleBucketMatched,
+		},
+		{
+			name:       "several buckets",
+			matches:    []string{"bucket2", "bucket1"},
+			wantStatus: metav1.ConditionTrue,
+			wantReason: veleroInstallCR.ReasonMultipleBucketsMatched,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &veleroInstallCR.VeleroInstall{}
+			RecoverBucket(instance, tt.matches)
+
+			if instance.Status.StorageBucket.Name != tt.matches[0] || !instance.Status.StorageBucket.Provisioned {
+				t.Errorf("RecoverBucket() bucket = %+v, want %v provisioned", instance.Status.StorageBucket, tt.matches[0])
+			}
+			if !instance.IsConditionTrue(veleroInstallCR.ConditionBucketProvisioned) {
+				t.Errorf("RecoverBucket() didn't set %v", veleroInstallCR.ConditionBucketProvisioned)
+			}
+			condition := meta.FindStatusCondition(instance.Status.Conditions, veleroInstallCR.ConditionBucketRecoveryAmbiguous)
+			if condition == nil || condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("RecoverBucket() %v = %+v, want %v/%v", veleroInstallCR.ConditionBucketRecoveryAmbiguous, condition, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRecoveryNamePrefixes(t *testing.T) {
+	tests := []struct {
+		name         string
+		specPrefix   string
+		statusPrefix string
+		want         []string
+	}{
+		{
+			name: "default prefix",
+			want: []string{veleroInstallCR.DefaultStorageNamePrefix},
+		},
+		{
+			name:       "prefix in the spec",
+			specPrefix: "custom-",
+			want:       []string{"custom-", veleroInstallCR.DefaultStorageNamePrefix},
+		},
+		{
+			name:         "prefix changed since the name was generated",
+			specPrefix:   "custom-",
+			statusPrefix: "previous-",
+			want:         []string{"custom-", veleroInstallCR.DefaultStorageNamePrefix, "previous-"},
+		},
+		{
+			name:         "prefix unchanged since the name was generated",
+			specPrefix:   "custom-",
+			statusPrefix: "custom-",
+			want:         []string{"custom-", veleroInstallCR.DefaultStorageNamePrefix},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &veleroInstallCR.VeleroInstall{}
+			instance.Spec.Storage.NamePrefix = tt.specPrefix
+			instance.Status.StorageBucket.NamePrefix = tt.statusPrefix
+			if got := RecoveryNamePrefixes(instance); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RecoveryNamePrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnedBuckets(t *testing.T) {
+	marker := &ClusterMarker{InfraName: "infra", ClusterID: "cluster"}
+	markers := map[string]*ClusterMarker{
+		"owned":         marker,
+		"other-cluster": {InfraName: "infra", ClusterID: "other-cluster"},
+	}
+	errs := map[string]error{
+		"denied":      fmt.Errorf("%w: AccessDenied", ErrClusterMarkerAccessDenied),
+		"unreachable": errors.New("connection reset"),
+	}
+	tests := []struct {
+		name        string
+		buckets     []string
+		want        []string
+		wantAdopted bool
+		wantErr     bool
+	}{
+		{
+			name:    "owned bucket",
+			buckets: []string{"owned", "unmarked", "other-cluster"},
+			want:    []string{"owned"},
+		},
+		{
+			name:        "single bucket without a marker",
+			buckets:     []string{"unmarked", "other-cluster"},
+			want:        []string{"unmarked"},
+			wantAdopted: true,
+		},
+		{
+			name:        "several buckets without a marker",
+			buckets:     []string{"unmarked", "other-cluster", "unmarked-2"},
+			want:        []string{"unmarked", "unmarked-2"},
+			wantAdopted: true,
+		},
+		{
+			name:    "bucket whose marker can't be read",
+			buckets: []string{"denied", "owned"},
+			want:    []string{"owned"},
+		},
+		{
+			name:        "only bucket without a marker beside one that can't be read",
+			buckets:     []string{"denied", "unmarked"},
+			want:        []string{"unmarked"},
+			wantAdopted: true,
+		},
+		{
+			name:    "marker read failed",
+			buckets: []string{"unreachable", "owned"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			written := map[string]*ClusterMarker{}
+			got, adopted, err := OwnedBuckets(logr.Discard(), tt.buckets, marker,
+				func(bucketName string) (*ClusterMarker, error) {
+					return markers[bucketName], errs[bucketName]
+				},
+				func(bucketName string, marker *ClusterMarker) error {
+					written[bucketName] = marker
+					return nil
+				})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OwnedBuckets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) || adopted != tt.wantAdopted {
+				t.Errorf("OwnedBuckets() = %v, %v, want %v, %v", got, adopted, tt.want, tt.wantAdopted)
+			}
+			// The marker is only written into the bucket that is recovered
+			if tt.wantAdopted && (written[tt.want[0]] != marker || len(written) != 1) {
+				t.Errorf("OwnedBuckets() wrote markers into %v, want %v", written, tt.want[0])
+			}
+			if !tt.wantAdopted && len(written) > 0 {
+				t.Errorf("OwnedBuckets() wrote markers into %v", written)
+			}
+		})
+	}
+}
+
+func TestAdoptBucket(t *testing.T) {
+	tests := []struct {
+		name          string
+		matches       []string
+		wantAmbiguous metav1.ConditionStatus
+	}{
+		{
+			name:          "single bucket",
+			matches:       []string{"bucket1"},
+			wantAmbiguous: metav1.ConditionFalse,
+		},
+		{
+			name:          "several buckets",
+			matches:       []string{"bucket2", "bucket1"},
+			wantAmbiguous: metav1.ConditionTrue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &veleroInstallCR.VeleroInstall{}
+			AdoptBucket(instance, tt.matches)
+
+			if instance.Status.StorageBucket.Name != tt.matches[0] || !instance.Status.StorageBucket.Provisioned {
+				t.Errorf("AdoptBucket() bucket = %+v, want %v provisioned", instance.Status.StorageBucket, tt.matches[0])
+			}
+			condition := meta.FindStatusCondition(instance.Status.Conditions, veleroInstallCR.ConditionBucketProvisioned)
+			if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != veleroInstallCR.ReasonBucketAdopted {
+				t.Errorf("AdoptBucket() %v = %+v, want %v/%v", veleroInstallCR.ConditionBucketProvisioned, condition, metav1.ConditionTrue, veleroInstallCR.ReasonBucketAdopted)
+			}
+			if !meta.IsStatusConditionPresentAndEqual(instance.Status.Conditions, veleroInstallCR.ConditionBucketRecoveryAmbiguous, tt.wantAmbiguous) {
+				t.Errorf("AdoptBucket() %v is not %v", veleroInstallCR.ConditionBucketRecoveryAmbiguous, tt.wantAmbiguous)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package base
+
+import (
+	"context"
+	"sync"
+
+	"github.com/openshift/managed-velero-operator/config"
+	"github.com/openshift/managed-velero-operator/version"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CredentialsSecretName is the name of the secret in the operator's namespace
+// that the cloud-credential-operator writes the operator's credentials to
+var CredentialsSecretName = version.OperatorName + "-iam-credentials"
+
+// ClientCache holds the cloud clients built from credentials secrets, so that
+// they can be reused across reconciles. A client is only built again once the
+// resourceVersion of its secret changes, which happens when the credentials
+// are rotated. A nil ClientCache builds a new client every time.
+type ClientCache struct {
+	mu      sync.Mutex
+	clients map[string]cachedClient
+}
+
+type cachedClient struct {
+	resourceVersion string
+	client          interface{}
+}
+
+// GetCredentialsSecret reads the secret with the given name in the operator's namespace
+func GetCredentialsSecret(kubeClient client.Client, secretName string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := kubeClient.Get(context.TODO(),
+		types.NamespacedName{
+			Name:      secretName,
+			Namespace: config.OperatorNamespace,
+		},
+		secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Get returns the client cached under key, if it was built from the current
+// version of the secret. Otherwise, build is called to create a client from
+// the secret, which replaces the cached one. A replaced client is closed if it
+// has a Close method.
+func (c *ClientCache) Get(key string, secret *corev1.Secret, build func(*corev1.Secret) (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return build(secret)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.clients[key]
+	if ok && cached.resourceVersion == secret.ResourceVersion {
+		return cached.client, nil
+	}
+
+	newClient, err := build(secret)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if closer, isCloser := cached.client.(interface{ Close() error }); isCloser {
+			// The old client is no longer used, so failing to close it is harmless
+			_ = closer.Close()
+		}
+	}
+	if c.clients == nil {
+		c.clients = make(map[string]cachedClient)
+	}
+	c.clients[key] = cachedClient{resourceVersion: secret.ResourceVersion, client: newClient}
+	return newClient, nil
+}
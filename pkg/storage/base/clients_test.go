@@ -0,0 +1,64 @@
+package base
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeCloudClient records whether it was closed after being replaced
+type fakeCloudClient struct {
+	resourceVersion string
+	closed          bool
+}
+
+func (c *fakeCloudClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestClientCache(t *testing.T) {
+	builds := 0
+	build := func(secret *corev1.Secret) (interface{}, error) {
+		builds++
+		return &fakeCloudClient{resourceVersion: secret.ResourceVersion}, nil
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: CredentialsSecretName, ResourceVersion: "1"}}
+
+	cache := &ClientCache{}
+	first, err := cache.Get("us-east-1", secret, build)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	// The client is reused while the secret is unchanged
+	second, _ := cache.Get("us-east-1", secret, build)
+	if second != first || builds != 1 {
+		t.Errorf("Get() built %v clients for an unchanged secret, want 1", builds)
+	}
+
+	// Each key has its own client
+	if other, _ := cache.Get("us-west-2", secret, build); other == first {
+		t.Errorf("Get() returned the same client for another key")
+	}
+
+	// Rotating the credentials replaces the client, and closes the old one
+	secret.ResourceVersion = "2"
+	rotated, _ := cache.Get("us-east-1", secret, build)
+	if rotated == first || rotated.(*fakeCloudClient).resourceVersion != "2" {
+		t.Errorf("Get() didn't rebuild the client for a rotated secret")
+	}
+	if !first.(*fakeCloudClient).closed {
+		t.Errorf("Get() didn't close the replaced client")
+	}
+
+	// Without a cache, a client is built on every call
+	var noCache *ClientCache
+	builds = 0
+	_, _ = noCache.Get("us-east-1", secret, build)
+	_, _ = noCache.Get("us-east-1", secret, build)
+	if builds != 2 {
+		t.Errorf("Get() on a nil cache built %v clients, want 2", builds)
+	}
+}
@@ -0,0 +1,118 @@
+package base
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/openshift/managed-velero-operator/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClusterMarkerKey is the key of the object, written into each bucket the
+// operator provisions, that identifies the cluster owning the bucket
+const ClusterMarkerKey = "managed-velero/cluster.json"
+
+// ErrClusterMarkerAccessDenied is wrapped by the drivers in the errors of
+// marker reads that were denied, such as reads of a bucket whose policy or KMS
+// key belongs to someone else
+var ErrClusterMarkerAccessDenied = errors.New("access to the cluster marker was denied")
+
+// ClusterMarker identifies the cluster that owns a bucket. Unlike the bucket's
+// tags, it is only ever written by the operator, so it is a second proof of
+// ownership before a bucket is adopted.
+type ClusterMarker struct {
+	InfraName         string    `json:"infraName"`
+	ClusterID         string    `json:"clusterID"`
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+	OperatorVersion   string    `json:"operatorVersion"`
+}
+
+// ClusterMarker returns the marker for the cluster the operator is running
+// on. The cluster ID is read from the ClusterVersion, which is unique to each
+// install of a cluster, even when the infrastructure name is reused.
+func (d *Driver) ClusterMarker(infraName string) (*ClusterMarker, error) {
+	clusterVersion := &configv1.ClusterVersion{}
+	if err := d.KubeClient.Get(d.Context, client.ObjectKey{Name: "version"}, clusterVersion); err != nil {
+		return nil, fmt.Errorf("unable to read the cluster ID: %v", err)
+	}
+	return &ClusterMarker{
+		InfraName:         infraName,
+		ClusterID:         string(clusterVersion.Spec.ClusterID),
+		CreationTimestamp: time.Now().UTC(),
+		OperatorVersion:   version.Version,
+	}, nil
+}
+
+// Marshal encodes the marker as the contents of the marker object
+func (m *ClusterMarker) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// ParseClusterMarker decodes the contents of a marker object
+func ParseClusterMarker(data []byte) (*ClusterMarker, error) {
+	marker := &ClusterMarker{}
+	if err := json.Unmarshal(data, marker); err != nil {
+		return nil, fmt.Errorf("unable to parse %v: %v", ClusterMarkerKey, err)
+	}
+	return marker, nil
+}
+
+// Owns returns true if the marker found in a bucket was written by the same
+// cluster as this marker
+func (m *ClusterMarker) Owns(found *ClusterMarker) bool {
+	return found != nil && found.InfraName == m.InfraName && found.ClusterID == m.ClusterID
+}
+
+// OwnedBuckets returns the buckets, ranked as RankBucketCandidates ranks them,
+// whose marker, read with getMarker, shows that they were provisioned by the
+// cluster. Buckets with the marker of another cluster, or whose marker can't be
+// read because access is denied, are left out, so that a bucket is never
+// shared between two clusters because their tags collide.
+//
+// Buckets provisioned before markers were introduced don't have one, so when no
+// bucket is owned, the buckets without a marker are returned instead, and
+// adopted is returned true. The marker is written into the first of them with
+// putMarker, since that is the bucket that is recovered.
+func OwnedBuckets(log logr.Logger, bucketNames []string, marker *ClusterMarker,
+	getMarker func(bucketName string) (*ClusterMarker, error),
+	putMarker func(bucketName string, marker *ClusterMarker) error) (owned []string, adopted bool, err error) {
+	var unmarked []string
+	for _, bucketName := range bucketNames {
+		found, err := getMarker(bucketName)
+		if errors.Is(err, ErrClusterMarkerAccessDenied) {
+			log.Info("Skipping bucket whose cluster marker can't be read", "Bucket.Name", bucketName, "error", err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to read the cluster marker of bucket %v: %v", bucketName, err)
+		}
+		switch {
+		case found == nil:
+			unmarked = append(unmarked, bucketName)
+		case marker.Owns(found):
+			owned = append(owned, bucketName)
+		}
+	}
+	if len(owned) > 0 || len(unmarked) == 0 {
+		return owned, false, nil
+	}
+	if err := putMarker(unmarked[0], marker); err != nil {
+		return nil, false, fmt.Errorf("unable to write the cluster marker of bucket %v: %v", unmarked[0], err)
+	}
+	return unmarked, true, nil
+}
+
+// VerifyClusterMarker checks the marker found in a bucket against the
+// cluster's marker. A bucket without a marker is not an error, since buckets
+// provisioned before markers were introduced don't have one yet.
+func VerifyClusterMarker(bucketName string, marker, found *ClusterMarker) error {
+	if found == nil || marker.Owns(found) {
+		return nil
+	}
+	return fmt.Errorf("bucket %v belongs to cluster %v (%v), not to this cluster %v (%v)",
+		bucketName, found.InfraName, found.ClusterID, marker.InfraName, marker.ClusterID)
+}
@@ -1,8 +1,21 @@
 package constants
 
 const (
-	StorageBucketPrefix                = "managed-velero-backups-"
 	DefaultVeleroBackupStorageLocation = "default"
+	ReplicaVeleroBackupStorageLocation = "replica"
 	BucketTagBackupStorageLocation     = "velero.io/backup-location"
 	BucketTagInfrastructureName        = "velero.io/infrastructureName"
+	BucketTagOrphanedAt                = "velero.io/orphaned-at"
+
+	// AbortIncompleteMultipartUploadDays is the number of days after which
+	// multipart uploads that were never completed are aborted
+	AbortIncompleteMultipartUploadDays = 7
+
+	// Bucket settings that are reported when they drift from the enforced policy
+	BucketSettingEncryption        = "encryption"
+	BucketSettingPublicAccessBlock = "publicAccessBlock"
+	BucketSettingVersioning        = "versioning"
+	BucketSettingObjectLock        = "objectLock"
+	BucketSettingLifecycle         = "lifecycle"
+	BucketSettingTags              = "tags"
 )
@@ -1,13 +1,22 @@
 package gcs
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
 	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
 
 	gstorage "cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 )
 
@@ -15,27 +24,317 @@ var (
 	UniformBucketLevelAccessEnabled = gstorage.UniformBucketLevelAccess{Enabled: true}
 )
 
-// CreateBucket creates a new GCS bucket.
-func (d *driver) createBucket(gcsClient stiface.Client, bucketName string) error {
-	return gcsClient.Bucket(bucketName).Create(d.Context, d.Config.Project, &gstorage.BucketAttrs{
+const (
+	// backupsPrefix is where Velero writes backups in the bucket
+	backupsPrefix = "backups/"
+
+	// veleroLabelPrefix is the prefix of the labels owned by the operator,
+	// which is the velero.io/ tag prefix once sanitized
+	veleroLabelPrefix = "velero-io-"
+
+	// maxLabelLength is the longest a label key or value may be
+	maxLabelLength = 63
+)
+
+// invalidLabelChars matches the characters that aren't allowed in a label
+var invalidLabelChars = regexp.MustCompile("[^a-z0-9-_]+")
+
+// dualRegionLocations maps the prefix of a region's name to the multi-region
+// that a dual-region bucket spanning two regions with that prefix belongs to.
+// https://cloud.google.com/storage/docs/locations#configurable
+var dualRegionLocations = map[string]string{
+	"asia-":   "ASIA",
+	"europe-": "EU",
+	"us-":     "US",
+}
+
+// CreateBucket creates a new versioned GCS bucket. If a Cloud KMS key name is
+// given, objects in the bucket are encrypted with that key by default. If a
+// replica region is given, the bucket is a dual-region bucket spanning the
+// cluster's region and the replica region, optionally with turbo replication.
+func (d *driver) createBucket(gcsClient stiface.Client, bucketName string, kmsKeyName string, extraLabels map[string]string, replicaRegion string, turbo bool) error {
+	attrs := &gstorage.BucketAttrs{
 		Location:                 strings.ToUpper(d.Config.Region),
 		UniformBucketLevelAccess: UniformBucketLevelAccessEnabled,
-		Labels:                   buildLabelMap(d.Config.InfraName),
+		PublicAccessPrevention:   gstorage.PublicAccessPreventionEnforced,
+		VersioningEnabled:        true,
+		Labels:                   buildLabelMap(d.Config.InfraName, extraLabels),
+	}
+	if kmsKeyName != "" {
+		attrs.Encryption = &gstorage.BucketEncryption{DefaultKMSKeyName: kmsKeyName}
+	}
+	if replicaRegion != "" {
+		location, err := dualRegionLocation(d.Config.Region, replicaRegion)
+		if err != nil {
+			return err
+		}
+		attrs.Location = location
+		attrs.CustomPlacementConfig = &gstorage.CustomPlacementConfig{
+			DataLocations: []string{strings.ToUpper(d.Config.Region), strings.ToUpper(replicaRegion)},
+		}
+		attrs.RPO = bucketRPO(turbo)
+	}
+	return gcsClient.Bucket(bucketName).Create(d.Context, d.Config.Project, attrs)
+}
+
+// dualRegionLocation returns the multi-region of a dual-region bucket spanning
+// the two regions. Both regions must belong to the same multi-region.
+func dualRegionLocation(region string, replicaRegion string) (string, error) {
+	for prefix, location := range dualRegionLocations {
+		if strings.HasPrefix(strings.ToLower(region), prefix) && strings.HasPrefix(strings.ToLower(replicaRegion), prefix) {
+			return location, nil
+		}
+	}
+	return "", fmt.Errorf("a dual-region bucket can't span regions %v and %v, which must both be in the US, EU or ASIA multi-region", region, replicaRegion)
+}
+
+// bucketRPO returns the recovery point objective of a dual-region bucket
+func bucketRPO(turbo bool) gstorage.RPO {
+	if turbo {
+		return gstorage.RPOAsyncTurbo
+	}
+	return gstorage.RPODefault
+}
+
+// enforceBucketReplication checks that the GCS bucket is a dual-region bucket
+// spanning the cluster's region and the replica region, and sets its recovery
+// point objective. A bucket's placement can't be changed after it is created,
+// so an error is returned for any other bucket. The bucket is only updated if
+// its recovery point objective has drifted.
+func (d *driver) enforceBucketReplication(gcsClient stiface.Client, bucketName string, replicaRegion string, turbo bool) error {
+	bucket := gcsClient.Bucket(bucketName)
+	attrs, err := bucket.Attrs(d.Context)
+	if err != nil {
+		return err
+	}
+	if !isDualRegionBucket(attrs, d.Config.Region, replicaRegion) {
+		return fmt.Errorf("bucket %v is not a dual-region bucket spanning regions %v and %v, and can only be made one when it is created",
+			bucketName, d.Config.Region, replicaRegion)
+	}
+	if attrs.RPO == bucketRPO(turbo) {
+		return nil
+	}
+
+	_, err = bucket.Update(d.Context, gstorage.BucketAttrsToUpdate{
+		RPO: bucketRPO(turbo),
+	})
+	return err
+}
+
+// isDualRegionBucket returns true if the data in the GCS bucket is placed in
+// exactly the two given regions.
+func isDualRegionBucket(attrs *gstorage.BucketAttrs, region string, replicaRegion string) bool {
+	if attrs.CustomPlacementConfig == nil || len(attrs.CustomPlacementConfig.DataLocations) != 2 {
+		return false
+	}
+	found := map[string]bool{}
+	for _, location := range attrs.CustomPlacementConfig.DataLocations {
+		found[strings.ToLower(location)] = true
+	}
+	return found[strings.ToLower(region)] && found[strings.ToLower(replicaRegion)]
+}
+
+// encryptBucket sets the default Cloud KMS key of the GCS bucket. The bucket is
+// only updated if its key has drifted.
+func (d *driver) encryptBucket(gcsClient stiface.Client, bucketName string, kmsKeyName string) error {
+	bucket := gcsClient.Bucket(bucketName)
+	attrs, err := bucket.Attrs(d.Context)
+	if err != nil {
+		return err
+	}
+	if isBucketEncrypted(attrs, kmsKeyName) {
+		return nil
+	}
+
+	_, err = bucket.Update(d.Context, gstorage.BucketAttrsToUpdate{
+		Encryption: &gstorage.BucketEncryption{DefaultKMSKeyName: kmsKeyName},
 	})
+	return err
 }
 
 // enforceBucketLabels enforces labels on an GCS bucket. The tags are used to indicate that velero backups
-// are stored in the bucket, and to identify the associated cluster.
-func (d *driver) enforceBucketLabels(gcsClient stiface.Client, bucketName string) error {
+// are stored in the bucket, and to identify the associated cluster. Labels that don't belong to the
+// operator are kept, and the bucket is only updated if its labels have drifted.
+func (d *driver) enforceBucketLabels(gcsClient stiface.Client, bucketName string, extraLabels map[string]string) error {
+	bucket := gcsClient.Bucket(bucketName)
+	attrs, err := bucket.Attrs(d.Context)
+	if err != nil {
+		return err
+	}
+
 	bucketAttrs := &gstorage.BucketAttrsToUpdate{}
-	labels := buildLabelMap(d.Config.InfraName)
+	changed := false
+	labels := buildLabelMap(d.Config.InfraName, extraLabels)
 	for k, v := range labels {
-		bucketAttrs.SetLabel(k, v)
+		if current, ok := attrs.Labels[k]; !ok || current != v {
+			bucketAttrs.SetLabel(k, v)
+			changed = true
+		}
+	}
+	// The operator owns the velero labels, so stale ones are removed, while
+	// labels added to the bucket by anything else are kept
+	for k := range attrs.Labels {
+		if _, ok := labels[k]; !ok && strings.HasPrefix(k, veleroLabelPrefix) {
+			bucketAttrs.DeleteLabel(k)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	_, err = bucket.Update(d.Context, *bucketAttrs)
+	return err
+}
+
+// blockBucketPublicAccess enables uniform bucket-level access on the GCS bucket,
+// so that object ACLs can't grant access, and enforces public access
+// prevention. The bucket is only updated if either setting has drifted.
+func (d *driver) blockBucketPublicAccess(gcsClient stiface.Client, bucketName string) error {
+	bucket := gcsClient.Bucket(bucketName)
+	attrs, err := bucket.Attrs(d.Context)
+	if err != nil {
+		return err
+	}
+	if attrs.UniformBucketLevelAccess.Enabled && attrs.PublicAccessPrevention == gstorage.PublicAccessPreventionEnforced {
+		return nil
+	}
+
+	_, err = bucket.Update(d.Context, gstorage.BucketAttrsToUpdate{
+		UniformBucketLevelAccess: &UniformBucketLevelAccessEnabled,
+		PublicAccessPrevention:   gstorage.PublicAccessPreventionEnforced,
+	})
+	return err
+}
+
+// enableBucketVersioning enables object versioning on the GCS bucket. The bucket
+// is only updated if versioning has been disabled.
+func (d *driver) enableBucketVersioning(gcsClient stiface.Client, bucketName string) error {
+	bucket := gcsClient.Bucket(bucketName)
+	attrs, err := bucket.Attrs(d.Context)
+	if err != nil {
+		return err
+	}
+	if attrs.VersioningEnabled {
+		return nil
+	}
+
+	_, err = bucket.Update(d.Context, gstorage.BucketAttrsToUpdate{
+		VersioningEnabled: true,
+	})
+	return err
+}
+
+// setBucketLifecycle sets a lifecycle on the GCS bucket, deleting backups after
+// the given number of days, and noncurrent object versions noncurrentDays after
+// they were replaced or deleted. The bucket is only updated if its lifecycle
+// has drifted.
+func (d *driver) setBucketLifecycle(gcsClient stiface.Client, bucketName string, retentionDays int64, noncurrentDays int64) error {
+	bucket := gcsClient.Bucket(bucketName)
+	attrs, err := bucket.Attrs(d.Context)
+	if err != nil {
+		return err
+	}
+	lifecycle := bucketLifecycle(retentionDays, noncurrentDays)
+	if reflect.DeepEqual(attrs.Lifecycle, lifecycle) {
+		return nil
+	}
+
+	_, err = bucket.Update(d.Context, gstorage.BucketAttrsToUpdate{
+		Lifecycle: &lifecycle,
+	})
+	return err
+}
+
+// bucketLifecycle returns the lifecycle for a GCS bucket, deleting backups
+// after the given number of days. In a versioned bucket, deleting a backup only
+// makes it noncurrent, so noncurrent versions are deleted noncurrentDays later.
+// Incomplete multipart uploads are also aborted.
+func bucketLifecycle(retentionDays int64, noncurrentDays int64) gstorage.Lifecycle {
+	return gstorage.Lifecycle{
+		Rules: []gstorage.LifecycleRule{
+			{
+				Action: gstorage.LifecycleAction{
+					Type: gstorage.DeleteAction,
+				},
+				Condition: gstorage.LifecycleCondition{
+					AgeInDays:     retentionDays,
+					MatchesPrefix: []string{backupsPrefix},
+				},
+			},
+			{
+				Action: gstorage.LifecycleAction{
+					Type: gstorage.DeleteAction,
+				},
+				Condition: gstorage.LifecycleCondition{
+					DaysSinceNoncurrentTime: noncurrentDays,
+				},
+			},
+			{
+				Action: gstorage.LifecycleAction{
+					Type: gstorage.AbortIncompleteMPUAction,
+				},
+				Condition: gstorage.LifecycleCondition{
+					AgeInDays: storageConstants.AbortIncompleteMultipartUploadDays,
+				},
+			},
+		},
 	}
+}
+
+// verifyBucketPolicy reads back the attributes of the GCS bucket, and returns an
+// error describing each setting that does not meet the policy.
+func (d *driver) verifyBucketPolicy(gcsClient stiface.Client, bucketName string, retentionDays int64, kmsKeyName string) error {
+	attrs, err := gcsClient.Bucket(bucketName).Attrs(d.Context)
+	if err != nil {
+		return err
+	}
+	if gaps := findBucketPolicyGaps(attrs, retentionDays, kmsKeyName); len(gaps) > 0 {
+		return fmt.Errorf("%v", strings.Join(gaps, "; "))
+	}
+	return nil
+}
+
+// markBucketOrphaned adds a label to the GCS bucket recording the time that it
+// was orphaned by its cluster. Existing labels on the bucket are kept.
+func (d *driver) markBucketOrphaned(gcsClient stiface.Client, bucketName string, orphanedAt time.Time) error {
+	bucketAttrs := &gstorage.BucketAttrsToUpdate{}
+	bucketAttrs.SetLabel(sanitizeBucketLabel(storageConstants.BucketTagOrphanedAt), sanitizeBucketLabel(orphanedAt.UTC().Format(time.RFC3339)))
 	_, err := gcsClient.Bucket(bucketName).Update(d.Context, *bucketAttrs)
 	return err
 }
 
+// deleteBucket deletes every object generation in the GCS bucket, and then the
+// bucket itself. A bucket that no longer exists is not an error.
+func (d *driver) deleteBucket(gcsClient stiface.Client, bucketName string) error {
+	bucket := gcsClient.Bucket(bucketName)
+
+	objects := bucket.Objects(d.Context, &gstorage.Query{Versions: true})
+	for {
+		object, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err == gstorage.ErrBucketNotExist {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Object(object.Name).Generation(object.Generation).Delete(d.Context)
+		if err != nil && err != gstorage.ErrObjectNotExist {
+			return err
+		}
+	}
+
+	err := bucket.Delete(d.Context)
+	if err != nil && err != gstorage.ErrBucketNotExist {
+		return err
+	}
+	return nil
+}
+
 // listBuckets lists all buckets in the GCP account.
 func (d *driver) listBuckets(gcsClient stiface.Client) ([]*gstorage.BucketAttrs, error) {
 	var results []*gstorage.BucketAttrs
@@ -56,10 +355,138 @@ func (d *driver) listBuckets(gcsClient stiface.Client) ([]*gstorage.BucketAttrs,
 	return results, nil
 }
 
-// FindVeleroBucket looks through the Labels for all GCS buckets and determines if
+// isBucketInProject returns true if the GCS bucket belongs to the given project.
+func (d *driver) isBucketInProject(gcsClient stiface.Client, bucketName string, project string) (bool, error) {
+	list := gcsClient.Buckets(d.Context, project)
+	list.SetPrefix(bucketName)
+	for {
+		bucket, err := list.Next()
+		if err == iterator.Done {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if bucket.Name == bucketName {
+			return true, nil
+		}
+	}
+}
+
+// findBucketPolicyGaps returns a description of each setting of the GCS bucket
+// that does not meet the policy the operator would otherwise enforce on it.
+func findBucketPolicyGaps(attrs *gstorage.BucketAttrs, retentionDays int64, kmsKeyName string) []string {
+	var gaps []string
+
+	if !isBucketEncrypted(attrs, kmsKeyName) {
+		gaps = append(gaps, fmt.Sprintf("default encryption does not use key %v", kmsKeyName))
+	}
+	if !attrs.UniformBucketLevelAccess.Enabled {
+		gaps = append(gaps, "uniform bucket-level access is not enabled")
+	}
+	if attrs.PublicAccessPrevention != gstorage.PublicAccessPreventionEnforced {
+		gaps = append(gaps, "public access prevention is not enforced")
+	}
+	if !attrs.VersioningEnabled {
+		gaps = append(gaps, "versioning is not enabled")
+	}
+	if !doBackupsExpire(attrs.Lifecycle, retentionDays) {
+		gaps = append(gaps, fmt.Sprintf("backups are not expired within %v days", retentionDays))
+	}
+
+	return gaps
+}
+
+// isBucketEncrypted returns true if the default encryption of the GCS bucket
+// uses the given Cloud KMS key. Every GCS bucket is encrypted at rest, with
+// Google-managed keys unless a key is set, so any bucket is encrypted when no
+// key is given.
+func isBucketEncrypted(attrs *gstorage.BucketAttrs, kmsKeyName string) bool {
+	if kmsKeyName == "" {
+		return true
+	}
+	return attrs.Encryption != nil && attrs.Encryption.DefaultKMSKeyName == kmsKeyName
+}
+
+// doBackupsExpire returns true if the lifecycle has a rule that deletes
+// backups within the given number of days.
+func doBackupsExpire(lifecycle gstorage.Lifecycle, retentionDays int64) bool {
+	for _, rule := range lifecycle.Rules {
+		if rule.Action.Type != gstorage.DeleteAction {
+			continue
+		}
+		if rule.Condition.AgeInDays < 1 || rule.Condition.AgeInDays > retentionDays {
+			continue
+		}
+		// The rule must cover the backups/ prefix that Velero writes to
+		if len(rule.Condition.MatchesPrefix) == 0 {
+			return true
+		}
+		for _, prefix := range rule.Condition.MatchesPrefix {
+			if strings.HasPrefix(backupsPrefix, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getClusterMarker reads the marker object that identifies the cluster owning
+// the bucket. nil is returned when the bucket doesn't have a marker, and a
+// denied read returns an error wrapping storageBase.ErrClusterMarkerAccessDenied.
+func (d *driver) getClusterMarker(gcsClient stiface.Client, bucketName string) (*storageBase.ClusterMarker, error) {
+	reader, err := gcsClient.Bucket(bucketName).Object(storageBase.ClusterMarkerKey).NewReader(d.Context)
+	if err != nil {
+		if err == gstorage.ErrObjectNotExist {
+			return nil, nil
+		}
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: %v", storageBase.ErrClusterMarkerAccessDenied, err)
+		}
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return storageBase.ParseClusterMarker(data)
+}
+
+// putClusterMarker writes the marker object that identifies the cluster
+// owning the bucket.
+func (d *driver) putClusterMarker(gcsClient stiface.Client, bucketName string, marker *storageBase.ClusterMarker) error {
+	data, err := marker.Marshal()
+	if err != nil {
+		return err
+	}
+	writer := gcsClient.Bucket(bucketName).Object(storageBase.ClusterMarkerKey).NewWriter(d.Context)
+	if _, err = writer.Write(data); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// ownedBuckets returns the buckets whose marker object shows that they were
+// provisioned by the cluster, or the buckets without a marker when the first
+// of them was adopted. See storageBase.OwnedBuckets.
+func (d *driver) ownedBuckets(log logr.Logger, gcsClient stiface.Client, bucketNames []string, marker *storageBase.ClusterMarker) ([]string, bool, error) {
+	return storageBase.OwnedBuckets(log, bucketNames, marker,
+		func(bucketName string) (*storageBase.ClusterMarker, error) {
+			return d.getClusterMarker(gcsClient, bucketName)
+		},
+		func(bucketName string, marker *storageBase.ClusterMarker) error {
+			return d.putClusterMarker(gcsClient, bucketName, marker)
+		})
+}
+
+// findVeleroBucket looks through the Labels for all GCS buckets and determines if
 // any of the buckets are tagged for velero updates for the cluster.
-// If matching tags are found, the bucket name is returned.
-func (d *driver) findVeleroBucket(buckets []*gstorage.BucketAttrs) string {
+// The names of the matching buckets are returned, newest first.
+func (d *driver) findVeleroBucket(buckets []*gstorage.BucketAttrs) []string {
+	var candidates []storageBase.BucketCandidate
 	for _, bucket := range buckets {
 		tagMatchesCluster := false
 		tagMatchesVelero := false
@@ -73,24 +500,50 @@ func (d *driver) findVeleroBucket(buckets []*gstorage.BucketAttrs) string {
 		}
 
 		if tagMatchesCluster && tagMatchesVelero {
-			return bucket.Name
+			candidates = append(candidates, storageBase.BucketCandidate{
+				Name:         bucket.Name,
+				CreationDate: bucket.Created,
+			})
 		}
 	}
-
-	// No matching buckets found.
-	return ""
+	return storageBase.RankBucketCandidates(candidates)
 }
 
+// sanitizeBucketLabel converts a string into a valid label value, which may
+// only hold up to 63 lowercase letters, digits, dashes and underscores.
+// https://cloud.google.com/storage/docs/key-terms#bucket-labels
 func sanitizeBucketLabel(input string) string {
-	// https://cloud.google.com/storage/docs/key-terms#bucket-labels
-	allowedRegEx := regexp.MustCompile("[^a-z0-9-_]+")
+	label := invalidLabelChars.ReplaceAllString(strings.ToLower(input), "-")
+	if len(label) > maxLabelLength {
+		label = label[:maxLabelLength]
+	}
+	return label
+}
 
-	return allowedRegEx.ReplaceAllString(strings.ToLower(input), "-")
+// sanitizeBucketLabelKey converts a string into a valid label key, which must
+// also start with a lowercase letter.
+func sanitizeBucketLabelKey(input string) string {
+	key := sanitizeBucketLabel(input)
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		key = sanitizeBucketLabel("label-" + key)
+	}
+	return key
 }
 
-func buildLabelMap(infraName string) map[string]string {
-	return map[string]string{
-		sanitizeBucketLabel(storageConstants.BucketTagBackupStorageLocation): sanitizeBucketLabel(storageConstants.DefaultVeleroBackupStorageLocation),
-		sanitizeBucketLabel(storageConstants.BucketTagInfrastructureName):    sanitizeBucketLabel(infraName),
+// SanitizeLabels converts user tags into a set of valid GCP labels.
+func SanitizeLabels(tags map[string]string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for k, v := range tags {
+		labels[sanitizeBucketLabelKey(k)] = sanitizeBucketLabel(v)
 	}
+	return labels
+}
+
+// buildLabelMap builds the sanitized set of labels for a velero bucket. Any extra
+// labels are included, but cannot override the velero labels.
+func buildLabelMap(infraName string, extraLabels map[string]string) map[string]string {
+	labels := SanitizeLabels(extraLabels)
+	labels[sanitizeBucketLabel(storageConstants.BucketTagBackupStorageLocation)] = sanitizeBucketLabel(storageConstants.DefaultVeleroBackupStorageLocation)
+	labels[sanitizeBucketLabel(storageConstants.BucketTagInfrastructureName)] = sanitizeBucketLabel(infraName)
+	return labels
 }
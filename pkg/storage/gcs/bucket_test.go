@@ -4,10 +4,21 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/go-logr/logr"
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	configv1 "github.com/openshift/api/config/v1"
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+	"google.golang.org/api/iterator"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	fakekubeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -29,21 +40,515 @@ func TestCreateBucket(t *testing.T) {
 	}
 	drv.Context = ctx
 	drv.KubeClient = fakekubeclient.NewClientBuilder().WithRuntimeObjects(localObjects...).Build()
-	err := drv.createBucket(fakeGClient, "dummy-bucket-name")
+	err := drv.createBucket(fakeGClient, "dummy-bucket-name", "", nil, "", false)
 	if err != nil {
 		t.Errorf("CreateBucket() Error: %v", err)
 	}
 
 }
 
+func TestDeleteBucket(t *testing.T) {
+	drv := &driver{
+		Config: &GCS{
+			Region:    "us-east1",
+			Project:   "dummy-project-id",
+			InfraName: "dummy-infra",
+		},
+	}
+	drv.Context = context.Background()
+	fakeGClient := newFakeClient()
+
+	if err := drv.createBucket(fakeGClient, "dummy-bucket-name", "", nil, "", false); err != nil {
+		t.Fatalf("createBucket() Error: %v", err)
+	}
+	for _, name := range []string{"backups/a/velero-backup.json", "backups/b/velero-backup.json"} {
+		w := fakeGClient.Bucket("dummy-bucket-name").Object(name).NewWriter(drv.Context)
+		if _, err := w.Write([]byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := drv.deleteBucket(fakeGClient, "dummy-bucket-name"); err != nil {
+		t.Fatalf("deleteBucket() Error: %v", err)
+	}
+	if _, err := fakeGClient.Bucket("dummy-bucket-name").Attrs(drv.Context); err == nil {
+		t.Errorf("deleteBucket() did not delete the bucket")
+	}
+
+	// Deleting a bucket that is already gone succeeds
+	if err := drv.deleteBucket(fakeGClient, "dummy-bucket-name"); err != nil {
+		t.Errorf("deleteBucket() Error on a deleted bucket: %v", err)
+	}
+}
+
+func TestIsBucketInProject(t *testing.T) {
+	drv := &driver{
+		Config: &GCS{
+			Region:    "us-east1",
+			Project:   "dummy-project-id",
+			InfraName: "dummy-infra",
+		},
+	}
+	drv.Context = context.Background()
+	fakeGClient := newFakeClient()
+
+	for _, name := range []string{"dummy-bucket-name", "dummy-bucket-name-2"} {
+		if err := drv.createBucket(fakeGClient, name, "", nil, "", false); err != nil {
+			t.Fatalf("createBucket() Error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		bucketName string
+		project    string
+		want       bool
+	}{
+		{
+			name:       "bucket in project",
+			bucketName: "dummy-bucket-name",
+			project:    "dummy-project-id",
+			want:       true,
+		},
+		{
+			name:       "bucket in another project",
+			bucketName: "dummy-bucket-name",
+			project:    "other-project-id",
+		},
+		{
+			name:       "only a bucket with a longer name",
+			bucketName: "dummy-bucket",
+			project:    "dummy-project-id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := drv.isBucketInProject(fakeGClient, tt.bucketName, tt.project)
+			if err != nil {
+				t.Fatalf("isBucketInProject() Error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isBucketInProject() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindBucketPolicyGaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		attrs    *storage.BucketAttrs
+		wantGaps int
+	}{
+		{
+			name:     "bucket without any policy",
+			attrs:    &storage.BucketAttrs{},
+			wantGaps: 4,
+		},
+		{
+			name: "bucket meeting the policy",
+			attrs: &storage.BucketAttrs{
+				UniformBucketLevelAccess: UniformBucketLevelAccessEnabled,
+				PublicAccessPrevention:   storage.PublicAccessPreventionEnforced,
+				VersioningEnabled:        true,
+				Lifecycle: storage.Lifecycle{
+					Rules: []storage.LifecycleRule{
+						{
+							Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+							Condition: storage.LifecycleCondition{AgeInDays: 30, MatchesPrefix: []string{"backups/"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "lifecycle rule for another prefix",
+			attrs: &storage.BucketAttrs{
+				UniformBucketLevelAccess: UniformBucketLevelAccessEnabled,
+				PublicAccessPrevention:   storage.PublicAccessPreventionEnforced,
+				VersioningEnabled:        true,
+				Lifecycle: storage.Lifecycle{
+					Rules: []storage.LifecycleRule{
+						{
+							Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+							Condition: storage.LifecycleCondition{AgeInDays: 30, MatchesPrefix: []string{"restores/"}},
+						},
+					},
+				},
+			},
+			wantGaps: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gaps := findBucketPolicyGaps(tt.attrs, 90, "")
+			if len(gaps) != tt.wantGaps {
+				t.Errorf("findBucketPolicyGaps() = %v, want %d gaps", gaps, tt.wantGaps)
+			}
+		})
+	}
+}
+
+func TestEnforceBucketPolicy(t *testing.T) {
+	drv := &driver{
+		Config: &GCS{
+			Region:    "us-east1",
+			Project:   "dummy-project-id",
+			InfraName: "dummy-infra",
+		},
+	}
+	drv.Context = context.Background()
+	fakeGClient := newFakeClient()
+
+	// A bucket that has drifted from the policy since it was created
+	err := fakeGClient.Bucket("dummy-bucket-name").Create(drv.Context, "dummy-project-id", &storage.BucketAttrs{
+		PublicAccessPrevention: storage.PublicAccessPreventionInherited,
+		Lifecycle: storage.Lifecycle{
+			Rules: []storage.LifecycleRule{
+				{
+					Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+					Condition: storage.LifecycleCondition{AgeInDays: 365, MatchesPrefix: []string{"backups/"}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.verifyBucketPolicy(fakeGClient, "dummy-bucket-name", 90, ""); err == nil {
+		t.Errorf("verifyBucketPolicy() found no gaps before the policy was enforced")
+	}
+
+	if err := drv.blockBucketPublicAccess(fakeGClient, "dummy-bucket-name"); err != nil {
+		t.Fatalf("blockBucketPublicAccess() Error: %v", err)
+	}
+	if err := drv.enableBucketVersioning(fakeGClient, "dummy-bucket-name"); err != nil {
+		t.Fatalf("enableBucketVersioning() Error: %v", err)
+	}
+	if err := drv.setBucketLifecycle(fakeGClient, "dummy-bucket-name", 90, 30); err != nil {
+		t.Fatalf("setBucketLifecycle() Error: %v", err)
+	}
+	if err := drv.verifyBucketPolicy(fakeGClient, "dummy-bucket-name", 90, ""); err != nil {
+		t.Errorf("verifyBucketPolicy() Error after the policy was enforced: %v", err)
+	}
+
+	attrs, _ := fakeGClient.Bucket("dummy-bucket-name").Attrs(drv.Context)
+	if got := attrs.Lifecycle.Rules[0].Condition.AgeInDays; got != 90 {
+		t.Errorf("setBucketLifecycle() deletes backups after %d days, want %d", got, 90)
+	}
+	if got := attrs.Lifecycle.Rules[1].Condition.DaysSinceNoncurrentTime; got != 30 {
+		t.Errorf("setBucketLifecycle() deletes noncurrent versions after %d days, want %d", got, 30)
+	}
+
+	// Enforcing the policy again leaves a compliant bucket untouched
+	bkt := fakeGClient.(*fakeClient).buckets["dummy-bucket-name"]
+	updates := bkt.updates
+	if err := drv.blockBucketPublicAccess(fakeGClient, "dummy-bucket-name"); err != nil {
+		t.Fatalf("blockBucketPublicAccess() Error: %v", err)
+	}
+	if err := drv.enableBucketVersioning(fakeGClient, "dummy-bucket-name"); err != nil {
+		t.Fatalf("enableBucketVersioning() Error: %v", err)
+	}
+	if err := drv.setBucketLifecycle(fakeGClient, "dummy-bucket-name", 90, 30); err != nil {
+		t.Fatalf("setBucketLifecycle() Error: %v", err)
+	}
+	if bkt.updates != updates {
+		t.Errorf("enforcing the policy on a compliant bucket made %d updates, want 0", bkt.updates-updates)
+	}
+}
+
+func TestEncryptBucket(t *testing.T) {
+	drv := &driver{
+		Config: &GCS{
+			Region:    "us-east1",
+			Project:   "dummy-project-id",
+			InfraName: "dummy-infra",
+		},
+	}
+	drv.Context = context.Background()
+	fakeGClient := newFakeClient()
+	kmsKeyName := "projects/dummy-project-id/locations/us-east1/keyRings/velero/cryptoKeys/backups"
+
+	if err := drv.createBucket(fakeGClient, "dummy-bucket-name", "", nil, "", false); err != nil {
+		t.Fatalf("createBucket() Error: %v", err)
+	}
+	attrs, _ := fakeGClient.Bucket("dummy-bucket-name").Attrs(drv.Context)
+	if gaps := findBucketPolicyGaps(attrs, 90, kmsKeyName); len(gaps) == 0 {
+		t.Errorf("findBucketPolicyGaps() found no gaps in a bucket without the key")
+	}
+
+	if err := drv.encryptBucket(fakeGClient, "dummy-bucket-name", kmsKeyName); err != nil {
+		t.Fatalf("encryptBucket() Error: %v", err)
+	}
+	attrs, _ = fakeGClient.Bucket("dummy-bucket-name").Attrs(drv.Context)
+	if attrs.Encryption == nil || attrs.Encryption.DefaultKMSKeyName != kmsKeyName {
+		t.Errorf("encryptBucket() default key = %v, want %v", attrs.Encryption, kmsKeyName)
+	}
+
+	// A new bucket is created with the key
+	if err := drv.createBucket(fakeGClient, "dummy-bucket-name-2", kmsKeyName, nil, "", false); err != nil {
+		t.Fatalf("createBucket() Error: %v", err)
+	}
+	attrs, _ = fakeGClient.Bucket("dummy-bucket-name-2").Attrs(drv.Context)
+	if !isBucketEncrypted(attrs, kmsKeyName) {
+		t.Errorf("createBucket() did not set the default key %v", kmsKeyName)
+	}
+}
+
+func TestDualRegionLocation(t *testing.T) {
+	tests := []struct {
+		region        string
+		replicaRegion string
+		want          string
+		wantErr       bool
+	}{
+		{region: "us-east1", replicaRegion: "us-central1", want: "US"},
+		{region: "europe-west1", replicaRegion: "europe-north1", want: "EU"},
+		{region: "asia-northeast1", replicaRegion: "asia-northeast2", want: "ASIA"},
+		{region: "us-east1", replicaRegion: "europe-west1", wantErr: true},
+		{region: "australia-southeast1", replicaRegion: "australia-southeast2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region+"/"+tt.replicaRegion, func(t *testing.T) {
+			got, err := dualRegionLocation(tt.region, tt.replicaRegion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dualRegionLocation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("dualRegionLocation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforceBucketReplication(t *testing.T) {
+	drv := &driver{
+		Config: &GCS{
+			Region:    "us-east1",
+			Project:   "dummy-project-id",
+			InfraName: "dummy-infra",
+		},
+	}
+	drv.Context = context.Background()
+	fakeGClient := newFakeClient()
+
+	if err := drv.createBucket(fakeGClient, "dummy-bucket-name", "", nil, "", false); err != nil {
+		t.Fatalf("createBucket() Error: %v", err)
+	}
+	if err := drv.enforceBucketReplication(fakeGClient, "dummy-bucket-name", "us-central1", false); err == nil {
+		t.Errorf("enforceBucketReplication() succeeded on a single-region bucket")
+	}
+
+	if err := drv.createBucket(fakeGClient, "dummy-bucket-name-2", "", nil, "us-central1", false); err != nil {
+		t.Fatalf("createBucket() Error: %v", err)
+	}
+	attrs, _ := fakeGClient.Bucket("dummy-bucket-name-2").Attrs(drv.Context)
+	if attrs.Location != "US" || !isDualRegionBucket(attrs, "us-east1", "us-central1") {
+		t.Errorf("createBucket() location = %v, placement = %v, want a dual-region bucket", attrs.Location, attrs.CustomPlacementConfig)
+	}
+	if attrs.RPO != storage.RPODefault {
+		t.Errorf("createBucket() RPO = %v, want %v", attrs.RPO, storage.RPODefault)
+	}
+
+	if err := drv.enforceBucketReplication(fakeGClient, "dummy-bucket-name-2", "us-central1", true); err != nil {
+		t.Fatalf("enforceBucketReplication() Error: %v", err)
+	}
+	attrs, _ = fakeGClient.Bucket("dummy-bucket-name-2").Attrs(drv.Context)
+	if attrs.RPO != storage.RPOAsyncTurbo {
+		t.Errorf("enforceBucketReplication() RPO = %v, want %v", attrs.RPO, storage.RPOAsyncTurbo)
+	}
+
+	if err := drv.enforceBucketReplication(fakeGClient, "dummy-bucket-name-2", "us-west1", true); err == nil {
+		t.Errorf("enforceBucketReplication() succeeded on a bucket spanning another region")
+	}
+}
+
+func TestEnforceBucketLabels(t *testing.T) {
+	drv := &driver{
+		Config: &GCS{
+			Region:    "us-east1",
+			Project:   "dummy-project-id",
+			InfraName: "dummy-infra",
+		},
+	}
+	drv.Context = context.Background()
+	fakeGClient := newFakeClient()
+	extraLabels := map[string]string{"cost-center": "1234"}
+
+	if err := drv.createBucket(fakeGClient, "dummy-bucket-name", "", extraLabels, "", false); err != nil {
+		t.Fatalf("createBucket() Error: %v", err)
+	}
+	bkt := fakeGClient.(*fakeClient).buckets["dummy-bucket-name"]
+
+	// Labels added by anything else are left alone
+	bkt.attrs.Labels["finance-owner"] = "team-a"
+	if err := drv.enforceBucketLabels(fakeGClient, "dummy-bucket-name", extraLabels); err != nil {
+		t.Fatalf("enforceBucketLabels() Error: %v", err)
+	}
+	if bkt.updates != 0 {
+		t.Errorf("enforceBucketLabels() made %d updates to a bucket with the expected labels, want 0", bkt.updates)
+	}
+
+	// A stale velero label is removed
+	bkt.attrs.Labels["velero-io-orphaned-at"] = "2021-03-04t05-06-07z"
+	if err := drv.enforceBucketLabels(fakeGClient, "dummy-bucket-name", extraLabels); err != nil {
+		t.Fatalf("enforceBucketLabels() Error: %v", err)
+	}
+	if bkt.updates != 1 {
+		t.Errorf("enforceBucketLabels() made %d updates to a bucket with a stale velero label, want 1", bkt.updates)
+	}
+}
+
+func TestResourceLabels(t *testing.T) {
+	infra := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "config.openshift.io/v1",
+		"kind":       "Infrastructure",
+		"metadata":   map[string]interface{}{"name": "cluster"},
+		"status": map[string]interface{}{
+			"platformStatus": map[string]interface{}{
+				"type": "GCP",
+				"gcp": map[string]interface{}{
+					"resourceLabels": []interface{}{
+						map[string]interface{}{"key": "cost-center", "value": "1234"},
+						map[string]interface{}{"key": "environment", "value": "production"},
+					},
+				},
+			},
+		},
+	}}
+	kubeClient := fakekubeclient.NewClientBuilder().WithObjects(infra).Build()
+
+	got, err := ResourceLabels(context.Background(), kubeClient)
+	if err != nil {
+		t.Fatalf("ResourceLabels() Error: %v", err)
+	}
+	want := map[string]string{"cost-center": "1234", "environment": "production"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResourceLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestFindVeleroBucket(t *testing.T) {
+	drv := &driver{Config: &GCS{InfraName: "dummy-infra"}}
+	matchingLabels := buildLabelMap("dummy-infra", nil)
+	buckets := []*storage.BucketAttrs{
+		{Name: "older", Labels: matchingLabels, Created: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "other-cluster", Labels: buildLabelMap("other-infra", nil), Created: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "cluster-only", Labels: map[string]string{"velero-io-infrastructurename": "dummy-infra"}, Created: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "newer", Labels: matchingLabels, Created: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	got := drv.findVeleroBucket(buckets)
+	want := []string{"newer", "older"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findVeleroBucket() = %v, want %v", got, want)
+	}
+}
+
+func TestClusterMarker(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := configv1.Install(s); err != nil {
+		t.Fatal(err)
+	}
+	drv := &driver{Config: &GCS{InfraName: "dummy-infra"}}
+	drv.Context = context.Background()
+	drv.KubeClient = fakekubeclient.NewClientBuilder().WithScheme(s).WithObjects(&configv1.ClusterVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "version"},
+		Spec:       configv1.ClusterVersionSpec{ClusterID: "dummy-cluster-id"},
+	}).Build()
+	fakeGClient := newFakeClient()
+	for _, bucketName := range []string{"owned", "unmarked", "other-cluster"} {
+		if err := drv.createBucket(fakeGClient, bucketName, "", nil, "", false); err != nil {
+			t.Fatalf("createBucket() Error: %v", err)
+		}
+	}
+
+	marker, err := drv.ClusterMarker("dummy-infra")
+	if err != nil {
+		t.Fatalf("ClusterMarker() Error: %v", err)
+	}
+	if err := drv.putClusterMarker(fakeGClient, "owned", marker); err != nil {
+		t.Fatalf("putClusterMarker() Error: %v", err)
+	}
+	if err := drv.putClusterMarker(fakeGClient, "other-cluster", &storageBase.ClusterMarker{InfraName: "dummy-infra", ClusterID: "other-cluster-id"}); err != nil {
+		t.Fatalf("putClusterMarker() Error: %v", err)
+	}
+
+	owned, adopted, err := drv.ownedBuckets(logr.Discard(), fakeGClient, []string{"owned", "unmarked", "other-cluster"}, marker)
+	if err != nil {
+		t.Fatalf("ownedBuckets() Error: %v", err)
+	}
+	if want := []string{"owned"}; !reflect.DeepEqual(owned, want) || adopted {
+		t.Errorf("ownedBuckets() = %v, %v, want %v, false", owned, adopted, want)
+	}
+
+	// A marker is written into a bucket without one, and another cluster's
+	// bucket is rejected
+	instance := &veleroInstallCR.VeleroInstall{}
+	instance.Status.StorageBucket.Name = "unmarked"
+	if err := drv.ensureClusterMarker(fakeGClient, instance); err != nil {
+		t.Fatalf("ensureClusterMarker() Error: %v", err)
+	}
+	if found, _ := drv.getClusterMarker(fakeGClient, "unmarked"); !marker.Owns(found) {
+		t.Errorf("ensureClusterMarker() wrote marker %+v, want %+v", found, marker)
+	}
+	instance.Status.StorageBucket.Name = "other-cluster"
+	if err := drv.ensureClusterMarker(fakeGClient, instance); err == nil {
+		t.Errorf("ensureClusterMarker() accepted the bucket of another cluster")
+	}
+	if !meta.IsStatusConditionFalse(instance.Status.Conditions, veleroInstallCR.ConditionBucketProvisioned) {
+		t.Errorf("ensureClusterMarker() didn't report the bucket of another cluster")
+	}
+}
+
+func TestBuildLabelMap(t *testing.T) {
+	got := buildLabelMap("Dummy.Infra", map[string]string{
+		"Cost-Center":                  "R&D",
+		"velero.io/infrastructureName": "overridden",
+	})
+	want := map[string]string{
+		"cost-center":                  "r-d",
+		"velero-io-backup-location":    "default",
+		"velero-io-infrastructurename": "dummy-infra",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildLabelMap() = %v, want %v", got, want)
+	}
+}
+
+func TestSanitizeLabels(t *testing.T) {
+	got := SanitizeLabels(map[string]string{
+		"Owner.Email":           "Jane.Doe@Example.com",
+		"2021-budget":           "q1",
+		"_internal":             "",
+		"long-value":            strings.Repeat("a", 70),
+		strings.Repeat("k", 70): "x",
+	})
+	want := map[string]string{
+		"owner-email":           "jane-doe-example-com",
+		"label-2021-budget":     "q1",
+		"label-_internal":       "",
+		"long-value":            strings.Repeat("a", 63),
+		strings.Repeat("k", 63): "x",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SanitizeLabels() = %v, want %v", got, want)
+	}
+}
+
 type fakeClient struct {
 	stiface.Client
 	buckets map[string]*fakeBucket
 }
 
 type fakeBucket struct {
+	project string
 	attrs   *storage.BucketAttrs
 	objects map[string][]byte
+	updates int
 }
 
 func newFakeClient() stiface.Client {
@@ -54,13 +559,46 @@ func (c *fakeClient) Bucket(name string) stiface.BucketHandle {
 	return fakeBucketHandle{c: c, name: name}
 }
 
+func (c *fakeClient) Buckets(_ context.Context, project string) stiface.BucketIterator {
+	return &fakeBucketIterator{c: c, project: project}
+}
+
+type fakeBucketIterator struct {
+	stiface.BucketIterator
+	c       *fakeClient
+	project string
+	prefix  string
+	names   []string
+}
+
+func (it *fakeBucketIterator) SetPrefix(prefix string) {
+	it.prefix = prefix
+}
+
+func (it *fakeBucketIterator) Next() (*storage.BucketAttrs, error) {
+	if it.names == nil {
+		it.names = []string{}
+		for name, bkt := range it.c.buckets {
+			if bkt.project == it.project && strings.HasPrefix(name, it.prefix) {
+				it.names = append(it.names, name)
+			}
+		}
+	}
+	if len(it.names) == 0 {
+		return nil, iterator.Done
+	}
+	name := it.names[0]
+	it.names = it.names[1:]
+	return it.c.buckets[name].attrs, nil
+}
+
 type fakeBucketHandle struct {
 	stiface.BucketHandle
 	c    *fakeClient
 	name string
 }
 
-func (b fakeBucketHandle) Create(_ context.Context, _ string, attrs *storage.BucketAttrs) error {
+func (b fakeBucketHandle) Create(_ context.Context, project string, attrs *storage.BucketAttrs) error {
 	if _, ok := b.c.buckets[b.name]; ok {
 		return fmt.Errorf("bucket %q already exists", b.name)
 	}
@@ -68,7 +606,7 @@ func (b fakeBucketHandle) Create(_ context.Context, _ string, attrs *storage.Buc
 		attrs = &storage.BucketAttrs{}
 	}
 	attrs.Name = b.name
-	b.c.buckets[b.name] = &fakeBucket{attrs: attrs, objects: map[string][]byte{}}
+	b.c.buckets[b.name] = &fakeBucket{project: project, attrs: attrs, objects: map[string][]byte{}}
 	return nil
 }
 
@@ -80,6 +618,79 @@ func (b fakeBucketHandle) Attrs(context.Context) (*storage.BucketAttrs, error) {
 	return bkt.attrs, nil
 }
 
+func (b fakeBucketHandle) Update(_ context.Context, uattrs storage.BucketAttrsToUpdate) (*storage.BucketAttrs, error) {
+	bkt, ok := b.c.buckets[b.name]
+	if !ok {
+		return nil, storage.ErrBucketNotExist
+	}
+	// Labels can't be read back from BucketAttrsToUpdate, so only the policy
+	// settings are applied
+	attrs := *bkt.attrs
+	if uattrs.UniformBucketLevelAccess != nil {
+		attrs.UniformBucketLevelAccess = *uattrs.UniformBucketLevelAccess
+	}
+	if uattrs.PublicAccessPrevention != storage.PublicAccessPreventionUnknown {
+		attrs.PublicAccessPrevention = uattrs.PublicAccessPrevention
+	}
+	if uattrs.Lifecycle != nil {
+		attrs.Lifecycle = *uattrs.Lifecycle
+	}
+	if uattrs.Encryption != nil {
+		attrs.Encryption = uattrs.Encryption
+	}
+	if enabled, ok := uattrs.VersioningEnabled.(bool); ok {
+		attrs.VersioningEnabled = enabled
+	}
+	if uattrs.RPO != storage.RPOUnknown {
+		attrs.RPO = uattrs.RPO
+	}
+	bkt.attrs = &attrs
+	bkt.updates++
+	return bkt.attrs, nil
+}
+
+func (b fakeBucketHandle) Delete(context.Context) error {
+	bkt, ok := b.c.buckets[b.name]
+	if !ok {
+		return storage.ErrBucketNotExist
+	}
+	if len(bkt.objects) > 0 {
+		return fmt.Errorf("bucket %q is not empty", b.name)
+	}
+	delete(b.c.buckets, b.name)
+	return nil
+}
+
+func (b fakeBucketHandle) Objects(context.Context, *storage.Query) stiface.ObjectIterator {
+	bkt, ok := b.c.buckets[b.name]
+	if !ok {
+		return &fakeObjectIterator{err: storage.ErrBucketNotExist}
+	}
+	it := &fakeObjectIterator{}
+	for name := range bkt.objects {
+		it.objects = append(it.objects, &storage.ObjectAttrs{Bucket: b.name, Name: name, Generation: 1})
+	}
+	return it
+}
+
+type fakeObjectIterator struct {
+	stiface.ObjectIterator
+	objects []*storage.ObjectAttrs
+	err     error
+}
+
+func (it *fakeObjectIterator) Next() (*storage.ObjectAttrs, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if len(it.objects) == 0 {
+		return nil, iterator.Done
+	}
+	object := it.objects[0]
+	it.objects = it.objects[1:]
+	return object, nil
+}
+
 func (b fakeBucketHandle) Object(name string) stiface.ObjectHandle {
 	return fakeObjectHandle{c: b.c, bucketName: b.name, name: name}
 }
@@ -91,6 +702,10 @@ type fakeObjectHandle struct {
 	name       string
 }
 
+func (o fakeObjectHandle) Generation(int64) stiface.ObjectHandle {
+	return o
+}
+
 func (o fakeObjectHandle) NewReader(context.Context) (stiface.Reader, error) {
 	bkt, ok := o.c.buckets[o.bucketName]
 	if !ok {
@@ -98,7 +713,7 @@ func (o fakeObjectHandle) NewReader(context.Context) (stiface.Reader, error) {
 	}
 	contents, ok := bkt.objects[o.name]
 	if !ok {
-		return nil, fmt.Errorf("object %q not found in bucket %q", o.name, o.bucketName)
+		return nil, storage.ErrObjectNotExist
 	}
 	return fakeReader{r: bytes.NewReader(contents)}, nil
 }
@@ -2,14 +2,13 @@ package gcs
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
-	"github.com/openshift/managed-velero-operator/config"
-	"github.com/openshift/managed-velero-operator/version"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	gstorage "cloud.google.com/go/storage"
@@ -18,31 +17,41 @@ import (
 )
 
 var (
-	storageCredsSecretName = version.OperatorName + "-iam-credentials"
+	storageCredsSecretName = storageBase.CredentialsSecretName
 )
 
 // NewGcsClient reads the gcp secrets in the operator's namespace and uses
-// them to create a new client for accessing the GCS API.
-func NewGcsClient(kubeClient client.Client) (stiface.Client, error) {
-	var err error
-
-	namespace := config.OperatorNamespace
-	secret := &corev1.Secret{}
-	err = kubeClient.Get(context.TODO(),
-		types.NamespacedName{
-			Name:      storageCredsSecretName,
-			Namespace: namespace,
-		},
-		secret)
+// them to create a new client for accessing the GCS API. The client is reused
+// from the cache until the secret changes.
+func NewGcsClient(clients *storageBase.ClientCache, kubeClient client.Client) (stiface.Client, error) {
+	secret, err := storageBase.GetCredentialsSecret(kubeClient, storageCredsSecretName)
 	if err != nil {
 		return nil, err
 	}
+	gcsClient, err := clients.Get(storageCredsSecretName, secret, func(secret *corev1.Secret) (interface{}, error) {
+		return newGcsClient(secret)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gcsClient.(stiface.Client), nil
+}
+
+// newGcsClient uses the gcp secret to create a new client for accessing the GCS API.
+func newGcsClient(secret *corev1.Secret) (stiface.Client, error) {
+	namespace := secret.Namespace
 	keyFileData, ok := secret.Data["service_account.json"]
 	if !ok {
 		return nil, fmt.Errorf("secret %q does not contain required key \"service_account.json\"", fmt.Sprintf("%s/%s", namespace, storageCredsSecretName))
 	}
 
-	credentials, err := goauth2.CredentialsFromJSON(context.TODO(), []byte(string(keyFileData)), gstorage.ScopeFullControl)
+	// The key is either a service account key, or on clusters using Workload
+	// Identity Federation, an external account config for the projected token
+	credentialsType, err := keyFileCredentialsType(keyFileData)
+	if err != nil {
+		return nil, fmt.Errorf("secret %q key \"service_account.json\": %v", fmt.Sprintf("%s/%s", namespace, storageCredsSecretName), err)
+	}
+	credentials, err := goauth2.CredentialsFromJSONWithType(context.TODO(), keyFileData, credentialsType, gstorage.ScopeFullControl)
 	if err != nil {
 		return nil, err
 	}
@@ -54,3 +63,20 @@ func NewGcsClient(kubeClient client.Client) (stiface.Client, error) {
 
 	return stiface.AdaptClient(gcsClient), nil
 }
+
+// keyFileCredentialsType returns the type of the credentials in keyFileData,
+// which must be a service account key or an external account config.
+func keyFileCredentialsType(keyFileData []byte) (goauth2.CredentialsType, error) {
+	var keyFile struct {
+		Type goauth2.CredentialsType `json:"type"`
+	}
+	if err := json.Unmarshal(keyFileData, &keyFile); err != nil {
+		return "", err
+	}
+	switch keyFile.Type {
+	case goauth2.ServiceAccount, goauth2.ExternalAccount:
+		return keyFile.Type, nil
+	default:
+		return "", fmt.Errorf("unsupported credentials type %q", keyFile.Type)
+	}
+}
@@ -0,0 +1,55 @@
+package gcs
+
+import (
+	"testing"
+
+	goauth2 "golang.org/x/oauth2/google"
+)
+
+func TestKeyFileCredentialsType(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyFile string
+		want    goauth2.CredentialsType
+		wantErr bool
+	}{
+		{
+			name:    "service account key",
+			keyFile: `{"type": "service_account", "project_id": "my-project", "client_email": "velero@my-project.iam.gserviceaccount.com"}`,
+			want:    goauth2.ServiceAccount,
+		},
+		{
+			name: "external account config for Workload Identity Federation",
+			keyFile: `{
+				"type": "external_account",
+				"audience": "//iam.googleapis.com/projects/123456789/locations/global/workloadIdentityPools/my-pool/providers/my-provider",
+				"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+				"token_url": "https://sts.googleapis.com/v1/token",
+				"service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/velero@my-project.iam.gserviceaccount.com:generateAccessToken",
+				"credential_source": {"file": "/var/run/secrets/openshift/serviceaccount/token", "format": {"type": "text"}}
+			}`,
+			want: goauth2.ExternalAccount,
+		},
+		{
+			name:    "user credentials are not supported",
+			keyFile: `{"type": "authorized_user"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not a key file",
+			keyFile: `not json`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keyFileCredentialsType([]byte(tt.keyFile))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("keyFileCredentialsType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("keyFileCredentialsType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
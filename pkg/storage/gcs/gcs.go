@@ -3,18 +3,21 @@ package gcs
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	gstorage "cloud.google.com/go/storage"
 
 	"github.com/go-logr/logr"
 	"github.com/google/uuid"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
 	configv1 "github.com/openshift/api/config/v1"
 	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
 	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
-	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -31,7 +34,7 @@ type driver struct {
 
 // NewDriver creates a new gcs storage driver
 // Used during bootstrapping
-func NewDriver(ctx context.Context, cfg *configv1.InfrastructureStatus, clnt client.Client) *driver {
+func NewDriver(ctx context.Context, cfg *configv1.InfrastructureStatus, clnt client.Client, recorder record.EventRecorder) *driver {
 	drv := driver{
 		Config: &GCS{
 			Region:    cfg.PlatformStatus.GCP.Region,
@@ -41,6 +44,7 @@ func NewDriver(ctx context.Context, cfg *configv1.InfrastructureStatus, clnt cli
 	}
 	drv.Context = ctx
 	drv.KubeClient = clnt
+	drv.Recorder = recorder
 	return &drv
 }
 
@@ -53,16 +57,65 @@ func (d *driver) GetPlatformType() configv1.PlatformType {
 func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
 	var err error
 
+	// GCS has no default KMS key, so a key is required to use one
+	if instance.Spec.Storage.GetEncryptionMode() == veleroInstallCR.StorageEncryptionModeKMS && instance.Spec.Storage.GetKMSKeyID() == "" {
+		err = fmt.Errorf("encryption mode %v requires a kmsKeyID for GCS buckets", instance.Spec.Storage.GetEncryptionMode())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+	kmsKeyName := instance.Spec.Storage.GetKMSKeyID()
+
+	// Immutability relies on S3 Object Lock
+	if instance.Spec.Storage.Immutability != nil {
+		err = fmt.Errorf("spec.storage.immutability is not supported for GCS buckets")
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+
+	existingBucket := instance.Spec.Storage.ExistingBucket
+
+	// Replication relies on the placement of a dual-region bucket, which is
+	// only set when the operator creates the bucket
+	replication := instance.Spec.Storage.Replication
+	if replication != nil {
+		if existingBucket != nil && !existingBucket.Manage {
+			err = fmt.Errorf("spec.storage.replication is not supported for an unmanaged existing bucket")
+		} else {
+			_, err = dualRegionLocation(d.Config.Region, replication.Region)
+		}
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+			return err
+		}
+	} else {
+		instance.RemoveCondition(veleroInstallCR.ConditionReplicationReady)
+	}
+
 	// Create a GCS client
-	gcsClient, err := NewGcsClient(d.KubeClient)
+	gcsClient, err := NewGcsClient(&d.Clients, d.KubeClient)
 	if err != nil {
 		return err
 	}
 
+	// Label the bucket with the cluster's user labels, as well as any set on the instance
+	resourceLabels, err := ResourceLabels(d.Context, d.KubeClient)
+	if err != nil {
+		return fmt.Errorf("unable to read the cluster's resource labels: %v", err)
+	}
+	extraLabels := storageBase.ExtraBucketTags(resourceLabels, instance)
+
 	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", d.Config.Region)
 
 	// This switch handles the provisioning steps/checks
 	switch {
+	// An existing bucket was provided, so we never create one
+	case existingBucket != nil:
+		if instance.Status.StorageBucket.Name != existingBucket.Name {
+			bucketLog.Info("Using existing GCS bucket", "StorageBucket.Name", existingBucket.Name)
+			instance.Status.StorageBucket.Name = existingBucket.Name
+			instance.Status.StorageBucket.Provisioned = false
+		}
+
 	// We don't yet have a bucket name selected
 	case instance.Status.StorageBucket.Name == "":
 
@@ -73,16 +126,28 @@ func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.
 			return err
 		}
 
-		existingBucket := d.findVeleroBucket(bucketlist)
-		if existingBucket != "" {
-			bucketLog.Info("Recovered existing bucket", "StorageBucket.Name", existingBucket)
-			instance.Status.StorageBucket.Name = existingBucket
-			instance.Status.StorageBucket.Provisioned = true
+		// Only recover a bucket whose marker shows it was provisioned by this cluster
+		marker, err := d.ClusterMarker(d.Config.InfraName)
+		if err != nil {
+			return err
+		}
+		existingBuckets, adopted, err := d.ownedBuckets(bucketLog, gcsClient, d.findVeleroBucket(bucketlist), marker)
+		if err != nil {
+			return err
+		}
+		if adopted {
+			bucketLog.Info("Adopted existing bucket without a cluster marker", "StorageBucket.Name", existingBuckets[0], "MatchingBuckets", existingBuckets)
+			storageBase.AdoptBucket(instance, existingBuckets)
+			return instance.StatusUpdate(reqLogger, d.KubeClient)
+		}
+		if len(existingBuckets) > 0 {
+			bucketLog.Info("Recovered existing bucket", "StorageBucket.Name", existingBuckets[0], "MatchingBuckets", existingBuckets)
+			storageBase.RecoverBucket(instance, existingBuckets)
 			return instance.StatusUpdate(reqLogger, d.KubeClient)
 		}
 
 		// Prepare to create a new bucket, if none exist.
-		proposedName := generateBucketName(storageConstants.StorageBucketPrefix)
+		proposedName := generateBucketName(instance.Spec.Storage.GetNamePrefix())
 		proposedBucketExists, err := d.StorageExists(proposedName)
 		if err != nil {
 			return err
@@ -93,7 +158,10 @@ func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.
 
 		bucketLog.Info("Setting proposed bucket name", "StorageBucket.Name", proposedName)
 		instance.Status.StorageBucket.Name = proposedName
+		instance.Status.StorageBucket.NamePrefix = instance.Spec.Storage.GetNamePrefix()
 		instance.Status.StorageBucket.Provisioned = false
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNameSelected,
+			fmt.Sprintf("Selected name %v for a new bucket", proposedName))
 		return instance.StatusUpdate(reqLogger, d.KubeClient)
 
 	// We have a bucket name, but haven't kicked off provisioning of the bucket yet
@@ -102,9 +170,16 @@ func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.
 
 		// Create GCS bucket
 		bucketLog.Info("Creating GCS Bucket")
-		err = d.createBucket(gcsClient, instance.Status.StorageBucket.Name)
+		var replicaRegion string
+		var turbo bool
+		if replication != nil {
+			replicaRegion, turbo = replication.Region, replication.Turbo
+		}
+		err = d.createBucket(gcsClient, instance.Status.StorageBucket.Name, kmsKeyName, extraLabels, replicaRegion, turbo)
 		if err != nil {
-			return fmt.Errorf("error occurred when creating bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+			err = fmt.Errorf("error occurred when creating bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed, err.Error())
+			return err
 		}
 	}
 
@@ -112,29 +187,138 @@ func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.
 	bucketLog.Info("Verifing GCS Bucket exists")
 	exists, err := d.StorageExists(instance.Status.StorageBucket.Name)
 	if err != nil {
-		return fmt.Errorf("error occurred when verifying bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		err = fmt.Errorf("error occurred when verifying bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
 	}
 	if !exists {
 		bucketLog.Error(nil, "GCS bucket doesn't appear to exist")
 		instance.Status.StorageBucket.Provisioned = false
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNotFound,
+			fmt.Sprintf("Bucket %v does not exist or is not accessible", instance.Status.StorageBucket.Name))
 		return instance.StatusUpdate(reqLogger, d.KubeClient)
 	}
 
-	//TODO(cblecker): ACL enforcement
+	if existingBucket != nil {
+		// Verify the existing GCS bucket is in the expected project and location
+		bucketLog.Info("Verifying GCS Bucket location")
+		err = d.verifyExistingBucket(gcsClient, existingBucket)
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketLocationMismatch, err.Error())
+			return err
+		}
+	}
+
+	// Verify GCS bucket belongs to this cluster. The marker isn't written
+	// into an unmanaged bucket, which the operator never changes.
+	if existingBucket == nil || existingBucket.Manage {
+		bucketLog.Info("Verifying GCS Bucket cluster marker")
+		if err = d.ensureClusterMarker(gcsClient, instance); err != nil {
+			return err
+		}
+	}
+
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionTrue, veleroInstallCR.ReasonBucketAvailable,
+		fmt.Sprintf("Bucket %v is available", instance.Status.StorageBucket.Name))
 
-	//TODO(cblecker): Lifecycle enforcement
+	if existingBucket != nil && !existingBucket.Manage {
+		// Report, but don't correct, any policy gaps on an unmanaged bucket
+		bucketLog.Info("Checking GCS Bucket policy")
+		attrs, err := gcsClient.Bucket(instance.Status.StorageBucket.Name).Attrs(d.Context)
+		if err != nil {
+			err = fmt.Errorf("unable to read %v bucket attributes: %v", instance.Status.StorageBucket.Name, err)
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyCompliant, metav1.ConditionUnknown, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		storageBase.SetUnmanagedBucketConditions(instance, findBucketPolicyGaps(attrs, instance.Spec.Storage.GetRetentionDays(), kmsKeyName))
+		instance.Status.StorageBucket.VersioningEnabled = attrs.VersioningEnabled
+
+		instance.Status.StorageBucket.Provisioned = true
+		instance.Status.StorageBucket.LastSyncTimestamp = &metav1.Time{
+			Time: time.Now(),
+		}
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+	instance.RemoveCondition(veleroInstallCR.ConditionBucketPolicyCompliant)
+
+	// Encrypt GCS bucket with the customer-managed key
+	if kmsKeyName != "" {
+		bucketLog.Info("Enforcing GCS Bucket encryption")
+		err = d.encryptBucket(gcsClient, instance.Status.StorageBucket.Name, kmsKeyName)
+		if err != nil {
+			err = fmt.Errorf("error occurred when encrypting bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonEncryptionFailed, err.Error())
+			return err
+		}
+	}
+
+	// Block public access to GCS bucket
+	bucketLog.Info("Enforcing GCS Bucket public access policy")
+	err = d.blockBucketPublicAccess(gcsClient, instance.Status.StorageBucket.Name)
+	if err != nil {
+		err = fmt.Errorf("error occurred when blocking public access to bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonPublicAccessBlockFailed, err.Error())
+		return err
+	}
+
+	// Enable versioning on GCS bucket
+	bucketLog.Info("Enforcing GCS Bucket versioning")
+	err = d.enableBucketVersioning(gcsClient, instance.Status.StorageBucket.Name)
+	if err != nil {
+		err = fmt.Errorf("error occurred when enabling versioning on bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonVersioningFailed, err.Error())
+		return err
+	}
+
+	// Configure lifecycle rules on GCS bucket
+	bucketLog.Info("Enforcing GCS Bucket lifecycle rules on GCS Bucket")
+	err = d.setBucketLifecycle(gcsClient, instance.Status.StorageBucket.Name, instance.Spec.Storage.GetRetentionDays(), instance.Spec.Storage.GetNoncurrentVersionRetentionDays())
+	if err != nil {
+		err = fmt.Errorf("error occurred when configuring lifecycle rules on bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonLifecycleFailed, err.Error())
+		return err
+	}
 
 	// Make sure that tags are applied to buckets
 	bucketLog.Info("Enforcing GCS Bucket tags on GCS Bucket")
-	err = d.enforceBucketLabels(gcsClient, instance.Status.StorageBucket.Name)
+	err = d.enforceBucketLabels(gcsClient, instance.Status.StorageBucket.Name, extraLabels)
+	if err != nil {
+		err = fmt.Errorf("error occurred when tagging bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonTaggingFailed, err.Error())
+		return err
+	}
+
+	// Read back the bucket to confirm that the policy took effect
+	bucketLog.Info("Verifying GCS Bucket policy")
+	err = d.verifyBucketPolicy(gcsClient, instance.Status.StorageBucket.Name, instance.Spec.Storage.GetRetentionDays(), kmsKeyName)
 	if err != nil {
-		return fmt.Errorf("error occurred when tagging bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		err = fmt.Errorf("bucket %v does not meet the bucket policy after it was enforced: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
 	}
+	// Versioning is part of the policy that was just verified
+	instance.Status.StorageBucket.VersioningEnabled = true
+	instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionTrue, veleroInstallCR.ReasonPolicyEnforced,
+		"Encryption, public access prevention, versioning, lifecycle and labelling policy is enforced")
 
 	instance.Status.StorageBucket.Provisioned = true
 	instance.Status.StorageBucket.LastSyncTimestamp = &metav1.Time{
 		Time: time.Now(),
 	}
+
+	// Backups are replicated between the regions of a dual-region bucket
+	if replication != nil {
+		bucketLog.Info("Enforcing GCS Bucket replication")
+		err = d.enforceBucketReplication(gcsClient, instance.Status.StorageBucket.Name, replication.Region, replication.Turbo)
+		if err != nil {
+			err = fmt.Errorf("error occurred when configuring replication of bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonReplicationFailed, err.Error())
+			return err
+		}
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionTrue, veleroInstallCR.ReasonReplicationConfigured,
+			fmt.Sprintf("Backups are replicated between regions %v and %v", d.Config.Region, replication.Region))
+	}
+
 	return instance.StatusUpdate(reqLogger, d.KubeClient)
 
 }
@@ -144,7 +328,7 @@ func (d *driver) StorageExists(bucketName string) (bool, error) {
 	var err error
 
 	//create an GCS Client
-	gcsClient, err := NewGcsClient(d.KubeClient)
+	gcsClient, err := NewGcsClient(&d.Clients, d.KubeClient)
 	if err != nil {
 		return false, err
 	}
@@ -161,6 +345,113 @@ func (d *driver) StorageExists(bucketName string) (bool, error) {
 	return true, nil
 }
 
+// DeleteStorage empties and deletes the GCS bucket
+func (d *driver) DeleteStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	gcsClient, err := NewGcsClient(&d.Clients, d.KubeClient)
+	if err != nil {
+		return err
+	}
+
+	reqLogger.Info("Deleting GCS Bucket", "StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", d.Config.Region)
+	return d.deleteBucket(gcsClient, instance.Status.StorageBucket.Name)
+}
+
+// MarkStorageOrphaned labels the GCS bucket with the time it was orphaned
+func (d *driver) MarkStorageOrphaned(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	gcsClient, err := NewGcsClient(&d.Clients, d.KubeClient)
+	if err != nil {
+		return err
+	}
+
+	reqLogger.Info("Labelling GCS Bucket as orphaned", "StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", d.Config.Region)
+	return d.markBucketOrphaned(gcsClient, instance.Status.StorageBucket.Name, time.Now())
+}
+
+// verifyExistingBucket checks that an existing GCS bucket belongs to the
+// expected project and, if a region was given, is in that location.
+func (d *driver) verifyExistingBucket(gcsClient stiface.Client, existingBucket *veleroInstallCR.ExistingBucket) error {
+	project := existingBucket.Project
+	if project == "" {
+		project = d.Config.Project
+	}
+	inProject, err := d.isBucketInProject(gcsClient, existingBucket.Name, project)
+	if err != nil {
+		return fmt.Errorf("unable to list buckets in project %v: %v", project, err)
+	}
+	if !inProject {
+		return fmt.Errorf("bucket %v does not belong to project %v", existingBucket.Name, project)
+	}
+
+	if existingBucket.Region == "" {
+		return nil
+	}
+	attrs, err := gcsClient.Bucket(existingBucket.Name).Attrs(d.Context)
+	if err != nil {
+		return fmt.Errorf("unable to determine bucket %v location: %v", existingBucket.Name, err)
+	}
+	if !strings.EqualFold(attrs.Location, existingBucket.Region) {
+		return fmt.Errorf("bucket %v is in location %v, expected %v", existingBucket.Name, attrs.Location, strings.ToUpper(existingBucket.Region))
+	}
+	return nil
+}
+
+// ensureClusterMarker verifies that the storage bucket was provisioned by this
+// cluster, and writes the cluster's marker into a bucket that doesn't have one.
+func (d *driver) ensureClusterMarker(gcsClient stiface.Client, instance *veleroInstallCR.VeleroInstall) error {
+	bucketName := instance.Status.StorageBucket.Name
+	marker, err := d.ClusterMarker(d.Config.InfraName)
+	if err != nil {
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	found, err := d.getClusterMarker(gcsClient, bucketName)
+	if err != nil {
+		err = fmt.Errorf("error occurred when reading the cluster marker of bucket %v: %v", bucketName, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	if err = storageBase.VerifyClusterMarker(bucketName, marker, found); err != nil {
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketOwnershipMismatch, err.Error())
+		return err
+	}
+	if found == nil {
+		if err = d.putClusterMarker(gcsClient, bucketName, marker); err != nil {
+			err = fmt.Errorf("error occurred when writing the cluster marker of bucket %v: %v", bucketName, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// ResourceLabels returns the user labels that the cluster applies to the
+// GCP resources it creates. They are read from the unstructured Infrastructure
+// config, as the API types the operator is built with don't include them yet.
+func ResourceLabels(ctx context.Context, kubeClient client.Client) (map[string]string, error) {
+	infra := &unstructured.Unstructured{}
+	infra.SetGroupVersionKind(configv1.GroupVersion.WithKind("Infrastructure"))
+	if err := kubeClient.Get(ctx, client.ObjectKey{Name: "cluster"}, infra); err != nil {
+		return nil, err
+	}
+	entries, _, err := unstructured.NestedSlice(infra.Object, "status", "platformStatus", "gcp", "resourceLabels")
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		label, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key, _ := label["key"].(string)
+		value, _ := label["value"].(string)
+		if key != "" {
+			labels[key] = value
+		}
+	}
+	return labels, nil
+}
+
 //generateBucketName generates a proposed name for the GCS Bucket
 func generateBucketName(prefix string) string {
 	id := uuid.New().String()
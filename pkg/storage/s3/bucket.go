@@ -1,24 +1,56 @@
 package s3
 
 import (
+	"bytes"
+	"crypto/md5" // #nosec G501
+	"encoding/base64"
 	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-logr/logr"
+	"golang.org/x/sync/errgroup"
+
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
 )
 
 const (
+	// bucketTagPrefix is the prefix of the tag keys owned by the operator.
+	// Tags with any other key are left as they are.
+	bucketTagPrefix         = "velero.io/"
 	bucketTagBackupLocation = "velero.io/backup-location"
 	bucketTagInfraName      = "velero.io/infrastructureName"
+	bucketTagOrphanedAt     = "velero.io/orphaned-at"
+
+	// discoveryConcurrency is the most bucket lookups made at once while
+	// searching the account for an existing bucket
+	discoveryConcurrency = 16
+	// maxThrottleRetries is how many times a throttled request is retried
+	maxThrottleRetries = 5
 )
 
-// CreateBucket creates a new S3 bucket.
-func CreateBucket(s3Client Client, bucketName string) error {
+// throttleRetryDelay is how long to wait before retrying a throttled request
+// for the first time. The delay doubles with each retry.
+var throttleRetryDelay = 200 * time.Millisecond
+
+// CreateBucket creates a new S3 bucket, optionally with Object Lock enabled.
+// Object Lock can't be enabled on a bucket after it has been created.
+func CreateBucket(s3Client Client, bucketName string, objectLock bool) error {
 	createBucketInput := &s3.CreateBucketInput{
 		ACL:    aws.String(s3.BucketCannedACLPrivate),
 		Bucket: aws.String(bucketName),
 	}
+	if objectLock {
+		createBucketInput.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
 	// Only set a location constraint if the cluster isn't in us-east-1
 	// https://github.com/boto/boto3/issues/125
 	config := s3Client.GetAWSClientConfig()
@@ -63,18 +95,36 @@ func DoesBucketExist(s3Client Client, bucketName string) (bool, error) {
 	return true, nil
 }
 
-// EncryptBucket sets the encryption configuration for the bucket.
-func EncryptBucket(s3Client Client, bucketName string) error {
+// GetBucketRegion returns the region that an S3 bucket is located in.
+func GetBucketRegion(s3Client Client, bucketName string) (string, error) {
+	result, err := s3Client.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		return "", fmt.Errorf("unable to determine bucket %v location: %v", bucketName, err)
+	}
+	// Buckets in us-east-1 have no location constraint
+	return s3.NormalizeBucketLocation(aws.StringValue(result.LocationConstraint)), nil
+}
+
+// EncryptBucket sets the encryption configuration for the bucket using the
+// given server-side encryption algorithm. With SSE-KMS, objects are encrypted
+// with the given KMS key, or the AWS-managed key if none is given, and bucket
+// keys are enabled to reduce the number of requests made to KMS.
+func EncryptBucket(s3Client Client, bucketName string, sseAlgorithm string, kmsKeyID string) error {
+	rule := &s3.ServerSideEncryptionRule{
+		ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+			SSEAlgorithm: aws.String(sseAlgorithm),
+		},
+	}
+	if sseAlgorithm == s3.ServerSideEncryptionAwsKms {
+		if kmsKeyID != "" {
+			rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID = aws.String(kmsKeyID)
+		}
+		rule.BucketKeyEnabled = aws.Bool(true)
+	}
 	bucketEncryptionInput := &s3.PutBucketEncryptionInput{
 		Bucket: aws.String(bucketName),
 		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
-			Rules: []*s3.ServerSideEncryptionRule{
-				{
-					ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
-						SSEAlgorithm: aws.String(s3.ServerSideEncryptionAes256),
-					},
-				},
-			},
+			Rules: []*s3.ServerSideEncryptionRule{rule},
 		},
 	}
 
@@ -87,6 +137,46 @@ func EncryptBucket(s3Client Client, bucketName string) error {
 	return err
 }
 
+// EnsureBucketEncryption reads the encryption configuration of the bucket, and
+// only sets it if it doesn't match the one EncryptBucket would set. It returns
+// true if the bucket's encryption had drifted and was updated.
+func EnsureBucketEncryption(s3Client Client, bucketName string, sseAlgorithm string, kmsKeyID string) (bool, error) {
+	result, err := s3Client.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "ServerSideEncryptionConfigurationNotFoundError" {
+			return false, fmt.Errorf("unable to read %v bucket encryption configuration: %v", bucketName, err)
+		}
+	} else if isBucketEncryptionCurrent(result.ServerSideEncryptionConfiguration, sseAlgorithm, kmsKeyID) {
+		return false, nil
+	}
+	if err := EncryptBucket(s3Client, bucketName, sseAlgorithm, kmsKeyID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isBucketEncryptionCurrent returns true if the encryption configuration holds
+// exactly the rule that EncryptBucket would set. Unlike IsBucketEncrypted, the
+// AWS-managed key only matches when no key is given, and bucket keys must be
+// enabled for SSE-KMS.
+func isBucketEncryptionCurrent(config *s3.ServerSideEncryptionConfiguration, sseAlgorithm string, kmsKeyID string) bool {
+	if config == nil || len(config.Rules) != 1 || config.Rules[0].ApplyServerSideEncryptionByDefault == nil {
+		return false
+	}
+	rule := config.Rules[0]
+	if aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm) != sseAlgorithm {
+		return false
+	}
+	if sseAlgorithm != s3.ServerSideEncryptionAwsKms {
+		return true
+	}
+	bucketKeyID := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)
+	if (kmsKeyID == "") != (bucketKeyID == "") || !isKMSKey(bucketKeyID, kmsKeyID) {
+		return false
+	}
+	return aws.BoolValue(rule.BucketKeyEnabled)
+}
+
 // BlockBucketPublicAccess blocks public access to the bucket's contents.
 func BlockBucketPublicAccess(s3Client Client, bucketName string) error {
 	publicAccessBlockInput := &s3.PutPublicAccessBlockInput{
@@ -108,23 +198,33 @@ func BlockBucketPublicAccess(s3Client Client, bucketName string) error {
 	return err
 }
 
-// SetBucketLifecycle sets a lifecycle on the specified bucket.
-func SetBucketLifecycle(s3Client Client, bucketName string) error {
+// EnsureBucketPublicAccessBlock blocks public access to the bucket's contents,
+// unless it is already fully blocked. It returns true if the bucket's public
+// access block had drifted and was updated.
+func EnsureBucketPublicAccessBlock(s3Client Client, bucketName string) (bool, error) {
+	blocked, err := IsBucketPublicAccessBlocked(s3Client, bucketName)
+	if err != nil {
+		return false, fmt.Errorf("unable to read %v bucket public access configuration: %v", bucketName, err)
+	}
+	if blocked {
+		return false, nil
+	}
+	if err := BlockBucketPublicAccess(s3Client, bucketName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetBucketLifecycle sets a lifecycle on the specified bucket, expiring backups
+// after the given number of days. If noncurrentDays is set, noncurrent object
+// versions are removed that many days after they were replaced or deleted, along
+// with any delete markers left behind, so that a versioned bucket doesn't retain
+// expired backups indefinitely. Incomplete multipart uploads are also aborted.
+func SetBucketLifecycle(s3Client Client, bucketName string, retentionDays int64, noncurrentDays int64) error {
 	bucketLifecycleConfigurationInput := &s3.PutBucketLifecycleConfigurationInput{
 		Bucket: aws.String(bucketName),
 		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
-			Rules: []*s3.LifecycleRule{
-				{
-					ID:     aws.String("Backup Expiry"),
-					Status: aws.String("Enabled"),
-					Filter: &s3.LifecycleRuleFilter{
-						Prefix: aws.String("backups/"),
-					},
-					Expiration: &s3.LifecycleExpiration{
-						Days: aws.Int64(90),
-					},
-				},
-			},
+			Rules: bucketLifecycleRules(retentionDays, noncurrentDays),
 		},
 	}
 
@@ -137,6 +237,420 @@ func SetBucketLifecycle(s3Client Client, bucketName string) error {
 	return err
 }
 
+// EnsureBucketLifecycle reads the lifecycle rules of the bucket, and only sets
+// them if they differ from the rules SetBucketLifecycle would set. Rules are
+// compared by ID on the settings the operator sets, in any order, since S3
+// doesn't return them shaped as they were written. It returns true if the
+// bucket's lifecycle had drifted and was updated.
+func EnsureBucketLifecycle(s3Client Client, bucketName string, retentionDays int64, noncurrentDays int64) (bool, error) {
+	result, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchLifecycleConfiguration" {
+			return false, fmt.Errorf("unable to read %v bucket lifecycle configuration: %v", bucketName, err)
+		}
+	} else if reflect.DeepEqual(lifecycleRuleSettings(result.Rules), lifecycleRuleSettings(bucketLifecycleRules(retentionDays, noncurrentDays))) {
+		return false, nil
+	}
+	if err := SetBucketLifecycle(s3Client, bucketName, retentionDays, noncurrentDays); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lifecycleRuleSetting holds the settings of a lifecycle rule that the operator sets
+type lifecycleRuleSetting struct {
+	status                    string
+	prefix                    string
+	expirationDays            int64
+	expiredObjectDeleteMarker bool
+	noncurrentDays            int64
+	abortDays                 int64
+}
+
+// lifecycleRuleSettings returns the settings of the lifecycle rules by rule ID
+func lifecycleRuleSettings(rules []*s3.LifecycleRule) map[string]lifecycleRuleSetting {
+	settings := make(map[string]lifecycleRuleSetting, len(rules))
+	for _, rule := range rules {
+		setting := lifecycleRuleSetting{
+			status: aws.StringValue(rule.Status),
+			prefix: lifecycleRulePrefix(rule),
+		}
+		if rule.Expiration != nil {
+			setting.expirationDays = aws.Int64Value(rule.Expiration.Days)
+			setting.expiredObjectDeleteMarker = aws.BoolValue(rule.Expiration.ExpiredObjectDeleteMarker)
+		}
+		if rule.NoncurrentVersionExpiration != nil {
+			setting.noncurrentDays = aws.Int64Value(rule.NoncurrentVersionExpiration.NoncurrentDays)
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			setting.abortDays = aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+		}
+		settings[aws.StringValue(rule.ID)] = setting
+	}
+	return settings
+}
+
+// lifecycleRulePrefix returns the object key prefix a lifecycle rule applies
+// to, wherever in the rule it is set
+func lifecycleRulePrefix(rule *s3.LifecycleRule) string {
+	if rule.Filter != nil {
+		if rule.Filter.And != nil {
+			return aws.StringValue(rule.Filter.And.Prefix)
+		}
+		return aws.StringValue(rule.Filter.Prefix)
+	}
+	return aws.StringValue(rule.Prefix)
+}
+
+// bucketLifecycleRules returns the lifecycle rules set on a bucket by SetBucketLifecycle
+func bucketLifecycleRules(retentionDays int64, noncurrentDays int64) []*s3.LifecycleRule {
+	rules := []*s3.LifecycleRule{
+		{
+			ID:     aws.String("Backup Expiry"),
+			Status: aws.String("Enabled"),
+			Filter: &s3.LifecycleRuleFilter{
+				Prefix: aws.String("backups/"),
+			},
+			Expiration: &s3.LifecycleExpiration{
+				Days: aws.Int64(retentionDays),
+			},
+		},
+	}
+	if noncurrentDays > 0 {
+		rules = append(rules, &s3.LifecycleRule{
+			ID:     aws.String("Noncurrent Version Expiry"),
+			Status: aws.String("Enabled"),
+			Filter: &s3.LifecycleRuleFilter{
+				Prefix: aws.String(""),
+			},
+			Expiration: &s3.LifecycleExpiration{
+				ExpiredObjectDeleteMarker: aws.Bool(true),
+			},
+			NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{
+				NoncurrentDays: aws.Int64(noncurrentDays),
+			},
+			AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int64(storageConstants.AbortIncompleteMultipartUploadDays),
+			},
+		})
+	}
+	return rules
+}
+
+// EnableBucketVersioning enables versioning on the specified bucket.
+func EnableBucketVersioning(s3Client Client, bucketName string) error {
+	bucketVersioningInput := &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String(s3.BucketVersioningStatusEnabled),
+		},
+	}
+
+	if err := bucketVersioningInput.Validate(); err != nil {
+		return fmt.Errorf("unable to validate %v bucket versioning configuration: %v", bucketName, err)
+	}
+
+	_, err := s3Client.PutBucketVersioning(bucketVersioningInput)
+
+	return err
+}
+
+// IsBucketVersioned returns true if versioning is enabled on the specified bucket.
+func IsBucketVersioned(s3Client Client, bucketName string) (bool, error) {
+	result, err := s3Client.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		return false, err
+	}
+	return aws.StringValue(result.Status) == s3.BucketVersioningStatusEnabled, nil
+}
+
+// EnsureBucketVersioning enables versioning on the specified bucket, unless it
+// is already enabled. It returns true if the bucket's versioning had drifted
+// and was updated.
+func EnsureBucketVersioning(s3Client Client, bucketName string) (bool, error) {
+	versioned, err := IsBucketVersioned(s3Client, bucketName)
+	if err != nil {
+		return false, fmt.Errorf("unable to read %v bucket versioning configuration: %v", bucketName, err)
+	}
+	if versioned {
+		return false, nil
+	}
+	if err := EnableBucketVersioning(s3Client, bucketName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetBucketObjectLock sets the default retention of the specified bucket, so that
+// new objects are locked in the given mode for the given number of days. Object
+// Lock must have been enabled when the bucket was created.
+func SetBucketObjectLock(s3Client Client, bucketName string, mode string, retentionDays int64) error {
+	objectLockInput := &s3.PutObjectLockConfigurationInput{
+		Bucket: aws.String(bucketName),
+		ObjectLockConfiguration: &s3.ObjectLockConfiguration{
+			ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled),
+			Rule: &s3.ObjectLockRule{
+				DefaultRetention: &s3.DefaultRetention{
+					Mode: aws.String(mode),
+					Days: aws.Int64(retentionDays),
+				},
+			},
+		},
+	}
+
+	if err := objectLockInput.Validate(); err != nil {
+		return fmt.Errorf("unable to validate %v bucket object lock configuration: %v", bucketName, err)
+	}
+
+	_, err := s3Client.PutObjectLockConfiguration(objectLockInput)
+
+	return err
+}
+
+// EnsureBucketObjectLock reads the Object Lock configuration of the bucket, and
+// only sets its default retention if it differs from the given mode and number
+// of days. It returns true if the bucket's default retention had drifted and
+// was updated.
+func EnsureBucketObjectLock(s3Client Client, bucketName string, mode string, retentionDays int64) (bool, error) {
+	config, err := GetBucketObjectLock(s3Client, bucketName)
+	if err != nil {
+		return false, fmt.Errorf("unable to read %v bucket object lock configuration: %v", bucketName, err)
+	}
+	if config != nil && config.Rule != nil && config.Rule.DefaultRetention != nil {
+		retention := config.Rule.DefaultRetention
+		if aws.StringValue(retention.Mode) == mode && aws.Int64Value(retention.Days) == retentionDays && retention.Years == nil {
+			return false, nil
+		}
+	}
+	if err := SetBucketObjectLock(s3Client, bucketName, mode, retentionDays); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetBucketObjectLock returns the Object Lock configuration of the specified
+// bucket, or nil if Object Lock is not enabled on the bucket.
+func GetBucketObjectLock(s3Client Client, bucketName string) (*s3.ObjectLockConfiguration, error) {
+	result, err := s3Client.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ObjectLockConfigurationNotFoundError" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if result.ObjectLockConfiguration == nil || aws.StringValue(result.ObjectLockConfiguration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return nil, nil
+	}
+	return result.ObjectLockConfiguration, nil
+}
+
+// IsBucketLocked returns true if the Object Lock configuration locks new objects
+// in the given mode for at least the given number of days.
+func IsBucketLocked(config *s3.ObjectLockConfiguration, mode string, retentionDays int64) bool {
+	if config == nil || config.Rule == nil || config.Rule.DefaultRetention == nil {
+		return false
+	}
+	retention := config.Rule.DefaultRetention
+	days := aws.Int64Value(retention.Days)
+	if retention.Years != nil {
+		days = aws.Int64Value(retention.Years) * 365
+	}
+	return aws.StringValue(retention.Mode) == mode && days >= retentionDays
+}
+
+// SetBucketReplication configures S3 Cross-Region Replication of every object
+// in the bucket, including delete markers, to the replica bucket using the
+// given IAM role. With a replica KMS key, objects encrypted with SSE-KMS are
+// replicated too, and re-encrypted with that key. Versioning must be enabled
+// on both buckets.
+func SetBucketReplication(s3Client Client, bucketName string, roleARN string, replicaBucketARN string, replicaKMSKeyARN string) error {
+	rule := &s3.ReplicationRule{
+		ID:       aws.String("Backup Replication"),
+		Status:   aws.String(s3.ReplicationRuleStatusEnabled),
+		Priority: aws.Int64(1),
+		Filter: &s3.ReplicationRuleFilter{
+			Prefix: aws.String(""),
+		},
+		DeleteMarkerReplication: &s3.DeleteMarkerReplication{
+			Status: aws.String(s3.DeleteMarkerReplicationStatusEnabled),
+		},
+		Destination: &s3.Destination{
+			Bucket: aws.String(replicaBucketARN),
+		},
+	}
+	if replicaKMSKeyARN != "" {
+		rule.SourceSelectionCriteria = &s3.SourceSelectionCriteria{
+			SseKmsEncryptedObjects: &s3.SseKmsEncryptedObjects{
+				Status: aws.String(s3.SseKmsEncryptedObjectsStatusEnabled),
+			},
+		}
+		rule.Destination.EncryptionConfiguration = &s3.EncryptionConfiguration{
+			ReplicaKmsKeyID: aws.String(replicaKMSKeyARN),
+		}
+	}
+	bucketReplicationInput := &s3.PutBucketReplicationInput{
+		Bucket: aws.String(bucketName),
+		ReplicationConfiguration: &s3.ReplicationConfiguration{
+			Role:  aws.String(roleARN),
+			Rules: []*s3.ReplicationRule{rule},
+		},
+	}
+
+	if err := bucketReplicationInput.Validate(); err != nil {
+		return fmt.Errorf("unable to validate %v bucket replication configuration: %v", bucketName, err)
+	}
+
+	_, err := s3Client.PutBucketReplication(bucketReplicationInput)
+
+	return err
+}
+
+// IsBucketReplicated returns true if the bucket has an enabled replication rule
+// for the replica bucket.
+func IsBucketReplicated(s3Client Client, bucketName string, replicaBucketARN string) (bool, error) {
+	result, err := s3Client.GetBucketReplication(&s3.GetBucketReplicationInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ReplicationConfigurationNotFoundError" {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to read %v bucket replication configuration: %v", bucketName, err)
+	}
+	if result.ReplicationConfiguration == nil {
+		return false, nil
+	}
+	for _, rule := range result.ReplicationConfiguration.Rules {
+		if aws.StringValue(rule.Status) == s3.ReplicationRuleStatusEnabled && rule.Destination != nil &&
+			aws.StringValue(rule.Destination.Bucket) == replicaBucketARN {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RemoveBucketReplication removes the replication configuration from the bucket.
+func RemoveBucketReplication(s3Client Client, bucketName string) error {
+	_, err := s3Client.DeleteBucketReplication(&s3.DeleteBucketReplicationInput{Bucket: aws.String(bucketName)})
+	return err
+}
+
+// FindBucketPolicyGaps reads the encryption, public access block, versioning and
+// lifecycle configuration of an S3 bucket, and returns a description of each setting that
+// does not meet the policy the operator would otherwise enforce on the bucket.
+func FindBucketPolicyGaps(s3Client Client, bucketName string, sseAlgorithm string, kmsKeyID string, retentionDays int64) ([]string, error) {
+	var gaps []string
+
+	encrypted, err := IsBucketEncrypted(s3Client, bucketName, sseAlgorithm, kmsKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v bucket encryption configuration: %v", bucketName, err)
+	}
+	if !encrypted && kmsKeyID != "" {
+		gaps = append(gaps, fmt.Sprintf("default encryption is not %v with key %v", sseAlgorithm, kmsKeyID))
+	} else if !encrypted {
+		gaps = append(gaps, fmt.Sprintf("default encryption is not %v", sseAlgorithm))
+	}
+
+	blocked, err := IsBucketPublicAccessBlocked(s3Client, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v bucket public access configuration: %v", bucketName, err)
+	}
+	if !blocked {
+		gaps = append(gaps, "public access is not fully blocked")
+	}
+
+	versioned, err := IsBucketVersioned(s3Client, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v bucket versioning configuration: %v", bucketName, err)
+	}
+	if !versioned {
+		gaps = append(gaps, "versioning is not enabled")
+	}
+
+	expires, err := DoBackupsExpire(s3Client, bucketName, retentionDays)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v bucket lifecycle configuration: %v", bucketName, err)
+	}
+	if !expires {
+		gaps = append(gaps, fmt.Sprintf("backups are not expired within %v days", retentionDays))
+	}
+
+	return gaps, nil
+}
+
+// IsBucketEncrypted returns true if the default encryption of the bucket uses
+// the given server-side encryption algorithm, and the given KMS key if any.
+func IsBucketEncrypted(s3Client Client, bucketName string, sseAlgorithm string, kmsKeyID string) (bool, error) {
+	result, err := s3Client.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ServerSideEncryptionConfigurationNotFoundError" {
+			return false, nil
+		}
+		return false, err
+	}
+	if result.ServerSideEncryptionConfiguration == nil {
+		return false, nil
+	}
+	for _, rule := range result.ServerSideEncryptionConfiguration.Rules {
+		if rule.ApplyServerSideEncryptionByDefault != nil &&
+			aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm) == sseAlgorithm &&
+			isKMSKey(aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID), kmsKeyID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isKMSKey returns true if the KMS key configured on a bucket is the given key.
+// Either may be a key ID or a key ARN, and any key matches when none is given.
+func isKMSKey(bucketKeyID string, kmsKeyID string) bool {
+	if kmsKeyID == "" || bucketKeyID == kmsKeyID {
+		return true
+	}
+	return strings.HasSuffix(bucketKeyID, ":key/"+kmsKeyID) || strings.HasSuffix(kmsKeyID, ":key/"+bucketKeyID)
+}
+
+// IsBucketPublicAccessBlocked returns true if all public access to the bucket is blocked.
+func IsBucketPublicAccessBlocked(s3Client Client, bucketName string) (bool, error) {
+	result, err := s3Client.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchPublicAccessBlockConfiguration" {
+			return false, nil
+		}
+		return false, err
+	}
+	config := result.PublicAccessBlockConfiguration
+	return config != nil &&
+		aws.BoolValue(config.BlockPublicAcls) &&
+		aws.BoolValue(config.BlockPublicPolicy) &&
+		aws.BoolValue(config.IgnorePublicAcls) &&
+		aws.BoolValue(config.RestrictPublicBuckets), nil
+}
+
+// DoBackupsExpire returns true if the bucket has an enabled lifecycle rule that
+// expires backups within the given number of days.
+func DoBackupsExpire(s3Client Client, bucketName string, retentionDays int64) (bool, error) {
+	result, err := s3Client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchLifecycleConfiguration" {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, rule := range result.Rules {
+		if aws.StringValue(rule.Status) != s3.ExpirationStatusEnabled || rule.Expiration == nil {
+			continue
+		}
+		days := aws.Int64Value(rule.Expiration.Days)
+		if days < 1 || days > retentionDays {
+			continue
+		}
+		// The rule must cover the backups/ prefix that Velero writes to
+		if strings.HasPrefix("backups/", lifecycleRulePrefix(rule)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // CreateBucketTaggingInput creates an S3 PutBucketTaggingInput object,
 // which is used to associate a list of tags with a bucket.
 func CreateBucketTaggingInput(bucketname string, tags map[string]string) *s3.PutBucketTaggingInput {
@@ -156,27 +670,196 @@ func CreateBucketTaggingInput(bucketname string, tags map[string]string) *s3.Put
 	return putInput
 }
 
-// ClearBucketTags wipes all existing tags from a bucket so that velero-specific
-// tags can be applied to the bucket instead.
-func ClearBucketTags(s3Client Client, bucketName string) (err error) {
-	deleteInput := &s3.DeleteBucketTaggingInput{Bucket: aws.String(bucketName)}
-	_, err = s3Client.DeleteBucketTagging(deleteInput)
-	return err
+// mergeBucketTags returns the tags that TagBucket sets on an S3 bucket with
+// the given tags. The operator owns every tag with the velero.io/ prefix, so
+// those are replaced by the velero tags, while tags added to the bucket by
+// anything else are kept. Any extra tags are set alongside them, but cannot
+// override the velero tags.
+func mergeBucketTags(currentTags map[string]string, backUpLocation string, infraName string, extraTags map[string]string) map[string]string {
+	tags := make(map[string]string, len(currentTags)+len(extraTags)+2)
+	for key, value := range currentTags {
+		if !strings.HasPrefix(key, bucketTagPrefix) {
+			tags[key] = value
+		}
+	}
+	for key, value := range extraTags {
+		tags[key] = value
+	}
+	tags[bucketTagBackupLocation] = backUpLocation
+	tags[bucketTagInfraName] = infraName
+	return tags
+}
+
+// getBucketTags returns the tags of an S3 bucket. A bucket without tags has an
+// empty tag set.
+func getBucketTags(s3Client Client, bucketName string) (map[string]string, error) {
+	tags := make(map[string]string)
+	response, err := s3Client.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String(bucketName)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchTagSet" {
+			return nil, fmt.Errorf("unable to read %v bucket tags: %v", bucketName, err)
+		}
+		return tags, nil
+	}
+	for _, tag := range response.TagSet {
+		tags[*tag.Key] = *tag.Value
+	}
+	return tags, nil
 }
 
 // TagBucket adds tags to an S3 bucket. The tags are used to indicate that velero backups
-// are stored in the bucket, and to identify the associated cluster.
-func TagBucket(s3Client Client, bucketName string, backUpLocation string, infraName string) error {
-	err := ClearBucketTags(s3Client, bucketName)
+// are stored in the bucket, and to identify the associated cluster. Any extra tags are
+// applied alongside them, but cannot override the velero tags. Tags that don't have the
+// velero.io/ prefix, such as cost allocation tags, are kept.
+func TagBucket(s3Client Client, bucketName string, backUpLocation string, infraName string, extraTags map[string]string) error {
+	_, err := EnsureBucketTags(s3Client, bucketName, backUpLocation, infraName, extraTags)
+	return err
+}
+
+// EnsureBucketTags reads the tags of an S3 bucket, and only updates them if
+// they differ from the merged tags TagBucket would set. It returns true if
+// the bucket's tags had drifted and were updated.
+func EnsureBucketTags(s3Client Client, bucketName string, backUpLocation string, infraName string, extraTags map[string]string) (bool, error) {
+	currentTags, err := getBucketTags(s3Client, bucketName)
+	if err != nil {
+		return false, err
+	}
+	tags := mergeBucketTags(currentTags, backUpLocation, infraName, extraTags)
+	if reflect.DeepEqual(currentTags, tags) {
+		return false, nil
+	}
+	_, err = s3Client.PutBucketTagging(CreateBucketTaggingInput(bucketName, tags))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MarkBucketOrphaned adds a tag to an S3 bucket recording the time that it was
+// orphaned by its cluster. Existing tags on the bucket are kept.
+func MarkBucketOrphaned(s3Client Client, bucketName string, orphanedAt time.Time) error {
+	tags, err := getBucketTags(s3Client, bucketName)
 	if err != nil {
-		return fmt.Errorf("unable to clear %v bucket tags: %v", bucketName, err)
+		return err
 	}
-	input := CreateBucketTaggingInput(bucketName, map[string]string{
-		bucketTagBackupLocation: backUpLocation,
-		bucketTagInfraName:      infraName,
+	tags[bucketTagOrphanedAt] = orphanedAt.UTC().Format(time.RFC3339)
+	_, err = s3Client.PutBucketTagging(CreateBucketTaggingInput(bucketName, tags))
+	return err
+}
+
+// GetClusterMarker reads the marker object that identifies the cluster owning
+// the bucket. nil is returned when the bucket doesn't have a marker, and a
+// denied read returns an error wrapping storageBase.ErrClusterMarkerAccessDenied.
+func GetClusterMarker(s3Client Client, bucketName string) (*storageBase.ClusterMarker, error) {
+	output, err := s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(storageBase.ClusterMarkerKey),
 	})
-	_, err = s3Client.PutBucketTagging(input)
 	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey:
+				return nil, nil
+			case "AccessDenied", "KMS.AccessDeniedException":
+				return nil, fmt.Errorf("%w: %v", storageBase.ErrClusterMarkerAccessDenied, err)
+			}
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, err
+	}
+	return storageBase.ParseClusterMarker(data)
+}
+
+// PutClusterMarker writes the marker object that identifies the cluster
+// owning the bucket.
+func PutClusterMarker(s3Client Client, bucketName string, marker *storageBase.ClusterMarker) error {
+	data, err := marker.Marshal()
+	if err != nil {
+		return err
+	}
+	// Buckets with Object Lock enabled only accept objects with a Content-MD5
+	sum := md5.Sum(data) // #nosec G401
+	_, err = s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(storageBase.ClusterMarkerKey),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+		ContentMD5:  aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	})
+	return err
+}
+
+// OwnedBuckets returns the buckets whose marker object shows that they were
+// provisioned by the cluster, or the buckets without a marker when the first
+// of them was adopted. See storageBase.OwnedBuckets.
+func OwnedBuckets(log logr.Logger, s3Client Client, bucketNames []string, marker *storageBase.ClusterMarker) ([]string, bool, error) {
+	return storageBase.OwnedBuckets(log, bucketNames, marker,
+		func(bucketName string) (*storageBase.ClusterMarker, error) {
+			return GetClusterMarker(s3Client, bucketName)
+		},
+		func(bucketName string, marker *storageBase.ClusterMarker) error {
+			return PutClusterMarker(s3Client, bucketName, marker)
+		})
+}
+
+// EmptyBucket deletes every object in an S3 bucket, including any noncurrent
+// versions and delete markers, so that the bucket itself can be deleted.
+func EmptyBucket(s3Client Client, bucketName string) error {
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(bucketName)}
+	for {
+		result, err := s3Client.ListObjectVersions(input)
+		if err != nil {
+			return err
+		}
+
+		var objects []*s3.ObjectIdentifier
+		for _, version := range result.Versions {
+			objects = append(objects, &s3.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, marker := range result.DeleteMarkers {
+			objects = append(objects, &s3.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+		}
+		if len(objects) > 0 {
+			// A listing page holds at most 1000 entries, which is also the
+			// most that can be deleted in a single request
+			output, err := s3Client.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket: aws.String(bucketName),
+				Delete: &s3.Delete{
+					Objects: objects,
+					Quiet:   aws.Bool(true),
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if len(output.Errors) > 0 {
+				return fmt.Errorf("unable to delete %v objects from bucket %v: %v", len(output.Errors), bucketName, aws.StringValue(output.Errors[0].Message))
+			}
+		}
+
+		if !aws.BoolValue(result.IsTruncated) {
+			return nil
+		}
+		input.KeyMarker = result.NextKeyMarker
+		input.VersionIdMarker = result.NextVersionIdMarker
+	}
+}
+
+// DeleteBucket empties and deletes an S3 bucket. A bucket that no longer
+// exists is not an error.
+func DeleteBucket(s3Client Client, bucketName string) error {
+	err := EmptyBucket(s3Client, bucketName)
+	if err == nil {
+		_, err = s3Client.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(bucketName)})
+	}
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchBucket {
+			return nil
+		}
 		return err
 	}
 	return nil
@@ -185,43 +868,81 @@ func TagBucket(s3Client Client, bucketName string, backUpLocation string, infraN
 // ListBuckets lists all buckets in the AWS account.
 func ListBuckets(s3Client Client) (*s3.ListBucketsOutput, error) {
 	input := &s3.ListBucketsInput{}
-	result, err := s3Client.ListBuckets(input)
+	var result *s3.ListBucketsOutput
+	err := retryThrottled(func() error {
+		var err error
+		result, err = s3Client.ListBuckets(input)
+		return err
+	})
 	if err != nil {
 		return result, err
 	}
 	return result, nil
 }
 
-// ListBucketsInRegion lists buckets in the AWS account in the given region.
-func ListBucketsInRegion(s3Client Client, region string) (*s3.ListBucketsOutput, error) {
+// FilterBucketsByPrefix returns the buckets whose names start with any of the
+// prefixes. Buckets are filtered before any lookups are made for them, since
+// accounts can hold thousands of buckets that the operator didn't create.
+func FilterBucketsByPrefix(buckets []*s3.Bucket, prefixes ...string) []*s3.Bucket {
+	filtered := []*s3.Bucket{}
+	for _, bucket := range buckets {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(aws.StringValue(bucket.Name), prefix) {
+				filtered = append(filtered, bucket)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// ListBucketsInRegion lists buckets in the AWS account in the given region,
+// whose names start with any of the prefixes. The region of each bucket is
+// looked up concurrently.
+//
+// ListBuckets isn't paginated, and can't filter by region, in the version of
+// the AWS SDK in use, so every bucket in the account is listed and filtered
+// here.
+func ListBucketsInRegion(s3Client Client, region string, prefixes ...string) (*s3.ListBucketsOutput, error) {
 	result, err := ListBuckets(s3Client)
 	if err != nil {
 		return result, err
 	}
+	buckets := FilterBucketsByPrefix(result.Buckets, prefixes...)
+
 	defaultRegion := "us-east-1"
-	filteredBuckets := []*s3.Bucket{}
-	for _, bucket := range result.Buckets {
+	inRegion := make([]bool, len(buckets))
+	err = forEachBucket(buckets, func(i int, bucket *s3.Bucket) error {
 		input := &s3.GetBucketLocationInput{Bucket: bucket.Name}
-		locationResult, err := s3Client.GetBucketLocation(input)
+		var locationResult *s3.GetBucketLocationOutput
+		err := retryThrottled(func() error {
+			var err error
+			locationResult, err = s3Client.GetBucketLocation(input)
+			return err
+		})
 		if err != nil {
 			// cast err to awserr.Error. if that works then check the awserror code
 			if aerr, ok := err.(awserr.Error); ok {
 				if (aerr.Code() == s3.ErrCodeNoSuchBucket) || (aerr.Code() == "NotFound") {
 					// The bucket specified no longer exists (can be due to delays in AWS API), continue.
-					continue
-				} else {
-					// other AWS error that we aren't handling
-					return nil, aerr
+					return nil
 				}
-			} else {
-				// not an AWS error
-				return nil, err
 			}
+			return err
 		}
 		if locationResult.LocationConstraint == nil {
 			locationResult.LocationConstraint = &defaultRegion
 		}
-		if *locationResult.LocationConstraint == region {
+		inRegion[i] = *locationResult.LocationConstraint == region
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	filteredBuckets := []*s3.Bucket{}
+	for i, bucket := range buckets {
+		if inRegion[i] {
 			filteredBuckets = append(filteredBuckets, bucket)
 		}
 	}
@@ -230,59 +951,117 @@ func ListBucketsInRegion(s3Client Client, region string) (*s3.ListBucketsOutput,
 
 // ListBucketTags returns a list of s3.GetBucketTagging objects, one for each bucket.
 // If the bucket is not readable, or has no tags, the bucket name is omitted from the taglist.
-// So taglist only contains the list of buckets that have tags.
+// So taglist only contains the list of buckets that have tags. The tags of
+// each bucket are read concurrently.
 func ListBucketTags(s3Client Client, buckets []*s3.Bucket) (map[string][]*s3.Tag, error) {
-	taglist := make(map[string][]*s3.Tag)
-	for _, bucket := range buckets {
+	tagsets := make([][]*s3.Tag, len(buckets))
+	found := make([]bool, len(buckets))
+	err := forEachBucket(buckets, func(i int, bucket *s3.Bucket) error {
 		request := &s3.GetBucketTaggingInput{
 			Bucket: aws.String(*bucket.Name),
 		}
-		response, err := s3Client.GetBucketTagging(request)
+		var response *s3.GetBucketTaggingOutput
+		err := retryThrottled(func() error {
+			var err error
+			response, err = s3Client.GetBucketTagging(request)
+			return err
+		})
 		if err != nil {
 			if aerr, ok := err.(awserr.Error); ok {
 				switch aerr.Code() {
 				case "NoSuchTagSet":
 					// There are no tags on this bucket, continue.
-					continue
+					return nil
 				case "NoSuchBucket":
 					// The bucket specified no longer exists (can be due to delays in AWS API), continue.
-					continue
-				default:
-					return taglist, err
+					return nil
 				}
-			} else {
-				return taglist, err
 			}
+			return err
+		}
+		tagsets[i] = response.TagSet
+		found[i] = true
+		return nil
+	})
+
+	taglist := make(map[string][]*s3.Tag)
+	for i, bucket := range buckets {
+		if found[i] {
+			taglist[*bucket.Name] = tagsets[i]
 		}
-		taglist[*bucket.Name] = response.TagSet
 	}
-	return taglist, nil
+	return taglist, err
+}
+
+// forEachBucket calls fn for each of the buckets, with at most
+// discoveryConcurrency calls running at once. Each call is given the index of
+// its bucket, so that results can be stored without locking. The first error
+// returned by a call is returned once all calls have finished.
+func forEachBucket(buckets []*s3.Bucket, fn func(int, *s3.Bucket) error) error {
+	var group errgroup.Group
+	group.SetLimit(discoveryConcurrency)
+	for i, bucket := range buckets {
+		i, bucket := i, bucket
+		group.Go(func() error {
+			return fn(i, bucket)
+		})
+	}
+	return group.Wait()
+}
+
+// retryThrottled calls fn, and calls it again with exponential backoff for as
+// long as S3 throttles the request, up to maxThrottleRetries times. The SDK's
+// own retries give up quickly when thousands of buckets are being looked up.
+func retryThrottled(fn func() error) error {
+	delay := throttleRetryDelay
+	err := fn()
+	for i := 0; i < maxThrottleRetries && isThrottled(err); i++ {
+		time.Sleep(delay)
+		delay *= 2
+		err = fn()
+	}
+	return err
+}
+
+// isThrottled returns true if the error is S3 asking for requests to be slowed down
+func isThrottled(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "SlowDown" {
+		return true
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		return rerr.StatusCode() == http.StatusTooManyRequests || rerr.StatusCode() == http.StatusServiceUnavailable
+	}
+	return false
 }
 
 // FindMatchingTags looks through the TagSets for all AWS buckets and determines if
-// any of the buckets are tagged for velero updates for the cluster.
-// If matching tags are found, the bucket name is returned.
-func FindMatchingTags(buckets map[string][]*s3.Tag, infraName string) string {
-	var tagMatchesCluster, tagMatchesVelero bool
-	var possiblematch string
-	for bucket, tagset := range buckets {
-		for _, tag := range tagset {
-			if *tag.Key == bucketTagInfraName && *tag.Value == infraName {
+// any of the buckets are tagged for velero updates for the cluster. A bucket
+// only matches when it carries both the cluster's infrastructure name and the
+// default backup location tag. The names of the matching buckets are returned,
+// newest first.
+func FindMatchingTags(buckets []*s3.Bucket, bucketTags map[string][]*s3.Tag, infraName string) []string {
+	var candidates []storageBase.BucketCandidate
+	for _, bucket := range buckets {
+		var tagMatchesCluster, tagMatchesVelero bool
+		for _, tag := range bucketTags[aws.StringValue(bucket.Name)] {
+			if aws.StringValue(tag.Key) == bucketTagInfraName && aws.StringValue(tag.Value) == infraName {
 				tagMatchesCluster = true
-				possiblematch = bucket
 			}
-			if *tag.Key == bucketTagBackupLocation {
+			if aws.StringValue(tag.Key) == bucketTagBackupLocation && aws.StringValue(tag.Value) == storageConstants.DefaultVeleroBackupStorageLocation {
 				tagMatchesVelero = true
-				possiblematch = bucket
 			}
 		}
-	}
 
-	// If these two conditions are true, the match is confirmed.
-	if tagMatchesCluster && tagMatchesVelero {
-		return possiblematch
+		// If these two conditions are true, the match is confirmed.
+		if tagMatchesCluster && tagMatchesVelero {
+			candidates = append(candidates, storageBase.BucketCandidate{
+				Name:         aws.StringValue(bucket.Name),
+				CreationDate: aws.TimeValue(bucket.CreationDate),
+			})
+		}
 	}
-
-	// No matching buckets found.
-	return ""
+	return storageBase.RankBucketCandidates(candidates)
 }
@@ -1,7 +1,13 @@
 package s3
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
@@ -11,11 +17,13 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
 	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
 )
 
 const (
 	clusterInfraName = "fakeCluster"
+	clusterID        = "00000000-0000-0000-0000-000000000001"
 	region           = "us-east-1"
 )
 
@@ -50,10 +58,18 @@ var emptyBuckets = []*s3.Bucket{}
 // Create a fake AWS client for mocking API responses.
 func newMockAWSClient(buckets []*s3.Bucket) *mockAWSClient {
 	return &mockAWSClient{
-		s3Client:    s3.New(s),
-		Config:      awsConfig,
-		Buckets:     buckets,
-		BucketsTags: make(map[string]*s3.Tagging),
+		s3Client:           s3.New(s),
+		Config:             awsConfig,
+		Buckets:            buckets,
+		BucketsTags:        make(map[string]*s3.Tagging),
+		BucketsLifecycle:   make(map[string]*s3.BucketLifecycleConfiguration),
+		BucketsEncryption:  make(map[string]*s3.ServerSideEncryptionConfiguration),
+		BucketsAccess:      make(map[string]*s3.PublicAccessBlockConfiguration),
+		BucketsObjects:     make(map[string][]string),
+		BucketsVersioning:  make(map[string]string),
+		BucketsObjectLock:  make(map[string]*s3.ObjectLockConfiguration),
+		BucketsReplication: make(map[string]*s3.ReplicationConfiguration),
+		BucketsMarkers:     make(map[string][]byte),
 	}
 }
 
@@ -63,22 +79,69 @@ var fakeEmptyClient = newMockAWSClient(emptyBuckets)
 
 // mockAWSClient implements the Client interface.
 type mockAWSClient struct {
-	s3Client    s3iface.S3API
-	Config      *aws.Config
-	Buckets     []*s3.Bucket
-	BucketsTags map[string]*s3.Tagging
+	s3Client           s3iface.S3API
+	Config             *aws.Config
+	Buckets            []*s3.Bucket
+	BucketsTags        map[string]*s3.Tagging
+	BucketsLifecycle   map[string]*s3.BucketLifecycleConfiguration
+	BucketsEncryption  map[string]*s3.ServerSideEncryptionConfiguration
+	BucketsAccess      map[string]*s3.PublicAccessBlockConfiguration
+	BucketsObjects     map[string][]string
+	BucketsVersioning  map[string]string
+	BucketsObjectLock  map[string]*s3.ObjectLockConfiguration
+	BucketsReplication map[string]*s3.ReplicationConfiguration
+	BucketsMarkers     map[string][]byte
 }
 
+// mockListPageSize is the number of object versions the mockAWSClient returns
+// per ListObjectVersions page, small enough to exercise pagination.
+const mockListPageSize = 2
+
 // CreateBucket implements the CreateBucket method for mockAWSClient.
 func (c *mockAWSClient) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	if aws.BoolValue(input.ObjectLockEnabledForBucket) {
+		c.BucketsVersioning[*input.Bucket] = s3.BucketVersioningStatusEnabled
+		c.BucketsObjectLock[*input.Bucket] = &s3.ObjectLockConfiguration{ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled)}
+	}
 	return &s3.CreateBucketOutput{
 		Location: aws.String(region),
 	}, nil
 }
 
-// DeleteBucketTagging implements the DeleteBucketTagging method for mockAWSClient.
-func (c *mockAWSClient) DeleteBucketTagging(input *s3.DeleteBucketTaggingInput) (*s3.DeleteBucketTaggingOutput, error) {
-	return c.s3Client.DeleteBucketTagging(input)
+// DeleteBucket implements the DeleteBucket method for mockAWSClient.
+func (c *mockAWSClient) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	if len(c.BucketsObjects[*input.Bucket]) > 0 {
+		return nil, awserr.New("BucketNotEmpty", "The bucket you tried to delete is not empty", nil)
+	}
+	for i, bucket := range c.Buckets {
+		if *bucket.Name == *input.Bucket {
+			c.Buckets = append(c.Buckets[:i:i], c.Buckets[i+1:]...)
+			return &s3.DeleteBucketOutput{}, nil
+		}
+	}
+	return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+}
+
+// DeleteBucketReplication implements the DeleteBucketReplication method for mockAWSClient.
+func (c *mockAWSClient) DeleteBucketReplication(input *s3.DeleteBucketReplicationInput) (*s3.DeleteBucketReplicationOutput, error) {
+	delete(c.BucketsReplication, *input.Bucket)
+	return &s3.DeleteBucketReplicationOutput{}, nil
+}
+
+// DeleteObjects implements the DeleteObjects method for mockAWSClient.
+func (c *mockAWSClient) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	deleted := make(map[string]bool)
+	for _, object := range input.Delete.Objects {
+		deleted[*object.Key] = true
+	}
+	var remaining []string
+	for _, key := range c.BucketsObjects[*input.Bucket] {
+		if !deleted[key] {
+			remaining = append(remaining, key)
+		}
+	}
+	c.BucketsObjects[*input.Bucket] = remaining
+	return &s3.DeleteObjectsOutput{}, nil
 }
 
 // GetAWSClientConfig returns a copy of the AWS Client Config for the mockAWSClient.
@@ -95,6 +158,25 @@ func (c *mockAWSClient) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOut
 	return &s3.HeadBucketOutput{}, awserr.New("NotFound", "Not Found", nil)
 }
 
+// GetBucketEncryption implements the GetBucketEncryption method for mockAWSClient.
+func (c *mockAWSClient) GetBucketEncryption(input *s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error) {
+	config, ok := c.BucketsEncryption[*input.Bucket]
+	if !ok {
+		return nil, awserr.New("ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found", nil)
+	}
+	return &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: config}, nil
+}
+
+// GetBucketLifecycleConfiguration implements the GetBucketLifecycleConfiguration method for mockAWSClient.
+func (c *mockAWSClient) GetBucketLifecycleConfiguration(
+	input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	config, ok := c.BucketsLifecycle[*input.Bucket]
+	if !ok {
+		return nil, awserr.New("NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", nil)
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: config.Rules}, nil
+}
+
 // GetBucketLocation implements the GetBucketLocation method for mockAWSClient.
 // This mocks the AWS API response of having access to a single bucket named "testBucket".
 func (c *mockAWSClient) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
@@ -104,8 +186,20 @@ func (c *mockAWSClient) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3
 	return &s3.GetBucketLocationOutput{}, awserr.New("NotFound", "Not Found", nil)
 }
 
+// GetBucketReplication implements the GetBucketReplication method for mockAWSClient.
+func (c *mockAWSClient) GetBucketReplication(input *s3.GetBucketReplicationInput) (*s3.GetBucketReplicationOutput, error) {
+	config, ok := c.BucketsReplication[*input.Bucket]
+	if !ok {
+		return nil, awserr.New("ReplicationConfigurationNotFoundError", "The replication configuration was not found", nil)
+	}
+	return &s3.GetBucketReplicationOutput{ReplicationConfiguration: config}, nil
+}
+
 // GetBucketTagging implements the GetBucketTagging method for mockAWSClient.
 func (c *mockAWSClient) GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error) {
+	if tagging, ok := c.BucketsTags[*input.Bucket]; ok {
+		return &s3.GetBucketTaggingOutput{TagSet: tagging.TagSet}, nil
+	}
 	if *input.Bucket == "testBucket" {
 		return &s3.GetBucketTaggingOutput{
 			TagSet: []*s3.Tag{
@@ -125,9 +219,50 @@ func (c *mockAWSClient) GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.G
 	}, nil
 }
 
+// GetBucketVersioning implements the GetBucketVersioning method for mockAWSClient.
+func (c *mockAWSClient) GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error) {
+	output := &s3.GetBucketVersioningOutput{}
+	if status, ok := c.BucketsVersioning[*input.Bucket]; ok {
+		output.Status = aws.String(status)
+	}
+	return output, nil
+}
+
+// GetObject implements the GetObject method for mockAWSClient.
+// Only the cluster marker object is supported. "testBucket" has the marker of
+// the test cluster unless another was written.
+func (c *mockAWSClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if *input.Key != storageBase.ClusterMarkerKey {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist", nil)
+	}
+	data, ok := c.BucketsMarkers[*input.Bucket]
+	if !ok && *input.Bucket == "testBucket" {
+		data, _ = (&storageBase.ClusterMarker{InfraName: clusterInfraName, ClusterID: clusterID}).Marshal()
+		ok = true
+	}
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+// GetObjectLockConfiguration implements the GetObjectLockConfiguration method for mockAWSClient.
+func (c *mockAWSClient) GetObjectLockConfiguration(
+	input *s3.GetObjectLockConfigurationInput) (*s3.GetObjectLockConfigurationOutput, error) {
+	config, ok := c.BucketsObjectLock[*input.Bucket]
+	if !ok {
+		return nil, awserr.New("ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket", nil)
+	}
+	return &s3.GetObjectLockConfigurationOutput{ObjectLockConfiguration: config}, nil
+}
+
 // GetPublicAccessBlock implements the GetPublicAccessBlock method for mockAWSClient.
 func (c *mockAWSClient) GetPublicAccessBlock(input *s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error) {
-	return c.s3Client.GetPublicAccessBlock(input)
+	config, ok := c.BucketsAccess[*input.Bucket]
+	if !ok {
+		return nil, awserr.New("NoSuchPublicAccessBlockConfiguration", "The public access block configuration was not found", nil)
+	}
+	return &s3.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: config}, nil
 }
 
 // ListBuckets implements the ListBuckets method for mockAWSClient.
@@ -138,15 +273,48 @@ func (c *mockAWSClient) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBuckets
 	}, nil
 }
 
+// ListObjectVersions implements the ListObjectVersions method for mockAWSClient.
+// Objects are listed in pages of mockListPageSize, starting after the KeyMarker.
+func (c *mockAWSClient) ListObjectVersions(input *s3.ListObjectVersionsInput) (*s3.ListObjectVersionsOutput, error) {
+	if _, err := c.HeadBucket(&s3.HeadBucketInput{Bucket: input.Bucket}); err != nil {
+		return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	output := &s3.ListObjectVersionsOutput{IsTruncated: aws.Bool(false)}
+	for _, key := range c.BucketsObjects[*input.Bucket] {
+		if input.KeyMarker != nil && key <= *input.KeyMarker {
+			continue
+		}
+		if len(output.Versions) == mockListPageSize {
+			output.IsTruncated = aws.Bool(true)
+			output.NextKeyMarker = output.Versions[mockListPageSize-1].Key
+			break
+		}
+		output.Versions = append(output.Versions, &s3.ObjectVersion{Key: aws.String(key), VersionId: aws.String("null")})
+	}
+	return output, nil
+}
+
 // PutBucketEncryption implements the PutBucketEncryption method for mockAWSClient.
 func (c *mockAWSClient) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
-	return c.s3Client.PutBucketEncryption(input)
+	c.BucketsEncryption[*input.Bucket] = input.ServerSideEncryptionConfiguration
+	return &s3.PutBucketEncryptionOutput{}, nil
 }
 
 // PutBucketLifecycleConfiguration implements the PutBucketLifecycleConfiguration method for mockAWSClient.
 func (c *mockAWSClient) PutBucketLifecycleConfiguration(
 	input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
-	return c.s3Client.PutBucketLifecycleConfiguration(input)
+	c.BucketsLifecycle[*input.Bucket] = input.LifecycleConfiguration
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+// PutBucketReplication implements the PutBucketReplication method for mockAWSClient.
+// Like S3, it only accepts a configuration for a bucket with versioning enabled.
+func (c *mockAWSClient) PutBucketReplication(input *s3.PutBucketReplicationInput) (*s3.PutBucketReplicationOutput, error) {
+	if c.BucketsVersioning[*input.Bucket] != s3.BucketVersioningStatusEnabled {
+		return nil, awserr.New("InvalidRequest", "Versioning must be 'Enabled' on the bucket to apply a replication configuration", nil)
+	}
+	c.BucketsReplication[*input.Bucket] = input.ReplicationConfiguration
+	return &s3.PutBucketReplicationOutput{}, nil
 }
 
 // PutBucketTagging implements the PutBucketTagging method for mockAWSClient.
@@ -155,18 +323,61 @@ func (c *mockAWSClient) PutBucketTagging(input *s3.PutBucketTaggingInput) (*s3.P
 	return &s3.PutBucketTaggingOutput{}, nil
 }
 
+// PutBucketVersioning implements the PutBucketVersioning method for mockAWSClient.
+func (c *mockAWSClient) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	c.BucketsVersioning[*input.Bucket] = *input.VersioningConfiguration.Status
+	return &s3.PutBucketVersioningOutput{}, nil
+}
+
+// PutObject implements the PutObject method for mockAWSClient.
+// Only the cluster marker object is supported.
+func (c *mockAWSClient) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if *input.Key != storageBase.ClusterMarkerKey {
+		return nil, awserr.New("NotImplemented", "Only the cluster marker can be written", nil)
+	}
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.BucketsMarkers[*input.Bucket] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+// PutObjectLockConfiguration implements the PutObjectLockConfiguration method for mockAWSClient.
+// Like S3, it only accepts a configuration for a bucket created with Object Lock enabled.
+func (c *mockAWSClient) PutObjectLockConfiguration(
+	input *s3.PutObjectLockConfigurationInput) (*s3.PutObjectLockConfigurationOutput, error) {
+	if _, ok := c.BucketsObjectLock[*input.Bucket]; !ok {
+		return nil, awserr.New("InvalidBucketState", "Object Lock configuration cannot be enabled on existing buckets", nil)
+	}
+	c.BucketsObjectLock[*input.Bucket] = input.ObjectLockConfiguration
+	return &s3.PutObjectLockConfigurationOutput{}, nil
+}
+
 // PutPublicAccessBlock implements the PutPublicAccessBlock method for mockAWSClient.
 func (c *mockAWSClient) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
-	return c.s3Client.PutPublicAccessBlock(input)
+	c.BucketsAccess[*input.Bucket] = input.PublicAccessBlockConfiguration
+	return &s3.PutPublicAccessBlockOutput{}, nil
 }
 
 func TestFindMatchingTags(t *testing.T) {
+	matchingTags := []*s3.Tag{
+		{
+			Key:   aws.String(bucketTagBackupLocation),
+			Value: aws.String(storageConstants.DefaultVeleroBackupStorageLocation),
+		},
+		{
+			Key:   aws.String(bucketTagInfraName),
+			Value: aws.String(clusterInfraName),
+		},
+	}
 
 	tests := []struct {
 		name       string
 		bucketinfo map[string][]*s3.Tag
+		created    map[string]time.Time
 		infraName  string
-		want       string
+		want       []string
 	}{
 		// This tests the case of having buckets that don't match our cluster's name.
 		// Since this bucket belongs to a different cluster, we want the function to return "",
@@ -186,7 +397,7 @@ func TestFindMatchingTags(t *testing.T) {
 					},
 				},
 			},
-			want: "",
+			want: nil,
 		},
 		// This tests the case of having a bucket with a matching infraName, indicating that
 		// the bucket belongs to our cluster. We expect the name of the bucket returned.
@@ -205,7 +416,7 @@ func TestFindMatchingTags(t *testing.T) {
 					},
 				},
 			},
-			want: "bucket1",
+			want: []string{"bucket1"},
 		},
 		// This tests the case of two buckets. The first bucket should not match.
 		// The name of the second bucket should be returned.
@@ -234,14 +445,79 @@ func TestFindMatchingTags(t *testing.T) {
 					},
 				},
 			},
-			want: "bucket2",
+			want: []string{"bucket2"},
+		},
+		// This tests the case of the cluster's tag and the backup location tag
+		// being on different buckets. Neither bucket should match.
+		{
+			name:      "Tags split across two buckets.",
+			infraName: clusterInfraName,
+			bucketinfo: map[string][]*s3.Tag{
+				"bucket1": {
+					{
+						Key:   aws.String(bucketTagInfraName),
+						Value: aws.String(clusterInfraName),
+					},
+				},
+				"bucket2": {
+					{
+						Key:   aws.String(bucketTagBackupLocation),
+						Value: aws.String(storageConstants.DefaultVeleroBackupStorageLocation),
+					},
+				},
+			},
+			want: nil,
+		},
+		// This tests the case of the replica bucket, which is tagged with a
+		// different backup location. It should not be recovered.
+		{
+			name:      "Replica bucket doesn't match.",
+			infraName: clusterInfraName,
+			bucketinfo: map[string][]*s3.Tag{
+				"bucket1": {
+					{
+						Key:   aws.String(bucketTagBackupLocation),
+						Value: aws.String(storageConstants.ReplicaVeleroBackupStorageLocation),
+					},
+					{
+						Key:   aws.String(bucketTagInfraName),
+						Value: aws.String(clusterInfraName),
+					},
+				},
+			},
+			want: nil,
+		},
+		// This tests the case of several buckets matching. They should be
+		// ranked newest first, and by name when created at the same time.
+		{
+			name:      "Several buckets match.",
+			infraName: clusterInfraName,
+			bucketinfo: map[string][]*s3.Tag{
+				"bucket1": matchingTags,
+				"bucket2": matchingTags,
+				"bucket3": matchingTags,
+			},
+			created: map[string]time.Time{
+				"bucket1": time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				"bucket2": time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+				"bucket3": time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			want: []string{"bucket2", "bucket1", "bucket3"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := FindMatchingTags(tt.bucketinfo, tt.infraName)
-			if got != tt.want {
+			// List the buckets in reverse order of name, so that the
+			// ranking doesn't depend on the order they were listed in
+			var buckets []*s3.Bucket
+			for name := range tt.bucketinfo {
+				buckets = append(buckets, &s3.Bucket{Name: aws.String(name), CreationDate: aws.Time(tt.created[name])})
+			}
+			sort.Slice(buckets, func(i, j int) bool { return *buckets[i].Name > *buckets[j].Name })
+
+			got := FindMatchingTags(buckets, tt.bucketinfo, tt.infraName)
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("FindMatchingTags() = %v, want %v", got, tt.want)
 			}
 		})
@@ -292,7 +568,7 @@ func TestCreateBucket(t *testing.T) {
 				tt.args.s3Client.GetAWSClientConfig().Region = tt.args.region
 				expectLocation = *tt.args.region
 			}
-			if err := CreateBucket(tt.args.s3Client, tt.args.bucketName); (err != nil) != tt.wantErr {
+			if err := CreateBucket(tt.args.s3Client, tt.args.bucketName, false); (err != nil) != tt.wantErr {
 				t.Errorf("CreateBucket() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			gblOut, err := tt.args.s3Client.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String("testBucket")})
@@ -356,6 +632,7 @@ func TestListBucketsInRegion(t *testing.T) {
 	type args struct {
 		s3Client Client
 		region   string
+		prefix   string
 	}
 	tests := []struct {
 		name    string
@@ -392,6 +669,18 @@ func TestListBucketsInRegion(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Do not include buckets without the prefix",
+			args: args{
+				s3Client: fakeClient,
+				region:   region,
+				prefix:   "managed-velero-backups-",
+			},
+			want: &s3.ListBucketsOutput{
+				Buckets: []*s3.Bucket{},
+				Owner:   &s3.Owner{},
+			},
+		},
 		{
 			name: "Do not include buckets that return NotFound",
 			args: args{
@@ -411,7 +700,7 @@ func TestListBucketsInRegion(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := ListBucketsInRegion(tt.args.s3Client, tt.args.region)
+			got, err := ListBucketsInRegion(tt.args.s3Client, tt.args.region, tt.args.prefix)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ListBucketsInRegion() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -487,3 +776,789 @@ func TestListBucketTags(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryThrottled(t *testing.T) {
+	defer func(delay time.Duration) { throttleRetryDelay = delay }(throttleRetryDelay)
+	throttleRetryDelay = 0
+
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{
+			name:      "success is not retried",
+			wantCalls: 1,
+		},
+		{
+			name:      "throttled requests are retried",
+			err:       awserr.New("SlowDown", "Please reduce your request rate.", nil),
+			wantCalls: maxThrottleRetries + 1,
+		},
+		{
+			name:      "too many requests are retried",
+			err:       awserr.NewRequestFailure(awserr.New("TooManyRequests", "Too Many Requests", nil), http.StatusTooManyRequests, ""),
+			wantCalls: maxThrottleRetries + 1,
+		},
+		{
+			name:      "other errors are not retried",
+			err:       awserr.New("AccessDenied", "Access Denied", nil),
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryThrottled(func() error {
+				calls++
+				return tt.err
+			})
+			if err != tt.err {
+				t.Errorf("retryThrottled() error = %v, want %v", err, tt.err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("retryThrottled() made %v calls, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryThrottledRecovers(t *testing.T) {
+	defer func(delay time.Duration) { throttleRetryDelay = delay }(throttleRetryDelay)
+	throttleRetryDelay = 0
+
+	calls := 0
+	err := retryThrottled(func() error {
+		calls++
+		if calls < 3 {
+			return awserr.New("SlowDown", "Please reduce your request rate.", nil)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retryThrottled() error = %v after %v calls, want success after 3 calls", err, calls)
+	}
+}
+
+func TestFilterBucketsByPrefix(t *testing.T) {
+	buckets := []*s3.Bucket{
+		{Name: aws.String("managed-velero-backups-1")},
+		{Name: aws.String("someone-elses-bucket")},
+		{Name: aws.String("managed-velero-backups-2")},
+		{Name: aws.String("custom-3")},
+	}
+	got := FilterBucketsByPrefix(buckets, "managed-velero-backups-")
+	if len(got) != 2 || *got[0].Name != "managed-velero-backups-1" || *got[1].Name != "managed-velero-backups-2" {
+		t.Errorf("FilterBucketsByPrefix() = %v", got)
+	}
+	got = FilterBucketsByPrefix(buckets, "custom-", "managed-velero-backups-")
+	if len(got) != 3 || *got[2].Name != "custom-3" {
+		t.Errorf("FilterBucketsByPrefix() with several prefixes = %v", got)
+	}
+}
+
+// slowAWSClient is a mockAWSClient for an account holding many buckets, where
+// each discovery request takes as long as a round trip to AWS.
+type slowAWSClient struct {
+	*mockAWSClient
+	latency time.Duration
+}
+
+// newSlowAWSClient creates a slowAWSClient with count buckets, one in every ten
+// of which has the default name prefix and is in the test region.
+func newSlowAWSClient(count int, latency time.Duration) *slowAWSClient {
+	buckets := make([]*s3.Bucket, count)
+	for i := range buckets {
+		name := fmt.Sprintf("other-bucket-%d", i)
+		if i%10 == 0 {
+			name = fmt.Sprintf("managed-velero-backups-%d", i)
+		}
+		buckets[i] = &s3.Bucket{Name: aws.String(name), CreationDate: &time.Time{}}
+	}
+	return &slowAWSClient{mockAWSClient: newMockAWSClient(buckets), latency: latency}
+}
+
+func (c *slowAWSClient) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	time.Sleep(c.latency)
+	return &s3.GetBucketLocationOutput{LocationConstraint: aws.String(region)}, nil
+}
+
+func (c *slowAWSClient) GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error) {
+	time.Sleep(c.latency)
+	return c.mockAWSClient.GetBucketTagging(input)
+}
+
+func TestListBucketsInRegionLargeAccount(t *testing.T) {
+	client := newSlowAWSClient(1000, 0)
+	result, err := ListBucketsInRegion(client, region, "managed-velero-backups-")
+	if err != nil {
+		t.Fatalf("ListBucketsInRegion() error = %v", err)
+	}
+	if len(result.Buckets) != 100 {
+		t.Errorf("ListBucketsInRegion() returned %v buckets, want 100", len(result.Buckets))
+	}
+	// Results keep the order the buckets were listed in
+	for i, bucket := range result.Buckets {
+		if want := fmt.Sprintf("managed-velero-backups-%d", i*10); *bucket.Name != want {
+			t.Fatalf("ListBucketsInRegion() bucket %v = %v, want %v", i, *bucket.Name, want)
+		}
+	}
+}
+
+func BenchmarkBucketDiscovery(b *testing.B) {
+	client := newSlowAWSClient(5000, time.Millisecond)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := ListBucketsInRegion(client, region, "managed-velero-backups-")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ListBucketTags(client, result.Buckets); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncryptBucket(t *testing.T) {
+	tests := []struct {
+		name             string
+		sseAlgorithm     string
+		kmsKeyID         string
+		wantBucketKey    bool
+		wantKMSMasterKey string
+	}{
+		{
+			name:         "Encrypt with S3 managed keys",
+			sseAlgorithm: s3.ServerSideEncryptionAes256,
+		},
+		{
+			name:          "Encrypt with KMS managed keys",
+			sseAlgorithm:  s3.ServerSideEncryptionAwsKms,
+			wantBucketKey: true,
+		},
+		{
+			name:             "Encrypt with a customer managed key",
+			sseAlgorithm:     s3.ServerSideEncryptionAwsKms,
+			kmsKeyID:         "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			wantBucketKey:    true,
+			wantKMSMasterKey: "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockAWSClient(validBuckets)
+			if err := EncryptBucket(client, "testBucket", tt.sseAlgorithm, tt.kmsKeyID); err != nil {
+				t.Fatalf("EncryptBucket() error = %v", err)
+			}
+			rule := client.BucketsEncryption["testBucket"].Rules[0]
+			if got := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm); got != tt.sseAlgorithm {
+				t.Errorf("EncryptBucket() SSEAlgorithm = %v, want %v", got, tt.sseAlgorithm)
+			}
+			if got := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID); got != tt.wantKMSMasterKey {
+				t.Errorf("EncryptBucket() KMSMasterKeyID = %v, want %v", got, tt.wantKMSMasterKey)
+			}
+			if got := aws.BoolValue(rule.BucketKeyEnabled); got != tt.wantBucketKey {
+				t.Errorf("EncryptBucket() BucketKeyEnabled = %v, want %v", got, tt.wantBucketKey)
+			}
+		})
+	}
+}
+
+func TestIsKMSKey(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	keyID := "1234abcd-12ab-34cd-56ef-1234567890ab"
+	tests := []struct {
+		name        string
+		bucketKeyID string
+		kmsKeyID    string
+		want        bool
+	}{
+		{name: "no key required", bucketKeyID: keyARN, want: true},
+		{name: "same key ARN", bucketKeyID: keyARN, kmsKeyID: keyARN, want: true},
+		{name: "key ID of the bucket key ARN", bucketKeyID: keyARN, kmsKeyID: keyID, want: true},
+		{name: "key ARN of the bucket key ID", bucketKeyID: keyID, kmsKeyID: keyARN, want: true},
+		{name: "AWS managed key", kmsKeyID: keyID},
+		{name: "another key", bucketKeyID: "arn:aws:kms:us-east-1:123456789012:key/other", kmsKeyID: keyID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKMSKey(tt.bucketKeyID, tt.kmsKeyID); got != tt.want {
+				t.Errorf("isKMSKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetBucketLifecycle(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	if err := SetBucketLifecycle(client, "testBucket", 30, 0); err != nil {
+		t.Fatalf("SetBucketLifecycle() error = %v", err)
+	}
+	rules := client.BucketsLifecycle["testBucket"].Rules
+	if len(rules) != 1 {
+		t.Fatalf("SetBucketLifecycle() got %d rules, want 1", len(rules))
+	}
+	if got := *rules[0].Expiration.Days; got != 30 {
+		t.Errorf("SetBucketLifecycle() expiration days = %v, want 30", got)
+	}
+}
+
+func TestSetBucketLifecycleNoncurrentVersions(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	if err := SetBucketLifecycle(client, "testBucket", 30, 7); err != nil {
+		t.Fatalf("SetBucketLifecycle() error = %v", err)
+	}
+	rules := client.BucketsLifecycle["testBucket"].Rules
+	if len(rules) != 2 {
+		t.Fatalf("SetBucketLifecycle() got %d rules, want 2", len(rules))
+	}
+	if got := *rules[1].NoncurrentVersionExpiration.NoncurrentDays; got != 7 {
+		t.Errorf("SetBucketLifecycle() noncurrent version expiration days = %v, want 7", got)
+	}
+	if !*rules[1].Expiration.ExpiredObjectDeleteMarker {
+		t.Errorf("SetBucketLifecycle() expired delete markers are not removed")
+	}
+	if rules[1].AbortIncompleteMultipartUpload == nil {
+		t.Errorf("SetBucketLifecycle() incomplete multipart uploads are not aborted")
+	}
+}
+
+func TestIsBucketVersioned(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	versioned, err := IsBucketVersioned(client, "testBucket")
+	if err != nil {
+		t.Fatalf("IsBucketVersioned() error = %v", err)
+	}
+	if versioned {
+		t.Errorf("IsBucketVersioned() = true before versioning was enabled")
+	}
+
+	if err := EnableBucketVersioning(client, "testBucket"); err != nil {
+		t.Fatalf("EnableBucketVersioning() error = %v", err)
+	}
+	versioned, err = IsBucketVersioned(client, "testBucket")
+	if err != nil {
+		t.Fatalf("IsBucketVersioned() error = %v", err)
+	}
+	if !versioned {
+		t.Errorf("IsBucketVersioned() = false after versioning was enabled")
+	}
+}
+
+func TestSetBucketObjectLock(t *testing.T) {
+	tests := []struct {
+		name       string
+		objectLock bool
+		wantErr    bool
+	}{
+		{
+			name:       "bucket created with object lock",
+			objectLock: true,
+		},
+		{
+			name:    "bucket created without object lock",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockAWSClient(validBuckets)
+			if err := CreateBucket(client, "testBucket", tt.objectLock); err != nil {
+				t.Fatalf("CreateBucket() error = %v", err)
+			}
+			if err := EnableBucketVersioning(client, "testBucket"); err != nil {
+				t.Fatalf("EnableBucketVersioning() error = %v", err)
+			}
+			err := SetBucketObjectLock(client, "testBucket", s3.ObjectLockRetentionModeCompliance, 30)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetBucketObjectLock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			config, err := GetBucketObjectLock(client, "testBucket")
+			if err != nil {
+				t.Fatalf("GetBucketObjectLock() error = %v", err)
+			}
+			if (config != nil) != tt.objectLock {
+				t.Errorf("GetBucketObjectLock() = %v, want object lock enabled %v", config, tt.objectLock)
+			}
+			if got := IsBucketLocked(config, s3.ObjectLockRetentionModeCompliance, 30); got != !tt.wantErr {
+				t.Errorf("IsBucketLocked() = %v, want %v", got, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsBucketLocked(t *testing.T) {
+	locked := func(mode string, days, years int64) *s3.ObjectLockConfiguration {
+		retention := &s3.DefaultRetention{Mode: aws.String(mode)}
+		if days > 0 {
+			retention.Days = aws.Int64(days)
+		}
+		if years > 0 {
+			retention.Years = aws.Int64(years)
+		}
+		return &s3.ObjectLockConfiguration{
+			ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled),
+			Rule:              &s3.ObjectLockRule{DefaultRetention: retention},
+		}
+	}
+	tests := []struct {
+		name   string
+		config *s3.ObjectLockConfiguration
+		want   bool
+	}{
+		{name: "not enabled"},
+		{name: "no default retention", config: &s3.ObjectLockConfiguration{ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled)}},
+		{name: "same retention", config: locked(s3.ObjectLockRetentionModeGovernance, 30, 0), want: true},
+		{name: "longer retention", config: locked(s3.ObjectLockRetentionModeGovernance, 0, 1), want: true},
+		{name: "shorter retention", config: locked(s3.ObjectLockRetentionModeGovernance, 7, 0)},
+		{name: "another mode", config: locked(s3.ObjectLockRetentionModeCompliance, 30, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBucketLocked(tt.config, s3.ObjectLockRetentionModeGovernance, 30); got != tt.want {
+				t.Errorf("IsBucketLocked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetBucketReplication(t *testing.T) {
+	replicaBucketARN := "arn:aws:s3:::replicaBucket"
+	client := newMockAWSClient(validBuckets)
+
+	replicated, err := IsBucketReplicated(client, "testBucket", replicaBucketARN)
+	if err != nil {
+		t.Fatalf("IsBucketReplicated() error = %v", err)
+	}
+	if replicated {
+		t.Errorf("IsBucketReplicated() = true before replication was configured")
+	}
+
+	roleARN := "arn:aws:iam::123456789012:role/fakeCluster-velero-replication"
+	if err := SetBucketReplication(client, "testBucket", roleARN, replicaBucketARN, ""); err == nil {
+		t.Errorf("SetBucketReplication() succeeded on a bucket without versioning")
+	}
+	if err := EnableBucketVersioning(client, "testBucket"); err != nil {
+		t.Fatalf("EnableBucketVersioning() error = %v", err)
+	}
+	replicaKMSKeyARN := "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	if err := SetBucketReplication(client, "testBucket", roleARN, replicaBucketARN, replicaKMSKeyARN); err != nil {
+		t.Fatalf("SetBucketReplication() error = %v", err)
+	}
+	rule := client.BucketsReplication["testBucket"].Rules[0]
+	if got := aws.StringValue(rule.Destination.EncryptionConfiguration.ReplicaKmsKeyID); got != replicaKMSKeyARN {
+		t.Errorf("SetBucketReplication() replica key = %v, want %v", got, replicaKMSKeyARN)
+	}
+
+	replicated, err = IsBucketReplicated(client, "testBucket", replicaBucketARN)
+	if err != nil {
+		t.Fatalf("IsBucketReplicated() error = %v", err)
+	}
+	if !replicated {
+		t.Errorf("IsBucketReplicated() = false after replication was configured")
+	}
+	replicated, err = IsBucketReplicated(client, "testBucket", "arn:aws:s3:::otherBucket")
+	if err != nil {
+		t.Fatalf("IsBucketReplicated() error = %v", err)
+	}
+	if replicated {
+		t.Errorf("IsBucketReplicated() = true for another replica bucket")
+	}
+
+	if err := RemoveBucketReplication(client, "testBucket"); err != nil {
+		t.Fatalf("RemoveBucketReplication() error = %v", err)
+	}
+	replicated, err = IsBucketReplicated(client, "testBucket", replicaBucketARN)
+	if err != nil {
+		t.Fatalf("IsBucketReplicated() error = %v", err)
+	}
+	if replicated {
+		t.Errorf("IsBucketReplicated() = true after replication was removed")
+	}
+}
+
+func TestGetBucketRegion(t *testing.T) {
+	got, err := GetBucketRegion(fakeClient, "testBucket")
+	if err != nil {
+		t.Fatalf("GetBucketRegion() error = %v", err)
+	}
+	if got != region {
+		t.Errorf("GetBucketRegion() = %v, want %v", got, region)
+	}
+	if _, err := GetBucketRegion(fakeClient, "missingBucket"); err == nil {
+		t.Errorf("GetBucketRegion() expected an error for a missing bucket")
+	}
+}
+
+func TestEnsureBucketLifecycleReadBack(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	// S3 returns the rules in its own order, with the whole-bucket filter
+	// left empty and the prefix of the backups rule nested in an And filter
+	client.BucketsLifecycle["testBucket"] = &s3.BucketLifecycleConfiguration{
+		Rules: []*s3.LifecycleRule{
+			{
+				ID:     aws.String("Noncurrent Version Expiry"),
+				Status: aws.String(s3.ExpirationStatusEnabled),
+				Filter: &s3.LifecycleRuleFilter{},
+				Expiration: &s3.LifecycleExpiration{
+					ExpiredObjectDeleteMarker: aws.Bool(true),
+				},
+				NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(30)},
+				AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{
+					DaysAfterInitiation: aws.Int64(storageConstants.AbortIncompleteMultipartUploadDays),
+				},
+			},
+			{
+				ID:         aws.String("Backup Expiry"),
+				Status:     aws.String(s3.ExpirationStatusEnabled),
+				Filter:     &s3.LifecycleRuleFilter{And: &s3.LifecycleRuleAndOperator{Prefix: aws.String("backups/")}},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(90)},
+			},
+		},
+	}
+	updated, err := EnsureBucketLifecycle(client, "testBucket", 90, 30)
+	if err != nil {
+		t.Fatalf("EnsureBucketLifecycle() error = %v", err)
+	}
+	if updated {
+		t.Errorf("EnsureBucketLifecycle() updated equivalent rules")
+	}
+}
+
+func TestFindBucketPolicyGaps(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(client *mockAWSClient)
+		wantGaps  int
+	}{
+		{
+			name:     "bucket without any policy",
+			wantGaps: 4,
+		},
+		{
+			name: "bucket with the enforced policy",
+			configure: func(client *mockAWSClient) {
+				_ = EncryptBucket(client, "testBucket", s3.ServerSideEncryptionAes256, "")
+				_ = BlockBucketPublicAccess(client, "testBucket")
+				_ = EnableBucketVersioning(client, "testBucket")
+				_ = SetBucketLifecycle(client, "testBucket", 90, 30)
+			},
+		},
+		{
+			name: "backups kept longer than the retention period",
+			configure: func(client *mockAWSClient) {
+				_ = EncryptBucket(client, "testBucket", s3.ServerSideEncryptionAes256, "")
+				_ = BlockBucketPublicAccess(client, "testBucket")
+				_ = EnableBucketVersioning(client, "testBucket")
+				_ = SetBucketLifecycle(client, "testBucket", 365, 30)
+			},
+			wantGaps: 1,
+		},
+		{
+			name: "encrypted with a different algorithm and public access partially blocked",
+			configure: func(client *mockAWSClient) {
+				_ = EncryptBucket(client, "testBucket", s3.ServerSideEncryptionAwsKms, "")
+				client.BucketsAccess["testBucket"] = &s3.PublicAccessBlockConfiguration{BlockPublicAcls: aws.Bool(true)}
+				client.BucketsLifecycle["testBucket"] = &s3.BucketLifecycleConfiguration{
+					Rules: []*s3.LifecycleRule{
+						{
+							Status:     aws.String(s3.ExpirationStatusEnabled),
+							Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+							Expiration: &s3.LifecycleExpiration{Days: aws.Int64(30)},
+						},
+					},
+				}
+			},
+			wantGaps: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockAWSClient(validBuckets)
+			if tt.configure != nil {
+				tt.configure(client)
+			}
+			gaps, err := FindBucketPolicyGaps(client, "testBucket", s3.ServerSideEncryptionAes256, "", 90)
+			if err != nil {
+				t.Fatalf("FindBucketPolicyGaps() error = %v", err)
+			}
+			if len(gaps) != tt.wantGaps {
+				t.Errorf("FindBucketPolicyGaps() = %v, want %d gaps", gaps, tt.wantGaps)
+			}
+		})
+	}
+}
+
+func TestTagBucket(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	extraTags := map[string]string{
+		"cost-center":      "1234",
+		bucketTagInfraName: "overridden",
+	}
+	if err := TagBucket(client, "testBucket", storageConstants.DefaultVeleroBackupStorageLocation, clusterInfraName, extraTags); err != nil {
+		t.Fatalf("TagBucket() error = %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, tag := range client.BucketsTags["testBucket"].TagSet {
+		got[*tag.Key] = *tag.Value
+	}
+	want := map[string]string{
+		"cost-center":           "1234",
+		bucketTagBackupLocation: storageConstants.DefaultVeleroBackupStorageLocation,
+		bucketTagInfraName:      clusterInfraName,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TagBucket() tags = %v, want %v", got, want)
+	}
+}
+
+func TestTagBucketKeepsForeignTags(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	client.BucketsTags["testBucket"] = &s3.Tagging{
+		TagSet: []*s3.Tag{
+			{Key: aws.String("finance-owner"), Value: aws.String("team-a")},
+			{Key: aws.String(bucketTagOrphanedAt), Value: aws.String("2021-03-04T05:06:07Z")},
+		},
+	}
+	if err := TagBucket(client, "testBucket", storageConstants.DefaultVeleroBackupStorageLocation, clusterInfraName, map[string]string{"cost-center": "1234"}); err != nil {
+		t.Fatalf("TagBucket() error = %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, tag := range client.BucketsTags["testBucket"].TagSet {
+		got[*tag.Key] = *tag.Value
+	}
+	want := map[string]string{
+		"finance-owner":         "team-a",
+		"cost-center":           "1234",
+		bucketTagBackupLocation: storageConstants.DefaultVeleroBackupStorageLocation,
+		bucketTagInfraName:      clusterInfraName,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TagBucket() tags = %v, want %v", got, want)
+	}
+}
+
+func TestEnsureBucketSettings(t *testing.T) {
+	kmsKeyID := "1234abcd-12ab-34cd-56ef-1234567890ab"
+	extraTags := map[string]string{"cost-center": "1234"}
+	tests := []struct {
+		name   string
+		ensure func(Client) (bool, error)
+		drift  func(*mockAWSClient)
+	}{
+		{
+			name: "encryption",
+			ensure: func(client Client) (bool, error) {
+				return EnsureBucketEncryption(client, "testBucket", s3.ServerSideEncryptionAwsKms, kmsKeyID)
+			},
+			drift: func(client *mockAWSClient) {
+				client.BucketsEncryption["testBucket"].Rules[0].BucketKeyEnabled = aws.Bool(false)
+			},
+		},
+		{
+			name: "public access block",
+			ensure: func(client Client) (bool, error) {
+				return EnsureBucketPublicAccessBlock(client, "testBucket")
+			},
+			drift: func(client *mockAWSClient) {
+				client.BucketsAccess["testBucket"].BlockPublicPolicy = aws.Bool(false)
+			},
+		},
+		{
+			name: "versioning",
+			ensure: func(client Client) (bool, error) {
+				return EnsureBucketVersioning(client, "testBucket")
+			},
+			drift: func(client *mockAWSClient) {
+				client.BucketsVersioning["testBucket"] = s3.BucketVersioningStatusSuspended
+			},
+		},
+		{
+			name: "lifecycle",
+			ensure: func(client Client) (bool, error) {
+				return EnsureBucketLifecycle(client, "testBucket", 90, 30)
+			},
+			drift: func(client *mockAWSClient) {
+				client.BucketsLifecycle["testBucket"].Rules[0].Expiration.Days = aws.Int64(365)
+			},
+		},
+		{
+			name: "tags",
+			ensure: func(client Client) (bool, error) {
+				return EnsureBucketTags(client, "testBucket", storageConstants.DefaultVeleroBackupStorageLocation, clusterInfraName, extraTags)
+			},
+			drift: func(client *mockAWSClient) {
+				client.BucketsTags["testBucket"].TagSet = client.BucketsTags["testBucket"].TagSet[:1]
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockAWSClient(validBuckets)
+
+			// The setting is applied to a new bucket, and left alone once it is in place
+			for i, want := range []bool{true, false} {
+				updated, err := tt.ensure(client)
+				if err != nil {
+					t.Fatalf("pass %v: error = %v", i, err)
+				}
+				if updated != want {
+					t.Errorf("pass %v: updated = %v, want %v", i, updated, want)
+				}
+			}
+
+			// A setting that drifts is corrected
+			tt.drift(client)
+			updated, err := tt.ensure(client)
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if !updated {
+				t.Errorf("updated = false after the setting drifted")
+			}
+			if updated, _ = tt.ensure(client); updated {
+				t.Errorf("updated = true after the drift was corrected")
+			}
+		})
+	}
+}
+
+func TestEnsureBucketObjectLock(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	if err := CreateBucket(client, "testBucket", true); err != nil {
+		t.Fatalf("CreateBucket() error = %v", err)
+	}
+	for i, want := range []bool{true, false} {
+		updated, err := EnsureBucketObjectLock(client, "testBucket", s3.ObjectLockRetentionModeGovernance, 30)
+		if err != nil {
+			t.Fatalf("EnsureBucketObjectLock() pass %v error = %v", i, err)
+		}
+		if updated != want {
+			t.Errorf("EnsureBucketObjectLock() pass %v = %v, want %v", i, updated, want)
+		}
+	}
+	updated, err := EnsureBucketObjectLock(client, "testBucket", s3.ObjectLockRetentionModeGovernance, 7)
+	if err != nil {
+		t.Fatalf("EnsureBucketObjectLock() error = %v", err)
+	}
+	if !updated {
+		t.Errorf("EnsureBucketObjectLock() = false after the retention changed")
+	}
+}
+
+func TestIsBucketEncryptionCurrent(t *testing.T) {
+	encrypted := func(sseAlgorithm string, kmsKeyID string, bucketKey bool) *s3.ServerSideEncryptionConfiguration {
+		rule := &s3.ServerSideEncryptionRule{
+			ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String(sseAlgorithm)},
+			BucketKeyEnabled:                   aws.Bool(bucketKey),
+		}
+		if kmsKeyID != "" {
+			rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID = aws.String(kmsKeyID)
+		}
+		return &s3.ServerSideEncryptionConfiguration{Rules: []*s3.ServerSideEncryptionRule{rule}}
+	}
+	keyARN := "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	tests := []struct {
+		name         string
+		config       *s3.ServerSideEncryptionConfiguration
+		sseAlgorithm string
+		kmsKeyID     string
+		want         bool
+	}{
+		{name: "not encrypted", sseAlgorithm: s3.ServerSideEncryptionAes256},
+		{name: "SSE-S3", config: encrypted(s3.ServerSideEncryptionAes256, "", false), sseAlgorithm: s3.ServerSideEncryptionAes256, want: true},
+		{name: "SSE-KMS instead of SSE-S3", config: encrypted(s3.ServerSideEncryptionAwsKms, "", true), sseAlgorithm: s3.ServerSideEncryptionAes256},
+		{name: "AWS-managed key", config: encrypted(s3.ServerSideEncryptionAwsKms, "", true), sseAlgorithm: s3.ServerSideEncryptionAwsKms, want: true},
+		{name: "customer key instead of AWS-managed key", config: encrypted(s3.ServerSideEncryptionAwsKms, keyARN, true), sseAlgorithm: s3.ServerSideEncryptionAwsKms},
+		{name: "key ID matches key ARN", config: encrypted(s3.ServerSideEncryptionAwsKms, keyARN, true), sseAlgorithm: s3.ServerSideEncryptionAwsKms,
+			kmsKeyID: "1234abcd-12ab-34cd-56ef-1234567890ab", want: true},
+		{name: "bucket key disabled", config: encrypted(s3.ServerSideEncryptionAwsKms, keyARN, false), sseAlgorithm: s3.ServerSideEncryptionAwsKms, kmsKeyID: keyARN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBucketEncryptionCurrent(tt.config, tt.sseAlgorithm, tt.kmsKeyID); got != tt.want {
+				t.Errorf("isBucketEncryptionCurrent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkBucketOrphaned(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	orphanedAt := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+	if err := MarkBucketOrphaned(client, "testBucket", orphanedAt); err != nil {
+		t.Fatalf("MarkBucketOrphaned() error = %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, tag := range client.BucketsTags["testBucket"].TagSet {
+		got[*tag.Key] = *tag.Value
+	}
+	want := map[string]string{
+		bucketTagBackupLocation: storageConstants.DefaultVeleroBackupStorageLocation,
+		bucketTagInfraName:      clusterInfraName,
+		bucketTagOrphanedAt:     "2021-03-04T05:06:07Z",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MarkBucketOrphaned() tags = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteBucket(t *testing.T) {
+	tests := []struct {
+		name       string
+		bucketName string
+		objects    []string
+	}{
+		{
+			name:       "delete empty bucket",
+			bucketName: "testBucket",
+		},
+		{
+			name:       "empty and delete bucket over several listing pages",
+			bucketName: "testBucket",
+			objects:    []string{"backups/a", "backups/b", "backups/c", "backups/d", "backups/e"},
+		},
+		{
+			name:       "bucket already deleted",
+			bucketName: "missingBucket",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockAWSClient([]*s3.Bucket{{Name: aws.String("testBucket")}})
+			client.BucketsObjects["testBucket"] = tt.objects
+			if err := DeleteBucket(client, tt.bucketName); err != nil {
+				t.Fatalf("DeleteBucket() error = %v", err)
+			}
+			if len(client.BucketsObjects["testBucket"]) != 0 {
+				t.Errorf("DeleteBucket() left objects %v", client.BucketsObjects["testBucket"])
+			}
+			for _, bucket := range client.Buckets {
+				if *bucket.Name == tt.bucketName {
+					t.Errorf("DeleteBucket() left bucket %v", tt.bucketName)
+				}
+			}
+		})
+	}
+}
+
+// deniedAWSClient is a mockAWSClient whose objects can't be read, as when
+// they are encrypted with a KMS key that the operator can't use
+type deniedAWSClient struct {
+	*mockAWSClient
+}
+
+func (c *deniedAWSClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return nil, awserr.New("AccessDenied", "Access Denied", nil)
+}
+
+func TestGetClusterMarkerAccessDenied(t *testing.T) {
+	_, err := GetClusterMarker(&deniedAWSClient{newMockAWSClient(validBuckets)}, "testBucket")
+	if !errors.Is(err, storageBase.ErrClusterMarkerAccessDenied) {
+		t.Errorf("GetClusterMarker() error = %v, want %v", err, storageBase.ErrClusterMarkerAccessDenied)
+	}
+}
@@ -1,18 +1,19 @@
 package s3
 
 import (
-	"context"
 	"fmt"
+	"strings"
 
-	"github.com/openshift/managed-velero-operator/config"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
 	"github.com/openshift/managed-velero-operator/version"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -21,10 +22,11 @@ import (
 const (
 	awsCredsSecretIDKey     = "aws_access_key_id"     // #nosec G101
 	awsCredsSecretAccessKey = "aws_secret_access_key" // #nosec G101
+	awsCredsSecretFileKey   = "credentials"           // #nosec G101
 )
 
 var (
-	awsCredsSecretName = version.OperatorName + "-iam-credentials"
+	awsCredsSecretName = storageBase.CredentialsSecretName
 )
 
 // awsClient implements the Client interface.
@@ -36,16 +38,29 @@ type awsClient struct {
 // Client is a wrapper object for the actual AWS SDK client to allow for easier testing.
 type Client interface {
 	CreateBucket(*s3.CreateBucketInput) (*s3.CreateBucketOutput, error)
-	DeleteBucketTagging(*s3.DeleteBucketTaggingInput) (*s3.DeleteBucketTaggingOutput, error)
+	DeleteBucket(*s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error)
+	DeleteBucketReplication(*s3.DeleteBucketReplicationInput) (*s3.DeleteBucketReplicationOutput, error)
+	DeleteObjects(*s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error)
 	HeadBucket(*s3.HeadBucketInput) (*s3.HeadBucketOutput, error)
 	GetAWSClientConfig() *aws.Config
+	GetBucketEncryption(*s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error)
+	GetBucketLifecycleConfiguration(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error)
 	GetBucketLocation(*s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error)
+	GetBucketReplication(*s3.GetBucketReplicationInput) (*s3.GetBucketReplicationOutput, error)
 	GetBucketTagging(*s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error)
+	GetBucketVersioning(*s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error)
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	GetObjectLockConfiguration(*s3.GetObjectLockConfigurationInput) (*s3.GetObjectLockConfigurationOutput, error)
 	GetPublicAccessBlock(*s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error)
 	ListBuckets(*s3.ListBucketsInput) (*s3.ListBucketsOutput, error)
+	ListObjectVersions(*s3.ListObjectVersionsInput) (*s3.ListObjectVersionsOutput, error)
 	PutBucketEncryption(*s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error)
 	PutBucketLifecycleConfiguration(*s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error)
+	PutBucketReplication(*s3.PutBucketReplicationInput) (*s3.PutBucketReplicationOutput, error)
 	PutBucketTagging(*s3.PutBucketTaggingInput) (*s3.PutBucketTaggingOutput, error)
+	PutBucketVersioning(*s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error)
+	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	PutObjectLockConfiguration(*s3.PutObjectLockConfigurationInput) (*s3.PutObjectLockConfigurationOutput, error)
 	PutPublicAccessBlock(*s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error)
 }
 
@@ -56,9 +71,19 @@ func (c *awsClient) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketO
 	return c.s3Client.CreateBucket(input)
 }
 
-// DeleteBucketTagging implements the DeleteBucketTagging method for awsClient.
-func (c *awsClient) DeleteBucketTagging(input *s3.DeleteBucketTaggingInput) (*s3.DeleteBucketTaggingOutput, error) {
-	return c.s3Client.DeleteBucketTagging(input)
+// DeleteBucket implements the DeleteBucket method for awsClient.
+func (c *awsClient) DeleteBucket(input *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	return c.s3Client.DeleteBucket(input)
+}
+
+// DeleteBucketReplication implements the DeleteBucketReplication method for awsClient.
+func (c *awsClient) DeleteBucketReplication(input *s3.DeleteBucketReplicationInput) (*s3.DeleteBucketReplicationOutput, error) {
+	return c.s3Client.DeleteBucketReplication(input)
+}
+
+// DeleteObjects implements the DeleteObjects method for awsClient.
+func (c *awsClient) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	return c.s3Client.DeleteObjects(input)
 }
 
 // GetAWSClientConfig returns a copy of the AWS Client Config for the awsClient.
@@ -71,16 +96,48 @@ func (c *awsClient) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput,
 	return c.s3Client.HeadBucket(input)
 }
 
+// GetBucketEncryption implements the GetBucketEncryption method for awsClient.
+func (c *awsClient) GetBucketEncryption(input *s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error) {
+	return c.s3Client.GetBucketEncryption(input)
+}
+
+// GetBucketLifecycleConfiguration implements the GetBucketLifecycleConfiguration method for awsClient.
+func (c *awsClient) GetBucketLifecycleConfiguration(
+	input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	return c.s3Client.GetBucketLifecycleConfiguration(input)
+}
+
 // GetBucketLocation implements the GetBucketLocation method for awsClient.
 func (c *awsClient) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
 	return c.s3Client.GetBucketLocation(input)
 }
 
+// GetBucketReplication implements the GetBucketReplication method for awsClient.
+func (c *awsClient) GetBucketReplication(input *s3.GetBucketReplicationInput) (*s3.GetBucketReplicationOutput, error) {
+	return c.s3Client.GetBucketReplication(input)
+}
+
 // GetBucketTagging implements the GetBucketTagging method for awsClient.
 func (c *awsClient) GetBucketTagging(input *s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error) {
 	return c.s3Client.GetBucketTagging(input)
 }
 
+// GetBucketVersioning implements the GetBucketVersioning method for awsClient.
+func (c *awsClient) GetBucketVersioning(input *s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error) {
+	return c.s3Client.GetBucketVersioning(input)
+}
+
+// GetObject implements the GetObject method for awsClient.
+func (c *awsClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return c.s3Client.GetObject(input)
+}
+
+// GetObjectLockConfiguration implements the GetObjectLockConfiguration method for awsClient.
+func (c *awsClient) GetObjectLockConfiguration(
+	input *s3.GetObjectLockConfigurationInput) (*s3.GetObjectLockConfigurationOutput, error) {
+	return c.s3Client.GetObjectLockConfiguration(input)
+}
+
 // GetPublicAccessBlock implements the GetPublicAccessBlock method for awsClient.
 func (c *awsClient) GetPublicAccessBlock(input *s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error) {
 	return c.s3Client.GetPublicAccessBlock(input)
@@ -91,6 +148,11 @@ func (c *awsClient) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutp
 	return c.s3Client.ListBuckets(input)
 }
 
+// ListObjectVersions implements the ListObjectVersions method for awsClient.
+func (c *awsClient) ListObjectVersions(input *s3.ListObjectVersionsInput) (*s3.ListObjectVersionsOutput, error) {
+	return c.s3Client.ListObjectVersions(input)
+}
+
 // PutBucketEncryption implements the PutBucketEncryption method for awsClient.
 func (c *awsClient) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
 	return c.s3Client.PutBucketEncryption(input)
@@ -102,55 +164,143 @@ func (c *awsClient) PutBucketLifecycleConfiguration(
 	return c.s3Client.PutBucketLifecycleConfiguration(input)
 }
 
+// PutBucketReplication implements the PutBucketReplication method for awsClient.
+func (c *awsClient) PutBucketReplication(input *s3.PutBucketReplicationInput) (*s3.PutBucketReplicationOutput, error) {
+	return c.s3Client.PutBucketReplication(input)
+}
+
 // PutBucketTagging implements the PutBucketTagging method for awsClient.
 func (c *awsClient) PutBucketTagging(input *s3.PutBucketTaggingInput) (*s3.PutBucketTaggingOutput, error) {
 	return c.s3Client.PutBucketTagging(input)
 }
 
+// PutBucketVersioning implements the PutBucketVersioning method for awsClient.
+func (c *awsClient) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	return c.s3Client.PutBucketVersioning(input)
+}
+
+// PutObject implements the PutObject method for awsClient.
+func (c *awsClient) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return c.s3Client.PutObject(input)
+}
+
+// PutObjectLockConfiguration implements the PutObjectLockConfiguration method for awsClient.
+func (c *awsClient) PutObjectLockConfiguration(
+	input *s3.PutObjectLockConfigurationInput) (*s3.PutObjectLockConfigurationOutput, error) {
+	return c.s3Client.PutObjectLockConfiguration(input)
+}
+
 // PutPublicAccessBlock implements the PutPublicAccessBlock method for awsClient.
 func (c *awsClient) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
 	return c.s3Client.PutPublicAccessBlock(input)
 }
 
 // NewS3Client reads the aws secrets in the operator's namespace and uses
-// them to create a new client for accessing the S3 API.
-func NewS3Client(kubeClient client.Client, region string) (Client, error) {
-	var err error
-
-	awsConfig := &aws.Config{Region: aws.String(region)}
-	namespace := config.OperatorNamespace
-	secret := &corev1.Secret{}
-	err = kubeClient.Get(context.TODO(),
-		types.NamespacedName{
-			Name:      awsCredsSecretName,
-			Namespace: namespace,
-		},
-		secret)
+// them to create a new client for accessing the S3 API. The client for the
+// region is reused from the cache until the secret changes.
+func NewS3Client(clients *storageBase.ClientCache, kubeClient client.Client, region string) (Client, error) {
+	return newS3Client(clients, kubeClient, awsCredsSecretName, &aws.Config{Region: aws.String(region)})
+}
+
+// NewS3CompatibleClient reads the given secret in the operator's namespace and
+// uses it to create a new client for accessing an S3-compatible API at the
+// given endpoint. Buckets are addressed by path, as most S3-compatible object
+// stores do not support virtual-hosted-style requests. The client for the
+// endpoint is reused from the cache until the secret changes.
+func NewS3CompatibleClient(clients *storageBase.ClientCache, kubeClient client.Client, endpoint, region, secretName string) (Client, error) {
+	return newS3Client(clients, kubeClient, secretName, &aws.Config{
+		Region:           aws.String(region),
+		Endpoint:         aws.String(endpoint),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+}
+
+func newS3Client(clients *storageBase.ClientCache, kubeClient client.Client, secretName string, awsConfig *aws.Config) (Client, error) {
+	secret, err := storageBase.GetCredentialsSecret(kubeClient, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	// A client is cached for each secret, endpoint and region
+	key := strings.Join([]string{secretName, aws.StringValue(awsConfig.Endpoint), aws.StringValue(awsConfig.Region)}, "/")
+	s3Client, err := clients.Get(key, secret, func(secret *corev1.Secret) (interface{}, error) {
+		s, err := newSession(secret, awsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// Load the actual AWS client into the awsClient interface.
+		return &awsClient{
+			s3Client: s3.New(s),
+			Config:   awsConfig,
+		}, nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return s3Client.(Client), nil
+}
+
+// newSession uses the aws secret to create a new AWS session.
+func newSession(secret *corev1.Secret, awsConfig *aws.Config) (*session.Session, error) {
+	secretName := secret.Name
+
+	// On clusters with short-lived credentials, the secret holds a credentials
+	// file naming a role to assume with the operator's service account token
+	if credentialsFile, ok := secret.Data[awsCredsSecretFileKey]; ok {
+		roleARN, tokenFile := parseWebIdentityConfig(credentialsFile)
+		if roleARN != "" && tokenFile != "" {
+			return newWebIdentitySession(awsConfig, roleARN, tokenFile)
+		}
+	}
+
 	accessKeyID, ok := secret.Data[awsCredsSecretIDKey]
 	if !ok {
 		return nil, fmt.Errorf("AWS credentials secret %v did not contain key %v",
-			awsCredsSecretName, awsCredsSecretIDKey)
+			secretName, awsCredsSecretIDKey)
 	}
 	secretAccessKey, ok := secret.Data[awsCredsSecretAccessKey]
 	if !ok {
 		return nil, fmt.Errorf("AWS credentials secret %v did not contain key %v",
-			awsCredsSecretName, awsCredsSecretAccessKey)
+			secretName, awsCredsSecretAccessKey)
 	}
 
 	awsConfig.Credentials = credentials.NewStaticCredentials(
 		string(accessKeyID), string(secretAccessKey), "")
 
-	s, err := session.NewSession(awsConfig)
+	return session.NewSession(awsConfig)
+}
+
+// newWebIdentitySession creates a new AWS session that assumes the given
+// role with the web identity token in tokenFile.
+func newWebIdentitySession(awsConfig *aws.Config, roleARN, tokenFile string) (*session.Session, error) {
+	// Use the regional STS endpoint, as the global one may not be reachable
+	awsConfig.STSRegionalEndpoint = endpoints.RegionalSTSEndpoint
+
+	stsSession, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, err
 	}
+	awsConfig.Credentials = stscreds.NewWebIdentityCredentials(stsSession, roleARN, version.OperatorName, tokenFile)
 
-	// Load the actual AWS client into the awsClient interface.
-	return &awsClient{
-		s3Client: s3.New(s),
-		Config:   awsConfig,
-	}, nil
+	return session.NewSession(awsConfig)
+}
+
+// parseWebIdentityConfig reads the role_arn and web_identity_token_file
+// settings from an AWS shared credentials file, such as the one written by the
+// cloud-credential-operator on clusters with short-lived credentials.
+func parseWebIdentityConfig(credentialsFile []byte) (roleARN, tokenFile string) {
+	for _, line := range strings.Split(string(credentialsFile), "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "role_arn":
+			roleARN = strings.TrimSpace(value)
+		case "web_identity_token_file":
+			tokenFile = strings.TrimSpace(value)
+		}
+	}
+	return roleARN, tokenFile
 }
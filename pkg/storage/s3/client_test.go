@@ -1,8 +1,11 @@
 package s3
 
 import (
+	"context"
 	"testing"
 
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -10,6 +13,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	velerov1alpha2 "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
 )
 
 // utils and variables
@@ -37,7 +41,116 @@ func setUpInstance(t *testing.T) *velerov1alpha2.VeleroInstall {
 func setUpTestClient(t *testing.T, instance *velerov1alpha2.VeleroInstall) k8sClient.Client {
 	s := scheme.Scheme
 	s.AddKnownTypes(velerov1alpha2.GroupVersion, instance)
-	objects := []runtime.Object{instance}
+	if err := configv1.Install(s); err != nil {
+		t.Fatal(err)
+	}
+	objects := []runtime.Object{instance, &configv1.ClusterVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "version"},
+		Spec:       configv1.ClusterVersionSpec{ClusterID: clusterID},
+	}}
 
 	return fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objects...).Build()
 }
+
+func TestParseWebIdentityConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		wantRoleARN   string
+		wantTokenFile string
+	}{
+		{
+			name: "credentials file written for a web identity",
+			file: `[default]
+sts_regional_endpoints = regional
+role_arn = arn:aws:iam::123456789012:role/managed-velero-operator
+web_identity_token_file = /var/run/secrets/openshift/serviceaccount/token
+`,
+			wantRoleARN:   "arn:aws:iam::123456789012:role/managed-velero-operator",
+			wantTokenFile: "/var/run/secrets/openshift/serviceaccount/token",
+		},
+		{
+			name: "credentials file with static keys",
+			file: `[default]
+aws_access_key_id = AKIAEXAMPLE
+aws_secret_access_key = secret
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roleARN, tokenFile := parseWebIdentityConfig([]byte(tt.file))
+			if roleARN != tt.wantRoleARN || tokenFile != tt.wantTokenFile {
+				t.Errorf("parseWebIdentityConfig() = %q, %q, want %q, %q", roleARN, tokenFile, tt.wantRoleARN, tt.wantTokenFile)
+			}
+		})
+	}
+}
+
+func TestNewS3ClientWebIdentity(t *testing.T) {
+	instance := setUpInstance(t)
+	kubeClient := setUpTestClient(t, instance)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      awsCredsSecretName,
+			Namespace: "openshift-velero",
+		},
+		Data: map[string][]byte{
+			awsCredsSecretFileKey: []byte(`[default]
+role_arn = arn:aws:iam::123456789012:role/managed-velero-operator
+web_identity_token_file = /var/run/secrets/openshift/serviceaccount/token
+`),
+		},
+	}
+	if err := kubeClient.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	// A secret without static keys is only usable through the web identity
+	s3Client, err := NewS3Client(nil, kubeClient, "us-east-1")
+	if err != nil {
+		t.Fatalf("NewS3Client() error = %v", err)
+	}
+	if s3Client.GetAWSClientConfig().Credentials == nil {
+		t.Errorf("NewS3Client() did not set credentials")
+	}
+}
+
+func TestNewS3ClientCached(t *testing.T) {
+	instance := setUpInstance(t)
+	kubeClient := setUpTestClient(t, instance)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      awsCredsSecretName,
+			Namespace: "openshift-velero",
+		},
+		Data: map[string][]byte{
+			awsCredsSecretIDKey:     []byte("id"),
+			awsCredsSecretAccessKey: []byte("key"),
+		},
+	}
+	if err := kubeClient.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	clients := &storageBase.ClientCache{}
+	first, err := NewS3Client(clients, kubeClient, "us-east-1")
+	if err != nil {
+		t.Fatalf("NewS3Client() error = %v", err)
+	}
+	if second, _ := NewS3Client(clients, kubeClient, "us-east-1"); second != first {
+		t.Errorf("NewS3Client() built a new client for an unchanged secret")
+	}
+	if other, _ := NewS3Client(clients, kubeClient, "us-west-2"); other == first {
+		t.Errorf("NewS3Client() reused the client of another region")
+	}
+
+	// Rotating the credentials changes the secret's resourceVersion
+	secret.Data[awsCredsSecretAccessKey] = []byte("rotated")
+	if err := kubeClient.Update(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if rotated, _ := NewS3Client(clients, kubeClient, "us-east-1"); rotated == first {
+		t.Errorf("NewS3Client() reused the client after the secret was rotated")
+	}
+}
@@ -0,0 +1,240 @@
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	replicationRoleSuffix     = "-velero-replication"
+	replicationRolePolicyName = "velero-replication"
+)
+
+// awsIAMClient implements the IAMClient interface.
+type awsIAMClient struct {
+	iamClient iamiface.IAMAPI
+}
+
+// IAMClient is a wrapper object for the actual AWS SDK IAM client to allow for easier testing.
+type IAMClient interface {
+	CreateRole(*iam.CreateRoleInput) (*iam.CreateRoleOutput, error)
+	DeleteRole(*iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error)
+	DeleteRolePolicy(*iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error)
+	GetRole(*iam.GetRoleInput) (*iam.GetRoleOutput, error)
+	PutRolePolicy(*iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error)
+}
+
+// CreateRole implements the CreateRole method for awsIAMClient.
+func (c *awsIAMClient) CreateRole(input *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	return c.iamClient.CreateRole(input)
+}
+
+// DeleteRole implements the DeleteRole method for awsIAMClient.
+func (c *awsIAMClient) DeleteRole(input *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	return c.iamClient.DeleteRole(input)
+}
+
+// DeleteRolePolicy implements the DeleteRolePolicy method for awsIAMClient.
+func (c *awsIAMClient) DeleteRolePolicy(input *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	return c.iamClient.DeleteRolePolicy(input)
+}
+
+// GetRole implements the GetRole method for awsIAMClient.
+func (c *awsIAMClient) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	return c.iamClient.GetRole(input)
+}
+
+// PutRolePolicy implements the PutRolePolicy method for awsIAMClient.
+func (c *awsIAMClient) PutRolePolicy(input *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	return c.iamClient.PutRolePolicy(input)
+}
+
+// NewIAMClient reads the aws secrets in the operator's namespace and uses
+// them to create a new client for accessing the IAM API. The client is reused
+// from the cache until the secret changes.
+func NewIAMClient(clients *storageBase.ClientCache, kubeClient client.Client, region string) (IAMClient, error) {
+	secret, err := storageBase.GetCredentialsSecret(kubeClient, awsCredsSecretName)
+	if err != nil {
+		return nil, err
+	}
+	iamClient, err := clients.Get("iam/"+region, secret, func(secret *corev1.Secret) (interface{}, error) {
+		s, err := newSession(secret, &aws.Config{Region: aws.String(region)})
+		if err != nil {
+			return nil, err
+		}
+		return &awsIAMClient{iamClient: iam.New(s)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return iamClient.(IAMClient), nil
+}
+
+// policyDocument is an IAM policy document
+type policyDocument struct {
+	Version   string
+	Statement []policyStatement
+}
+
+// policyStatement is a statement in an IAM policy document
+type policyStatement struct {
+	Effect    string
+	Principal map[string]string `json:",omitempty"`
+	Action    []string
+	Resource  []string `json:",omitempty"`
+}
+
+// ReplicationRoleName returns the name of the IAM role that S3 assumes to
+// replicate the cluster's backups.
+func ReplicationRoleName(infraName string) string {
+	return infraName + replicationRoleSuffix
+}
+
+// ReplicationRolePolicy returns the policy that allows S3 to replicate objects
+// from the source bucket to the replica bucket. When the buckets are encrypted
+// with KMS, objects are decrypted with the source key and re-encrypted with the
+// replica key. Without a source key, the source bucket uses the AWS-managed
+// aws/s3 key, whose ARN isn't known, so any key in the source region is allowed.
+func ReplicationRolePolicy(partition, sourceRegion, sourceBucket, replicaBucket, sourceKMSKeyID, replicaKMSKeyARN string) (string, error) {
+	policy := policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetReplicationConfiguration", "s3:ListBucket"},
+				Resource: []string{bucketARN(partition, sourceBucket)},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetObjectVersionForReplication", "s3:GetObjectVersionAcl", "s3:GetObjectVersionTagging"},
+				Resource: []string{bucketARN(partition, sourceBucket) + "/*"},
+			},
+			{
+				Effect:   "Allow",
+				Action:   []string{"s3:ReplicateObject", "s3:ReplicateDelete", "s3:ReplicateTags"},
+				Resource: []string{bucketARN(partition, replicaBucket) + "/*"},
+			},
+		},
+	}
+	if replicaKMSKeyARN != "" {
+		sourceKMSKeyARN := fmt.Sprintf("arn:%s:kms:%s:*:key/*", partition, sourceRegion)
+		if sourceKMSKeyID != "" {
+			sourceKMSKeyARN = KMSKeyARN(partition, sourceRegion, sourceKMSKeyID)
+		}
+		policy.Statement = append(policy.Statement,
+			policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"kms:Decrypt"},
+				Resource: []string{sourceKMSKeyARN},
+			},
+			policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"kms:Encrypt"},
+				Resource: []string{replicaKMSKeyARN},
+			},
+		)
+	}
+	document, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+	return string(document), nil
+}
+
+// EnsureReplicationRole creates the IAM role that S3 assumes to replicate
+// objects, if it doesn't already exist, sets its policy and returns its ARN.
+func EnsureReplicationRole(iamClient IAMClient, roleName string, infraName string, policy string) (string, error) {
+	var roleARN string
+	role, err := iamClient.GetRole(&iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if err == nil {
+		roleARN = aws.StringValue(role.Role.Arn)
+	} else {
+		if !isNoSuchEntity(err) {
+			return "", fmt.Errorf("unable to read role %v: %v", roleName, err)
+		}
+		trustPolicy, err := json.Marshal(policyDocument{
+			Version: "2012-10-17",
+			Statement: []policyStatement{
+				{
+					Effect:    "Allow",
+					Principal: map[string]string{"Service": "s3.amazonaws.com"},
+					Action:    []string{"sts:AssumeRole"},
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		created, err := iamClient.CreateRole(&iam.CreateRoleInput{
+			RoleName:                 aws.String(roleName),
+			AssumeRolePolicyDocument: aws.String(string(trustPolicy)),
+			Description:              aws.String("Replicates Velero backups for cluster " + infraName),
+			Tags: []*iam.Tag{
+				{
+					Key:   aws.String(bucketTagInfraName),
+					Value: aws.String(infraName),
+				},
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("unable to create role %v: %v", roleName, err)
+		}
+		roleARN = aws.StringValue(created.Role.Arn)
+	}
+
+	_, err = iamClient.PutRolePolicy(&iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(replicationRolePolicyName),
+		PolicyDocument: aws.String(policy),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to set policy of role %v: %v", roleName, err)
+	}
+	return roleARN, nil
+}
+
+// DeleteReplicationRole deletes the IAM role that S3 assumes to replicate
+// objects, along with its policy. A role that doesn't exist is ignored.
+func DeleteReplicationRole(iamClient IAMClient, roleName string) error {
+	_, err := iamClient.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(replicationRolePolicyName),
+	})
+	if err != nil && !isNoSuchEntity(err) {
+		return fmt.Errorf("unable to delete policy of role %v: %v", roleName, err)
+	}
+	_, err = iamClient.DeleteRole(&iam.DeleteRoleInput{RoleName: aws.String(roleName)})
+	if err != nil && !isNoSuchEntity(err) {
+		return fmt.Errorf("unable to delete role %v: %v", roleName, err)
+	}
+	return nil
+}
+
+// isNoSuchEntity returns true if the error is the IAM API reporting that an entity doesn't exist
+func isNoSuchEntity(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == iam.ErrCodeNoSuchEntityException
+}
+
+// bucketARN returns the ARN of an S3 bucket
+func bucketARN(partition, bucketName string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s", partition, bucketName)
+}
+
+// KMSKeyARN returns the ARN of a KMS key, which may be given as either its ID
+// in the region, or its ARN
+func KMSKeyARN(partition, region, kmsKeyID string) string {
+	if strings.HasPrefix(kmsKeyID, "arn:") {
+		return kmsKeyID
+	}
+	return fmt.Sprintf("arn:%s:kms:%s:*:key/%s", partition, region, kmsKeyID)
+}
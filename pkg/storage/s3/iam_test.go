@@ -0,0 +1,203 @@
+package s3
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// mockIAMClient is a fake IAM client that keeps roles and their inline policies in memory
+type mockIAMClient struct {
+	Roles    map[string]*iam.Role
+	Policies map[string]string
+}
+
+func newMockIAMClient() *mockIAMClient {
+	return &mockIAMClient{
+		Roles:    make(map[string]*iam.Role),
+		Policies: make(map[string]string),
+	}
+}
+
+// CreateRole implements the CreateRole method for mockIAMClient.
+func (c *mockIAMClient) CreateRole(input *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	if _, ok := c.Roles[*input.RoleName]; ok {
+		return nil, awserr.New(iam.ErrCodeEntityAlreadyExistsException, "Role already exists", nil)
+	}
+	role := &iam.Role{
+		RoleName:                 input.RoleName,
+		Arn:                      aws.String("arn:aws:iam::123456789012:role/" + *input.RoleName),
+		AssumeRolePolicyDocument: input.AssumeRolePolicyDocument,
+		Tags:                     input.Tags,
+	}
+	c.Roles[*input.RoleName] = role
+	return &iam.CreateRoleOutput{Role: role}, nil
+}
+
+// DeleteRole implements the DeleteRole method for mockIAMClient.
+func (c *mockIAMClient) DeleteRole(input *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	if _, ok := c.Roles[*input.RoleName]; !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "Role not found", nil)
+	}
+	if _, ok := c.Policies[*input.RoleName]; ok {
+		return nil, awserr.New(iam.ErrCodeDeleteConflictException, "Role has inline policies", nil)
+	}
+	delete(c.Roles, *input.RoleName)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+// DeleteRolePolicy implements the DeleteRolePolicy method for mockIAMClient.
+func (c *mockIAMClient) DeleteRolePolicy(input *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	if _, ok := c.Policies[*input.RoleName]; !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "Policy not found", nil)
+	}
+	delete(c.Policies, *input.RoleName)
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+// GetRole implements the GetRole method for mockIAMClient.
+func (c *mockIAMClient) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	role, ok := c.Roles[*input.RoleName]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "Role not found", nil)
+	}
+	return &iam.GetRoleOutput{Role: role}, nil
+}
+
+// PutRolePolicy implements the PutRolePolicy method for mockIAMClient.
+func (c *mockIAMClient) PutRolePolicy(input *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	if _, ok := c.Roles[*input.RoleName]; !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "Role not found", nil)
+	}
+	c.Policies[*input.RoleName] = *input.PolicyDocument
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func TestReplicationRolePolicy(t *testing.T) {
+	tests := []struct {
+		name             string
+		sourceKMSKeyID   string
+		replicaKMSKeyARN string
+		wantKMS          map[string]string
+	}{
+		{
+			name: "provider managed encryption",
+		},
+		{
+			name:             "AWS managed source key",
+			replicaKMSKeyARN: "arn:aws:kms:us-west-2:123456789012:key/replica",
+			wantKMS: map[string]string{
+				"kms:Decrypt": "arn:aws:kms:us-east-1:*:key/*",
+				"kms:Encrypt": "arn:aws:kms:us-west-2:123456789012:key/replica",
+			},
+		},
+		{
+			name:             "customer managed source key",
+			sourceKMSKeyID:   "source",
+			replicaKMSKeyARN: "arn:aws:kms:us-west-2:123456789012:key/replica",
+			wantKMS: map[string]string{
+				"kms:Decrypt": "arn:aws:kms:us-east-1:*:key/source",
+				"kms:Encrypt": "arn:aws:kms:us-west-2:123456789012:key/replica",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := ReplicationRolePolicy("aws", region, "testBucket", "replicaBucket", tt.sourceKMSKeyID, tt.replicaKMSKeyARN)
+			if err != nil {
+				t.Fatalf("ReplicationRolePolicy() error = %v", err)
+			}
+			var policy policyDocument
+			if err := json.Unmarshal([]byte(document), &policy); err != nil {
+				t.Fatal(err)
+			}
+
+			kms := map[string]string{}
+			for _, statement := range policy.Statement {
+				for _, action := range statement.Action {
+					switch action {
+					case "s3:ReplicateObject":
+						if statement.Resource[0] != "arn:aws:s3:::replicaBucket/*" {
+							t.Errorf("ReplicationRolePolicy() replicates to %v", statement.Resource[0])
+						}
+					case "kms:Decrypt", "kms:Encrypt":
+						kms[action] = statement.Resource[0]
+					}
+				}
+			}
+			if len(kms) != len(tt.wantKMS) {
+				t.Errorf("ReplicationRolePolicy() KMS statements = %v, want %v", kms, tt.wantKMS)
+			}
+			for action, resource := range tt.wantKMS {
+				if kms[action] != resource {
+					t.Errorf("ReplicationRolePolicy() %v on %v, want %v", action, kms[action], resource)
+				}
+			}
+		})
+	}
+}
+
+func TestKMSKeyARN(t *testing.T) {
+	if got, want := KMSKeyARN("aws", "us-east-1", "1234abcd-12ab-34cd-56ef-1234567890ab"), "arn:aws:kms:us-east-1:*:key/1234abcd-12ab-34cd-56ef-1234567890ab"; got != want {
+		t.Errorf("KMSKeyARN() = %v, want %v", got, want)
+	}
+	keyARN := "arn:aws-us-gov:kms:us-gov-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	if got := KMSKeyARN("aws-us-gov", "us-gov-west-1", keyARN); got != keyARN {
+		t.Errorf("KMSKeyARN() = %v, want %v", got, keyARN)
+	}
+}
+
+func TestEnsureReplicationRole(t *testing.T) {
+	client := newMockIAMClient()
+	roleName := ReplicationRoleName(clusterInfraName)
+	if roleName != "fakeCluster-velero-replication" {
+		t.Errorf("ReplicationRoleName() = %v, want %v", roleName, "fakeCluster-velero-replication")
+	}
+
+	roleARN, err := EnsureReplicationRole(client, roleName, clusterInfraName, "first")
+	if err != nil {
+		t.Fatalf("EnsureReplicationRole() error = %v", err)
+	}
+	if want := "arn:aws:iam::123456789012:role/" + roleName; roleARN != want {
+		t.Errorf("EnsureReplicationRole() = %v, want %v", roleARN, want)
+	}
+	tags := client.Roles[roleName].Tags
+	if len(tags) != 1 || *tags[0].Key != bucketTagInfraName || *tags[0].Value != clusterInfraName {
+		t.Errorf("EnsureReplicationRole() tags = %v", tags)
+	}
+
+	// An existing role is kept, and its policy is replaced
+	existingARN, err := EnsureReplicationRole(client, roleName, clusterInfraName, "second")
+	if err != nil {
+		t.Fatalf("EnsureReplicationRole() error = %v", err)
+	}
+	if existingARN != roleARN {
+		t.Errorf("EnsureReplicationRole() = %v, want %v", existingARN, roleARN)
+	}
+	if client.Policies[roleName] != "second" {
+		t.Errorf("EnsureReplicationRole() policy = %v, want %v", client.Policies[roleName], "second")
+	}
+}
+
+func TestDeleteReplicationRole(t *testing.T) {
+	client := newMockIAMClient()
+	roleName := ReplicationRoleName(clusterInfraName)
+	if _, err := EnsureReplicationRole(client, roleName, clusterInfraName, "policy"); err != nil {
+		t.Fatalf("EnsureReplicationRole() error = %v", err)
+	}
+
+	if err := DeleteReplicationRole(client, roleName); err != nil {
+		t.Fatalf("DeleteReplicationRole() error = %v", err)
+	}
+	if _, ok := client.Roles[roleName]; ok {
+		t.Errorf("DeleteReplicationRole() left role %v behind", roleName)
+	}
+
+	// Deleting a role that doesn't exist is not an error
+	if err := DeleteReplicationRole(client, roleName); err != nil {
+		t.Errorf("DeleteReplicationRole() error = %v for a missing role", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/service/s3"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -16,12 +17,14 @@ import (
 	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
 	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
 	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type S3 struct {
-	Region    string
-	InfraName string
+	Region       string
+	InfraName    string
+	ResourceTags map[string]string
 }
 
 type driver struct {
@@ -31,15 +34,21 @@ type driver struct {
 
 // NewDriver creates a new s3 storage driver
 // Used during bootstrapping
-func NewDriver(ctx context.Context, cfg *configv1.InfrastructureStatus, clnt client.Client) *driver {
+func NewDriver(ctx context.Context, cfg *configv1.InfrastructureStatus, clnt client.Client, recorder record.EventRecorder) *driver {
 	drv := driver{
 		Config: &S3{
-			Region:    cfg.PlatformStatus.AWS.Region,
-			InfraName: cfg.InfrastructureName,
+			Region:       cfg.PlatformStatus.AWS.Region,
+			InfraName:    cfg.InfrastructureName,
+			ResourceTags: make(map[string]string, len(cfg.PlatformStatus.AWS.ResourceTags)),
 		},
 	}
+	// User tags that the cluster applies to every AWS resource it creates
+	for _, tag := range cfg.PlatformStatus.AWS.ResourceTags {
+		drv.Config.ResourceTags[tag.Key] = tag.Value
+	}
 	drv.Context = ctx
 	drv.KubeClient = clnt
+	drv.Recorder = recorder
 	return &drv
 }
 
@@ -54,16 +63,47 @@ func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.
 
 	var err error
 
+	existingBucket := instance.Spec.Storage.ExistingBucket
+	immutability := instance.Spec.Storage.Immutability
+	replication := instance.Spec.Storage.Replication
+	region := d.bucketRegion(instance)
+
+	// Replication is configured on the storage bucket, so it must be managed
+	if replication != nil {
+		switch {
+		case existingBucket != nil && !existingBucket.Manage:
+			err = fmt.Errorf("spec.storage.replication is not supported for an unmanaged existing bucket")
+		case replication.Region == region:
+			err = fmt.Errorf("spec.storage.replication.region must differ from the storage bucket's region %v", region)
+		case instance.Spec.Storage.GetEncryptionMode() == veleroInstallCR.StorageEncryptionModeKMS && replication.KMSKeyID == "":
+			err = fmt.Errorf("encryption mode %v requires a replication kmsKeyID for the replica bucket", instance.Spec.Storage.GetEncryptionMode())
+		}
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+			return err
+		}
+	} else if instance.Status.StorageBucket.Replica == nil {
+		instance.RemoveCondition(veleroInstallCR.ConditionReplicationReady)
+	}
+
 	// Create an S3 client based on the region we received
-	s3Client, err := NewS3Client(d.KubeClient, d.Config.Region)
+	s3Client, err := NewS3Client(&d.Clients, d.KubeClient, region)
 	if err != nil {
 		return err
 	}
 
-	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", d.Config.Region)
+	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", region)
 
 	// This switch handles the provisioning steps/checks
 	switch {
+	// An existing bucket was provided, so we never create one
+	case existingBucket != nil:
+		if instance.Status.StorageBucket.Name != existingBucket.Name {
+			bucketLog.Info("Using existing S3 bucket", "StorageBucket.Name", existingBucket.Name)
+			instance.Status.StorageBucket.Name = existingBucket.Name
+			instance.Status.StorageBucket.Provisioned = false
+		}
+
 	// We don't yet have a bucket name selected
 	case instance.Status.StorageBucket.Name == "":
 		err = setInstanceBucketName(d, s3Client, reqLogger, instance)
@@ -77,26 +117,34 @@ func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.
 
 		// Create S3 bucket
 		bucketLog.Info("Creating S3 Bucket")
-		err = CreateBucket(s3Client, instance.Status.StorageBucket.Name)
+		err = CreateBucket(s3Client, instance.Status.StorageBucket.Name, immutability != nil)
 		if err != nil {
 			if aerr, ok := err.(awserr.Error); ok {
 				switch aerr.Code() {
 				case s3.ErrCodeBucketAlreadyExists:
 					bucketLog.Info("Bucket exists, but is not owned by current user; retrying")
+					instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed,
+						fmt.Sprintf("Bucket %v is owned by another account; selecting a new name", instance.Status.StorageBucket.Name))
 					instance.Status.StorageBucket.Name = ""
 					return instance.StatusUpdate(reqLogger, d.KubeClient)
 				case s3.ErrCodeBucketAlreadyOwnedByYou:
 					bucketLog.Info("Bucket exists, and is owned by current user; continue")
 				default:
-					return fmt.Errorf("error occurred when creating bucket %v: %v", instance.Status.StorageBucket.Name, aerr.Error())
+					err = fmt.Errorf("error occurred when creating bucket %v: %v", instance.Status.StorageBucket.Name, aerr.Error())
+					instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed, err.Error())
+					return err
 				}
 			} else {
-				return fmt.Errorf("error occurred when creating bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+				err = fmt.Errorf("error occurred when creating bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+				instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed, err.Error())
+				return err
 			}
 		}
-		err = TagBucket(s3Client, instance.Status.StorageBucket.Name, storageConstants.DefaultVeleroBackupStorageLocation, d.Config.InfraName)
+		err = TagBucket(s3Client, instance.Status.StorageBucket.Name, storageConstants.DefaultVeleroBackupStorageLocation, d.Config.InfraName, storageBase.ExtraBucketTags(d.Config.ResourceTags, instance))
 		if err != nil {
-			return fmt.Errorf("error occurred when tagging bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+			err = fmt.Errorf("error occurred when tagging bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonTaggingFailed, err.Error())
+			return err
 		}
 	}
 
@@ -104,67 +152,398 @@ func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.
 	bucketLog.Info("Verifing S3 Bucket exists")
 	exists, err := d.StorageExists(instance.Status.StorageBucket.Name)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			return fmt.Errorf("error occurred when verifying bucket %v: %v", instance.Status.StorageBucket.Name, aerr.Error())
-		}
-		return fmt.Errorf("error occurred when verifying bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		err = fmt.Errorf("error occurred when verifying bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
 	}
 	if !exists {
 		bucketLog.Error(nil, "S3 bucket doesn't appear to exist")
 		instance.Status.StorageBucket.Provisioned = false
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNotFound,
+			fmt.Sprintf("Bucket %v does not exist or is not accessible", instance.Status.StorageBucket.Name))
 		return instance.StatusUpdate(reqLogger, d.KubeClient)
 	}
 
+	if existingBucket != nil {
+		// Verify the existing S3 bucket is in the expected region
+		bucketLog.Info("Verifying S3 Bucket location")
+		location, err := GetBucketRegion(s3Client, instance.Status.StorageBucket.Name)
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		if location != region {
+			err = fmt.Errorf("bucket %v is in region %v, expected %v", instance.Status.StorageBucket.Name, location, region)
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketLocationMismatch, err.Error())
+			return err
+		}
+	}
+
+	// Verify S3 bucket belongs to this cluster. The marker isn't written into
+	// an unmanaged bucket, which the operator never changes.
+	if existingBucket == nil || existingBucket.Manage {
+		bucketLog.Info("Verifying S3 Bucket cluster marker")
+		if err = d.ensureClusterMarker(s3Client, instance); err != nil {
+			return err
+		}
+	}
+
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionTrue, veleroInstallCR.ReasonBucketAvailable,
+		fmt.Sprintf("Bucket %v is available", instance.Status.StorageBucket.Name))
+
+	if existingBucket != nil && !existingBucket.Manage {
+		// Report, but don't correct, any policy gaps on an unmanaged bucket
+		bucketLog.Info("Checking S3 Bucket policy")
+		gaps, err := FindBucketPolicyGaps(s3Client, instance.Status.StorageBucket.Name,
+			sseAlgorithm(instance.Spec.Storage.GetEncryptionMode()), instance.Spec.Storage.GetKMSKeyID(), instance.Spec.Storage.GetRetentionDays())
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyCompliant, metav1.ConditionUnknown, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		err = syncVersioningStatus(s3Client, instance)
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyCompliant, metav1.ConditionUnknown, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		objectLock, err := syncObjectLockStatus(s3Client, instance)
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyCompliant, metav1.ConditionUnknown, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		if immutability != nil && !IsBucketLocked(objectLock, objectLockMode(immutability.GetMode()), int64(immutability.RetentionDays)) {
+			gaps = append(gaps, fmt.Sprintf("Object Lock does not retain backups in %v mode for %v days", immutability.GetMode(), immutability.RetentionDays))
+		}
+		storageBase.SetUnmanagedBucketConditions(instance, gaps)
+
+		instance.Status.StorageBucket.Provisioned = true
+		instance.Status.StorageBucket.LastSyncTimestamp = &metav1.Time{
+			Time: time.Now(),
+		}
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+	instance.RemoveCondition(veleroInstallCR.ConditionBucketPolicyCompliant)
+
+	// Settings that differ on a bucket that was already provisioned have
+	// drifted from the policy; on a new bucket they are simply being applied
+	provisioned := instance.Status.StorageBucket.Provisioned
+	var drifted bool
+
 	// Encrypt S3 bucket
 	bucketLog.Info("Enforcing S3 Bucket encryption")
-	err = EncryptBucket(s3Client, instance.Status.StorageBucket.Name)
+	drifted, err = EnsureBucketEncryption(s3Client, instance.Status.StorageBucket.Name, sseAlgorithm(instance.Spec.Storage.GetEncryptionMode()), instance.Spec.Storage.GetKMSKeyID())
+	if drifted && provisioned {
+		d.reportDrift(bucketLog, instance, instance.Status.StorageBucket.Name, storageConstants.BucketSettingEncryption)
+	}
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			return fmt.Errorf("error occurred when encrypting bucket %v: %v", instance.Status.StorageBucket.Name, aerr.Error())
-		}
-		return fmt.Errorf("error occurred when encrypting bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		err = fmt.Errorf("error occurred when encrypting bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonEncryptionFailed, err.Error())
+		return err
 	}
 
 	// Block public access to S3 bucket
 	bucketLog.Info("Enforcing S3 Bucket public access policy")
-	err = BlockBucketPublicAccess(s3Client, instance.Status.StorageBucket.Name)
+	drifted, err = EnsureBucketPublicAccessBlock(s3Client, instance.Status.StorageBucket.Name)
+	if drifted && provisioned {
+		d.reportDrift(bucketLog, instance, instance.Status.StorageBucket.Name, storageConstants.BucketSettingPublicAccessBlock)
+	}
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			return fmt.Errorf("error occurred when blocking public access to bucket %v: %v", instance.Status.StorageBucket.Name, aerr.Error())
+		err = fmt.Errorf("error occurred when blocking public access to bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonPublicAccessBlockFailed, err.Error())
+		return err
+	}
+
+	// Enable versioning on S3 bucket
+	bucketLog.Info("Enforcing S3 Bucket versioning")
+	drifted, err = EnsureBucketVersioning(s3Client, instance.Status.StorageBucket.Name)
+	if drifted && provisioned {
+		d.reportDrift(bucketLog, instance, instance.Status.StorageBucket.Name, storageConstants.BucketSettingVersioning)
+	}
+	if err != nil {
+		err = fmt.Errorf("error occurred when enabling versioning on bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonVersioningFailed, err.Error())
+		return err
+	}
+
+	// Lock backups in S3 bucket
+	noncurrentDays := instance.Spec.Storage.GetNoncurrentVersionRetentionDays()
+	if immutability != nil {
+		bucketLog.Info("Enforcing S3 Bucket object lock")
+		drifted, err = EnsureBucketObjectLock(s3Client, instance.Status.StorageBucket.Name, objectLockMode(immutability.GetMode()), int64(immutability.RetentionDays))
+		if drifted && provisioned {
+			d.reportDrift(bucketLog, instance, instance.Status.StorageBucket.Name, storageConstants.BucketSettingObjectLock)
+		}
+		if err != nil {
+			err = fmt.Errorf("error occurred when configuring object lock on bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonObjectLockFailed, err.Error())
+			return err
+		}
+		// A version can't be removed until its lock expires, so there is no
+		// point expiring noncurrent versions any sooner
+		if int64(immutability.RetentionDays) > noncurrentDays {
+			noncurrentDays = int64(immutability.RetentionDays)
 		}
-		return fmt.Errorf("error occurred when blocking public access to bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
 	}
 
 	// Configure lifecycle rules on S3 bucket
 	bucketLog.Info("Enforcing S3 Bucket lifecycle rules on S3 Bucket")
-	err = SetBucketLifecycle(s3Client, instance.Status.StorageBucket.Name)
+	drifted, err = EnsureBucketLifecycle(s3Client, instance.Status.StorageBucket.Name, instance.Spec.Storage.GetRetentionDays(), noncurrentDays)
+	if drifted && provisioned {
+		d.reportDrift(bucketLog, instance, instance.Status.StorageBucket.Name, storageConstants.BucketSettingLifecycle)
+	}
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			return fmt.Errorf("error occurred when configuring lifecycle rules on bucket %v: %v", instance.Status.StorageBucket.Name, aerr.Error())
-		}
-		return fmt.Errorf("error occurred when configuring lifecycle rules on bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		err = fmt.Errorf("error occurred when configuring lifecycle rules on bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonLifecycleFailed, err.Error())
+		return err
 	}
 
 	// Make sure that tags are applied to buckets
 	bucketLog.Info("Enforcing S3 Bucket tags on S3 Bucket")
-	err = TagBucket(s3Client, instance.Status.StorageBucket.Name, storageConstants.DefaultVeleroBackupStorageLocation, d.Config.InfraName)
+	drifted, err = EnsureBucketTags(s3Client, instance.Status.StorageBucket.Name, storageConstants.DefaultVeleroBackupStorageLocation, d.Config.InfraName, storageBase.ExtraBucketTags(d.Config.ResourceTags, instance))
+	if drifted && provisioned {
+		d.reportDrift(bucketLog, instance, instance.Status.StorageBucket.Name, storageConstants.BucketSettingTags)
+	}
+	if err != nil {
+		err = fmt.Errorf("error occurred when tagging bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonTaggingFailed, err.Error())
+		return err
+	}
+
+	// Report the effective versioning and object lock configuration
+	err = syncVersioningStatus(s3Client, instance)
+	if err == nil {
+		_, err = syncObjectLockStatus(s3Client, instance)
+	}
 	if err != nil {
-		return fmt.Errorf("error occurred when tagging bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		err = fmt.Errorf("error occurred when reading versioning and object lock configuration of bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+
+	message := "Encryption, public access block, versioning, lifecycle and tagging policy is enforced"
+	if immutability != nil {
+		message = "Encryption, public access block, versioning, object lock, lifecycle and tagging policy is enforced"
 	}
+	instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionTrue, veleroInstallCR.ReasonPolicyEnforced, message)
 
 	instance.Status.StorageBucket.Provisioned = true
 	instance.Status.StorageBucket.LastSyncTimestamp = &metav1.Time{
 		Time: time.Now(),
 	}
+
+	// Replicate backups to the replica bucket, or stop once replication is no longer requested
+	switch {
+	case replication != nil:
+		err = d.reconcileReplica(reqLogger, s3Client, instance, region, noncurrentDays)
+	case instance.Status.StorageBucket.Replica != nil:
+		err = d.removeReplication(reqLogger, s3Client, instance, region)
+	}
+	if err != nil {
+		return err
+	}
+
 	return instance.StatusUpdate(reqLogger, d.KubeClient)
 
 }
 
+// reconcileReplica provisions the replica bucket in the replication region,
+// enforces the same encryption, public access, versioning, lifecycle and
+// tagging policy on it as on the storage bucket, and configures S3
+// Cross-Region Replication from the storage bucket to it.
+func (d *driver) reconcileReplica(reqLogger logr.Logger, s3Client Client, instance *veleroInstallCR.VeleroInstall, region string, noncurrentDays int64) error {
+	replication := instance.Spec.Storage.Replication
+	replica := instance.Status.StorageBucket.Replica
+
+	replicaClient, err := NewS3Client(&d.Clients, d.KubeClient, replication.Region)
+	if err != nil {
+		return err
+	}
+
+	// We don't yet have a replica bucket in the replication region, so select a
+	// name for one; it is created on the next pass
+	if replica == nil || replica.Region != replication.Region {
+		if replica != nil {
+			reqLogger.Info("Replication region changed; retaining previous replica bucket", "Replica.Name", replica.Name, "Replica.Region", replica.Region)
+		}
+		proposedName := generateBucketName(instance.Spec.Storage.GetNamePrefix())
+		proposedBucketExists, err := DoesBucketExist(replicaClient, proposedName)
+		if err != nil {
+			return err
+		}
+		if proposedBucketExists {
+			return fmt.Errorf("proposed replica bucket %s already exists, retrying", proposedName)
+		}
+
+		reqLogger.Info("Setting proposed replica bucket name", "Replica.Name", proposedName, "Replica.Region", replication.Region)
+		instance.Status.StorageBucket.Replica = &veleroInstallCR.ReplicaBucket{
+			Name:   proposedName,
+			Region: replication.Region,
+		}
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNameSelected,
+			fmt.Sprintf("Selected name %v for a replica bucket in region %v", proposedName, replication.Region))
+		return nil
+	}
+
+	replicaLog := reqLogger.WithValues("Replica.Name", replica.Name, "Replica.Region", replica.Region)
+
+	if !replica.Provisioned {
+		replicaLog.Info("Creating S3 replica bucket")
+		err = CreateBucket(replicaClient, replica.Name, false)
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeBucketAlreadyExists:
+				replicaLog.Info("Replica bucket exists, but is not owned by current user; retrying")
+				instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonReplicaCreateFailed,
+					fmt.Sprintf("Bucket %v is owned by another account; selecting a new name", replica.Name))
+				instance.Status.StorageBucket.Replica = nil
+				return nil
+			case s3.ErrCodeBucketAlreadyOwnedByYou:
+				replicaLog.Info("Replica bucket exists, and is owned by current user; continue")
+				err = nil
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("error occurred when creating replica bucket %v: %v", replica.Name, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonReplicaCreateFailed, err.Error())
+			return err
+		}
+	}
+
+	// Enforce the storage bucket's policy on the replica bucket, so that
+	// replicated backups are protected in the same way
+	var drifted bool
+	replicaLog.Info("Enforcing S3 replica bucket encryption")
+	drifted, err = EnsureBucketEncryption(replicaClient, replica.Name, sseAlgorithm(instance.Spec.Storage.GetEncryptionMode()), instance.Spec.Storage.GetReplicaKMSKeyID())
+	if drifted && replica.Provisioned {
+		d.reportDrift(replicaLog, instance, replica.Name, storageConstants.BucketSettingEncryption)
+	}
+	if err != nil {
+		err = fmt.Errorf("error occurred when encrypting replica bucket %v: %v", replica.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonEncryptionFailed, err.Error())
+		return err
+	}
+
+	replicaLog.Info("Enforcing S3 replica bucket public access policy")
+	drifted, err = EnsureBucketPublicAccessBlock(replicaClient, replica.Name)
+	if drifted && replica.Provisioned {
+		d.reportDrift(replicaLog, instance, replica.Name, storageConstants.BucketSettingPublicAccessBlock)
+	}
+	if err != nil {
+		err = fmt.Errorf("error occurred when blocking public access to replica bucket %v: %v", replica.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonPublicAccessBlockFailed, err.Error())
+		return err
+	}
+
+	// Replication requires versioning on both buckets
+	replicaLog.Info("Enforcing S3 replica bucket versioning")
+	drifted, err = EnsureBucketVersioning(replicaClient, replica.Name)
+	if drifted && replica.Provisioned {
+		d.reportDrift(replicaLog, instance, replica.Name, storageConstants.BucketSettingVersioning)
+	}
+	if err != nil {
+		err = fmt.Errorf("error occurred when enabling versioning on replica bucket %v: %v", replica.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonVersioningFailed, err.Error())
+		return err
+	}
+
+	replicaLog.Info("Enforcing S3 replica bucket lifecycle rules")
+	drifted, err = EnsureBucketLifecycle(replicaClient, replica.Name, instance.Spec.Storage.GetRetentionDays(), noncurrentDays)
+	if drifted && replica.Provisioned {
+		d.reportDrift(replicaLog, instance, replica.Name, storageConstants.BucketSettingLifecycle)
+	}
+	if err != nil {
+		err = fmt.Errorf("error occurred when configuring lifecycle rules on replica bucket %v: %v", replica.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonLifecycleFailed, err.Error())
+		return err
+	}
+
+	// The replica is tagged with its own backup location, so that it is never
+	// recovered as the storage bucket
+	replicaLog.Info("Enforcing S3 replica bucket tags")
+	drifted, err = EnsureBucketTags(replicaClient, replica.Name, storageConstants.ReplicaVeleroBackupStorageLocation, d.Config.InfraName, storageBase.ExtraBucketTags(d.Config.ResourceTags, instance))
+	if drifted && replica.Provisioned {
+		d.reportDrift(replicaLog, instance, replica.Name, storageConstants.BucketSettingTags)
+	}
+	if err != nil {
+		err = fmt.Errorf("error occurred when tagging replica bucket %v: %v", replica.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonTaggingFailed, err.Error())
+		return err
+	}
+
+	// Configure replication with a role that S3 assumes to copy objects
+	replicaLog.Info("Enforcing S3 Bucket replication")
+	err = d.configureReplication(s3Client, instance, region)
+	if err != nil {
+		err = fmt.Errorf("error occurred when configuring replication of bucket %v to %v: %v", instance.Status.StorageBucket.Name, replica.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonReplicationFailed, err.Error())
+		return err
+	}
+
+	replica.Provisioned = true
+	instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionTrue, veleroInstallCR.ReasonReplicationConfigured,
+		fmt.Sprintf("Backups are replicated to bucket %v in region %v", replica.Name, replica.Region))
+	return nil
+}
+
+// reportDrift logs and records a setting of a provisioned bucket that had
+// drifted from the enforced policy, and was corrected
+func (d *driver) reportDrift(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall, bucketName string, setting string) {
+	reqLogger.Info("Corrected drift in S3 Bucket setting", "Setting", setting)
+	d.RecordDrift(instance, bucketName, setting)
+}
+
+// configureReplication ensures the IAM role that S3 assumes to replicate
+// objects, and configures replication from the storage bucket in the given
+// region to the replica bucket with it.
+func (d *driver) configureReplication(s3Client Client, instance *veleroInstallCR.VeleroInstall, region string) error {
+	replica := instance.Status.StorageBucket.Replica
+
+	partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if !ok {
+		return fmt.Errorf("no partition found for region %q", region)
+	}
+	policy, err := ReplicationRolePolicy(partition.ID(), region, instance.Status.StorageBucket.Name, replica.Name,
+		instance.Spec.Storage.GetKMSKeyID(), instance.Spec.Storage.GetReplicaKMSKeyID())
+	if err != nil {
+		return err
+	}
+
+	iamClient, err := NewIAMClient(&d.Clients, d.KubeClient, region)
+	if err != nil {
+		return err
+	}
+	roleARN, err := EnsureReplicationRole(iamClient, ReplicationRoleName(d.Config.InfraName), d.Config.InfraName, policy)
+	if err != nil {
+		return err
+	}
+
+	return SetBucketReplication(s3Client, instance.Status.StorageBucket.Name, roleARN,
+		bucketARN(partition.ID(), replica.Name), instance.Spec.Storage.GetReplicaKMSKeyID())
+}
+
+// removeReplication stops replicating backups once replication is no longer
+// requested, and deletes the replication role. The replica bucket and the
+// backups in it are retained.
+func (d *driver) removeReplication(reqLogger logr.Logger, s3Client Client, instance *veleroInstallCR.VeleroInstall, region string) error {
+	replica := instance.Status.StorageBucket.Replica
+	reqLogger.Info("Removing S3 Bucket replication; retaining replica bucket", "Replica.Name", replica.Name, "Replica.Region", replica.Region)
+
+	err := d.deleteReplication(s3Client, instance.Status.StorageBucket.Name, region)
+	if err != nil {
+		err = fmt.Errorf("error occurred when removing replication of bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonReplicationFailed, err.Error())
+		return err
+	}
+
+	instance.Status.StorageBucket.Replica = nil
+	instance.RemoveCondition(veleroInstallCR.ConditionReplicationReady)
+	return nil
+}
+
 // StorageExists checks that the bucket exists, and that we have access to it.
 func (d *driver) StorageExists(bucketName string) (bool, error) {
 
 	//create an S3 Client
-	s3Client, err := NewS3Client(d.KubeClient, d.Config.Region)
+	s3Client, err := NewS3Client(&d.Clients, d.KubeClient, d.Config.Region)
 	if err != nil {
 		return false, err
 	}
@@ -181,6 +560,9 @@ func (d *driver) StorageExists(bucketName string) (bool, error) {
 			// https://github.com/aws/aws-sdk-go/issues/2593
 			case s3.ErrCodeNoSuchBucket, "NotFound":
 				return false, nil
+			// The bucket exists in another region, which is checked separately
+			case "BucketRegionError":
+				return true, nil
 			default:
 				return false, fmt.Errorf("unable to determine bucket %v status: %v", bucketName, aerr.Error())
 			}
@@ -192,6 +574,170 @@ func (d *driver) StorageExists(bucketName string) (bool, error) {
 	return true, nil
 }
 
+// DeleteStorage empties and deletes the s3 bucket
+func (d *driver) DeleteStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	region := d.bucketRegion(instance)
+	s3Client, err := NewS3Client(&d.Clients, d.KubeClient, region)
+	if err != nil {
+		return err
+	}
+
+	// Stop replication before emptying the replica, so that nothing is copied to it afterwards
+	if replica := instance.Status.StorageBucket.Replica; replica != nil {
+		err = d.deleteReplication(s3Client, instance.Status.StorageBucket.Name, region)
+		if err != nil {
+			return err
+		}
+		replicaClient, err := NewS3Client(&d.Clients, d.KubeClient, replica.Region)
+		if err != nil {
+			return err
+		}
+		reqLogger.Info("Deleting S3 replica bucket", "Replica.Name", replica.Name, "Replica.Region", replica.Region)
+		err = DeleteBucket(replicaClient, replica.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	reqLogger.Info("Deleting S3 Bucket", "StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", region)
+	return DeleteBucket(s3Client, instance.Status.StorageBucket.Name)
+}
+
+// MarkStorageOrphaned tags the s3 bucket with the time it was orphaned
+func (d *driver) MarkStorageOrphaned(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	region := d.bucketRegion(instance)
+	s3Client, err := NewS3Client(&d.Clients, d.KubeClient, region)
+	if err != nil {
+		return err
+	}
+
+	orphanedAt := time.Now()
+	if replica := instance.Status.StorageBucket.Replica; replica != nil && replica.Provisioned {
+		replicaClient, err := NewS3Client(&d.Clients, d.KubeClient, replica.Region)
+		if err != nil {
+			return err
+		}
+		reqLogger.Info("Tagging S3 replica bucket as orphaned", "Replica.Name", replica.Name, "Replica.Region", replica.Region)
+		err = MarkBucketOrphaned(replicaClient, replica.Name, orphanedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	reqLogger.Info("Tagging S3 Bucket as orphaned", "StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Region", region)
+	return MarkBucketOrphaned(s3Client, instance.Status.StorageBucket.Name, orphanedAt)
+}
+
+// deleteReplication removes the replication configuration from the storage
+// bucket in the given region, and deletes the replication role.
+func (d *driver) deleteReplication(s3Client Client, bucketName string, region string) error {
+	err := RemoveBucketReplication(s3Client, bucketName)
+	if err != nil {
+		return err
+	}
+	iamClient, err := NewIAMClient(&d.Clients, d.KubeClient, region)
+	if err != nil {
+		return err
+	}
+	return DeleteReplicationRole(iamClient, ReplicationRoleName(d.Config.InfraName))
+}
+
+// bucketRegion returns the region of the storage bucket, which is the cluster's
+// region unless an existing bucket in another region was provided
+func (d *driver) bucketRegion(instance *veleroInstallCR.VeleroInstall) string {
+	if instance.Spec.Storage.ExistingBucket != nil && instance.Spec.Storage.ExistingBucket.Region != "" {
+		return instance.Spec.Storage.ExistingBucket.Region
+	}
+	return d.Config.Region
+}
+
+// sseAlgorithm returns the S3 server-side encryption algorithm for the requested encryption mode
+func sseAlgorithm(mode veleroInstallCR.StorageEncryptionMode) string {
+	if mode == veleroInstallCR.StorageEncryptionModeKMS {
+		return s3.ServerSideEncryptionAwsKms
+	}
+	return s3.ServerSideEncryptionAes256
+}
+
+// objectLockMode returns the S3 Object Lock retention mode for the requested immutability mode
+func objectLockMode(mode veleroInstallCR.StorageImmutabilityMode) string {
+	if mode == veleroInstallCR.StorageImmutabilityModeCompliance {
+		return s3.ObjectLockRetentionModeCompliance
+	}
+	return s3.ObjectLockRetentionModeGovernance
+}
+
+// syncVersioningStatus reads the versioning configuration of the storage bucket,
+// and records whether versioning is active in the instance status.
+func syncVersioningStatus(s3Client Client, instance *veleroInstallCR.VeleroInstall) error {
+	versioned, err := IsBucketVersioned(s3Client, instance.Status.StorageBucket.Name)
+	if err != nil {
+		return err
+	}
+	instance.Status.StorageBucket.VersioningEnabled = versioned
+	return nil
+}
+
+// syncObjectLockStatus reads the Object Lock configuration of the storage bucket,
+// records it in the instance status and returns it. The configuration is nil if
+// Object Lock is not enabled on the bucket.
+func syncObjectLockStatus(s3Client Client, instance *veleroInstallCR.VeleroInstall) (*s3.ObjectLockConfiguration, error) {
+	config, err := GetBucketObjectLock(s3Client, instance.Status.StorageBucket.Name)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		instance.Status.StorageBucket.ObjectLock = nil
+		return nil, nil
+	}
+
+	status := &veleroInstallCR.ObjectLockStatus{Enabled: true}
+	if config.Rule != nil && config.Rule.DefaultRetention != nil {
+		retention := config.Rule.DefaultRetention
+		switch aws.StringValue(retention.Mode) {
+		case s3.ObjectLockRetentionModeGovernance:
+			status.Mode = veleroInstallCR.StorageImmutabilityModeGovernance
+		case s3.ObjectLockRetentionModeCompliance:
+			status.Mode = veleroInstallCR.StorageImmutabilityModeCompliance
+		}
+		status.RetentionDays = int32(aws.Int64Value(retention.Days))
+		if retention.Years != nil {
+			status.RetentionDays = int32(aws.Int64Value(retention.Years) * 365)
+		}
+	}
+	instance.Status.StorageBucket.ObjectLock = status
+	return config, nil
+}
+
+// ensureClusterMarker verifies that the storage bucket was provisioned by this
+// cluster, and writes the cluster's marker into a bucket that doesn't have one.
+func (d *driver) ensureClusterMarker(s3Client Client, instance *veleroInstallCR.VeleroInstall) error {
+	bucketName := instance.Status.StorageBucket.Name
+	marker, err := d.ClusterMarker(d.Config.InfraName)
+	if err != nil {
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	found, err := GetClusterMarker(s3Client, bucketName)
+	if err != nil {
+		err = fmt.Errorf("error occurred when reading the cluster marker of bucket %v: %v", bucketName, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	if err = storageBase.VerifyClusterMarker(bucketName, marker, found); err != nil {
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketOwnershipMismatch, err.Error())
+		return err
+	}
+	if found == nil {
+		if err = PutClusterMarker(s3Client, bucketName, marker); err != nil {
+			err = fmt.Errorf("error occurred when writing the cluster marker of bucket %v: %v", bucketName, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
 //generateBucketName generates a proposed name for the S3 Bucket
 func generateBucketName(prefix string) string {
 	id := uuid.New().String()
@@ -206,7 +752,7 @@ func setInstanceBucketName(d *driver, s3Client Client, reqLogger logr.Logger, in
 
 	// Use an existing bucket, if it exists.
 	bucketLog.Info("No S3 bucket defined. Searching for existing bucket to use")
-	bucketlist, err := ListBucketsInRegion(s3Client, d.Config.Region)
+	bucketlist, err := ListBucketsInRegion(s3Client, d.Config.Region, storageBase.RecoveryNamePrefixes(instance)...)
 	if err != nil {
 		return err
 	}
@@ -216,16 +762,28 @@ func setInstanceBucketName(d *driver, s3Client Client, reqLogger logr.Logger, in
 		return err
 	}
 
-	existingBucket := FindMatchingTags(bucketinfo, d.Config.InfraName)
-	if existingBucket != "" {
-		bucketLog.Info("Recovered existing bucket", "StorageBucket.Name", existingBucket)
-		instance.Status.StorageBucket.Name = existingBucket
-		instance.Status.StorageBucket.Provisioned = true
+	// Only recover a bucket whose marker shows it was provisioned by this cluster
+	marker, err := d.ClusterMarker(d.Config.InfraName)
+	if err != nil {
+		return err
+	}
+	existingBuckets, adopted, err := OwnedBuckets(bucketLog, s3Client, FindMatchingTags(bucketlist.Buckets, bucketinfo, d.Config.InfraName), marker)
+	if err != nil {
+		return err
+	}
+	if adopted {
+		bucketLog.Info("Adopted existing bucket without a cluster marker", "StorageBucket.Name", existingBuckets[0], "MatchingBuckets", existingBuckets)
+		storageBase.AdoptBucket(instance, existingBuckets)
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+	if len(existingBuckets) > 0 {
+		bucketLog.Info("Recovered existing bucket", "StorageBucket.Name", existingBuckets[0], "MatchingBuckets", existingBuckets)
+		storageBase.RecoverBucket(instance, existingBuckets)
 		return instance.StatusUpdate(reqLogger, d.KubeClient)
 	}
 
 	// Prepare to create a new bucket, if none exist.
-	proposedName := generateBucketName(storageConstants.StorageBucketPrefix)
+	proposedName := generateBucketName(instance.Spec.Storage.GetNamePrefix())
 	proposedBucketExists, err := DoesBucketExist(s3Client, proposedName)
 	if err != nil {
 		return err
@@ -236,6 +794,9 @@ func setInstanceBucketName(d *driver, s3Client Client, reqLogger logr.Logger, in
 
 	bucketLog.Info("Setting proposed bucket name", "StorageBucket.Name", proposedName)
 	instance.Status.StorageBucket.Name = proposedName
+	instance.Status.StorageBucket.NamePrefix = instance.Spec.Storage.GetNamePrefix()
 	instance.Status.StorageBucket.Provisioned = false
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNameSelected,
+		fmt.Sprintf("Selected name %v for a new bucket", proposedName))
 	return instance.StatusUpdate(reqLogger, d.KubeClient)
 }
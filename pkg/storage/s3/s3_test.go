@@ -6,9 +6,15 @@ import (
 	"testing"
 
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 
 	velerov1alpha2 "github.com/openshift/managed-velero-operator/api/v1alpha2"
-	"github.com/openshift/managed-velero-operator/pkg/storage/constants"
+	"github.com/openshift/managed-velero-operator/pkg/metrics"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
 )
 
 func TestSetInstanceBucketName(t *testing.T) {
@@ -43,6 +49,10 @@ func TestSetInstanceBucketName(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			instance := setUpInstance(t)
+			// Only buckets with the name prefix are searched for an existing bucket
+			if tt.matchBucketName {
+				instance.Spec.Storage.NamePrefix = "test"
+			}
 			testDriver := setUpDriver(t, instance)
 
 			err := setInstanceBucketName(testDriver, tt.awsClient, nullLogr, instance)
@@ -60,9 +70,92 @@ func TestSetInstanceBucketName(t *testing.T) {
 				t.Errorf("setInstanceBucketName() bucket name: %s, didn't expect %s", instance.Status.StorageBucket.Name, tt.bucketName)
 			}
 
+			// the BucketProvisioned condition should only be true for a recovered bucket
+			wantProvisioned := metav1.ConditionFalse
+			if tt.matchBucketName {
+				wantProvisioned = metav1.ConditionTrue
+			}
+			if !meta.IsStatusConditionPresentAndEqual(instance.Status.Conditions, velerov1alpha2.ConditionBucketProvisioned, wantProvisioned) {
+				t.Errorf("setInstanceBucketName() %v condition is not %v", velerov1alpha2.ConditionBucketProvisioned, wantProvisioned)
+			}
+
 			// if the instance status' bucket name doesn't have the expected prefix
-			if (!strings.HasPrefix(instance.Status.StorageBucket.Name, constants.StorageBucketPrefix)) && !tt.matchBucketName {
-				t.Errorf("setInstanceBucketName() bucket name: %s, didn't have prefix %s", instance.Status.StorageBucket.Name, constants.StorageBucketPrefix)
+			if (!strings.HasPrefix(instance.Status.StorageBucket.Name, velerov1alpha2.DefaultStorageNamePrefix)) && !tt.matchBucketName {
+				t.Errorf("setInstanceBucketName() bucket name: %s, didn't have prefix %s", instance.Status.StorageBucket.Name, velerov1alpha2.DefaultStorageNamePrefix)
+			}
+		})
+	}
+}
+
+func TestSetInstanceBucketNameOtherCluster(t *testing.T) {
+	instance := setUpInstance(t)
+	instance.Spec.Storage.NamePrefix = "test"
+	testDriver := setUpDriver(t, instance)
+
+	// testBucket is tagged for this cluster, but its marker shows that it
+	// was provisioned by another cluster with the same infrastructure name
+	awsClient := newMockAWSClient(validBuckets)
+	data, _ := (&storageBase.ClusterMarker{InfraName: clusterInfraName, ClusterID: "another-cluster"}).Marshal()
+	awsClient.BucketsMarkers["testBucket"] = data
+
+	if err := setInstanceBucketName(testDriver, awsClient, nullLogr, instance); err != nil {
+		t.Fatalf("got an unexpected error: %s", err)
+	}
+	if instance.Status.StorageBucket.Name == "testBucket" || instance.Status.StorageBucket.Provisioned {
+		t.Errorf("setInstanceBucketName() recovered bucket %v of another cluster", instance.Status.StorageBucket.Name)
+	}
+}
+
+func TestEnsureClusterMarker(t *testing.T) {
+	tests := []struct {
+		name       string
+		markerID   string
+		wantErr    bool
+		wantReason string
+	}{
+		{
+			name: "write missing marker",
+		},
+		{
+			name:     "keep own marker",
+			markerID: clusterID,
+		},
+		{
+			name:       "reject marker of another cluster",
+			markerID:   "another-cluster",
+			wantErr:    true,
+			wantReason: velerov1alpha2.ReasonBucketOwnershipMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := setUpInstance(t)
+			instance.Status.StorageBucket.Name = "newBucket"
+			testDriver := setUpDriver(t, instance)
+			awsClient := newMockAWSClient(validBuckets)
+			if tt.markerID != "" {
+				data, _ := (&storageBase.ClusterMarker{InfraName: clusterInfraName, ClusterID: tt.markerID}).Marshal()
+				awsClient.BucketsMarkers["newBucket"] = data
+			}
+
+			err := testDriver.ensureClusterMarker(awsClient, instance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensureClusterMarker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				condition := meta.FindStatusCondition(instance.Status.Conditions, velerov1alpha2.ConditionBucketProvisioned)
+				if condition == nil || condition.Reason != tt.wantReason {
+					t.Errorf("ensureClusterMarker() %v condition = %v, want reason %v", velerov1alpha2.ConditionBucketProvisioned, condition, tt.wantReason)
+				}
+				return
+			}
+
+			found, err := GetClusterMarker(awsClient, "newBucket")
+			if err != nil {
+				t.Fatalf("GetClusterMarker() error = %v", err)
+			}
+			if found == nil || found.InfraName != clusterInfraName || found.ClusterID != clusterID {
+				t.Errorf("ensureClusterMarker() left marker %+v", found)
 			}
 		})
 	}
@@ -90,3 +183,55 @@ func setUpDriver(t *testing.T, instance *velerov1alpha2.VeleroInstall) *driver {
 
 	return &drv
 }
+
+func TestSyncObjectLockStatus(t *testing.T) {
+	client := newMockAWSClient(validBuckets)
+	instance := setUpInstance(t)
+	instance.Status.StorageBucket.Name = "testBucket"
+	instance.Status.StorageBucket.ObjectLock = &velerov1alpha2.ObjectLockStatus{Enabled: true}
+
+	// A bucket without object lock clears any previously reported configuration
+	if _, err := syncObjectLockStatus(client, instance); err != nil {
+		t.Fatalf("syncObjectLockStatus() error = %v", err)
+	}
+	if instance.Status.StorageBucket.ObjectLock != nil {
+		t.Errorf("syncObjectLockStatus() status = %v, want nil", instance.Status.StorageBucket.ObjectLock)
+	}
+
+	if err := CreateBucket(client, "testBucket", true); err != nil {
+		t.Fatalf("CreateBucket() error = %v", err)
+	}
+	if err := SetBucketObjectLock(client, "testBucket", objectLockMode(velerov1alpha2.StorageImmutabilityModeCompliance), 30); err != nil {
+		t.Fatalf("SetBucketObjectLock() error = %v", err)
+	}
+	if _, err := syncObjectLockStatus(client, instance); err != nil {
+		t.Fatalf("syncObjectLockStatus() error = %v", err)
+	}
+	want := &velerov1alpha2.ObjectLockStatus{Enabled: true, Mode: velerov1alpha2.StorageImmutabilityModeCompliance, RetentionDays: 30}
+	if got := instance.Status.StorageBucket.ObjectLock; got == nil || *got != *want {
+		t.Errorf("syncObjectLockStatus() status = %v, want %v", got, want)
+	}
+}
+
+func TestReportDrift(t *testing.T) {
+	instance := setUpInstance(t)
+	testDriver := setUpDriver(t, instance)
+	recorder := record.NewFakeRecorder(1)
+	testDriver.Recorder = recorder
+
+	drift := metrics.BucketDriftTotal.WithLabelValues(storageConstants.BucketSettingTags)
+	before := testutil.ToFloat64(drift)
+	testDriver.reportDrift(logr.Discard(), instance, "testBucket", storageConstants.BucketSettingTags)
+
+	if got := testutil.ToFloat64(drift) - before; got != 1 {
+		t.Errorf("reportDrift() incremented the drift metric by %v, want 1", got)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, storageBase.EventReasonBucketDriftCorrected) || !strings.Contains(event, "testBucket") {
+			t.Errorf("reportDrift() event = %q", event)
+		}
+	default:
+		t.Error("reportDrift() emitted no event")
+	}
+}
@@ -0,0 +1,458 @@
+package s3compat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	configv1 "github.com/openshift/api/config/v1"
+	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+	"github.com/openshift/managed-velero-operator/pkg/storage/s3"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type S3Compatible struct {
+	Platform  configv1.PlatformType
+	InfraName string
+}
+
+type driver struct {
+	storageBase.Driver
+	Config *S3Compatible
+}
+
+// NewDriver creates a new S3-compatible storage driver
+// Used during bootstrapping
+func NewDriver(ctx context.Context, cfg *configv1.InfrastructureStatus, clnt client.Client, recorder record.EventRecorder) *driver {
+	drv := driver{
+		Config: &S3Compatible{
+			Platform:  cfg.PlatformStatus.Type,
+			InfraName: cfg.InfrastructureName,
+		},
+	}
+	drv.Context = ctx
+	drv.KubeClient = clnt
+	drv.Recorder = recorder
+	return &drv
+}
+
+// GetPlatformType returns the platform type of this driver
+func (d *driver) GetPlatformType() configv1.PlatformType {
+	return d.Config.Platform
+}
+
+// CreateStorage attempts to create a bucket in the S3-compatible object store
+// and apply any provided tags
+func (d *driver) CreateStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	var err error
+
+	// The object store is supplied by the user, so it must be configured
+	s3c := instance.Spec.Storage.S3Compatible
+	if s3c == nil {
+		err = fmt.Errorf("spec.storage.s3Compatible must be set on platform %v", d.Config.Platform)
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+
+	// Object Lock support varies too widely between object stores to rely on
+	if instance.Spec.Storage.Immutability != nil {
+		err = fmt.Errorf("spec.storage.immutability is not supported for S3-compatible object stores")
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+
+	// An S3-compatible object store has no second region to replicate to
+	if instance.Spec.Storage.Replication != nil {
+		err = fmt.Errorf("spec.storage.replication is not supported for S3-compatible object stores")
+		instance.SetCondition(veleroInstallCR.ConditionReplicationReady, metav1.ConditionFalse, veleroInstallCR.ReasonUnsupportedConfiguration, err.Error())
+		return err
+	}
+	instance.RemoveCondition(veleroInstallCR.ConditionReplicationReady)
+
+	// Create an S3 client for the configured endpoint
+	s3Client, err := d.newClient(instance)
+	if err != nil {
+		return err
+	}
+
+	return d.reconcileStorage(s3Client, reqLogger, instance)
+}
+
+// reconcileStorage handles the provisioning steps/checks for the bucket
+// using the given S3 client
+func (d *driver) reconcileStorage(s3Client s3.Client, reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	var err error
+
+	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Endpoint", instance.Spec.Storage.S3Compatible.Endpoint)
+
+	existingBucket := instance.Spec.Storage.ExistingBucket
+
+	// This switch handles the provisioning steps/checks
+	switch {
+	// An existing bucket was provided, so we never create one
+	case existingBucket != nil:
+		if instance.Status.StorageBucket.Name != existingBucket.Name {
+			bucketLog.Info("Using existing S3-compatible bucket", "StorageBucket.Name", existingBucket.Name)
+			instance.Status.StorageBucket.Name = existingBucket.Name
+			instance.Status.StorageBucket.Provisioned = false
+		}
+
+	// We don't yet have a bucket name selected
+	case instance.Status.StorageBucket.Name == "":
+		return setInstanceBucketName(d, s3Client, reqLogger, instance)
+
+	// We have a bucket name, but haven't kicked off provisioning of the bucket yet
+	case !instance.Status.StorageBucket.Provisioned:
+		bucketLog.Info("S3-compatible bucket defined, but not provisioned")
+
+		// Create bucket
+		bucketLog.Info("Creating S3-compatible bucket")
+		err = s3.CreateBucket(s3Client, instance.Status.StorageBucket.Name, false)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == awss3.ErrCodeBucketAlreadyOwnedByYou {
+				bucketLog.Info("Bucket exists, and is owned by current user; continue")
+			} else {
+				err = fmt.Errorf("error occurred when creating bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+				instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketCreateFailed, err.Error())
+				return err
+			}
+		}
+	}
+
+	// Verify bucket exists
+	bucketLog.Info("Verifing S3-compatible bucket exists")
+	exists, err := s3.DoesBucketExist(s3Client, instance.Status.StorageBucket.Name)
+	if err != nil {
+		err = fmt.Errorf("error occurred when verifying bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	if !exists {
+		bucketLog.Error(nil, "S3-compatible bucket doesn't appear to exist")
+		instance.Status.StorageBucket.Provisioned = false
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNotFound,
+			fmt.Sprintf("Bucket %v does not exist or is not accessible", instance.Status.StorageBucket.Name))
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+
+	// The location of an existing bucket is only checked if one was given,
+	// as object stores report their regions inconsistently
+	if existingBucket != nil && existingBucket.Region != "" {
+		bucketLog.Info("Verifying S3-compatible bucket location")
+		location, err := s3.GetBucketRegion(s3Client, instance.Status.StorageBucket.Name)
+		if isNotImplemented(err) {
+			bucketLog.Info("Bucket location is not supported by the object store; skipping")
+		} else if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		} else if location != existingBucket.Region {
+			err = fmt.Errorf("bucket %v is in region %v, expected %v", instance.Status.StorageBucket.Name, location, existingBucket.Region)
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketLocationMismatch, err.Error())
+			return err
+		}
+	}
+
+	// Verify bucket belongs to this cluster. The marker isn't written into
+	// an unmanaged bucket, which the operator never changes.
+	if existingBucket == nil || existingBucket.Manage {
+		bucketLog.Info("Verifying S3-compatible bucket cluster marker")
+		if err = d.ensureClusterMarker(s3Client, instance); err != nil {
+			return err
+		}
+	}
+
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionTrue, veleroInstallCR.ReasonBucketAvailable,
+		fmt.Sprintf("Bucket %v is available", instance.Status.StorageBucket.Name))
+
+	if existingBucket != nil && !existingBucket.Manage {
+		// Report, but don't correct, any policy gaps on an unmanaged bucket
+		bucketLog.Info("Checking S3-compatible bucket policy")
+		gaps, err := findBucketPolicyGaps(s3Client, instance.Status.StorageBucket.Name,
+			sseAlgorithm(instance.Spec.Storage.GetEncryptionMode()), instance.Spec.Storage.GetKMSKeyID(), instance.Spec.Storage.GetRetentionDays())
+		if err != nil {
+			instance.SetCondition(veleroInstallCR.ConditionBucketPolicyCompliant, metav1.ConditionUnknown, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+		storageBase.SetUnmanagedBucketConditions(instance, gaps)
+
+		instance.Status.StorageBucket.Provisioned = true
+		instance.Status.StorageBucket.LastSyncTimestamp = &metav1.Time{
+			Time: time.Now(),
+		}
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+	instance.RemoveCondition(veleroInstallCR.ConditionBucketPolicyCompliant)
+
+	// Not every S3-compatible object store implements every bucket setting,
+	// so unsupported settings are skipped and reported rather than failing
+	var skipped []string
+
+	// Encrypt bucket
+	bucketLog.Info("Enforcing S3-compatible bucket encryption")
+	err = s3.EncryptBucket(s3Client, instance.Status.StorageBucket.Name, sseAlgorithm(instance.Spec.Storage.GetEncryptionMode()), instance.Spec.Storage.GetKMSKeyID())
+	if isNotImplemented(err) {
+		bucketLog.Info("Bucket encryption is not supported by the object store; skipping")
+		skipped = append(skipped, "encryption")
+	} else if err != nil {
+		err = fmt.Errorf("error occurred when encrypting bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonEncryptionFailed, err.Error())
+		return err
+	}
+
+	// Block public access to bucket
+	bucketLog.Info("Enforcing S3-compatible bucket public access policy")
+	err = s3.BlockBucketPublicAccess(s3Client, instance.Status.StorageBucket.Name)
+	if isNotImplemented(err) {
+		bucketLog.Info("Bucket public access block is not supported by the object store; skipping")
+		skipped = append(skipped, "public access block")
+	} else if err != nil {
+		err = fmt.Errorf("error occurred when blocking public access to bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonPublicAccessBlockFailed, err.Error())
+		return err
+	}
+
+	// Configure lifecycle rules on bucket
+	bucketLog.Info("Enforcing S3-compatible bucket lifecycle rules")
+	err = s3.SetBucketLifecycle(s3Client, instance.Status.StorageBucket.Name, instance.Spec.Storage.GetRetentionDays(), 0)
+	if isNotImplemented(err) {
+		bucketLog.Info("Bucket lifecycle rules are not supported by the object store; skipping")
+		skipped = append(skipped, "lifecycle")
+	} else if err != nil {
+		err = fmt.Errorf("error occurred when configuring lifecycle rules on bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonLifecycleFailed, err.Error())
+		return err
+	}
+
+	// Make sure that tags are applied to bucket
+	bucketLog.Info("Enforcing S3-compatible bucket tags")
+	err = s3.TagBucket(s3Client, instance.Status.StorageBucket.Name, storageConstants.DefaultVeleroBackupStorageLocation, d.Config.InfraName, instance.Spec.Storage.Tags)
+	if isNotImplemented(err) {
+		bucketLog.Info("Bucket tagging is not supported by the object store; skipping")
+		skipped = append(skipped, "tagging")
+	} else if err != nil {
+		err = fmt.Errorf("error occurred when tagging bucket %v: %v", instance.Status.StorageBucket.Name, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionFalse, veleroInstallCR.ReasonTaggingFailed, err.Error())
+		return err
+	}
+
+	if len(skipped) > 0 {
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionTrue, veleroInstallCR.ReasonPolicyPartiallyEnforced,
+			fmt.Sprintf("Bucket policy is enforced, except for settings the object store does not support: %v", strings.Join(skipped, ", ")))
+	} else {
+		instance.SetCondition(veleroInstallCR.ConditionBucketPolicyEnforced, metav1.ConditionTrue, veleroInstallCR.ReasonPolicyEnforced,
+			"Encryption, public access block, lifecycle and tagging policy is enforced")
+	}
+
+	instance.Status.StorageBucket.Provisioned = true
+	instance.Status.StorageBucket.LastSyncTimestamp = &metav1.Time{
+		Time: time.Now(),
+	}
+	return instance.StatusUpdate(reqLogger, d.KubeClient)
+}
+
+// StorageExists checks that the bucket exists, and that we have access to it.
+// The object store is configured on the VeleroInstall, so the bucket can only
+// be checked once CreateStorage has been called with it.
+func (d *driver) StorageExists(bucketName string) (bool, error) {
+	return false, fmt.Errorf("unable to determine bucket %v status without the S3-compatible object store configuration", bucketName)
+}
+
+// DeleteStorage empties and deletes the bucket in the S3-compatible object store
+func (d *driver) DeleteStorage(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	s3Client, err := d.newClient(instance)
+	if err != nil {
+		return err
+	}
+
+	reqLogger.Info("Deleting S3-compatible bucket", "StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Endpoint", instance.Spec.Storage.S3Compatible.Endpoint)
+	return s3.DeleteBucket(s3Client, instance.Status.StorageBucket.Name)
+}
+
+// MarkStorageOrphaned tags the bucket in the S3-compatible object store with the
+// time it was orphaned. Object stores without bucket tagging are left as they are.
+func (d *driver) MarkStorageOrphaned(reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	s3Client, err := d.newClient(instance)
+	if err != nil {
+		return err
+	}
+
+	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Endpoint", instance.Spec.Storage.S3Compatible.Endpoint)
+	bucketLog.Info("Tagging S3-compatible bucket as orphaned")
+	err = s3.MarkBucketOrphaned(s3Client, instance.Status.StorageBucket.Name, time.Now())
+	if isNotImplemented(err) {
+		bucketLog.Info("Bucket tagging is not supported by the object store; leaving bucket untagged")
+		return nil
+	}
+	return err
+}
+
+// newClient creates an S3 client for the object store configured on the instance
+func (d *driver) newClient(instance *veleroInstallCR.VeleroInstall) (s3.Client, error) {
+	s3c := instance.Spec.Storage.S3Compatible
+	if s3c == nil {
+		return nil, fmt.Errorf("spec.storage.s3Compatible must be set on platform %v", d.Config.Platform)
+	}
+	return s3.NewS3CompatibleClient(&d.Clients, d.KubeClient, s3c.Endpoint, s3c.GetRegion(), s3c.CredentialsSecretName)
+}
+
+// findBucketPolicyGaps returns a description of each bucket setting that does
+// not meet the policy the operator would otherwise enforce on the bucket.
+// Settings the object store does not support can't be checked, and are skipped.
+func findBucketPolicyGaps(s3Client s3.Client, bucketName string, sseAlgorithm string, kmsKeyID string, retentionDays int64) ([]string, error) {
+	var gaps []string
+
+	encrypted, err := s3.IsBucketEncrypted(s3Client, bucketName, sseAlgorithm, kmsKeyID)
+	if err != nil && !isNotImplemented(err) {
+		return nil, fmt.Errorf("unable to read %v bucket encryption configuration: %v", bucketName, err)
+	}
+	if err == nil && !encrypted {
+		gaps = append(gaps, fmt.Sprintf("default encryption is not %v", sseAlgorithm))
+	}
+
+	blocked, err := s3.IsBucketPublicAccessBlocked(s3Client, bucketName)
+	if err != nil && !isNotImplemented(err) {
+		return nil, fmt.Errorf("unable to read %v bucket public access configuration: %v", bucketName, err)
+	}
+	if err == nil && !blocked {
+		gaps = append(gaps, "public access is not fully blocked")
+	}
+
+	expires, err := s3.DoBackupsExpire(s3Client, bucketName, retentionDays)
+	if err != nil && !isNotImplemented(err) {
+		return nil, fmt.Errorf("unable to read %v bucket lifecycle configuration: %v", bucketName, err)
+	}
+	if err == nil && !expires {
+		gaps = append(gaps, fmt.Sprintf("backups are not expired within %v days", retentionDays))
+	}
+
+	return gaps, nil
+}
+
+// isNotImplemented returns true if the object store rejected the call because
+// it does not implement it
+func isNotImplemented(err error) bool {
+	if err == nil {
+		return false
+	}
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		// Some bucket helpers wrap the AWS error, so fall back to the message
+		return strings.Contains(err.Error(), "NotImplemented")
+	}
+	switch aerr.Code() {
+	case "NotImplemented", "XNotImplemented":
+		return true
+	}
+	return false
+}
+
+// sseAlgorithm returns the S3 server-side encryption algorithm for the requested encryption mode
+func sseAlgorithm(mode veleroInstallCR.StorageEncryptionMode) string {
+	if mode == veleroInstallCR.StorageEncryptionModeKMS {
+		return awss3.ServerSideEncryptionAwsKms
+	}
+	return awss3.ServerSideEncryptionAes256
+}
+
+// generateBucketName generates a proposed name for the S3-compatible bucket
+func generateBucketName(prefix string) string {
+	id := uuid.New().String()
+	return prefix + id
+}
+
+// ensureClusterMarker verifies that the storage bucket was provisioned by this
+// cluster, and writes the cluster's marker into a bucket that doesn't have one.
+func (d *driver) ensureClusterMarker(s3Client s3.Client, instance *veleroInstallCR.VeleroInstall) error {
+	bucketName := instance.Status.StorageBucket.Name
+	marker, err := d.ClusterMarker(d.Config.InfraName)
+	if err != nil {
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	found, err := s3.GetClusterMarker(s3Client, bucketName)
+	if err != nil {
+		err = fmt.Errorf("error occurred when reading the cluster marker of bucket %v: %v", bucketName, err.Error())
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+		return err
+	}
+	if err = storageBase.VerifyClusterMarker(bucketName, marker, found); err != nil {
+		instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketOwnershipMismatch, err.Error())
+		return err
+	}
+	if found == nil {
+		if err = s3.PutClusterMarker(s3Client, bucketName, marker); err != nil {
+			err = fmt.Errorf("error occurred when writing the cluster marker of bucket %v: %v", bucketName, err.Error())
+			instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketVerifyFailed, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// setInstanceBucketName generates a bucket name for the S3-compatible bucket,
+// tests to confirm if the bucket is accessible and then updates the instance
+// status with the name
+func setInstanceBucketName(d *driver, s3Client s3.Client, reqLogger logr.Logger, instance *veleroInstallCR.VeleroInstall) error {
+	bucketLog := reqLogger.WithValues("StorageBucket.Name", instance.Status.StorageBucket.Name, "StorageBucket.Endpoint", instance.Spec.Storage.S3Compatible.Endpoint)
+
+	// Use an existing bucket, if it exists. Object stores without bucket
+	// tagging can't identify an existing bucket, so a new one is created.
+	bucketLog.Info("No S3-compatible bucket defined. Searching for existing bucket to use")
+	bucketlist, err := s3.ListBuckets(s3Client)
+	if err != nil {
+		return err
+	}
+
+	bucketinfo, err := s3.ListBucketTags(s3Client, s3.FilterBucketsByPrefix(bucketlist.Buckets, storageBase.RecoveryNamePrefixes(instance)...))
+	if err != nil && !isNotImplemented(err) {
+		return err
+	}
+
+	// Only recover a bucket whose marker shows it was provisioned by this cluster
+	marker, err := d.ClusterMarker(d.Config.InfraName)
+	if err != nil {
+		return err
+	}
+	existingBuckets, adopted, err := s3.OwnedBuckets(bucketLog, s3Client, s3.FindMatchingTags(bucketlist.Buckets, bucketinfo, d.Config.InfraName), marker)
+	if err != nil {
+		return err
+	}
+	if adopted {
+		bucketLog.Info("Adopted existing bucket without a cluster marker", "StorageBucket.Name", existingBuckets[0], "MatchingBuckets", existingBuckets)
+		storageBase.AdoptBucket(instance, existingBuckets)
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+	if len(existingBuckets) > 0 {
+		bucketLog.Info("Recovered existing bucket", "StorageBucket.Name", existingBuckets[0], "MatchingBuckets", existingBuckets)
+		storageBase.RecoverBucket(instance, existingBuckets)
+		return instance.StatusUpdate(reqLogger, d.KubeClient)
+	}
+
+	// Prepare to create a new bucket, if none exist.
+	proposedName := generateBucketName(instance.Spec.Storage.GetNamePrefix())
+	proposedBucketExists, err := s3.DoesBucketExist(s3Client, proposedName)
+	if err != nil {
+		return err
+	}
+	if proposedBucketExists {
+		return fmt.Errorf("proposed bucket %s already exists, retrying", proposedName)
+	}
+
+	bucketLog.Info("Setting proposed bucket name", "StorageBucket.Name", proposedName)
+	instance.Status.StorageBucket.Name = proposedName
+	instance.Status.StorageBucket.NamePrefix = instance.Spec.Storage.GetNamePrefix()
+	instance.Status.StorageBucket.Provisioned = false
+	instance.SetCondition(veleroInstallCR.ConditionBucketProvisioned, metav1.ConditionFalse, veleroInstallCR.ReasonBucketNameSelected,
+		fmt.Sprintf("Selected name %v for a new bucket", proposedName))
+	return instance.StatusUpdate(reqLogger, d.KubeClient)
+}
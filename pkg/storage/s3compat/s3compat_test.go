@@ -0,0 +1,639 @@
+package s3compat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8sClient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	velerov1alpha2 "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	storageBase "github.com/openshift/managed-velero-operator/pkg/storage/base"
+	storageConstants "github.com/openshift/managed-velero-operator/pkg/storage/constants"
+	"github.com/openshift/managed-velero-operator/pkg/storage/s3"
+)
+
+const (
+	clusterInfraName = "fakeCluster"
+	clusterID        = "00000000-0000-0000-0000-000000000001"
+	endpoint         = "https://minio.example.com:9000"
+)
+
+// utilities and variables
+var nullLogr = logr.Discard()
+
+// mockS3Client implements the s3.Client interface for an S3-compatible
+// object store. Calls named in notImplemented fail the way an object store
+// that doesn't support them would.
+type mockS3Client struct {
+	Config         *aws.Config
+	Buckets        map[string][]*awss3.Tag
+	Encryption     map[string]*awss3.ServerSideEncryptionConfiguration
+	Lifecycle      map[string]*awss3.BucketLifecycleConfiguration
+	PublicAccess   map[string]*awss3.PublicAccessBlockConfiguration
+	Objects        map[string][]byte
+	notImplemented map[string]bool
+}
+
+func newMockS3Client(notImplemented ...string) *mockS3Client {
+	c := &mockS3Client{
+		Config:         &aws.Config{Region: aws.String(velerov1alpha2.DefaultS3CompatibleRegion), Endpoint: aws.String(endpoint)},
+		Buckets:        make(map[string][]*awss3.Tag),
+		Encryption:     make(map[string]*awss3.ServerSideEncryptionConfiguration),
+		Lifecycle:      make(map[string]*awss3.BucketLifecycleConfiguration),
+		PublicAccess:   make(map[string]*awss3.PublicAccessBlockConfiguration),
+		Objects:        make(map[string][]byte),
+		notImplemented: make(map[string]bool),
+	}
+	for _, call := range notImplemented {
+		c.notImplemented[call] = true
+	}
+	return c
+}
+
+func (c *mockS3Client) check(call string) error {
+	if c.notImplemented[call] {
+		return awserr.New("NotImplemented", "A header you provided implies functionality that is not implemented", nil)
+	}
+	return nil
+}
+
+func (c *mockS3Client) CreateBucket(input *awss3.CreateBucketInput) (*awss3.CreateBucketOutput, error) {
+	if _, ok := c.Buckets[*input.Bucket]; ok {
+		return nil, awserr.New(awss3.ErrCodeBucketAlreadyOwnedByYou, "Bucket already owned by you", nil)
+	}
+	c.Buckets[*input.Bucket] = nil
+	return &awss3.CreateBucketOutput{}, nil
+}
+
+func (c *mockS3Client) DeleteBucket(input *awss3.DeleteBucketInput) (*awss3.DeleteBucketOutput, error) {
+	if _, ok := c.Buckets[*input.Bucket]; !ok {
+		return nil, awserr.New(awss3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	delete(c.Buckets, *input.Bucket)
+	return &awss3.DeleteBucketOutput{}, nil
+}
+
+func (c *mockS3Client) DeleteBucketReplication(input *awss3.DeleteBucketReplicationInput) (*awss3.DeleteBucketReplicationOutput, error) {
+	if err := c.check("DeleteBucketReplication"); err != nil {
+		return nil, err
+	}
+	return &awss3.DeleteBucketReplicationOutput{}, nil
+}
+
+func (c *mockS3Client) DeleteObjects(input *awss3.DeleteObjectsInput) (*awss3.DeleteObjectsOutput, error) {
+	return &awss3.DeleteObjectsOutput{}, nil
+}
+
+func (c *mockS3Client) GetAWSClientConfig() *aws.Config {
+	return c.Config
+}
+
+func (c *mockS3Client) HeadBucket(input *awss3.HeadBucketInput) (*awss3.HeadBucketOutput, error) {
+	if _, ok := c.Buckets[*input.Bucket]; ok {
+		return &awss3.HeadBucketOutput{}, nil
+	}
+	return nil, awserr.New("NotFound", "Not Found", nil)
+}
+
+func (c *mockS3Client) GetBucketEncryption(input *awss3.GetBucketEncryptionInput) (*awss3.GetBucketEncryptionOutput, error) {
+	if err := c.check("GetBucketEncryption"); err != nil {
+		return nil, err
+	}
+	config, ok := c.Encryption[*input.Bucket]
+	if !ok {
+		return nil, awserr.New("ServerSideEncryptionConfigurationNotFoundError", "The server side encryption configuration was not found", nil)
+	}
+	return &awss3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: config}, nil
+}
+
+func (c *mockS3Client) GetBucketLifecycleConfiguration(input *awss3.GetBucketLifecycleConfigurationInput) (*awss3.GetBucketLifecycleConfigurationOutput, error) {
+	if err := c.check("GetBucketLifecycleConfiguration"); err != nil {
+		return nil, err
+	}
+	config, ok := c.Lifecycle[*input.Bucket]
+	if !ok {
+		return nil, awserr.New("NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist", nil)
+	}
+	return &awss3.GetBucketLifecycleConfigurationOutput{Rules: config.Rules}, nil
+}
+
+func (c *mockS3Client) GetBucketLocation(input *awss3.GetBucketLocationInput) (*awss3.GetBucketLocationOutput, error) {
+	return &awss3.GetBucketLocationOutput{}, nil
+}
+
+func (c *mockS3Client) GetBucketReplication(input *awss3.GetBucketReplicationInput) (*awss3.GetBucketReplicationOutput, error) {
+	if err := c.check("GetBucketReplication"); err != nil {
+		return nil, err
+	}
+	return nil, awserr.New("ReplicationConfigurationNotFoundError", "The replication configuration was not found", nil)
+}
+
+func (c *mockS3Client) GetBucketTagging(input *awss3.GetBucketTaggingInput) (*awss3.GetBucketTaggingOutput, error) {
+	if err := c.check("GetBucketTagging"); err != nil {
+		return nil, err
+	}
+	tags := c.Buckets[*input.Bucket]
+	if len(tags) == 0 {
+		return nil, awserr.New("NoSuchTagSet", "The TagSet does not exist", nil)
+	}
+	return &awss3.GetBucketTaggingOutput{TagSet: tags}, nil
+}
+
+func (c *mockS3Client) GetBucketVersioning(input *awss3.GetBucketVersioningInput) (*awss3.GetBucketVersioningOutput, error) {
+	if err := c.check("GetBucketVersioning"); err != nil {
+		return nil, err
+	}
+	return &awss3.GetBucketVersioningOutput{}, nil
+}
+
+func (c *mockS3Client) GetObject(input *awss3.GetObjectInput) (*awss3.GetObjectOutput, error) {
+	if err := c.check("GetObject"); err != nil {
+		return nil, err
+	}
+	data, ok := c.Objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(awss3.ErrCodeNoSuchKey, "The specified key does not exist", nil)
+	}
+	return &awss3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (c *mockS3Client) GetObjectLockConfiguration(input *awss3.GetObjectLockConfigurationInput) (*awss3.GetObjectLockConfigurationOutput, error) {
+	if err := c.check("GetObjectLockConfiguration"); err != nil {
+		return nil, err
+	}
+	return nil, awserr.New("ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket", nil)
+}
+
+func (c *mockS3Client) GetPublicAccessBlock(input *awss3.GetPublicAccessBlockInput) (*awss3.GetPublicAccessBlockOutput, error) {
+	if err := c.check("GetPublicAccessBlock"); err != nil {
+		return nil, err
+	}
+	return &awss3.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: c.PublicAccess[*input.Bucket]}, nil
+}
+
+func (c *mockS3Client) ListBuckets(input *awss3.ListBucketsInput) (*awss3.ListBucketsOutput, error) {
+	output := &awss3.ListBucketsOutput{Owner: &awss3.Owner{}}
+	for name := range c.Buckets {
+		output.Buckets = append(output.Buckets, &awss3.Bucket{Name: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (c *mockS3Client) ListObjectVersions(input *awss3.ListObjectVersionsInput) (*awss3.ListObjectVersionsOutput, error) {
+	if _, ok := c.Buckets[*input.Bucket]; !ok {
+		return nil, awserr.New(awss3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	return &awss3.ListObjectVersionsOutput{IsTruncated: aws.Bool(false)}, nil
+}
+
+func (c *mockS3Client) PutBucketEncryption(input *awss3.PutBucketEncryptionInput) (*awss3.PutBucketEncryptionOutput, error) {
+	if err := c.check("PutBucketEncryption"); err != nil {
+		return nil, err
+	}
+	c.Encryption[*input.Bucket] = input.ServerSideEncryptionConfiguration
+	return &awss3.PutBucketEncryptionOutput{}, nil
+}
+
+func (c *mockS3Client) PutBucketLifecycleConfiguration(input *awss3.PutBucketLifecycleConfigurationInput) (*awss3.PutBucketLifecycleConfigurationOutput, error) {
+	if err := c.check("PutBucketLifecycleConfiguration"); err != nil {
+		return nil, err
+	}
+	c.Lifecycle[*input.Bucket] = input.LifecycleConfiguration
+	return &awss3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func (c *mockS3Client) PutBucketReplication(input *awss3.PutBucketReplicationInput) (*awss3.PutBucketReplicationOutput, error) {
+	if err := c.check("PutBucketReplication"); err != nil {
+		return nil, err
+	}
+	return &awss3.PutBucketReplicationOutput{}, nil
+}
+
+func (c *mockS3Client) PutBucketTagging(input *awss3.PutBucketTaggingInput) (*awss3.PutBucketTaggingOutput, error) {
+	if err := c.check("PutBucketTagging"); err != nil {
+		return nil, err
+	}
+	c.Buckets[*input.Bucket] = input.Tagging.TagSet
+	return &awss3.PutBucketTaggingOutput{}, nil
+}
+
+func (c *mockS3Client) PutBucketVersioning(input *awss3.PutBucketVersioningInput) (*awss3.PutBucketVersioningOutput, error) {
+	if err := c.check("PutBucketVersioning"); err != nil {
+		return nil, err
+	}
+	return &awss3.PutBucketVersioningOutput{}, nil
+}
+
+func (c *mockS3Client) PutObject(input *awss3.PutObjectInput) (*awss3.PutObjectOutput, error) {
+	if err := c.check("PutObject"); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Objects[*input.Bucket+"/"+*input.Key] = data
+	return &awss3.PutObjectOutput{}, nil
+}
+
+func (c *mockS3Client) PutObjectLockConfiguration(input *awss3.PutObjectLockConfigurationInput) (*awss3.PutObjectLockConfigurationOutput, error) {
+	if err := c.check("PutObjectLockConfiguration"); err != nil {
+		return nil, err
+	}
+	return &awss3.PutObjectLockConfigurationOutput{}, nil
+}
+
+func (c *mockS3Client) PutPublicAccessBlock(input *awss3.PutPublicAccessBlockInput) (*awss3.PutPublicAccessBlockOutput, error) {
+	if err := c.check("PutPublicAccessBlock"); err != nil {
+		return nil, err
+	}
+	c.PublicAccess[*input.Bucket] = input.PublicAccessBlockConfiguration
+	return &awss3.PutPublicAccessBlockOutput{}, nil
+}
+
+// setUpInstance sets up a new VeleroInstall instance and returns a pointer to it.
+// This is to avoid cross-contamination between tests
+func setUpInstance(t *testing.T) *velerov1alpha2.VeleroInstall {
+	t.Helper()
+
+	return &velerov1alpha2.VeleroInstall{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "VeleroInstall",
+			APIVersion: "managed.openshift.io/v1alpha2",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster",
+			Namespace: "openshift-velero",
+		},
+		Spec: velerov1alpha2.VeleroInstallSpec{
+			Storage: velerov1alpha2.StorageSpec{
+				S3Compatible: &velerov1alpha2.S3CompatibleStorage{
+					Endpoint:              endpoint,
+					CredentialsSecretName: "minio-credentials",
+				},
+			},
+		},
+		Status: velerov1alpha2.VeleroInstallStatus{},
+	}
+}
+
+// setUpTestClient sets up a test kube client loaded with a VeleroInstall instance
+func setUpTestClient(t *testing.T, instance *velerov1alpha2.VeleroInstall) k8sClient.Client {
+	s := scheme.Scheme
+	s.AddKnownTypes(velerov1alpha2.GroupVersion, instance)
+	if err := configv1.Install(s); err != nil {
+		t.Fatal(err)
+	}
+	objects := []runtime.Object{instance, &configv1.ClusterVersion{
+		ObjectMeta: metav1.ObjectMeta{Name: "version"},
+		Spec:       configv1.ClusterVersionSpec{ClusterID: clusterID},
+	}}
+
+	return fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objects...).Build()
+}
+
+// setUpDriver creates a new driver and returns a pointer to it. This is to avoid
+// cross-contamination between tests.
+func setUpDriver(t *testing.T, instance *velerov1alpha2.VeleroInstall) *driver {
+	t.Helper()
+
+	drv := driver{
+		Config: &S3Compatible{
+			Platform:  configv1.BareMetalPlatformType,
+			InfraName: clusterInfraName,
+		},
+	}
+	drv.Context = context.TODO()
+	drv.KubeClient = setUpTestClient(t, instance)
+
+	return &drv
+}
+
+func TestReconcileStorage(t *testing.T) {
+	tests := []struct {
+		name           string
+		notImplemented []string
+		wantReason     string
+		wantSkipped    []string
+	}{
+		{
+			name:       "enforce full policy",
+			wantReason: velerov1alpha2.ReasonPolicyEnforced,
+		},
+		{
+			name:           "skip unsupported public access block and lifecycle",
+			notImplemented: []string{"PutPublicAccessBlock", "PutBucketLifecycleConfiguration"},
+			wantReason:     velerov1alpha2.ReasonPolicyPartiallyEnforced,
+			wantSkipped:    []string{"public access block", "lifecycle"},
+		},
+		{
+			name:           "skip unsupported tagging",
+			notImplemented: []string{"GetBucketTagging", "PutBucketTagging"},
+			wantReason:     velerov1alpha2.ReasonPolicyPartiallyEnforced,
+			wantSkipped:    []string{"tagging"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := setUpInstance(t)
+			testDriver := setUpDriver(t, instance)
+			s3Client := newMockS3Client(tt.notImplemented...)
+
+			// The first pass selects a name, the second provisions the bucket
+			for i := 0; i < 2; i++ {
+				if err := testDriver.reconcileStorage(s3Client, nullLogr, instance); err != nil {
+					t.Fatalf("reconcileStorage() pass %d error: %v", i+1, err)
+				}
+			}
+
+			if !instance.Status.StorageBucket.Provisioned {
+				t.Errorf("reconcileStorage() did not mark the bucket as provisioned")
+			}
+			if _, ok := s3Client.Buckets[instance.Status.StorageBucket.Name]; !ok {
+				t.Errorf("reconcileStorage() did not create bucket %v", instance.Status.StorageBucket.Name)
+			}
+			if _, ok := s3Client.Objects[instance.Status.StorageBucket.Name+"/"+storageBase.ClusterMarkerKey]; !ok {
+				t.Errorf("reconcileStorage() did not write the cluster marker into bucket %v", instance.Status.StorageBucket.Name)
+			}
+
+			condition := meta.FindStatusCondition(instance.Status.Conditions, velerov1alpha2.ConditionBucketPolicyEnforced)
+			if condition == nil || condition.Status != metav1.ConditionTrue {
+				t.Fatalf("reconcileStorage() %v condition is not True: %v", velerov1alpha2.ConditionBucketPolicyEnforced, condition)
+			}
+			if condition.Reason != tt.wantReason {
+				t.Errorf("reconcileStorage() %v reason = %v, want %v", velerov1alpha2.ConditionBucketPolicyEnforced, condition.Reason, tt.wantReason)
+			}
+			for _, setting := range tt.wantSkipped {
+				if !strings.Contains(condition.Message, setting) {
+					t.Errorf("reconcileStorage() %v message %q does not report skipped %v", velerov1alpha2.ConditionBucketPolicyEnforced, condition.Message, setting)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileExistingBucket(t *testing.T) {
+	tests := []struct {
+		name           string
+		manage         bool
+		notImplemented []string
+		wantCompliant  metav1.ConditionStatus
+		wantGaps       []string
+	}{
+		{
+			name:          "report gaps on an unmanaged bucket",
+			wantCompliant: metav1.ConditionFalse,
+			wantGaps:      []string{"encryption", "public access", "backups"},
+		},
+		{
+			name:           "skip checks the object store does not support",
+			notImplemented: []string{"GetBucketEncryption", "GetPublicAccessBlock"},
+			wantCompliant:  metav1.ConditionFalse,
+			wantGaps:       []string{"backups"},
+		},
+		{
+			name:   "enforce policy on a managed bucket",
+			manage: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := setUpInstance(t)
+			instance.Spec.Storage.ExistingBucket = &velerov1alpha2.ExistingBucket{
+				Name:   "existing-bucket",
+				Manage: tt.manage,
+			}
+			testDriver := setUpDriver(t, instance)
+			s3Client := newMockS3Client(tt.notImplemented...)
+			s3Client.Buckets["existing-bucket"] = []*awss3.Tag{{Key: aws.String("owner"), Value: aws.String("backup-team")}}
+
+			if err := testDriver.reconcileStorage(s3Client, nullLogr, instance); err != nil {
+				t.Fatalf("reconcileStorage() error: %v", err)
+			}
+			if instance.Status.StorageBucket.Name != "existing-bucket" || !instance.Status.StorageBucket.Provisioned {
+				t.Errorf("reconcileStorage() status = %+v, want existing-bucket provisioned", instance.Status.StorageBucket)
+			}
+			if len(s3Client.Buckets) != 1 {
+				t.Errorf("reconcileStorage() created a bucket: %v", s3Client.Buckets)
+			}
+
+			compliant := meta.FindStatusCondition(instance.Status.Conditions, velerov1alpha2.ConditionBucketPolicyCompliant)
+			if tt.manage {
+				if compliant != nil {
+					t.Errorf("reconcileStorage() set %v on a managed bucket", velerov1alpha2.ConditionBucketPolicyCompliant)
+				}
+				if _, ok := s3Client.Lifecycle["existing-bucket"]; !ok {
+					t.Errorf("reconcileStorage() did not enforce lifecycle rules on a managed bucket")
+				}
+				return
+			}
+
+			if _, ok := s3Client.Lifecycle["existing-bucket"]; ok {
+				t.Errorf("reconcileStorage() changed lifecycle rules on an unmanaged bucket")
+			}
+			if tags := s3Client.Buckets["existing-bucket"]; len(tags) != 1 || *tags[0].Key != "owner" {
+				t.Errorf("reconcileStorage() re-tagged an unmanaged bucket: %v", tags)
+			}
+			if !meta.IsStatusConditionPresentAndEqual(instance.Status.Conditions, velerov1alpha2.ConditionBucketPolicyEnforced, metav1.ConditionTrue) {
+				t.Errorf("reconcileStorage() %v condition is not True", velerov1alpha2.ConditionBucketPolicyEnforced)
+			}
+			if compliant == nil || compliant.Status != tt.wantCompliant {
+				t.Fatalf("reconcileStorage() %v condition = %v, want %v", velerov1alpha2.ConditionBucketPolicyCompliant, compliant, tt.wantCompliant)
+			}
+			if got := strings.Count(compliant.Message, ";") + 1; got != len(tt.wantGaps) {
+				t.Errorf("reconcileStorage() reported %d gaps, want %d: %v", got, len(tt.wantGaps), compliant.Message)
+			}
+			for _, gap := range tt.wantGaps {
+				if !strings.Contains(compliant.Message, gap) {
+					t.Errorf("reconcileStorage() %v message %q does not report %v", velerov1alpha2.ConditionBucketPolicyCompliant, compliant.Message, gap)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileStorageFailure(t *testing.T) {
+	instance := setUpInstance(t)
+	instance.Status.StorageBucket.Name = "testBucket"
+	testDriver := setUpDriver(t, instance)
+	s3Client := newMockS3Client()
+	s3Client.Buckets["testBucket"] = nil
+
+	// Errors other than unsupported calls must still fail the reconcile
+	failing := &failingS3Client{mockS3Client: s3Client}
+	if err := testDriver.reconcileStorage(failing, nullLogr, instance); err == nil {
+		t.Fatalf("reconcileStorage() expected an error")
+	}
+	if !meta.IsStatusConditionPresentAndEqual(instance.Status.Conditions, velerov1alpha2.ConditionBucketPolicyEnforced, metav1.ConditionFalse) {
+		t.Errorf("reconcileStorage() %v condition is not False", velerov1alpha2.ConditionBucketPolicyEnforced)
+	}
+}
+
+// failingS3Client rejects bucket encryption with an access error.
+type failingS3Client struct {
+	*mockS3Client
+}
+
+func (c *failingS3Client) PutBucketEncryption(input *awss3.PutBucketEncryptionInput) (*awss3.PutBucketEncryptionOutput, error) {
+	return nil, awserr.New("AccessDenied", "Access Denied", nil)
+}
+
+func TestSetInstanceBucketName(t *testing.T) {
+	tests := []struct {
+		name           string
+		notImplemented []string
+		bucketName     string
+		marker         *storageBase.ClusterMarker
+		wantRecovered  bool
+		wantAdopted    bool
+	}{
+		{
+			name:          "recover tagged bucket",
+			bucketName:    "testBucket",
+			marker:        &storageBase.ClusterMarker{InfraName: clusterInfraName, ClusterID: clusterID},
+			wantRecovered: true,
+		},
+		{
+			name:          "adopt tagged bucket without a cluster marker",
+			bucketName:    "testBucket",
+			wantRecovered: true,
+			wantAdopted:   true,
+		},
+		{
+			name:       "don't recover tagged bucket of another cluster",
+			bucketName: "testBucket",
+			marker:     &storageBase.ClusterMarker{InfraName: clusterInfraName, ClusterID: "another-cluster"},
+		},
+		{
+			name:           "select new bucket name without tagging support",
+			notImplemented: []string{"GetBucketTagging"},
+			bucketName:     "testBucket",
+			marker:         &storageBase.ClusterMarker{InfraName: clusterInfraName, ClusterID: clusterID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := setUpInstance(t)
+			// Only buckets with the name prefix are searched for an existing bucket
+			instance.Spec.Storage.NamePrefix = "test"
+			testDriver := setUpDriver(t, instance)
+			s3Client := newMockS3Client(tt.notImplemented...)
+			s3Client.Buckets[tt.bucketName] = []*awss3.Tag{
+				{Key: aws.String("velero.io/backup-location"), Value: aws.String(storageConstants.DefaultVeleroBackupStorageLocation)},
+				{Key: aws.String("velero.io/infrastructureName"), Value: aws.String(clusterInfraName)},
+			}
+			if tt.marker != nil {
+				if err := s3.PutClusterMarker(s3Client, tt.bucketName, tt.marker); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := setInstanceBucketName(testDriver, s3Client, nullLogr, instance); err != nil {
+				t.Fatalf("got an unexpected error: %s", err)
+			}
+
+			if tt.wantRecovered && instance.Status.StorageBucket.Name != tt.bucketName {
+				t.Errorf("setInstanceBucketName() bucket name: %s, expected %s", instance.Status.StorageBucket.Name, tt.bucketName)
+			}
+			if !tt.wantRecovered && !strings.HasPrefix(instance.Status.StorageBucket.Name, instance.Spec.Storage.GetNamePrefix()) {
+				t.Errorf("setInstanceBucketName() bucket name: %s, didn't have prefix %s", instance.Status.StorageBucket.Name, instance.Spec.Storage.GetNamePrefix())
+			}
+			if instance.Status.StorageBucket.Provisioned != tt.wantRecovered {
+				t.Errorf("setInstanceBucketName() provisioned = %v, expected %v", instance.Status.StorageBucket.Provisioned, tt.wantRecovered)
+			}
+			if tt.wantAdopted {
+				condition := meta.FindStatusCondition(instance.Status.Conditions, velerov1alpha2.ConditionBucketProvisioned)
+				if condition == nil || condition.Reason != velerov1alpha2.ReasonBucketAdopted {
+					t.Errorf("setInstanceBucketName() %v condition = %v, want reason %v", velerov1alpha2.ConditionBucketProvisioned, condition, velerov1alpha2.ReasonBucketAdopted)
+				}
+				if _, ok := s3Client.Objects[tt.bucketName+"/"+storageBase.ClusterMarkerKey]; !ok {
+					t.Errorf("setInstanceBucketName() didn't write the cluster marker into the adopted bucket")
+				}
+			}
+		})
+	}
+}
+
+func TestCreateStorageWithoutConfiguration(t *testing.T) {
+	instance := setUpInstance(t)
+	instance.Spec.Storage.S3Compatible = nil
+	testDriver := setUpDriver(t, instance)
+
+	if err := testDriver.CreateStorage(nullLogr, instance); err == nil {
+		t.Fatalf("CreateStorage() expected an error without spec.storage.s3Compatible")
+	}
+	condition := meta.FindStatusCondition(instance.Status.Conditions, velerov1alpha2.ConditionBucketProvisioned)
+	if condition == nil || condition.Reason != velerov1alpha2.ReasonUnsupportedConfiguration {
+		t.Errorf("CreateStorage() %v condition = %v, want reason %v", velerov1alpha2.ConditionBucketProvisioned, condition, velerov1alpha2.ReasonUnsupportedConfiguration)
+	}
+}
+
+func TestIsNotImplemented(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "NotImplemented", err: awserr.New("NotImplemented", "", nil), want: true},
+		{name: "XNotImplemented", err: awserr.New("XNotImplemented", "", nil), want: true},
+		{name: "AccessDenied", err: awserr.New("AccessDenied", "", nil), want: false},
+		{name: "wrapped", err: fmt.Errorf("unable to clear bucket tags: %v", awserr.New("NotImplemented", "", nil)), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNotImplemented(tt.err); got != tt.want {
+				t.Errorf("isNotImplemented() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetInstanceBucketNameSeveralUnmarkedBuckets(t *testing.T) {
+	instance := setUpInstance(t)
+	instance.Spec.Storage.NamePrefix = "test"
+	testDriver := setUpDriver(t, instance)
+	// Two tagged buckets predate the cluster marker, and were listed
+	// without creation dates, so they are ranked by name
+	s3Client := newMockS3Client()
+	for _, bucketName := range []string{"testBucketB", "testBucketA"} {
+		s3Client.Buckets[bucketName] = []*awss3.Tag{
+			{Key: aws.String("velero.io/backup-location"), Value: aws.String(storageConstants.DefaultVeleroBackupStorageLocation)},
+			{Key: aws.String("velero.io/infrastructureName"), Value: aws.String(clusterInfraName)},
+		}
+	}
+
+	if err := setInstanceBucketName(testDriver, s3Client, nullLogr, instance); err != nil {
+		t.Fatalf("got an unexpected error: %s", err)
+	}
+	if instance.Status.StorageBucket.Name != "testBucketA" || !instance.Status.StorageBucket.Provisioned {
+		t.Errorf("setInstanceBucketName() bucket = %+v, want testBucketA provisioned", instance.Status.StorageBucket)
+	}
+	if !meta.IsStatusConditionTrue(instance.Status.Conditions, velerov1alpha2.ConditionBucketRecoveryAmbiguous) {
+		t.Errorf("setInstanceBucketName() didn't report the ambiguous adoption")
+	}
+	if _, ok := s3Client.Objects["testBucketA/"+storageBase.ClusterMarkerKey]; !ok {
+		t.Errorf("setInstanceBucketName() didn't write the cluster marker into the adopted bucket")
+	}
+	if _, ok := s3Client.Objects["testBucketB/"+storageBase.ClusterMarkerKey]; ok {
+		t.Errorf("setInstanceBucketName() wrote the cluster marker into a bucket it didn't adopt")
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"github.com/go-logr/logr"
 	configv1 "github.com/openshift/api/config/v1"
 	veleroInstallCR "github.com/openshift/managed-velero-operator/api/v1alpha2"
+	"github.com/openshift/managed-velero-operator/pkg/storage/azure"
 	"github.com/openshift/managed-velero-operator/pkg/storage/gcs"
 	"github.com/openshift/managed-velero-operator/pkg/storage/s3"
+	"github.com/openshift/managed-velero-operator/pkg/storage/s3compat"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -17,10 +20,12 @@ type Driver interface {
 	GetPlatformType() configv1.PlatformType
 	CreateStorage(logr.Logger, *veleroInstallCR.VeleroInstall) error
 	StorageExists(string) (bool, error)
+	DeleteStorage(logr.Logger, *veleroInstallCR.VeleroInstall) error
+	MarkStorageOrphaned(logr.Logger, *veleroInstallCR.VeleroInstall) error
 }
 
 //NewDriver will return a driver object
-func NewDriver(cfg *configv1.InfrastructureStatus, client client.Client) (Driver, error) {
+func NewDriver(cfg *configv1.InfrastructureStatus, client client.Client, recorder record.EventRecorder) (Driver, error) {
 	var driver Driver
 
 	ctx := context.Background()
@@ -32,14 +37,23 @@ func NewDriver(cfg *configv1.InfrastructureStatus, client client.Client) (Driver
 			len(cfg.PlatformStatus.AWS.Region) < 1 {
 			return nil, fmt.Errorf("unable to determine AWS region")
 		}
-		driver = s3.NewDriver(ctx, cfg, client)
+		driver = s3.NewDriver(ctx, cfg, client, recorder)
 	case configv1.GCPPlatformType:
 		if cfg.PlatformStatus.GCP == nil ||
 			len(cfg.PlatformStatus.GCP.Region) < 1 ||
 			len(cfg.PlatformStatus.GCP.ProjectID) < 1 {
 			return nil, fmt.Errorf("unable to determine GCP region")
 		}
-		driver = gcs.NewDriver(ctx, cfg, client)
+		driver = gcs.NewDriver(ctx, cfg, client, recorder)
+	case configv1.AzurePlatformType:
+		if cfg.PlatformStatus.Azure == nil ||
+			len(cfg.PlatformStatus.Azure.ResourceGroupName) < 1 {
+			return nil, fmt.Errorf("unable to determine Azure resource group")
+		}
+		driver = azure.NewDriver(ctx, cfg, client, recorder)
+	case configv1.BareMetalPlatformType, configv1.NonePlatformType, configv1.VSpherePlatformType, configv1.OpenStackPlatformType:
+		// The object store is configured on the VeleroInstall
+		driver = s3compat.NewDriver(ctx, cfg, client, recorder)
 	default:
 		return nil, fmt.Errorf("unable to determine platform")
 	}